@@ -1,10 +1,13 @@
 package irma
 
 import (
+	"encoding/json"
 	"encoding/xml"
 	"fmt"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"time"
 
 	"github.com/go-errors/errors"
 	"github.com/privacybydesign/irmago/internal/common"
@@ -125,6 +128,7 @@ type RequestorScheme struct {
 	storagepath string
 	index       SchemeManagerIndex
 	requestors  []*RequestorInfo
+	chunks      map[RequestorIdentifier]string // chunk file of each requestor, see ReadRequestorScheme()
 }
 
 // RequestorInfo describes a single verified requestor
@@ -215,6 +219,103 @@ func (wizard IssueWizard) Path(conf *Configuration, creds CredentialInfoList) ([
 	}
 }
 
+// IssueWizardPath is a path through an issue wizard as computed by IssueWizard.Path(),
+// along with the combinations of credential types owned by the user resulting in the path.
+type IssueWizardPath struct {
+	Items []IssueWizardItem
+	Owned [][]CredentialTypeIdentifier
+}
+
+// maxWizardPathCredentials is the maximum number of credential types that IssueWizard.Paths()
+// considers, as it computes the path for each combination of them.
+const maxWizardPathCredentials = 16
+
+// CredentialTypes returns the credential types that determine the path through the wizard:
+// those of its items and, if its dependencies are expanded, the (recursive) dependencies of
+// these as far as they are present in the configuration.
+func (wizard IssueWizard) CredentialTypes(conf *Configuration) []CredentialTypeIdentifier {
+	expand := wizard.ExpandDependencies == nil || *wizard.ExpandDependencies
+	found := map[CredentialTypeIdentifier]struct{}{}
+	var visit func(id CredentialTypeIdentifier)
+	visit = func(id CredentialTypeIdentifier) {
+		if _, ok := found[id]; ok {
+			return
+		}
+		found[id] = struct{}{}
+		credtype := conf.CredentialTypes[id]
+		if !expand || credtype == nil {
+			return
+		}
+		for _, discon := range credtype.Dependencies {
+			for _, con := range discon {
+				for _, dep := range con {
+					visit(dep)
+				}
+			}
+		}
+	}
+	for _, discon := range wizard.Contents {
+		for _, con := range discon {
+			for _, item := range con {
+				if item.Credential != nil {
+					visit(*item.Credential)
+				}
+			}
+		}
+	}
+
+	ids := make([]CredentialTypeIdentifier, 0, len(found))
+	for id := range found {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+	return ids
+}
+
+// Paths returns each distinct path through the wizard, by computing the path using Path() for
+// each combination of owned credential types from CredentialTypes(). The paths are returned in
+// the order in which they were first encountered, starting with the path of a user owning none
+// of these credential types.
+func (wizard IssueWizard) Paths(conf *Configuration) ([]*IssueWizardPath, error) {
+	ids := wizard.CredentialTypes(conf)
+	if len(ids) > maxWizardPathCredentials {
+		return nil, errors.Errorf("wizard involves %d credential types, at most %d supported", len(ids), maxWizardPathCredentials)
+	}
+
+	var paths []*IssueWizardPath
+	byItems := map[string]*IssueWizardPath{}
+	for mask := 0; mask < 1<<len(ids); mask++ {
+		owned := []CredentialTypeIdentifier{}
+		var creds CredentialInfoList
+		for i, id := range ids {
+			if mask&(1<<i) != 0 {
+				owned = append(owned, id)
+				creds = append(creds, &CredentialInfo{
+					SchemeManagerID: id.IssuerIdentifier().SchemeManagerIdentifier().Name(),
+					IssuerID:        id.IssuerIdentifier().Name(),
+					ID:              id.Name(),
+				})
+			}
+		}
+		items, err := wizard.Path(conf, creds)
+		if err != nil {
+			return nil, err
+		}
+		bts, err := json.Marshal(items)
+		if err != nil {
+			return nil, err
+		}
+		path, ok := byItems[string(bts)]
+		if !ok {
+			path = &IssueWizardPath{Items: items}
+			byItems[string(bts)] = path
+			paths = append(paths, path)
+		}
+		path.Owned = append(path.Owned, owned)
+	}
+	return paths, nil
+}
+
 func buildDependencyTree(contents []IssueWizardItem, conf *Configuration, credsmap map[CredentialTypeIdentifier]struct{}) ([]IssueWizardItem, error) {
 	// Each item in contents refers to a credential type that has dependencies, which may themselves
 	// have dependencies. So each item has a tree of dependencies. We must return a list
@@ -248,8 +349,9 @@ func buildDependencyTree(contents []IssueWizardItem, conf *Configuration, credsm
 		byID[*contents[i].Credential] = contents[i]
 	}
 
-	// Build a map containing per level of the dependency tree the (deduplicated) nodes at that level
-	depTree := map[int]map[CredentialTypeIdentifier]struct{}{}
+	// Build a map containing per level of the dependency tree the (deduplicated) nodes at that level,
+	// in the order in which they are encountered so that the result is deterministic
+	depTree := map[int][]CredentialTypeIdentifier{}
 	for i, item := range reversed {
 		populateDepTree(depTree, i, *item.Credential, conf, credentialDependencies{}, credsmap)
 	}
@@ -261,7 +363,7 @@ func buildDependencyTree(contents []IssueWizardItem, conf *Configuration, credsm
 	var result []IssueWizardItem                         // to return
 	resultMap := map[CredentialTypeIdentifier]struct{}{} // to keep track of credentials already put in the result slice
 	for i := len(depTree) - 1; i >= 0; i-- {
-		for id := range depTree[i] {
+		for _, id := range depTree[i] {
 			if _, ok := resultMap[id]; ok {
 				continue
 			}
@@ -287,17 +389,23 @@ type credentialDependencies map[CredentialTypeIdentifier][]IssueWizardItem
 // populateDepTree is a recursive function that populates a map containing per level of a tree the
 // (deduplicated) nodes at that level.
 func populateDepTree(
-	depTree map[int]map[CredentialTypeIdentifier]struct{},
+	depTree map[int][]CredentialTypeIdentifier,
 	level int,
 	id CredentialTypeIdentifier,
 	conf *Configuration,
 	deps credentialDependencies,
 	creds map[CredentialTypeIdentifier]struct{},
 ) {
-	if depTree[level] == nil {
-		depTree[level] = map[CredentialTypeIdentifier]struct{}{}
+	present := false
+	for _, other := range depTree[level] {
+		if other == id {
+			present = true
+			break
+		}
+	}
+	if !present {
+		depTree[level] = append(depTree[level], id)
 	}
-	depTree[level][id] = struct{}{}
 
 	for _, child := range deps.get(id, conf, creds) {
 		populateDepTree(depTree, level+1, *child.Credential, conf, deps, creds)
@@ -309,7 +417,13 @@ func populateDepTree(
 // is returned.
 func (deps credentialDependencies) get(id CredentialTypeIdentifier, conf *Configuration, creds map[CredentialTypeIdentifier]struct{}) []IssueWizardItem {
 	if _, present := deps[id]; !present {
-		deps[id] = conf.CredentialTypes[id].Dependencies.WizardContents().ChoosePath(conf, creds)
+		// Credential types of schemes that are not in the configuration (e.g. when validating
+		// a single requestor scheme) are taken to have no dependencies
+		if credtype := conf.CredentialTypes[id]; credtype != nil {
+			deps[id] = credtype.Dependencies.WizardContents().ChoosePath(conf, creds)
+		} else {
+			deps[id] = nil
+		}
 	}
 	return deps[id]
 }
@@ -364,23 +478,12 @@ func (wizard *IssueWizard) Validate(conf *Configuration) error {
 	}
 
 	// validate that no possible content graph is too complex
-	allRelevantPaths := wizard.Contents.buildValidationPaths(conf, map[CredentialTypeIdentifier]struct{}{})
-	for _, contents := range allRelevantPaths {
-		// validate expanded dependency tree if ExpandDependencies flag is set to true; otherwise validate current length
-		if wizard.ExpandDependencies == nil || *wizard.ExpandDependencies {
-			result, err := buildDependencyTree(contents, conf, map[CredentialTypeIdentifier]struct{}{})
-			if err != nil {
-				return err
-			}
-
-			if len(result) >= maxWizardComplexity {
-				return errors.New("wizard too complex")
-			}
-		} else {
-			if len(contents) >= maxWizardComplexity {
-				return errors.New("wizard too complex")
-			}
-		}
+	complexity, err := wizard.Contents.complexity(conf, wizard.ExpandDependencies == nil || *wizard.ExpandDependencies)
+	if err != nil {
+		return err
+	}
+	if complexity >= maxWizardComplexity {
+		return errors.New("wizard too complex")
 	}
 
 	// validate translations, IssueWizardItems and FAQSummaries of dependencies
@@ -410,6 +513,26 @@ func (wizard *IssueWizard) Validate(conf *Configuration) error {
 	return nil
 }
 
+// complexity returns the length of the longest path through the wizard contents, with the
+// dependencies of all items included if expand is true.
+func (contents IssueWizardContents) complexity(conf *Configuration, expand bool) (int, error) {
+	max := 0
+	for _, path := range contents.buildValidationPaths(conf, map[CredentialTypeIdentifier]struct{}{}) {
+		// validate expanded dependency tree if expand is true; otherwise validate current length
+		if expand {
+			result, err := buildDependencyTree(path, conf, map[CredentialTypeIdentifier]struct{}{})
+			if err != nil {
+				return 0, err
+			}
+			path = result
+		}
+		if len(path) > max {
+			max = len(path)
+		}
+	}
+	return max, nil
+}
+
 func (contents IssueWizardContents) buildValidationPaths(conf *Configuration, creds map[CredentialTypeIdentifier]struct{}) [][]IssueWizardItem {
 	var all [][]IssueWizardItem
 	var choice []IssueWizardItem
@@ -630,6 +753,12 @@ func (ct *CredentialType) RevocationSupported() bool {
 	return len(ct.RevocationServers) > 0
 }
 
+// IsDeprecated returns whether the credential type is deprecated, i.e., whether its
+// DeprecatedSince lies in the past.
+func (ct *CredentialType) IsDeprecated() bool {
+	return deprecated(ct.DeprecatedSince)
+}
+
 // ContainsAttribute tests whether the specified attribute is contained in this
 // credentialtype.
 func (ct *CredentialType) ContainsAttribute(ai AttributeTypeIdentifier) bool {
@@ -680,10 +809,15 @@ type xmlTranslatedString struct {
 
 // MarshalXML implements xml.Marshaler.
 func (ts *TranslatedString) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	langs := make([]string, 0, len(*ts))
+	for lang := range *ts {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
 	temp := &xmlTranslatedString{}
-	for lang, text := range *ts {
+	for _, lang := range langs {
 		temp.Translations = append(temp.Translations,
-			xmlTranslation{XMLName: xml.Name{Local: lang}, Text: text},
+			xmlTranslation{XMLName: xml.Name{Local: lang}, Text: (*ts)[lang]},
 		)
 	}
 	return e.EncodeElement(temp, start)
@@ -707,8 +841,13 @@ func (ts *TranslatedString) UnmarshalXML(d *xml.Decoder, start xml.StartElement)
 }
 
 func (ts *TranslatedString) validate() []string {
+	return ts.missing(validLangs)
+}
+
+// missing returns the languages among langs for which the string has no (nonempty) translation.
+func (ts *TranslatedString) missing(langs []string) []string {
 	var invalidLangs []string
-	for _, lang := range validLangs {
+	for _, lang := range langs {
 		if text, exists := (*ts)[lang]; !exists || text == "" {
 			invalidLangs = append(invalidLangs, lang)
 
@@ -733,14 +872,33 @@ func (deps CredentialDependencies) WizardContents() IssueWizardContents {
 	return contents
 }
 
-func (deps *CredentialDependencies) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
-	var temp struct {
-		Or []struct {
-			And []struct {
-				Con []CredentialTypeIdentifier `xml:"CredentialType"`
-			}
+type xmlCredentialDependencies struct {
+	Or []xmlCredentialDisjunction
+}
+
+type xmlCredentialDisjunction struct {
+	And []xmlCredentialConjunction
+}
+
+type xmlCredentialConjunction struct {
+	Con []CredentialTypeIdentifier `xml:"CredentialType"`
+}
+
+// MarshalXML implements xml.Marshaler.
+func (deps CredentialDependencies) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	temp := &xmlCredentialDependencies{}
+	for _, discon := range deps {
+		or := xmlCredentialDisjunction{}
+		for _, con := range discon {
+			or.And = append(or.And, xmlCredentialConjunction{Con: con})
 		}
+		temp.Or = append(temp.Or, or)
 	}
+	return e.EncodeElement(temp, start)
+}
+
+func (deps *CredentialDependencies) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var temp xmlCredentialDependencies
 	if err := d.DecodeElement(&temp, &start); err != nil {
 		return err
 	}
@@ -787,6 +945,16 @@ func (id *Issuer) SchemeManagerIdentifier() SchemeManagerIdentifier {
 	return NewSchemeManagerIdentifier(id.SchemeManagerID)
 }
 
+// IsDeprecated returns whether the issuer is deprecated, i.e., whether its DeprecatedSince
+// lies in the past.
+func (id *Issuer) IsDeprecated() bool {
+	return deprecated(id.DeprecatedSince)
+}
+
+func deprecated(since Timestamp) bool {
+	return !since.IsZero() && !since.After(Timestamp(time.Now()))
+}
+
 func (ri *RequestorInfo) logoPath(scheme *RequestorScheme) string {
 	if ri.Logo != nil {
 		logoPath := filepath.Join(scheme.path(), "assets", *ri.Logo+".png")
@@ -11,6 +11,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/go-errors/errors"
 	"github.com/privacybydesign/gabi/big"
@@ -237,6 +238,10 @@ func iterateFiles(path string, onlyDirs bool, handler func(string, os.FileInfo)
 		if filepath.Base(file) == ".git" {
 			continue
 		}
+		// Skip hidden directories, such as the scheme history within irma_configuration
+		if onlyDirs && strings.HasPrefix(filepath.Base(file), ".") {
+			continue
+		}
 		err = handler(file, stat)
 		if err != nil {
 			return err
@@ -300,7 +305,7 @@ filenameloop:
 	for _, filename := range filenames {
 		files := []string{filename}
 		if expectSignature {
-			files = append(files, "timestamp", "index", "index.sig")
+			files = append(files, "timestamp", "index")
 		}
 		for _, file := range files {
 			exists, err := PathExists(filepath.Join(dir, file))
@@ -311,6 +316,16 @@ filenameloop:
 				continue filenameloop
 			}
 		}
+		if expectSignature {
+			// index.sig, or index.$i.sig for schemes signed by multiple keys
+			sigs, err := filepath.Glob(filepath.Join(dir, "index*.sig"))
+			if err != nil {
+				return false, err
+			}
+			if len(sigs) == 0 {
+				continue
+			}
+		}
 		return true, nil
 	}
 
@@ -467,3 +467,59 @@ func TestIssueExpiredKey(t *testing.T) {
 	_, _, err := irmaServer.StartSession(getIssuanceRequest(true), nil)
 	require.Error(t, err)
 }
+
+func TestDeprecatedRequest(t *testing.T) {
+	StartIrmaServer(t, false, "")
+	defer StopIrmaServer()
+	id := irma.NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID")
+	credtype := irmaServerConfiguration.IrmaConfiguration.CredentialTypes[id.CredentialTypeIdentifier()]
+	credtype.DeprecatedSince = irma.Timestamp(time.Now().Add(-time.Hour))
+	defer func() { credtype.DeprecatedSince = irma.Timestamp{} }()
+	defer func() { irmaServerConfiguration.RejectDeprecated = false }()
+
+	// disclosure of deprecated credential types is allowed, unless configured otherwise
+	_, _, err := irmaServer.StartSession(getDisclosureRequest(id), nil)
+	require.NoError(t, err)
+	irmaServerConfiguration.RejectDeprecated = true
+	_, _, err = irmaServer.StartSession(getDisclosureRequest(id), nil)
+	require.Error(t, err)
+	require.IsType(t, &irma.SessionError{}, err)
+	require.Equal(t, irma.ErrorDeprecated, err.(*irma.SessionError).ErrorType)
+
+	// issuance of deprecated credential types is refused
+	irmaServerConfiguration.RejectDeprecated = false
+	_, _, err = irmaServer.StartSession(getIssuanceRequest(true), nil)
+	require.Error(t, err)
+	require.IsType(t, &irma.SessionError{}, err)
+	require.Equal(t, irma.ErrorDeprecated, err.(*irma.SessionError).ErrorType)
+
+	// as is issuance of credential types of deprecated issuers
+	credtype.DeprecatedSince = irma.Timestamp{}
+	issuer := irmaServerConfiguration.IrmaConfiguration.Issuers[id.CredentialTypeIdentifier().IssuerIdentifier()]
+	issuer.DeprecatedSince = irma.Timestamp(time.Now().Add(-time.Hour))
+	_, _, err = irmaServer.StartSession(getIssuanceRequest(true), nil)
+	issuer.DeprecatedSince = irma.Timestamp{}
+	require.Error(t, err)
+	require.Equal(t, irma.ErrorDeprecated, err.(*irma.SessionError).ErrorType)
+
+	// credential types not yet deprecated are unaffected
+	credtype.DeprecatedSince = irma.Timestamp(time.Now().Add(time.Hour))
+	_, _, err = irmaServer.StartSession(getIssuanceRequest(true), nil)
+	require.NoError(t, err)
+}
+
+func TestDeprecatedRequestWarnings(t *testing.T) {
+	StartRequestorServer(JwtServerConfiguration)
+	defer StopRequestorServer()
+	id := irma.NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID")
+
+	pkg := startSession(t, getDisclosureRequest(id), "verification")
+	require.Empty(t, pkg.Warnings)
+
+	credtype := JwtServerConfiguration.IrmaConfiguration.CredentialTypes[id.CredentialTypeIdentifier()]
+	credtype.DeprecatedSince = irma.Timestamp(time.Now().Add(-time.Hour))
+	defer func() { credtype.DeprecatedSince = irma.Timestamp{} }()
+	pkg = startSession(t, getDisclosureRequest(id), "verification")
+	require.Len(t, pkg.Warnings, 1)
+	require.Contains(t, pkg.Warnings[0], id.CredentialTypeIdentifier().String())
+}
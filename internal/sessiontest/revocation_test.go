@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"path/filepath"
 	"testing"
 	"time"
@@ -228,6 +229,85 @@ func TestRevocationAll(t *testing.T) {
 		require.Equal(t, accindex+1, sacc1.Accumulator.Index)
 	})
 
+	t.Run("Webhook", func(t *testing.T) {
+		revocationConfiguration = revocationConf(t)
+		revocationConfiguration.RevocationSettings[revKeyshareTestCred].Webhooks = []string{
+			"http://localhost:48680/revocation/" + revKeyshareTestCred.String() + "/update",
+		}
+		startRevocationServer(t, true)
+		defer stopRevocationServer()
+		StartIrmaServer(t, false, "")
+		defer StopIrmaServer()
+
+		rev := irmaServerConfiguration.IrmaConfiguration.Revocation
+		require.NoError(t, rev.SyncDB(revKeyshareTestCred))
+		sacc, err := rev.Accumulator(revKeyshareTestCred, 3)
+		require.NoError(t, err)
+		acctime := sacc.Accumulator.Time
+		time.Sleep(time.Second)
+
+		// run scheduled update of accumulator, which should be pushed to our IRMA server's webhook
+		revocationConfiguration.IrmaConfiguration.Scheduler.RunAll()
+		require.Eventually(t, func() bool {
+			sacc, err := rev.Accumulator(revKeyshareTestCred, 3)
+			return err == nil && sacc.Accumulator.Time > acctime
+		}, 5*time.Second, 50*time.Millisecond)
+
+		// the revocation server itself does not accept updates
+		update, err := rev.UpdateLatest(revKeyshareTestCred, 0, nil)
+		require.NoError(t, err)
+		err = irma.NewHTTPTransport("http://localhost:48683", false).
+			Post("revocation/"+revKeyshareTestCred.String()+"/update", nil, update[3])
+		require.Error(t, err)
+
+		// nor does our IRMA server for credential types for which it is not configured
+		err = irma.NewHTTPTransport("http://localhost:48680", false).
+			Post("revocation/"+revocationTestCred.String()+"/update", nil, update[3])
+		require.Error(t, err)
+	})
+
+	t.Run("Stats", func(t *testing.T) {
+		revocationConfiguration = revocationConf(t)
+		revocationConfiguration.RevocationStats = true
+		startRevocationServer(t, true)
+		defer stopRevocationServer()
+
+		var stats []*irma.RevocationStats
+		transport := irma.NewHTTPTransport("http://localhost:48683", false)
+		require.NoError(t, transport.Get("revocation/"+revKeyshareTestCred.String()+"/stats?cycles=2", &stats))
+		require.NotEmpty(t, stats)
+		for _, s := range stats {
+			require.Equal(t, revKeyshareTestCred, s.CredType)
+			require.NotNil(t, s.AccumulatorIndex)
+			require.NotZero(t, s.Events)
+			require.Len(t, s.Expiring, 2)
+		}
+
+		// servers that are not the revocation authority of the credential type refuse, even when enabled
+		conf := &server.Configuration{
+			URL:                  "http://localhost:48680",
+			Logger:               logger,
+			DisableSchemesUpdate: true,
+			SchemesPath:          filepath.Join(testdata, "irma_configuration"),
+			RevocationSettings: irma.RevocationSettings{
+				revKeyshareTestCred: {RevocationServerURL: "http://localhost:48683"},
+			},
+			RevocationStats: true,
+		}
+		irmaserv, err := irmaserver.New(conf)
+		require.NoError(t, err)
+		defer irmaserv.Stop()
+		httpserv := httptest.NewServer(irmaserv.HandlerFunc())
+		defer httpserv.Close()
+		err = irma.NewHTTPTransport(httpserv.URL, false).
+			Get("revocation/"+revKeyshareTestCred.String()+"/stats", &stats)
+		require.Error(t, err)
+		serr, ok := err.(*irma.SessionError)
+		require.True(t, ok)
+		require.NotNil(t, serr.RemoteError)
+		require.Equal(t, string(server.ErrorInvalidRequest.Type), serr.RemoteError.ErrorName)
+	})
+
 	t.Run("NoKnownAccumulator", func(t *testing.T) {
 		client, handler := revocationSetup(t)
 		defer test.ClearTestStorage(t, handler.storage)
@@ -537,6 +617,89 @@ func TestRevocationAll(t *testing.T) {
 		}
 	})
 
+	t.Run("VerifyDB", func(t *testing.T) {
+		startRevocationServer(t, true)
+		defer stopRevocationServer()
+		rev := revocationConfiguration.IrmaConfiguration.Revocation
+		sacc, err := rev.Accumulator(revocationTestCred, revocationPkCounter)
+		require.NoError(t, err)
+		fakeMultipleRevocations(t, 5, rev, sacc.Accumulator)
+
+		// a freshly populated database contains no problems
+		problems, err := rev.VerifyDB()
+		require.NoError(t, err)
+		require.Empty(t, problems)
+
+		// corrupt the database by removing an event and modifying another
+		g, err := gorm.Open(revocationDbType, revocationDbStr)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, g.Close()) }()
+		where := "cred_type = ? and pk_counter = ? and eventindex = ?"
+		require.NoError(t, g.Delete(irma.EventRecord{}, where, revocationTestCred, revocationPkCounter, 2).Error)
+		require.NoError(t, g.Model(irma.EventRecord{}).Where(where, revocationTestCred, revocationPkCounter, 4).
+			Update("e", (*irma.RevocationAttribute)(big.NewInt(42))).Error)
+
+		problems, err = rev.VerifyDB(revocationTestCred)
+		require.NoError(t, err)
+		require.Len(t, problems, 2)
+		require.Equal(t, irma.RevocationDBProblemGap, problems[0].Type)
+		require.Equal(t, uint64(2), *problems[0].Index)
+		require.Equal(t, irma.RevocationDBProblemFork, problems[1].Type)
+		require.Equal(t, uint64(5), *problems[1].Index)
+	})
+
+	t.Run("Snapshot", func(t *testing.T) {
+		startRevocationServer(t, true)
+		rev := revocationConfiguration.IrmaConfiguration.Revocation
+		sacc, err := rev.Accumulator(revocationTestCred, revocationPkCounter)
+		require.NoError(t, err)
+		fakeMultipleRevocations(t, 5, rev, sacc.Accumulator)
+		insertIssuanceRecord(t, "1", rev, sacc.Accumulator)
+		sacc, err = rev.Accumulator(revocationTestCred, revocationPkCounter)
+		require.NoError(t, err)
+
+		snapshot, err := rev.ExportSnapshot([]irma.CredentialTypeIdentifier{revocationTestCred}, true)
+		require.NoError(t, err)
+		bts, err := json.Marshal(snapshot)
+		require.NoError(t, err)
+		stopRevocationServer()
+
+		// Import the snapshot into an empty database
+		clearRevocationDB(t)
+		conf, err := irma.NewConfiguration(filepath.Join(testdata, "irma_configuration"), irma.ConfigurationOptions{
+			ReadOnly:            true,
+			RevocationDBConnStr: revocationDbStr,
+			RevocationDBType:    revocationDbType,
+		})
+		require.NoError(t, err)
+		require.NoError(t, conf.ParseFolder())
+		defer func() { require.NoError(t, conf.Revocation.Close()) }()
+
+		// A snapshot with a tampered event is rejected
+		var tampered irma.RevocationSnapshot
+		require.NoError(t, json.Unmarshal(bts, &tampered))
+		tampered.Records[revocationTestCred][revocationPkCounter].Update.Events[3].E = big.NewInt(42)
+		require.Error(t, conf.Revocation.ImportSnapshot(&tampered))
+
+		var imported irma.RevocationSnapshot
+		require.NoError(t, json.Unmarshal(bts, &imported))
+		require.NoError(t, conf.Revocation.ImportSnapshot(&imported))
+
+		// Check that the imported state equals the exported state
+		sacc2, err := conf.Revocation.Accumulator(revocationTestCred, revocationPkCounter)
+		require.NoError(t, err)
+		require.Equal(t, sacc.Data, sacc2.Data)
+		problems, err := conf.Revocation.VerifyDB(revocationTestCred)
+		require.NoError(t, err)
+		require.Empty(t, problems)
+		rec, err := conf.Revocation.IssuanceRecords(revocationTestCred, "1", time.Time{})
+		require.NoError(t, err)
+		require.Len(t, rec, 1)
+
+		// Importing again fails since the state already exists
+		require.Error(t, conf.Revocation.ImportSnapshot(&imported))
+	})
+
 	t.Run("RevocationTolerance", func(t *testing.T) {
 		client, handler := revocationSetup(t)
 		defer test.ClearTestStorage(t, handler.storage)
@@ -767,15 +930,7 @@ func startRevocationServer(t *testing.T, droptables bool) {
 
 	// Connect to database and clear records from previous test runs
 	if droptables {
-		g, err := gorm.Open(revocationDbType, revocationDbStr)
-		require.NoError(t, err)
-		require.NoError(t, g.DropTableIfExists((*irma.EventRecord)(nil)).Error)
-		require.NoError(t, g.DropTableIfExists((*irma.AccumulatorRecord)(nil)).Error)
-		require.NoError(t, g.DropTableIfExists((*irma.IssuanceRecord)(nil)).Error)
-		require.NoError(t, g.AutoMigrate((*irma.EventRecord)(nil)).Error)
-		require.NoError(t, g.AutoMigrate((*irma.AccumulatorRecord)(nil)).Error)
-		require.NoError(t, g.AutoMigrate((*irma.IssuanceRecord)(nil)).Error)
-		require.NoError(t, g.Close())
+		clearRevocationDB(t)
 	}
 
 	// Start revocation server
@@ -792,6 +947,18 @@ func startRevocationServer(t *testing.T, droptables bool) {
 	}()
 }
 
+func clearRevocationDB(t *testing.T) {
+	g, err := gorm.Open(revocationDbType, revocationDbStr)
+	require.NoError(t, err)
+	require.NoError(t, g.DropTableIfExists((*irma.EventRecord)(nil)).Error)
+	require.NoError(t, g.DropTableIfExists((*irma.AccumulatorRecord)(nil)).Error)
+	require.NoError(t, g.DropTableIfExists((*irma.IssuanceRecord)(nil)).Error)
+	require.NoError(t, g.AutoMigrate((*irma.EventRecord)(nil)).Error)
+	require.NoError(t, g.AutoMigrate((*irma.AccumulatorRecord)(nil)).Error)
+	require.NoError(t, g.AutoMigrate((*irma.IssuanceRecord)(nil)).Error)
+	require.NoError(t, g.Close())
+}
+
 func stopRevocationServer() {
 	revocationServer.Stop()
 	_ = revocationHttpServer.Close()
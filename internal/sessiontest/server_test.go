@@ -86,7 +86,7 @@ func StartIrmaServer(t *testing.T, updatedIrmaConf bool, storage string) {
 		IssuerPrivateKeysPath: filepath.Join(testdata, "privatekeys"),
 		RevocationSettings: irma.RevocationSettings{
 			revocationTestCred:  {RevocationServerURL: "http://localhost:48683", SSE: true},
-			revKeyshareTestCred: {RevocationServerURL: "http://localhost:48683"},
+			revKeyshareTestCred: {RevocationServerURL: "http://localhost:48683", AcceptUpdates: true},
 		},
 	}
 	var err error
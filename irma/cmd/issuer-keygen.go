@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -10,6 +11,7 @@ import (
 
 	"github.com/go-errors/errors"
 	"github.com/privacybydesign/gabi/gabikeys"
+	irma "github.com/privacybydesign/irmago"
 	"github.com/privacybydesign/irmago/internal/common"
 	"github.com/sietseringers/cobra"
 )
@@ -25,6 +27,13 @@ The keygen command adds an IRMA issuer private/public keypair to the IRMA issuer
 By default the keys are stored within the PrivateKeys and PublicKeys subfolder of "path" (which are
 created if necessary), next to any existing private-public keypairs.
 
+With --encrypt, the private key is encrypted with a passphrase (see "irma issuer keys encrypt") and
+written with the .enc extension appended to its filename. Encrypted private keys are only loaded from
+a private keys folder (as passed to --privkeys of "irma server"), not from the PrivateKeys subfolder
+of the issuer, so --encrypt requires --privatekey to be specified. To be loaded, the private key must
+be named scheme.issuer.counter.xml, e.g. --privatekey privkeys/irma-demo.MijnOverheid.2.xml for the
+file privkeys/irma-demo.MijnOverheid.2.xml.enc.
+
 After adding keys, the scheme must be resigned (using "irma scheme sign") before it can be used in
 IRMA applications.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -37,36 +46,11 @@ IRMA applications.`,
 		overwrite, _ := flags.GetBool("force-overwrite")
 		expiryDateString, _ := flags.GetString("expirydate")
 		validFor, _ := flags.GetString("valid-for")
+		encrypt, _ := flags.GetBool("encrypt")
 
-		var expiryDate time.Time
-		var err error
-		if expiryDateString != "" {
-			expiryDate, err = time.Parse(time.RFC3339, expiryDateString)
-			if err != nil {
-				return errors.WrapPrefix(err, "Failed to parse expirydate", 0)
-			}
-		} else {
-			expiryDate = time.Now()
-			m := regexp.MustCompile(`^(\d+)([yMdhm])$`).FindStringSubmatch(validFor)
-			if m == nil {
-				return errors.New("unable to parse valid-for period")
-			}
-			num, err := strconv.Atoi(m[1])
-			if err != nil {
-				return errors.New("unable to parse valid-for period")
-			}
-			switch m[2] {
-			case "m":
-				expiryDate = expiryDate.Add(time.Minute * time.Duration(num))
-			case "h":
-				expiryDate = expiryDate.Add(time.Hour * time.Duration(num))
-			case "d":
-				expiryDate = expiryDate.AddDate(0, 0, num)
-			case "M":
-				expiryDate = expiryDate.AddDate(0, num, 0)
-			case "y":
-				expiryDate = expiryDate.AddDate(num, 0, 0)
-			}
+		expiryDate, err := parseKeyExpiry(expiryDateString, validFor)
+		if err != nil {
+			return err
 		}
 
 		var path string
@@ -83,45 +67,114 @@ IRMA applications.`,
 			return errors.WrapPrefix(err, "Nonexisting path specified", 0)
 		}
 
-		if counter == 0 {
-			counter = uint(defaultCounter(path))
+		var passphrase []byte
+		if encrypt {
+			if privkeyfile == "" {
+				return errors.New("--encrypt requires --privatekey to be specified")
+			}
+			if passphrase, err = cmdPassphrase(cmd, true); err != nil {
+				return err
+			}
 		}
 
-		// Now generate the key pair
-		fmt.Println("Generating keys (may take several minutes)")
-		sysParams, ok := gabikeys.DefaultSystemParameters[keylength]
-		if !ok {
-			return errors.Errorf("Unsupported key length, should be one of %v", gabikeys.DefaultKeyLengths)
+		return generateIssuerKeys(path, keylength, counter, numAttributes, expiryDate, privkeyfile, pubkeyfile, overwrite, passphrase)
+	},
+}
+
+// parseKeyExpiry determines the expiry date of a new key pair from either an RFC3339 date
+// or, if that is empty, a period starting now such as "1y".
+func parseKeyExpiry(expiryDateString, validFor string) (time.Time, error) {
+	var expiryDate time.Time
+	var err error
+	if expiryDateString != "" {
+		expiryDate, err = time.Parse(time.RFC3339, expiryDateString)
+		if err != nil {
+			return expiryDate, errors.WrapPrefix(err, "Failed to parse expirydate", 0)
+		}
+	} else {
+		expiryDate = time.Now()
+		m := regexp.MustCompile(`^(\d+)([yMdhm])$`).FindStringSubmatch(validFor)
+		if m == nil {
+			return expiryDate, errors.New("unable to parse valid-for period")
 		}
-		privk, pubk, err := gabikeys.GenerateKeyPair(sysParams, numAttributes, counter, expiryDate)
+		num, err := strconv.Atoi(m[1])
 		if err != nil {
-			return err
+			return expiryDate, errors.New("unable to parse valid-for period")
+		}
+		switch m[2] {
+		case "m":
+			expiryDate = expiryDate.Add(time.Minute * time.Duration(num))
+		case "h":
+			expiryDate = expiryDate.Add(time.Hour * time.Duration(num))
+		case "d":
+			expiryDate = expiryDate.AddDate(0, 0, num)
+		case "M":
+			expiryDate = expiryDate.AddDate(0, num, 0)
+		case "y":
+			expiryDate = expiryDate.AddDate(num, 0, 0)
 		}
+	}
+	return expiryDate, nil
+}
 
-		defaultFilename := strconv.Itoa(int(counter)) + ".xml"
-		if privkeyfile == "" {
-			keypath := filepath.Join(path, "PrivateKeys")
-			if err = common.EnsureDirectoryExists(keypath); err != nil {
-				return errors.WrapPrefix(err, "Failed to create"+keypath, 0)
-			}
-			privkeyfile = filepath.Join(keypath, defaultFilename)
+// generateIssuerKeys generates a new key pair for the issuer whose directory is specified
+// by path. If the key files are not specified, the keys are written to the PrivateKeys and
+// PublicKeys subfolders of the issuer directory. If passphrase is not empty, the private key
+// is encrypted with it.
+func generateIssuerKeys(
+	path string, keylength int, counter uint, numAttributes int, expiryDate time.Time,
+	privkeyfile, pubkeyfile string, overwrite bool, passphrase []byte,
+) error {
+	if counter == 0 {
+		counter = uint(defaultCounter(path))
+	}
+
+	// Now generate the key pair
+	fmt.Println("Generating keys (may take several minutes)")
+	sysParams, ok := gabikeys.DefaultSystemParameters[keylength]
+	if !ok {
+		return errors.Errorf("Unsupported key length, should be one of %v", gabikeys.DefaultKeyLengths)
+	}
+	privk, pubk, err := gabikeys.GenerateKeyPair(sysParams, numAttributes, counter, expiryDate)
+	if err != nil {
+		return err
+	}
+
+	defaultFilename := strconv.Itoa(int(counter)) + ".xml"
+	if privkeyfile == "" {
+		keypath := filepath.Join(path, "PrivateKeys")
+		if err = common.EnsureDirectoryExists(keypath); err != nil {
+			return errors.WrapPrefix(err, "Failed to create"+keypath, 0)
 		}
-		if pubkeyfile == "" {
-			keypath := filepath.Join(path, "PublicKeys")
-			if err = common.EnsureDirectoryExists(keypath); err != nil {
-				return errors.WrapPrefix(err, "Failed to create"+keypath, 0)
-			}
-			pubkeyfile = filepath.Join(keypath, defaultFilename)
+		privkeyfile = filepath.Join(keypath, defaultFilename)
+	}
+	if pubkeyfile == "" {
+		keypath := filepath.Join(path, "PublicKeys")
+		if err = common.EnsureDirectoryExists(keypath); err != nil {
+			return errors.WrapPrefix(err, "Failed to create"+keypath, 0)
 		}
+		pubkeyfile = filepath.Join(keypath, defaultFilename)
+	}
 
-		if _, err = privk.WriteToFile(privkeyfile, overwrite); err != nil {
-			return errors.New("private key file already exists, will not overwrite (force with -f flag)")
+	if len(passphrase) > 0 {
+		var buf bytes.Buffer
+		if _, err = privk.WriteTo(&buf); err != nil {
+			return err
 		}
-		if _, err = pubk.WriteToFile(pubkeyfile, overwrite); err != nil {
-			return errors.New("public key file already exists, will not overwrite (force with -f flag)")
+		encrypted, err := irma.EncryptPrivateKey(buf.Bytes(), passphrase)
+		if err != nil {
+			return err
 		}
-		return nil
-	},
+		if err = writePrivateKeyFile(privkeyfile+irma.EncryptedPrivateKeySuffix, encrypted, overwrite); err != nil {
+			return err
+		}
+	} else if _, err = privk.WriteToFile(privkeyfile, overwrite); err != nil {
+		return errors.New("private key file already exists, will not overwrite (force with -f flag)")
+	}
+	if _, err = pubk.WriteToFile(pubkeyfile, overwrite); err != nil {
+		return errors.New("public key file already exists, will not overwrite (force with -f flag)")
+	}
+	return nil
 }
 
 func defaultCounter(path string) (counter int) {
@@ -151,4 +204,6 @@ func init() {
 	issuerKeygenCmd.Flags().UintP("counter", "c", 0, "Override key counter")
 	issuerKeygenCmd.Flags().IntP("numattributes", "a", 12, "Number of attributes")
 	issuerKeygenCmd.Flags().BoolP("force-overwrite", "f", false, "Force overwriting of key files if files already exist")
+	issuerKeygenCmd.Flags().Bool("encrypt", false, "Encrypt the private key with a passphrase")
+	issuerKeygenCmd.Flags().String("passphrase-file", "", "File containing the passphrase with which to encrypt the private key (default: read from IRMA_PRIVKEYS_PASSPHRASE or prompt)")
 }
@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/gabi/gabikeys"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/sietseringers/cobra"
+)
+
+var issuerKeysSplitCmd = &cobra.Command{
+	Use:   "split <issuer> [<counter>]",
+	Short: "Split an issuer private key into shares for backup",
+	Long: `Split an issuer private key into shares for backup.
+
+The split command splits the private key of the specified issuer with the specified counter (default: the
+latest private key), including its revocation key if any, into the number of shares specified with --shares
+using Shamir secret sharing. The private key can be reconstructed from any --threshold of these shares using
+"irma issuer keys combine", while fewer shares reveal nothing about the private key. Each share is written
+to a separate file named issuer.counter.share-i.json in the directory specified with --output; store these
+in separate places.
+
+The private key is read from the scheme or from the private keys folder specified with --privkeys (which
+may contain encrypted private keys), and must match the public key in the scheme.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.Flags()
+		schemespath, _ := flags.GetString("schemes-path")
+		privkeyspath, _ := flags.GetString("privkeys")
+		threshold, _ := flags.GetInt("threshold")
+		count, _ := flags.GetInt("shares")
+		output, _ := flags.GetString("output")
+		overwrite, _ := flags.GetBool("force-overwrite")
+
+		conf, err := irma.NewConfiguration(schemespath, irma.ConfigurationOptions{ReadOnly: true})
+		if err != nil {
+			die("failed to open irma_configuration", err)
+		}
+		if err = conf.ParseFolder(); err != nil {
+			die("failed to parse irma_configuration", err)
+		}
+		if privkeyspath != "" {
+			addPrivateKeys(cmd, conf, privkeyspath)
+		}
+
+		id := irma.NewIssuerIdentifier(args[0])
+		if conf.Issuers[id] == nil {
+			die("", errors.Errorf("unknown issuer %s", id))
+		}
+		var sk *gabikeys.PrivateKey
+		if len(args) == 2 {
+			counter, err := strconv.ParseUint(args[1], 10, 32)
+			if err != nil {
+				die("invalid counter", err)
+			}
+			sk, err = conf.PrivateKeys.Get(id, uint(counter))
+		} else {
+			sk, err = conf.PrivateKeys.Latest(id)
+		}
+		if err != nil {
+			die("failed to load private key", err)
+		}
+
+		shares, err := irma.SplitPrivateKey(id, sk, threshold, count)
+		if err != nil {
+			die("failed to split private key", err)
+		}
+		for _, share := range shares {
+			bts, err := json.MarshalIndent(share, "", "  ")
+			if err != nil {
+				die("", err)
+			}
+			filename := filepath.Join(output, fmt.Sprintf("%s.%d.share-%d.json", id, sk.Counter, share.Index))
+			if err = writePrivateKeyFile(filename, append(bts, '\n'), overwrite); err != nil {
+				die("", err)
+			}
+			fmt.Println("Wrote", filename)
+		}
+		fmt.Printf("Split private key %s-%d into %d shares, %d of which are required to reconstruct it\n",
+			id, sk.Counter, count, threshold)
+	},
+}
+
+var issuerKeysCombineCmd = &cobra.Command{
+	Use:   "combine <share>...",
+	Short: "Reconstruct an issuer private key from its shares",
+	Long: `Reconstruct an issuer private key from its shares.
+
+The combine command reconstructs an issuer private key from the specified share files created by
+"irma issuer keys split", of which at least the threshold number specified when splitting is required.
+The checksums of the shares and the reconstructed private key are verified, and the private key is
+validated against the corresponding public key in the scheme.
+
+The private key is written to the file specified with --output (default: issuer.counter.xml in the current
+directory). With --encrypt, it is encrypted with a passphrase like "irma issuer keys encrypt" does, and .enc
+is appended to its filename.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.Flags()
+		schemespath, _ := flags.GetString("schemes-path")
+		output, _ := flags.GetString("output")
+		encrypt, _ := flags.GetBool("encrypt")
+		overwrite, _ := flags.GetBool("force-overwrite")
+
+		conf, err := irma.NewConfiguration(schemespath, irma.ConfigurationOptions{ReadOnly: true})
+		if err != nil {
+			die("failed to open irma_configuration", err)
+		}
+		if err = conf.ParseFolder(); err != nil {
+			die("failed to parse irma_configuration", err)
+		}
+
+		var shares []*irma.PrivateKeyShare
+		for _, file := range args {
+			bts, err := ioutil.ReadFile(file)
+			if err != nil {
+				die("failed to read share", err)
+			}
+			share := &irma.PrivateKeyShare{}
+			if err = json.Unmarshal(bts, share); err != nil {
+				die("failed to parse share "+file, err)
+			}
+			shares = append(shares, share)
+		}
+		sk, err := irma.CombinePrivateKeyShares(conf, shares)
+		if err != nil {
+			die("failed to reconstruct private key", err)
+		}
+
+		var buf bytes.Buffer
+		if _, err = sk.WriteTo(&buf); err != nil {
+			die("", err)
+		}
+		bts := buf.Bytes()
+		if output == "" {
+			output = fmt.Sprintf("%s.%d.xml", shares[0].Issuer, sk.Counter)
+		}
+		if encrypt {
+			passphrase, err := cmdPassphrase(cmd, true)
+			if err != nil {
+				die("", err)
+			}
+			if bts, err = irma.EncryptPrivateKey(bts, passphrase); err != nil {
+				die("failed to encrypt private key", err)
+			}
+			output += irma.EncryptedPrivateKeySuffix
+		}
+		if err = writePrivateKeyFile(output, bts, overwrite); err != nil {
+			die("", err)
+		}
+		fmt.Printf("Reconstructed private key %s-%d into %s\n", shares[0].Issuer, sk.Counter, output)
+	},
+}
+
+func init() {
+	flags := issuerKeysSplitCmd.Flags()
+	flags.StringP("schemes-path", "s", irma.DefaultSchemesPath(), "path to irma_configuration")
+	flags.StringP("privkeys", "k", "", "path to IRMA private keys")
+	flags.String("passphrase-file", "", "file containing the passphrase of encrypted private keys in --privkeys")
+	flags.IntP("threshold", "t", 0, "number of shares required to reconstruct the private key")
+	flags.IntP("shares", "n", 0, "number of shares to create")
+	flags.StringP("output", "o", ".", "directory to write the shares to")
+	flags.BoolP("force-overwrite", "f", false, "force overwriting of existing files")
+	_ = issuerKeysSplitCmd.MarkFlagRequired("threshold")
+	_ = issuerKeysSplitCmd.MarkFlagRequired("shares")
+	issuerKeysCmd.AddCommand(issuerKeysSplitCmd)
+
+	flags = issuerKeysCombineCmd.Flags()
+	flags.StringP("schemes-path", "s", irma.DefaultSchemesPath(), "path to irma_configuration")
+	flags.StringP("output", "o", "", "file to write the private key to")
+	flags.Bool("encrypt", false, "encrypt the private key with a passphrase")
+	flags.String("passphrase-file", "", "file containing the passphrase with which to encrypt the private key")
+	flags.BoolP("force-overwrite", "f", false, "force overwriting of existing files")
+	issuerKeysCmd.AddCommand(issuerKeysCombineCmd)
+}
@@ -0,0 +1,346 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/gabi/gabikeys"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/sietseringers/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+var issuerKeysCmd = &cobra.Command{
+	Use:     "keys",
+	Aliases: []string{"key"},
+	Short:   "Manage issuer key pairs",
+}
+
+var issuerKeysStatusCmd = &cobra.Command{
+	Use:   "status [<issuer>...]",
+	Short: "Show the expiry dates and private key presence of issuer keys",
+	Long: `Show the expiry dates and private key presence of issuer keys.
+
+For each public key of the specified issuers (or of all issuers, if none are specified), the status command
+shows its expiry date and whether its private key is present in the scheme or in the private keys folder
+specified with --privkeys. The latest key of which the private key is present is the one that IRMA servers
+issue with; it is marked as "expiring" if it expires within the number of days specified by --warn.
+
+The exit code is nonzero if the issuance key of any nondeprecated issuer whose private keys are present
+has expired or is expiring.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.Flags()
+		schemespath, _ := flags.GetString("schemes-path")
+		privkeyspath, _ := flags.GetString("privkeys")
+		warn, _ := flags.GetInt("warn")
+		asJSON, _ := flags.GetBool("json")
+
+		conf, err := irma.NewConfiguration(schemespath, irma.ConfigurationOptions{ReadOnly: true})
+		if err != nil {
+			die("failed to open irma_configuration", err)
+		}
+		if err = conf.ParseFolder(); err != nil {
+			die("failed to parse irma_configuration", err)
+		}
+		if privkeyspath != "" {
+			addPrivateKeys(cmd, conf, privkeyspath)
+		}
+
+		var statuses []*irma.IssuerKeyStatus
+		if len(args) == 0 {
+			if statuses, err = conf.IssuerKeyStatuses(); err != nil {
+				die("failed to determine issuer key status", err)
+			}
+		}
+		for _, arg := range args {
+			status, err := conf.IssuerKeyStatus(irma.NewIssuerIdentifier(arg))
+			if err != nil {
+				die("failed to determine issuer key status", err)
+			}
+			statuses = append(statuses, status)
+		}
+
+		if asJSON {
+			bts, _ := json.MarshalIndent(statuses, "", "  ")
+			fmt.Println(string(bts))
+		} else {
+			printIssuerKeyStatuses(statuses, warn)
+		}
+
+		boundary := time.Now().AddDate(0, 0, warn)
+		for _, status := range statuses {
+			if key := status.LatestPrivate(); key != nil && !status.Deprecated && key.Expired(boundary) {
+				os.Exit(1)
+			}
+		}
+	},
+}
+
+var issuerKeysEncryptCmd = &cobra.Command{
+	Use:   "encrypt <privatekey>...",
+	Short: "Encrypt issuer private keys with a passphrase",
+	Long: `Encrypt issuer private keys with a passphrase.
+
+The encrypt command encrypts the specified private key files using AES-256-GCM, with a key derived from a
+passphrase using scrypt, writing each encrypted key next to the private key with the .enc extension
+appended to its filename. When the private keys folder of the IRMA server (see --privkeys in "irma server")
+contains encrypted private keys named scheme.issuer.xml.enc or scheme.issuer.counter.xml.enc, the IRMA server
+requires the passphrase to decrypt them.
+
+The passphrase is read from the file specified with --passphrase-file, or else from the
+IRMA_PRIVKEYS_PASSPHRASE environment variable, or else it is prompted for.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.Flags()
+		remove, _ := flags.GetBool("remove")
+		overwrite, _ := flags.GetBool("force-overwrite")
+		passphrase, err := cmdPassphrase(cmd, true)
+		if err != nil {
+			die("", err)
+		}
+
+		for _, file := range args {
+			bts, err := ioutil.ReadFile(file)
+			if err != nil {
+				die("failed to read private key", err)
+			}
+			if irma.IsEncryptedPrivateKey(bts) {
+				die("", errors.Errorf("%s is already encrypted", file))
+			}
+			if _, err = gabikeys.NewPrivateKeyFromXML(string(bts), true); err != nil {
+				die("failed to parse private key "+file, err)
+			}
+			encrypted, err := irma.EncryptPrivateKey(bts, passphrase)
+			if err != nil {
+				die("failed to encrypt private key", err)
+			}
+			if err = writePrivateKeyFile(file+irma.EncryptedPrivateKeySuffix, encrypted, overwrite); err != nil {
+				die("", err)
+			}
+			if remove {
+				if err = os.Remove(file); err != nil {
+					die("failed to remove private key", err)
+				}
+			}
+		}
+	},
+}
+
+var issuerKeysDecryptCmd = &cobra.Command{
+	Use:   "decrypt <privatekey.enc>...",
+	Short: "Decrypt issuer private keys encrypted with a passphrase",
+	Long: `Decrypt issuer private keys encrypted with a passphrase.
+
+The decrypt command decrypts the specified private key files encrypted by "irma issuer keys encrypt" or
+"irma issuer keygen --encrypt", writing each decrypted key next to the encrypted key with the .enc extension
+removed from its filename.
+
+The passphrase is read from the file specified with --passphrase-file, or else from the
+IRMA_PRIVKEYS_PASSPHRASE environment variable, or else it is prompted for.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.Flags()
+		remove, _ := flags.GetBool("remove")
+		overwrite, _ := flags.GetBool("force-overwrite")
+		passphrase, err := cmdPassphrase(cmd, false)
+		if err != nil {
+			die("", err)
+		}
+
+		for _, file := range args {
+			if !strings.HasSuffix(file, irma.EncryptedPrivateKeySuffix) {
+				die("", errors.Errorf("%s does not have the %s extension", file, irma.EncryptedPrivateKeySuffix))
+			}
+			bts, err := ioutil.ReadFile(file)
+			if err != nil {
+				die("failed to read private key", err)
+			}
+			decrypted, err := irma.DecryptPrivateKey(bts, passphrase)
+			if err != nil {
+				die("failed to decrypt "+file, err)
+			}
+			if err = writePrivateKeyFile(strings.TrimSuffix(file, irma.EncryptedPrivateKeySuffix), decrypted, overwrite); err != nil {
+				die("", err)
+			}
+			if remove {
+				if err = os.Remove(file); err != nil {
+					die("failed to remove encrypted private key", err)
+				}
+			}
+		}
+	},
+}
+
+// addPrivateKeys adds the private keys in the specified folder to the configuration, decrypting
+// encrypted private keys (if any) using the passphrase obtained by cmdPassphrase().
+func addPrivateKeys(cmd *cobra.Command, conf *irma.Configuration, privkeyspath string) {
+	path, err := filepath.Abs(privkeyspath)
+	if err != nil {
+		die("", err)
+	}
+	ring, err := irma.NewPrivateKeyRingFolder(path, conf)
+	if err != nil {
+		die("failed to read private keys", err)
+	}
+	if err = conf.AddPrivateKeyRing(ring); err != nil {
+		die("failed to add private keys", err)
+	}
+
+	encrypted, err := filepath.Glob(filepath.Join(path, "*.xml"+irma.EncryptedPrivateKeySuffix))
+	if err != nil {
+		die("", err)
+	}
+	if len(encrypted) == 0 {
+		return
+	}
+	passphrase, err := cmdPassphrase(cmd, false)
+	if err != nil {
+		die("", err)
+	}
+	encring, err := irma.NewPrivateKeyRingEncryptedFolder(path, conf, passphrase)
+	if err != nil {
+		die("failed to decrypt private keys", err)
+	}
+	if err = conf.AddPrivateKeyRing(encring); err != nil {
+		die("failed to add private keys", err)
+	}
+}
+
+// privkeysPassphraseEnv is the environment variable from which all commands read the passphrase
+// of encrypted private keys, if it is not read from a file. The IRMA server also reads it from
+// IRMASERVER_PRIVKEYS_PASSPHRASE, like its other settings.
+const privkeysPassphraseEnv = "IRMA_PRIVKEYS_PASSPHRASE"
+
+// cmdPassphrase reads the passphrase for encrypted private keys from the file specified with
+// --passphrase-file, or else from the IRMA_PRIVKEYS_PASSPHRASE environment variable, or else
+// by prompting for it.
+func cmdPassphrase(cmd *cobra.Command, confirm bool) ([]byte, error) {
+	file, _ := cmd.Flags().GetString("passphrase-file")
+	return readPassphrase(file, os.Getenv(privkeysPassphraseEnv), confirm)
+}
+
+// readPassphrase returns the contents of the specified file (without trailing newline) if
+// specified, or else value if not empty, or else prompts for the passphrase if stdin is a terminal.
+func readPassphrase(file, value string, confirm bool) ([]byte, error) {
+	if file != "" {
+		bts, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, errors.WrapPrefix(err, "failed to read passphrase file", 0)
+		}
+		bts = bytes.TrimRight(bts, "\r\n")
+		if len(bts) == 0 {
+			return nil, errors.New("passphrase file is empty")
+		}
+		return bts, nil
+	}
+	if value != "" {
+		return []byte(value), nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return nil, errors.New("no passphrase specified for encrypted private keys")
+	}
+	fmt.Fprint(os.Stderr, "Private key passphrase: ")
+	passphrase, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	if len(passphrase) == 0 {
+		return nil, errors.New("passphrase must not be empty")
+	}
+	if confirm {
+		fmt.Fprint(os.Stderr, "Repeat passphrase: ")
+		repeated, err := terminal.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(passphrase, repeated) {
+			return nil, errors.New("passphrases do not match")
+		}
+	}
+	return passphrase, nil
+}
+
+// writePrivateKeyFile writes the private key to the specified file, readable only by its owner.
+func writePrivateKeyFile(filename string, bts []byte, overwrite bool) error {
+	mode := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !overwrite {
+		mode |= os.O_EXCL
+	}
+	f, err := os.OpenFile(filename, mode, 0600)
+	if err != nil {
+		if os.IsExist(err) {
+			return errors.Errorf("%s already exists, will not overwrite (force with -f flag)", filename)
+		}
+		return err
+	}
+	if _, err = f.Write(bts); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func printIssuerKeyStatuses(statuses []*irma.IssuerKeyStatus, warn int) {
+	now := time.Now()
+	boundary := now.AddDate(0, 0, warn)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ISSUER\tKEY\tEXPIRES\tSTATUS\tPRIVATE KEY\tREVOCATION")
+	for _, status := range statuses {
+		issuance := status.LatestPrivate()
+		for _, key := range status.Keys {
+			state := "valid"
+			switch {
+			case key.Expired(now):
+				state = "expired"
+			case key == issuance && key.Expired(boundary):
+				state = "expiring"
+			}
+			if status.Deprecated {
+				state += " (deprecated issuer)"
+			}
+			private := "-"
+			if key == issuance {
+				private = "present (issuing)"
+			} else if key.PrivateKey {
+				private = "present"
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%t\n",
+				status.Issuer, key.Counter, time.Time(key.ExpiryDate).Format("2006-01-02"),
+				state, private, key.RevocationSupported,
+			)
+		}
+	}
+	_ = w.Flush()
+}
+
+func init() {
+	flags := issuerKeysStatusCmd.Flags()
+	flags.StringP("schemes-path", "s", irma.DefaultSchemesPath(), "path to irma_configuration")
+	flags.StringP("privkeys", "k", "", "path to IRMA private keys")
+	flags.String("passphrase-file", "", "file containing the passphrase of encrypted private keys in --privkeys")
+	flags.Int("warn", 31, "mark issuance keys expiring within this many days")
+	flags.Bool("json", false, "output key status in JSON")
+
+	issuerKeysCmd.AddCommand(issuerKeysStatusCmd)
+
+	for _, cmd := range []*cobra.Command{issuerKeysEncryptCmd, issuerKeysDecryptCmd} {
+		flags = cmd.Flags()
+		flags.String("passphrase-file", "", "file containing the passphrase")
+		flags.Bool("remove", false, "remove the original files afterwards")
+		flags.BoolP("force-overwrite", "f", false, "force overwriting of existing files")
+		issuerKeysCmd.AddCommand(cmd)
+	}
+	issuerCmd.AddCommand(issuerKeysCmd)
+}
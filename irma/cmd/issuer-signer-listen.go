@@ -0,0 +1,17 @@
+// +build !windows
+
+package cmd
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenSignerSocket listens on the Unix socket at the specified path. The socket is created with
+// a umask that denies access to everyone but the owner, so that other users cannot connect to it
+// before its permissions are set.
+func listenSignerSocket(socket string) (net.Listener, error) {
+	umask := syscall.Umask(0177)
+	defer syscall.Umask(umask)
+	return net.Listen("unix", socket)
+}
@@ -0,0 +1,8 @@
+package cmd
+
+import "net"
+
+// listenSignerSocket listens on the Unix socket at the specified path.
+func listenSignerSocket(socket string) (net.Listener, error) {
+	return net.Listen("unix", socket)
+}
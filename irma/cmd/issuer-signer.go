@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"github.com/go-errors/errors"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/server"
+	"github.com/sietseringers/cobra"
+)
+
+var issuerSignerCmd = &cobra.Command{
+	Use:   "signer <socket>",
+	Short: "Run an issuer signer holding the issuer private keys",
+	Long: `Run an issuer signer holding the issuer private keys.
+
+The signer command loads the issuer private keys in the schemes and in the private keys folder specified
+with --privkeys (decrypting encrypted private keys, if any), and listens on the specified Unix socket for
+requests to perform the operations that require them: computing issuance signatures, nonrevocation
+witnesses and revocation accumulators, and signing accumulators and issuance records. It only signs
+messages of these types, after checking that they belong to the private key being used.
+
+IRMA servers use the signer when started with --signer-socket instead of --privkeys, so that the private
+keys never enter the IRMA server process. As anyone who can connect to the socket can use the private keys,
+the socket is created with the permissions specified by --socket-mode; ensure that only the user running
+the IRMA server can connect to it.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.Flags()
+		schemespath, _ := flags.GetString("schemes-path")
+		privkeyspath, _ := flags.GetString("privkeys")
+		modestr, _ := flags.GetString("socket-mode")
+		verbosity, _ := flags.GetCount("verbose")
+		logger.Level = server.Verbosity(verbosity)
+		irma.SetLogger(logger)
+
+		mode, err := strconv.ParseUint(modestr, 8, 32)
+		if err != nil {
+			die("invalid socket mode", err)
+		}
+		conf, err := irma.NewConfiguration(schemespath, irma.ConfigurationOptions{ReadOnly: true})
+		if err != nil {
+			die("failed to open irma_configuration", err)
+		}
+		if err = conf.ParseFolder(); err != nil {
+			die("failed to parse irma_configuration", err)
+		}
+		if privkeyspath != "" {
+			addPrivateKeys(cmd, conf, privkeyspath)
+		}
+		issuers := 0
+		for id := range conf.Issuers {
+			if counters, err := conf.IssuerSigner().Counters(id); err == nil && len(counters) > 0 {
+				issuers++
+			}
+		}
+
+		socket := args[0]
+		if info, err := os.Stat(socket); err == nil {
+			if info.Mode()&os.ModeSocket == 0 {
+				die("", errors.Errorf("%s exists and is not a socket", socket))
+			}
+			// Remove the socket left behind by an earlier signer that did not shut down cleanly
+			if err = os.Remove(socket); err != nil {
+				die("failed to remove existing socket", err)
+			}
+		}
+		listener, err := listenSignerSocket(socket)
+		if err != nil {
+			die("failed to listen on socket", err)
+		}
+		if err = os.Chmod(socket, os.FileMode(mode)); err != nil {
+			_ = listener.Close()
+			die("failed to set socket permissions", err)
+		}
+
+		interrupt := make(chan os.Signal, 1)
+		stopped := make(chan struct{})
+		signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-interrupt
+			logger.Info("Shutting down issuer signer")
+			close(stopped)
+			_ = listener.Close() // also removes the socket
+		}()
+
+		logger.Infof("Serving private keys of %d issuers at %s", issuers, socket)
+		err = http.Serve(listener, irma.NewIssuerSignerHandler(conf))
+		select {
+		case <-stopped:
+		default:
+			die("failed to run issuer signer", err)
+		}
+	},
+}
+
+func init() {
+	flags := issuerSignerCmd.Flags()
+	flags.StringP("schemes-path", "s", irma.DefaultSchemesPath(), "path to irma_configuration")
+	flags.StringP("privkeys", "k", "", "path to folder containing issuer private keys")
+	flags.String("passphrase-file", "", "file containing the passphrase of encrypted private keys (default: read from IRMA_PRIVKEYS_PASSPHRASE or prompt)")
+	flags.String("socket-mode", "600", "permissions of the socket (octal)")
+	flags.CountP("verbose", "v", "verbose (repeatable)")
+	issuerCmd.AddCommand(issuerSignerCmd)
+}
@@ -0,0 +1,354 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-errors/errors"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/sietseringers/cobra"
+)
+
+// requestorSchemeCmd represents the requestorscheme command
+var requestorSchemeCmd = &cobra.Command{
+	Use:   "requestorscheme",
+	Short: "Manage the requestors of an IRMA requestor scheme",
+}
+
+var requestorSchemeRequestorCmd = &cobra.Command{
+	Use:   "requestor --id <id> [<path>]",
+	Short: "Add or update a requestor",
+	Long: `Add or update a requestor.
+
+The requestor command adds the requestor with the specified ID to the requestor scheme at the specified path
+(or the current directory if not specified), or updates it if the scheme already contains it. When updating,
+only the properties specified as flags are changed. The ID may be specified with or without the scheme ID.
+
+The logo must be a PNG file; it is stored in the assets directory of the scheme, named after its SHA256 hash.
+Hostnames must not be in use by other requestors of the scheme. Use --valid-until=none to remove the date
+until which the requestor is valid.
+
+New requestors are added to the smallest chunk of the scheme, or to a new chunk if all chunks contain
+--chunk-size requestors. Afterwards, sign the scheme using "irma requestorscheme sign".`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.Flags()
+		chunkSize, _ := flags.GetInt("chunk-size")
+		scheme := readRequestorSchemeArg(args)
+
+		id, _ := flags.GetString("id")
+		if id == "" {
+			die("", errors.New("--id is required"))
+		}
+		requestorID := requestorIdentifier(scheme, id)
+		requestor := &irma.RequestorInfo{ID: requestorID, Name: irma.TranslatedString{}}
+		if existing := scheme.Requestor(requestorID); existing != nil {
+			copied := *existing
+			requestor = &copied
+		}
+
+		for _, lang := range []string{"en", "nl"} {
+			if flags.Changed("name-" + lang) {
+				requestor.Name = copyTranslation(requestor.Name)
+				requestor.Name[lang], _ = flags.GetString("name-" + lang)
+			}
+			if flags.Changed("industry-" + lang) {
+				industry := irma.TranslatedString{}
+				if requestor.Industry != nil {
+					industry = copyTranslation(*requestor.Industry)
+				}
+				industry[lang], _ = flags.GetString("industry-" + lang)
+				requestor.Industry = &industry
+			}
+		}
+		if flags.Changed("hostname") {
+			requestor.Hostnames, _ = flags.GetStringSlice("hostname")
+		}
+		if flags.Changed("unverified") {
+			requestor.Unverified, _ = flags.GetBool("unverified")
+		}
+		if flags.Changed("valid-until") {
+			validUntil, _ := flags.GetString("valid-until")
+			ts, err := parseValidUntil(validUntil)
+			if err != nil {
+				die("", err)
+			}
+			requestor.ValidUntil = ts
+		}
+		if flags.Changed("logo") {
+			logo := addRequestorSchemeLogo(cmd, scheme)
+			requestor.Logo = &logo
+		}
+
+		if err := scheme.SetRequestor(requestor); err != nil {
+			die("invalid requestor", err)
+		}
+		if err := scheme.WriteRequestors(chunkSize); err != nil {
+			die("failed to write requestors", err)
+		}
+		fmt.Printf("Wrote requestor %s\n", requestor.ID)
+	},
+}
+
+var requestorSchemeWizardCmd = &cobra.Command{
+	Use:   "wizard <wizard.json> [<path>]",
+	Short: "Add or update an issue wizard",
+	Long: `Add or update an issue wizard.
+
+The wizard command adds the issue wizard in the specified JSON file to its requestor in the requestor
+scheme at the specified path (or the current directory if not specified), or replaces the wizard having
+the same ID. The JSON file contains the wizard as it appears in the "wizards" of the requestor. If its ID
+does not contain the requestor ID, the requestor must be specified using --requestor.
+
+The wizard is validated before it is written. Specify the irma_configuration directory containing the
+issuer schemes of the credential types used by the wizard with --irmaconf, to check these credential types
+as well. The logo specified with --logo is stored in the assets of the scheme, as with requestors.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.Flags()
+		requestorID, _ := flags.GetString("requestor")
+		irmaconf, _ := flags.GetString("irmaconf")
+		scheme := readRequestorSchemeArg(args[1:])
+
+		bts, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			die("failed to read wizard", err)
+		}
+		wizard := &irma.IssueWizard{}
+		if err = json.Unmarshal(bts, wizard); err != nil {
+			die("failed to parse wizard", err)
+		}
+		if !strings.Contains(wizard.ID.String(), ".") {
+			if requestorID == "" {
+				die("", errors.New("--requestor is required if the wizard ID does not contain the requestor ID"))
+			}
+			wizard.ID = irma.NewIssueWizardIdentifier(
+				requestorIdentifier(scheme, requestorID).String() + "." + wizard.ID.String())
+		}
+		if flags.Changed("logo") {
+			logo := addRequestorSchemeLogo(cmd, scheme)
+			wizard.Logo = &logo
+		}
+
+		var conf *irma.Configuration
+		if irmaconf != "" {
+			if conf, err = irma.NewConfiguration(irmaconf, irma.ConfigurationOptions{ReadOnly: true}); err != nil {
+				die("failed to open irma_configuration", err)
+			}
+			if err = conf.ParseFolder(); err != nil {
+				die("failed to parse irma_configuration", err)
+			}
+		}
+		warnings, err := scheme.SetIssueWizard(conf, wizard)
+		if err != nil {
+			die("invalid issue wizard", err)
+		}
+		if err = scheme.WriteRequestors(0); err != nil {
+			die("failed to write requestors", err)
+		}
+		printNewWarnings(warnings)
+		fmt.Printf("Wrote issue wizard %s\n", wizard.ID)
+	},
+}
+
+var requestorSchemeChunkCmd = &cobra.Command{
+	Use:   "chunk --size <n> [<path>]",
+	Short: "Redistribute the requestors over chunks",
+	Long: `Redistribute the requestors over chunks.
+
+The requestors of a requestor scheme are stored in one or more chunks (requestors.json, requestors-2.json,
+and so on), of which clients only download the ones that changed. The chunk command redistributes the
+requestors of the scheme at the specified path (or the current directory if not specified), ordered by
+their IDs, over chunks containing at most --size requestors.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		size, _ := cmd.Flags().GetInt("size")
+		scheme := readRequestorSchemeArg(args)
+		if err := scheme.Rechunk(size); err != nil {
+			die("", err)
+		}
+		if err := scheme.WriteRequestors(size); err != nil {
+			die("failed to write requestors", err)
+		}
+		fmt.Printf("Distributed %d requestors over chunks of at most %d\n", len(scheme.Requestors()), size)
+	},
+}
+
+var requestorSchemeCheckCmd = &cobra.Command{
+	Use:   "check [<path>]",
+	Short: "Check the requestors for hostname conflicts",
+	Long: `Check the requestors for hostname conflicts.
+
+The check command checks that no hostname is used by more than one requestor of the requestor scheme at
+the specified path (or the current directory if not specified). It exits with a nonzero exit code if so.
+Use "irma scheme verify" to fully verify a signed scheme.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		scheme := readRequestorSchemeArg(args)
+		if !checkHostnameConflicts(scheme) {
+			os.Exit(1)
+		}
+		fmt.Printf("%d requestors, no hostname conflicts\n", len(scheme.Requestors()))
+	},
+}
+
+var requestorSchemeSignCmd = &cobra.Command{
+	Use:   "sign [<privatekey>] [<path>]",
+	Short: "Sign a requestor scheme",
+	Long: `Sign a requestor scheme.
+
+The sign command checks the requestors of the requestor scheme for hostname conflicts, and then signs it
+in the same way as "irma scheme sign", using the specified ECDSA key. Both arguments are optional;
+"sk.pem" and the working directory are the defaults. Use "irma scheme sign" for schemes signed by
+multiple keys.`,
+	Args: cobra.MaximumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.Flags()
+		sk := "sk.pem"
+		if len(args) > 0 {
+			sk = args[0]
+		}
+		path, err := os.Getwd()
+		if len(args) > 1 {
+			path, err = filepath.Abs(args[1])
+		}
+		if err != nil {
+			die("invalid path", err)
+		}
+
+		scheme, err := irma.ReadRequestorScheme(path)
+		if err != nil {
+			die("failed to read requestor scheme", err)
+		}
+		if !checkHostnameConflicts(scheme) {
+			die("", errors.New("requestor scheme has hostname conflicts"))
+		}
+
+		privatekey, err := readPrivateKey(sk)
+		if err != nil {
+			die("failed to read private key", err)
+		}
+		opts := schemeSignOptions{}
+		opts.skipVerification, _ = flags.GetBool("noverification")
+		opts.skipLog, _ = flags.GetBool("nolog")
+		if err = signScheme(privatekey, path, opts); err != nil {
+			die("failed to sign requestor scheme", err)
+		}
+	},
+}
+
+func readRequestorSchemeArg(args []string) *irma.RequestorScheme {
+	path, err := os.Getwd()
+	if len(args) > 0 {
+		path, err = filepath.Abs(args[0])
+	}
+	if err != nil {
+		die("invalid path", err)
+	}
+	scheme, err := irma.ReadRequestorScheme(path)
+	if err != nil {
+		die("failed to read requestor scheme", err)
+	}
+	return scheme
+}
+
+// requestorIdentifier returns the identifier of the requestor in the scheme, which may be specified
+// with or without the scheme ID.
+func requestorIdentifier(scheme *irma.RequestorScheme, id string) irma.RequestorIdentifier {
+	if !strings.Contains(id, ".") {
+		id = scheme.ID.String() + "." + id
+	}
+	return irma.NewRequestorIdentifier(id)
+}
+
+func addRequestorSchemeLogo(cmd *cobra.Command, scheme *irma.RequestorScheme) string {
+	path, _ := cmd.Flags().GetString("logo")
+	bts, err := ioutil.ReadFile(path)
+	if err != nil {
+		die("failed to read logo", err)
+	}
+	logo, err := scheme.AddLogo(bts)
+	if err != nil {
+		die("failed to add logo", err)
+	}
+	return logo
+}
+
+func copyTranslation(ts irma.TranslatedString) irma.TranslatedString {
+	copied := irma.TranslatedString{}
+	for lang, text := range ts {
+		copied[lang] = text
+	}
+	return copied
+}
+
+// parseValidUntil parses a date (2006-01-02) or an RFC3339 timestamp, or "none".
+func parseValidUntil(s string) (*irma.Timestamp, error) {
+	if s == "none" {
+		return nil, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		if t, err = time.Parse(time.RFC3339, s); err != nil {
+			return nil, errors.New("failed to parse --valid-until: must be a date (YYYY-MM-DD) or an RFC3339 timestamp")
+		}
+	}
+	ts := irma.Timestamp(t)
+	return &ts, nil
+}
+
+// checkHostnameConflicts prints the hostname conflicts of the scheme, returning whether there are none.
+func checkHostnameConflicts(scheme *irma.RequestorScheme) bool {
+	conflicts := scheme.HostnameConflicts()
+	hostnames := make([]string, 0, len(conflicts))
+	for hostname := range conflicts {
+		hostnames = append(hostnames, hostname)
+	}
+	sort.Strings(hostnames)
+	for _, hostname := range hostnames {
+		var ids []string
+		for _, id := range conflicts[hostname] {
+			ids = append(ids, id.String())
+		}
+		fmt.Printf("Hostname %s is used by multiple requestors: %s\n", hostname, strings.Join(ids, ", "))
+	}
+	return len(conflicts) == 0
+}
+
+func init() {
+	RootCmd.AddCommand(requestorSchemeCmd)
+
+	flags := requestorSchemeRequestorCmd.Flags()
+	flags.String("id", "", "requestor ID")
+	flags.String("name-en", "", "requestor name (English)")
+	flags.String("name-nl", "", "requestor name (Dutch)")
+	flags.String("industry-en", "", "industry of the requestor (English)")
+	flags.String("industry-nl", "", "industry of the requestor (Dutch)")
+	flags.StringSlice("hostname", nil, "hostname of the requestor (repeatable)")
+	flags.String("logo", "", "logo of the requestor (PNG file)")
+	flags.String("valid-until", "", "date (YYYY-MM-DD) until which the requestor is valid, or none")
+	flags.Bool("unverified", false, "whether the requestor is unverified")
+	flags.Int("chunk-size", 0, "maximum number of requestors per chunk for new requestors (default unlimited)")
+	requestorSchemeCmd.AddCommand(requestorSchemeRequestorCmd)
+
+	flags = requestorSchemeWizardCmd.Flags()
+	flags.String("requestor", "", "ID of the requestor of the wizard")
+	flags.String("logo", "", "logo of the wizard (PNG file)")
+	flags.String("irmaconf", "", "irma_configuration containing the credential types used by the wizard")
+	requestorSchemeCmd.AddCommand(requestorSchemeWizardCmd)
+
+	requestorSchemeChunkCmd.Flags().Int("size", 0, "maximum number of requestors per chunk")
+	requestorSchemeCmd.AddCommand(requestorSchemeChunkCmd)
+
+	requestorSchemeCmd.AddCommand(requestorSchemeCheckCmd)
+
+	requestorSchemeSignCmd.Flags().BoolP("noverification", "n", false, "Skip verification of the scheme after signing it")
+	requestorSchemeSignCmd.Flags().Bool("nolog", false, "Do not append the new index to the transparency log of the scheme")
+	requestorSchemeCmd.AddCommand(requestorSchemeSignCmd)
+}
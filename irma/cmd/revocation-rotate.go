@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	irma "github.com/privacybydesign/irmago"
+	"github.com/sietseringers/cobra"
+)
+
+var revocationRotateCmd = &cobra.Command{
+	Use:   "rotate <credentialtype> [<counter>]",
+	Short: "Move revocation of a credential type to a new issuer key pair",
+	Long: `Move revocation of a credential type to a new issuer key pair.
+
+After an issuer has rotated its keys, revocation of the credentials issued under the new key pair requires
+an initial accumulator for the new public key counter. The rotate command guides this process. It:
+
+ 1. checks that the new public key (by default the latest one in the scheme) has revocation parameters
+    (if not, add them with "irma issuer revocation-keypair" before installing the new key pair);
+ 2. creates the initial accumulator for the new key pair in the revocation database, unless it exists;
+ 3. checks that clients can fetch revocation updates for both the new and the older public key counters
+    from the revocation servers of the credential type, as specified in the scheme;
+ 4. reports the issuance records of older public key counters that are still in use (i.e. neither revoked
+    nor expired): the revocation state of these counters must be kept until these have expired.
+
+The issuer private key of the new key pair is required (see --privkeys, or install it in the scheme).`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.Flags()
+		asJSON, _ := flags.GetBool("json")
+		list, _ := flags.GetBool("list")
+		skipFetch, _ := flags.GetBool("skip-fetch")
+		conf := openRevocationDB(cmd)
+		defer closeRevocationDB(conf)
+
+		id := credentialTypeArgs(conf, args[:1])[0]
+		issid := id.IssuerIdentifier()
+		var counter uint
+		if len(args) == 2 {
+			c, err := strconv.ParseUint(args[1], 10, 32)
+			if err != nil {
+				die("invalid public key counter", err)
+			}
+			counter = uint(c)
+		} else {
+			indices, err := conf.PublicKeyIndices(issid)
+			if err != nil || len(indices) == 0 {
+				die("failed to find public keys of "+issid.String(), err)
+			}
+			counter = indices[len(indices)-1]
+		}
+
+		rotation, err := conf.Revocation.Rotate(id, counter)
+		if err != nil {
+			die("failed to rotate revocation key", err)
+		}
+		if !asJSON {
+			if rotation.Created {
+				fmt.Printf("Created initial accumulator for %s-%d\n", id, counter)
+			} else {
+				fmt.Printf("Accumulator for %s-%d already exists\n", id, counter)
+			}
+		}
+
+		if !skipFetch {
+			counters := []uint{counter}
+			for _, prev := range rotation.Previous {
+				counters = append(counters, prev.PKCounter)
+			}
+			checkRevocationUpdates(conf, id, counters, !asJSON)
+		}
+
+		if asJSON {
+			bts, _ := json.MarshalIndent(rotation, "", "  ")
+			fmt.Println(string(bts))
+			return
+		}
+		for _, prev := range rotation.Previous {
+			if len(prev.IssuanceRecords) == 0 {
+				fmt.Printf("%s-%d: no issuance records in use, its revocation state may be discarded\n", id, prev.PKCounter)
+				continue
+			}
+			var last int64
+			for _, r := range prev.IssuanceRecords {
+				if r.ValidUntil > last {
+					last = r.ValidUntil
+				}
+			}
+			fmt.Printf("%s-%d: %d issuance record(s) still in use, the last of which expires at %s\n",
+				id, prev.PKCounter, len(prev.IssuanceRecords), time.Unix(0, last).Format(time.RFC3339))
+			if list {
+				for _, r := range prev.IssuanceRecords {
+					fmt.Printf("  %s (issued %s, valid until %s)\n", r.Key,
+						time.Unix(0, r.Issued).Format(time.RFC3339), time.Unix(0, r.ValidUntil).Format(time.RFC3339))
+				}
+			}
+		}
+	},
+}
+
+// checkRevocationUpdates checks that the latest revocation update of each of the specified public
+// key counters can be fetched from the revocation servers of the credential type, like clients do,
+// and that they are validly signed.
+func checkRevocationUpdates(conf *irma.Configuration, id irma.CredentialTypeIdentifier, counters []uint, verbose bool) {
+	updates, err := irma.RevocationClient{Conf: conf}.FetchUpdatesLatest(id, 1)
+	if err != nil {
+		die("failed to fetch revocation updates", err)
+	}
+	for _, counter := range counters {
+		update := updates[counter]
+		if update == nil {
+			die(fmt.Sprintf("revocation server does not serve updates for %s-%d", id, counter), nil)
+		}
+		pk, err := conf.Revocation.Keys.PublicKey(id.IssuerIdentifier(), counter)
+		if err != nil {
+			die("", err)
+		}
+		if _, err = update.Verify(pk); err != nil {
+			die(fmt.Sprintf("invalid revocation update for %s-%d", id, counter), err)
+		}
+		if verbose {
+			fmt.Printf("Revocation updates for %s-%d can be fetched\n", id, counter)
+		}
+	}
+}
+
+func init() {
+	revocationDBFlags(revocationRotateCmd)
+	flags := revocationRotateCmd.Flags()
+	flags.Bool("json", false, "output result in JSON")
+	flags.Bool("list", false, "list the issuance records of older public key counters that are still in use")
+	flags.Bool("skip-fetch", false, "skip checking that revocation updates can be fetched from the revocation servers")
+	issuerRevocationCmd.AddCommand(revocationRotateCmd)
+}
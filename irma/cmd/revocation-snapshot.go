@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	irma "github.com/privacybydesign/irmago"
+	"github.com/sietseringers/cobra"
+)
+
+var revocationExportCmd = &cobra.Command{
+	Use:   "export <file> <credentialtype>...",
+	Short: "Export a snapshot of the revocation state of credential types to a file",
+	Long: `Export a snapshot of the revocation state of credential types to a file.
+
+The export command writes the accumulators and all revocation events of the specified credential types
+to the specified file, for each public key counter. The accumulators are signed by the issuer, and their
+signatures cover the events, so that the snapshot can be verified when it is imported. If --issuance-records
+is specified, the issuance records (needed for revoking credentials) are also included, signed with the
+issuer private key; in that case the issuer private keys are required (see --privkeys). Note that issuance
+records contain the revocation keys of issued credentials, so the file should be kept confidential.
+
+The snapshot can be imported in an empty revocation database using "irma issuer revocation import".`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		issuanceRecords, _ := cmd.Flags().GetBool("issuance-records")
+		conf := openRevocationDB(cmd)
+		defer closeRevocationDB(conf)
+
+		snapshot, err := conf.Revocation.ExportSnapshot(credentialTypeArgs(conf, args[1:]), issuanceRecords)
+		if err != nil {
+			die("failed to export revocation snapshot", err)
+		}
+		bts, err := json.Marshal(snapshot)
+		if err != nil {
+			die("failed to marshal revocation snapshot", err)
+		}
+		if err = ioutil.WriteFile(args[0], bts, 0600); err != nil {
+			die("failed to write revocation snapshot", err)
+		}
+	},
+}
+
+var revocationImportCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Import a snapshot of the revocation state of credential types from a file",
+	Long: `Import a snapshot of the revocation state of credential types from a file.
+
+The import command reads a snapshot created with "irma issuer revocation export", verifies the accumulators
+and event chains that it contains against the issuer public keys in the scheme, verifies the signatures over
+the issuance records if present, and stores them in the revocation database. The database must not already
+contain revocation state for the credential types and public key counters in the snapshot.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		bts, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			die("failed to read revocation snapshot", err)
+		}
+		var snapshot irma.RevocationSnapshot
+		if err = json.Unmarshal(bts, &snapshot); err != nil {
+			die("failed to unmarshal revocation snapshot", err)
+		}
+
+		conf := openRevocationDB(cmd)
+		defer closeRevocationDB(conf)
+		if err = conf.Revocation.ImportSnapshot(&snapshot); err != nil {
+			die("failed to import revocation snapshot", err)
+		}
+	},
+}
+
+func init() {
+	revocationDBFlags(revocationExportCmd)
+	revocationExportCmd.Flags().Bool("issuance-records", false, "include issuance records (requires issuer private keys)")
+	issuerRevocationCmd.AddCommand(revocationExportCmd)
+
+	revocationDBFlags(revocationImportCmd)
+	issuerRevocationCmd.AddCommand(revocationImportCmd)
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	irma "github.com/privacybydesign/irmago"
+	"github.com/sietseringers/cobra"
+)
+
+var revocationStatsCmd = &cobra.Command{
+	Use:   "stats [<credentialtype>...]",
+	Short: "Show statistics of a revocation database",
+	Long: fmt.Sprintf(`Show statistics of a revocation database.
+
+For each public key counter of the specified credential types (or of all credential types supporting
+revocation, if none are specified), the stats command shows the index of the current accumulator and
+the time it was last updated, the number of revocation events, the number of active and revoked issuance
+records, and how many issuance records will expire and be deleted in each of the next runs of the job
+that deletes expired issuance records (which runs every %s).`,
+		time.Duration(irma.RevocationParameters.DeleteIssuanceRecordsInterval)*time.Minute,
+	),
+	Run: func(cmd *cobra.Command, args []string) {
+		asJSON, _ := cmd.Flags().GetBool("json")
+		cycles, _ := cmd.Flags().GetUint("cycles")
+		conf := openRevocationDB(cmd)
+		defer closeRevocationDB(conf)
+
+		stats, err := conf.Revocation.Stats(cycles, credentialTypeArgs(conf, args)...)
+		if err != nil {
+			die("failed to compute revocation statistics", err)
+		}
+
+		if asJSON {
+			bts, _ := json.MarshalIndent(stats, "", "  ")
+			fmt.Println(string(bts))
+			return
+		}
+		printRevocationStats(stats)
+	},
+}
+
+func printRevocationStats(stats []*irma.RevocationStats) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CREDENTIAL TYPE\tKEY\tINDEX\tUPDATED\tEVENTS\tACTIVE\tREVOKED\tEXPIRING")
+	for _, s := range stats {
+		index, updated := "-", "-"
+		if s.AccumulatorIndex != nil {
+			index = fmt.Sprint(*s.AccumulatorIndex)
+			updated = s.Updated.Format(time.RFC3339)
+		}
+		expiring := make([]string, len(s.Expiring))
+		for i, count := range s.Expiring {
+			expiring[i] = fmt.Sprint(count)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%d\t%d\t%d\t%s\n",
+			s.CredType, s.PKCounter, index, updated, s.Events,
+			s.ActiveIssuanceRecords, s.RevokedIssuanceRecords, strings.Join(expiring, " "),
+		)
+	}
+	_ = w.Flush()
+}
+
+func init() {
+	revocationDBFlags(revocationStatsCmd)
+	revocationStatsCmd.Flags().Bool("json", false, "output statistics in JSON")
+	revocationStatsCmd.Flags().Uint("cycles", 3, "number of upcoming deletion runs for which to count expiring issuance records")
+	issuerRevocationCmd.AddCommand(revocationStatsCmd)
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/sietseringers/cobra"
+)
+
+var revocationVerifyDBCmd = &cobra.Command{
+	Use:   "verify-db [<credentialtype>...]",
+	Short: "Verify the integrity of a revocation database",
+	Long: `Verify the integrity of a revocation database.
+
+The verify-db command walks through all revocation events of the specified credential types (or all
+credential types supporting revocation, if none are specified) per public key counter. It checks that
+the accumulators are validly signed by the issuer's revocation public key found in the scheme, and that
+the events form an unbroken hash chain ending at the accumulator. Any gaps (missing events), forks
+(events not referring to their predecessor) or corrupted rows that are found are reported.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		asJSON, _ := cmd.Flags().GetBool("json")
+		conf := openRevocationDB(cmd)
+		defer closeRevocationDB(conf)
+
+		problems, err := conf.Revocation.VerifyDB(credentialTypeArgs(conf, args)...)
+		if err != nil {
+			die("failed to verify revocation database", err)
+		}
+
+		if asJSON {
+			bts, _ := json.MarshalIndent(problems, "", "  ")
+			fmt.Println(string(bts))
+		} else {
+			for _, problem := range problems {
+				fmt.Println(problem.String())
+			}
+		}
+		if len(problems) > 0 {
+			die(fmt.Sprintf("revocation database contains %d problem(s)", len(problems)), nil)
+		}
+		if !asJSON {
+			fmt.Println("Verification was successful.")
+		}
+	},
+}
+
+func init() {
+	revocationDBFlags(revocationVerifyDBCmd)
+	revocationVerifyDBCmd.Flags().Bool("json", false, "output problems in JSON")
+	issuerRevocationCmd.AddCommand(revocationVerifyDBCmd)
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/server"
+	"github.com/sietseringers/cobra"
+)
+
+var issuerRevocationCmd = &cobra.Command{
+	Use:   "revocation",
+	Short: "Manage the revocation database of an IRMA issuer",
+}
+
+// revocationDBFlags adds the flags required for opening a revocation database.
+func revocationDBFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.StringP("schemes-path", "s", irma.DefaultSchemesPath(), "path to irma_configuration")
+	flags.StringP("privkeys", "k", "", "path to IRMA private keys")
+	flags.String("passphrase-file", "", "file containing the passphrase of encrypted private keys in --privkeys")
+	flags.String("revocation-db-type", "postgres", "database type for revocation database (supported: mysql, postgres)")
+	flags.String("revocation-db-str", "", "connection string for revocation database")
+	flags.CountP("verbose", "v", "verbose (repeatable)")
+}
+
+// openRevocationDB parses irma_configuration and connects to the revocation database
+// as specified by the flags added by revocationDBFlags().
+func openRevocationDB(cmd *cobra.Command) *irma.Configuration {
+	flags := cmd.Flags()
+	schemespath, _ := flags.GetString("schemes-path")
+	privkeyspath, _ := flags.GetString("privkeys")
+	dbtype, _ := flags.GetString("revocation-db-type")
+	dbstr, _ := flags.GetString("revocation-db-str")
+	verbosity, _ := flags.GetCount("verbose")
+
+	logger.Level = server.Verbosity(verbosity)
+	irma.SetLogger(logger)
+
+	if dbstr == "" {
+		die("no revocation database specified (see --revocation-db-str)", nil)
+	}
+	conf, err := irma.NewConfiguration(schemespath, irma.ConfigurationOptions{
+		ReadOnly:            true,
+		RevocationDBType:    dbtype,
+		RevocationDBConnStr: dbstr,
+	})
+	if err != nil {
+		die("failed to open irma_configuration", err)
+	}
+	if err = conf.ParseFolder(); err != nil {
+		die("failed to parse irma_configuration", err)
+	}
+	if privkeyspath != "" {
+		addPrivateKeys(cmd, conf, privkeyspath)
+	}
+	return conf
+}
+
+func closeRevocationDB(conf *irma.Configuration) {
+	if err := conf.Revocation.Close(); err != nil {
+		logger.Warn("failed to close revocation database: ", err)
+	}
+}
+
+// credentialTypeArgs parses the arguments as credential type identifiers,
+// checking that they exist and support revocation.
+func credentialTypeArgs(conf *irma.Configuration, args []string) []irma.CredentialTypeIdentifier {
+	var ids []irma.CredentialTypeIdentifier
+	for _, arg := range args {
+		id := irma.NewCredentialTypeIdentifier(arg)
+		credtype := conf.CredentialTypes[id]
+		if credtype == nil {
+			die("unknown credential type "+arg, nil)
+		}
+		if !credtype.RevocationSupported() {
+			die("credential type "+arg+" does not support revocation", nil)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func init() {
+	issuerCmd.AddCommand(issuerRevocationCmd)
+}
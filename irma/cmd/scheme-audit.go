@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-errors/errors"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/sietseringers/cobra"
+)
+
+var schemeAuditCmd = &cobra.Command{
+	Use:   "audit <log>...",
+	Short: "Audit scheme transparency logs for forks",
+	Long: `Audit scheme transparency logs for forks.
+
+The audit command checks that each of the specified scheme transparency logs is a valid hash chain, and
+that the logs are consistent with each other: of each pair of logs, one must extend the other. If not,
+the log has forked, meaning that different versions of the scheme were published to different users
+(for example, using a stolen scheme key). To detect this, compare logs obtained from different sources,
+such as the scheme URL, mirrors, and the irma_configuration directories of different servers or users.
+
+Each log may be specified as a transparency.log file, a scheme directory, or a URL of a transparency.log
+file or of a scheme. For scheme directories, it is also checked that the current index of the scheme
+is in its log. The command exits with a nonzero exit code if any problem was found.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var (
+			logs     []irma.SchemeLog
+			problems int
+		)
+		for _, source := range args {
+			log, err := readSchemeLogSource(source)
+			if err != nil {
+				fmt.Printf("%s: %s\n", source, err.Error())
+				problems++
+				logs = append(logs, nil)
+				continue
+			}
+			logs = append(logs, log)
+			if len(log) == 0 {
+				fmt.Printf("%s: empty\n", source)
+				continue
+			}
+			latest := log[len(log)-1]
+			fmt.Printf("%s: %d entries, latest %s\n", source, len(log),
+				time.Time(latest.Timestamp).UTC().Format(time.RFC3339))
+		}
+
+		forks := irma.AuditSchemeLogs(logs...)
+		for i := range args {
+			for j := i + 1; j < len(args); j++ {
+				fork, ok := forks[[2]int{i, j}]
+				if !ok {
+					continue
+				}
+				problems++
+				fmt.Printf("FORK between %s and %s at entry %d: index %s and %s\n",
+					args[i], args[j], fork.Sequence, fork.Entries[0].Index, fork.Entries[1].Index)
+			}
+		}
+
+		if problems > 0 {
+			die(fmt.Sprintf("found %d problems", problems), nil)
+		}
+		fmt.Println("No problems found.")
+	},
+}
+
+// readSchemeLogSource reads a transparency log from a file, scheme directory or URL.
+func readSchemeLogSource(source string) (irma.SchemeLog, error) {
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		url := source
+		if !strings.HasSuffix(url, "/"+irma.SchemeLogFilename) {
+			url = strings.TrimSuffix(url, "/") + "/" + irma.SchemeLogFilename
+		}
+		res, err := http.Get(url)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = res.Body.Close()
+		}()
+		if res.StatusCode != http.StatusOK {
+			return nil, errors.Errorf("failed to download %s: %s", url, res.Status)
+		}
+		bts, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			return nil, err
+		}
+		return irma.ParseSchemeLog(bts)
+	}
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		bts, err := ioutil.ReadFile(source)
+		if err != nil {
+			return nil, err
+		}
+		return irma.ParseSchemeLog(bts)
+	}
+
+	log, err := irma.ReadSchemeLog(source)
+	if err != nil {
+		return nil, err
+	}
+	if log == nil {
+		return nil, errors.New("scheme has no transparency log")
+	}
+	index, err := ioutil.ReadFile(filepath.Join(source, "index"))
+	if err != nil {
+		return nil, err
+	}
+	if log.Contains(index) == nil {
+		return log, errors.New("current index of scheme is not in its transparency log")
+	}
+	return log, nil
+}
+
+func init() {
+	schemeCmd.AddCommand(schemeAuditCmd)
+}
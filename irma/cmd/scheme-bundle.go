@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	irma "github.com/privacybydesign/irmago"
+	"github.com/sietseringers/cobra"
+)
+
+var schemeBundleCmd = &cobra.Command{
+	Use:   "bundle [<path>]",
+	Short: "Export a scheme as a single archive",
+	Long: `Export a scheme as a single archive.
+
+The bundle command writes the scheme in the specified directory (or the current directory if not specified)
+to a single archive (a .tar.gz file), containing the scheme index, its signature and public key, and all
+files listed in the index. The scheme must be validly signed. The archive can be installed with
+"irma scheme install --bundle", for example in deployments that cannot reach the scheme's URL.
+
+If --output is not specified, the archive is written to <scheme>.tar.gz in the current directory.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		path, err := os.Getwd()
+		if len(args) > 0 {
+			path, err = filepath.Abs(args[0])
+		}
+		if err != nil {
+			die("invalid path", err)
+		}
+		output, _ := cmd.Flags().GetString("output")
+		if output == "" {
+			output = filepath.Base(path) + ".tar.gz"
+		}
+
+		f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			die("failed to create bundle", err)
+		}
+		if err = irma.WriteSchemeBundle(path, f); err != nil {
+			_ = f.Close()
+			_ = os.Remove(output)
+			die("failed to write bundle", err)
+		}
+		if err = f.Close(); err != nil {
+			die("failed to write bundle", err)
+		}
+		fmt.Println("Wrote scheme bundle to " + output)
+	},
+}
+
+func init() {
+	schemeBundleCmd.Flags().StringP("output", "o", "", "file to write the bundle to")
+	schemeCmd.AddCommand(schemeBundleCmd)
+}
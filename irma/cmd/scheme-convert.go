@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/internal/common"
+	"github.com/sietseringers/cobra"
+)
+
+var schemeConvertCmd = &cobra.Command{
+	Use:   "convert [--to xml|json|yaml] [--output <file>] <file>",
+	Short: "Convert issuer and credential type descriptions between XML, JSON and YAML",
+	Long: `Convert issuer and credential type descriptions between XML, JSON and YAML.
+
+Issuer and credential type descriptions may be authored in JSON or YAML instead of XML. The convert
+command converts such a description to the canonical XML form, which must be written to description.xml
+in the issuer or credential type directory before the scheme is signed. The JSON or YAML source file may
+be kept alongside, as only XML files are included in the scheme index. Conversely, the convert command
+converts an existing description.xml to JSON or YAML for editing. No information is lost in conversions.
+
+The format of the specified file is determined from its extension (.xml, .json, .yaml or .yml). The output
+format is specified by --to, or else determined by the extension of the --output file; it defaults to XML
+for JSON and YAML files, and to YAML for XML files. If --output is not specified, the converted description
+is written to standard output.
+
+In the JSON and YAML formats, the fields are named after the XML elements in camelCase, for example:
+
+  version: 4
+  scheme: irma-demo
+  issuer: MijnOverheid
+  id: fullName
+  name:
+    en: Demo Name
+    nl: Demo Naam
+  issueUrl:
+    en: https://example.com
+  dependencies: [[[irma-demo.MijnOverheid.root]]]
+  attributes:
+  - id: firstname
+    name:
+      en: First name
+      nl: Voornaam
+    optional: true
+
+A description containing an issuer field is a credential type; otherwise it is an issuer.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.Flags()
+		to, _ := flags.GetString("to")
+		output, _ := flags.GetString("output")
+
+		from, err := irma.DescriptionFormatOf(args[0])
+		if err != nil {
+			die("", err)
+		}
+		var format irma.DescriptionFormat
+		switch {
+		case to != "":
+			format = irma.DescriptionFormat(to)
+		case output != "":
+			if format, err = irma.DescriptionFormatOf(output); err != nil {
+				die("", err)
+			}
+		case from == irma.DescriptionFormatXML:
+			format = irma.DescriptionFormatYAML
+		default:
+			format = irma.DescriptionFormatXML
+		}
+
+		bts, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			die("failed to read description", err)
+		}
+		converted, err := irma.ConvertDescription(bts, from, format)
+		if err != nil {
+			die("failed to convert description", err)
+		}
+
+		if output == "" {
+			fmt.Print(string(converted))
+			return
+		}
+		if err = common.SaveFile(output, converted); err != nil {
+			die("failed to write description", err)
+		}
+	},
+}
+
+func init() {
+	schemeConvertCmd.Flags().String("to", "", "output format (xml, json or yaml)")
+	schemeConvertCmd.Flags().StringP("output", "o", "", "file to write the converted description to")
+	schemeCmd.AddCommand(schemeConvertCmd)
+}
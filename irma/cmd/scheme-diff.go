@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/go-errors/errors"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/internal/common"
+	"github.com/sietseringers/cobra"
+)
+
+var schemeDiffCmd = &cobra.Command{
+	Use:   "diff <old> <new>",
+	Short: "Show the differences in content between two versions of a scheme",
+	Long: `Show the differences in content between two versions of a scheme.
+
+The diff command parses the two specified scheme directories, containing an old and a new version of the
+same issuer scheme, and reports the differences in their contents: added, removed and deprecated issuers
+and credential types; added, removed and moved attributes; added and removed public keys; and changed
+translations and revocation servers.
+
+Changes marked as breaking alter the indices of attributes within credentials of the credential type,
+so that credentials issued before the change can no longer be used with the new version of the scheme.
+
+Both scheme directories must be validly signed. To review changes before signing, use --unsigned: in that
+case the schemes are copied to a temporary directory and signed there with a throwaway key, so that the
+signatures of the schemes are not checked.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		asJSON, _ := cmd.Flags().GetBool("json")
+		unsigned, _ := cmd.Flags().GetBool("unsigned")
+
+		oldconf, oldid, oldtmp := parseSchemeVersion(args[0], unsigned)
+		defer os.RemoveAll(oldtmp)
+		newconf, newid, newtmp := parseSchemeVersion(args[1], unsigned)
+		defer os.RemoveAll(newtmp)
+		if oldid != newid {
+			die(fmt.Sprintf("cannot compare different schemes %s and %s", oldid, newid), nil)
+		}
+
+		diff, err := irma.DiffSchemes(oldconf, newconf, oldid)
+		if err != nil {
+			die("failed to compare schemes", err)
+		}
+
+		if asJSON {
+			bts, _ := json.MarshalIndent(diff, "", "  ")
+			fmt.Println(string(bts))
+			return
+		}
+		if len(diff.Changes) == 0 {
+			fmt.Println("No changes found.")
+			return
+		}
+		for _, change := range diff.Changes {
+			fmt.Println(change.String())
+		}
+	},
+}
+
+// parseSchemeVersion parses the issuer scheme at the specified path into a new configuration.
+// If unsigned, the scheme is parsed from a signed copy in a temporary directory, which is
+// returned so that the caller can remove it when done.
+func parseSchemeVersion(path string, unsigned bool) (*irma.Configuration, irma.SchemeManagerIdentifier, string) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		die("invalid path", err)
+	}
+	var tmp string
+	if unsigned {
+		if path, err = signedSchemeCopy(path); err != nil {
+			die("failed to sign copy of scheme", err)
+		}
+		tmp = filepath.Dir(path)
+	}
+
+	conf, err := irma.NewConfiguration(filepath.Dir(path), irma.ConfigurationOptions{ReadOnly: true})
+	if err != nil {
+		die("failed to create configuration", err)
+	}
+	scheme, err := conf.ParseSchemeFolder(path)
+	if err != nil {
+		die("failed to parse scheme "+path, err)
+	}
+	sm, ok := scheme.(*irma.SchemeManager)
+	if !ok {
+		die("comparing requestor schemes is not supported", nil)
+	}
+	return conf, sm.Identifier(), tmp
+}
+
+// signedSchemeCopy copies the scheme at the specified path to a temporary directory
+// and signs it there with a new key, returning the path of the copy.
+func signedSchemeCopy(path string) (string, error) {
+	dir, err := ioutil.TempDir("", "irmascheme")
+	if err != nil {
+		return "", err
+	}
+	dest := filepath.Join(dir, filepath.Base(path))
+	if err = common.CopyDirectory(path, dest); err != nil {
+		return "", err
+	}
+	sk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	// Sign with just the new key, even if the scheme is signed by multiple keys
+	if err = os.Remove(filepath.Join(dest, "pk.pem")); err != nil && !os.IsNotExist(err) {
+		_ = os.RemoveAll(dir)
+		return "", err
+	}
+	if err = signScheme(sk, dest, schemeSignOptions{skipVerification: true, skipLog: true}); err != nil {
+		_ = os.RemoveAll(dir)
+		return "", errors.WrapPrefix(err, "failed to sign scheme", 0)
+	}
+	return dest, nil
+}
+
+func init() {
+	schemeDiffCmd.Flags().Bool("json", false, "output changes in JSON")
+	schemeDiffCmd.Flags().Bool("unsigned", false, "do not check the signatures of the schemes")
+	schemeCmd.AddCommand(schemeDiffCmd)
+}
@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/go-errors/errors"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/sietseringers/cobra"
+)
+
+var schemeHistoryCmd = &cobra.Command{
+	Use:   "history <path>",
+	Short: "List the previous versions of a scheme",
+	Long: `List the previous versions of a scheme.
+
+The history command lists the current version of the scheme at the specified path, the previous versions
+of the scheme that are kept in the scheme history, and the version to which the scheme is pinned, if any.
+Previous versions are kept when updating a scheme using "irma scheme update --history" or by an IRMA server
+with --schemes-history. Versions are identified by their Unix timestamp.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		conf, scheme := parseHistoryScheme(args[0])
+		versions, err := conf.SchemeHistory(scheme)
+		if err != nil {
+			die("failed to read scheme history", err)
+		}
+		pin, err := conf.SchemePin(scheme)
+		if err != nil {
+			die("failed to read scheme pin", err)
+		}
+
+		current := schemeTimestamp(scheme)
+		fmt.Printf("%s\t(current)\n", formatSchemeVersion(current))
+		for _, v := range versions {
+			fmt.Println(formatSchemeVersion(v.Timestamp))
+		}
+		if pin != nil {
+			fmt.Printf("\nPinned to version %s\n", formatSchemeVersion(*pin))
+		}
+	},
+}
+
+var schemeRollbackCmd = &cobra.Command{
+	Use:   "rollback <path> [<version>]",
+	Short: "Roll back a scheme to a previous version",
+	Long: `Roll back a scheme to a previous version.
+
+The rollback command replaces the scheme at the specified path with the specified previous version from
+the scheme history (as listed by "irma scheme history"), or with the most recent previous version if no
+version is specified. The scheme is then pinned to that version, so that it is not updated again until
+it is unpinned using "irma scheme pin --remove".`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		conf, scheme := parseHistoryScheme(args[0])
+		var version *irma.Timestamp
+		if len(args) > 1 {
+			version = parseVersionTimestamp(args[1])
+		}
+		if err := conf.RollbackScheme(scheme, version); err != nil {
+			die("failed to roll back scheme", err)
+		}
+		pin, err := conf.SchemePin(scheme)
+		if err != nil {
+			die("failed to read scheme pin", err)
+		}
+		fmt.Printf("Rolled back scheme to version %s\n", formatSchemeVersion(*pin))
+	},
+}
+
+var schemePinCmd = &cobra.Command{
+	Use:   "pin [--remove] <path> [<version>]",
+	Short: "Pin a scheme to a version",
+	Long: `Pin a scheme to a version.
+
+The pin command pins the scheme at the specified path to the specified version, or to its current
+version if no version is specified: the scheme is then not updated to versions newer than that.
+Use --remove to unpin the scheme, so that it is updated again.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		remove, _ := cmd.Flags().GetBool("remove")
+		conf, scheme := parseHistoryScheme(args[0])
+		if remove {
+			if len(args) > 1 {
+				die("cannot specify a version with --remove", nil)
+			}
+			if err := conf.UnpinScheme(scheme); err != nil {
+				die("failed to unpin scheme", err)
+			}
+			fmt.Println("Unpinned scheme")
+			return
+		}
+
+		var version *irma.Timestamp
+		if len(args) > 1 {
+			version = parseVersionTimestamp(args[1])
+		}
+		if err := conf.PinScheme(scheme, version); err != nil {
+			die("failed to pin scheme", err)
+		}
+		pin, err := conf.SchemePin(scheme)
+		if err != nil {
+			die("failed to read scheme pin", err)
+		}
+		fmt.Printf("Pinned scheme to version %s\n", formatSchemeVersion(*pin))
+	},
+}
+
+// parseHistoryScheme parses the scheme at the specified path in a Configuration of its parent
+// directory, which contains the scheme history.
+func parseHistoryScheme(path string) (*irma.Configuration, irma.Scheme) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		die("invalid path", err)
+	}
+	conf, err := irma.NewConfiguration(filepath.Dir(path), irma.ConfigurationOptions{})
+	if err != nil {
+		die("failed to open irma_configuration directory", err)
+	}
+	scheme, err := conf.ParseSchemeFolder(path)
+	if err != nil {
+		die("failed to parse scheme", err)
+	}
+	return conf, scheme
+}
+
+func schemeTimestamp(scheme irma.Scheme) irma.Timestamp {
+	switch s := scheme.(type) {
+	case *irma.SchemeManager:
+		return s.Timestamp
+	case *irma.RequestorScheme:
+		return s.Timestamp
+	default:
+		return irma.Timestamp{}
+	}
+}
+
+func parseVersionTimestamp(version string) *irma.Timestamp {
+	unix, err := strconv.ParseInt(version, 10, 64)
+	if err != nil {
+		die("invalid version", errors.New("version must be a Unix timestamp"))
+	}
+	ts := irma.Timestamp(time.Unix(unix, 0))
+	return &ts
+}
+
+func formatSchemeVersion(ts irma.Timestamp) string {
+	return fmt.Sprintf("%s\t%s", ts.String(), time.Time(ts).UTC().Format(time.RFC3339))
+}
+
+func init() {
+	schemePinCmd.Flags().Bool("remove", false, "unpin the scheme")
+	schemeCmd.AddCommand(schemeHistoryCmd)
+	schemeCmd.AddCommand(schemeRollbackCmd)
+	schemeCmd.AddCommand(schemePinCmd)
+}
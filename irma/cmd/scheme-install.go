@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/internal/common"
+	"github.com/sietseringers/cobra"
+)
+
+var schemeInstallCmd = &cobra.Command{
+	Use:   "install --bundle <file> [<path>]",
+	Short: "Install or update a scheme from a bundle",
+	Long: `Install or update a scheme from a bundle.
+
+The install command installs the scheme contained in the bundle specified by --bundle (as created by "irma
+scheme bundle") into the specified irma_configuration directory, or the default irma_configuration
+directory if not specified. If the scheme is already installed, it is updated to the version in the
+bundle, if that is newer than the installed version.
+
+When installing a new scheme, the signature of the scheme in the bundle is verified against the public
+key specified with --publickey. Alternatively, --dangerous-tofu trusts the public key contained in the
+bundle, which is only safe if the bundle was obtained from a trusted source. When updating, the signature
+is verified against the public key of the installed scheme, and neither flag may be specified.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		bundlePath, _ := cmd.Flags().GetString("bundle")
+		pkPath, _ := cmd.Flags().GetString("publickey")
+		tofu, _ := cmd.Flags().GetBool("dangerous-tofu")
+		if bundlePath == "" {
+			die("no bundle specified", nil)
+		}
+		if pkPath != "" && tofu {
+			die("--publickey and --dangerous-tofu are mutually exclusive", nil)
+		}
+
+		path := irma.DefaultSchemesPath()
+		if len(args) > 0 {
+			path = args[0]
+		}
+		if path == "" {
+			die("Failed to determine default irma_configuration path", nil)
+		}
+		if err := common.EnsureDirectoryExists(path); err != nil {
+			die("Failed to create irma_configuration directory", err)
+		}
+		conf, err := irma.NewConfiguration(path, irma.ConfigurationOptions{})
+		if err != nil {
+			die("failed to open irma_configuration directory", err)
+		}
+		if err = conf.ParseFolder(); err != nil {
+			die("failed to parse irma_configuration directory", err)
+		}
+
+		bts, err := ioutil.ReadFile(bundlePath)
+		if err != nil {
+			die("failed to read bundle", err)
+		}
+		id, err := irma.SchemeBundleID(bytes.NewReader(bts))
+		if err != nil {
+			die("invalid bundle", err)
+		}
+
+		_, issuerScheme := conf.SchemeManagers[irma.NewSchemeManagerIdentifier(id)]
+		_, requestorScheme := conf.RequestorSchemes[irma.NewRequestorSchemeIdentifier(id)]
+		if issuerScheme || requestorScheme {
+			if pkPath != "" || tofu {
+				die("scheme "+id+" is already installed; updates are verified against its installed public key, so --publickey and --dangerous-tofu cannot be used", nil)
+			}
+			if err = conf.UpdateSchemeBundle(bytes.NewReader(bts), nil); err != nil {
+				die("failed to update scheme", err)
+			}
+			fmt.Printf("Updated scheme %s from %s\n", id, bundlePath)
+			return
+		}
+
+		if tofu {
+			fmt.Println("Warning: trusting the public key contained in the bundle, without verifying it against a known public key")
+			err = conf.DangerousTOFUInstallSchemeBundle(bytes.NewReader(bts))
+		} else if pkPath == "" {
+			die("no public key specified; specify it with --publickey (or use --dangerous-tofu to trust the key in the bundle)", nil)
+		} else {
+			var pk []byte
+			if pk, err = ioutil.ReadFile(pkPath); err != nil {
+				die("failed to read public key", err)
+			}
+			err = conf.InstallSchemeBundle(bytes.NewReader(bts), pk)
+		}
+		if err != nil {
+			die("failed to install scheme", err)
+		}
+		fmt.Printf("Installed scheme %s from %s\n", id, bundlePath)
+	},
+}
+
+func init() {
+	schemeInstallCmd.Flags().String("bundle", "", "scheme bundle to install")
+	schemeInstallCmd.Flags().String("publickey", "", "public key of the scheme to verify a new scheme against")
+	schemeInstallCmd.Flags().Bool("dangerous-tofu", false, "trust the public key contained in the bundle when installing a new scheme")
+	schemeCmd.AddCommand(schemeInstallCmd)
+}
@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-errors/errors"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/internal/common"
+	"github.com/sietseringers/cobra"
+)
+
+var schemeLintCmd = &cobra.Command{
+	Use:   "lint [<path>]",
+	Short: "Check the contents of schemes for problems",
+	Long: `Check the contents of schemes for problems.
+
+The lint command parses the specified irma_configuration directory or scheme directory (or the current
+directory if not specified), and checks the contents of the contained issuer schemes and issue wizards
+against a number of rules, such as missing translations or logos, public keys nearing expiry, and issue
+wizards that are too complex. Use --list-rules to show the available rules and their default severities.
+
+The severity of each rule can be changed using --severity, e.g. --severity translations=error; a rule is
+disabled by setting its severity to "off". If a scheme cannot be parsed, this is reported as an issue of
+the "parse" rule. The command exits with a nonzero exit code if issues of at least the --fail-on severity
+were found, so that it can be used to check changes to schemes in a pipeline.
+
+Schemes must be validly signed. To lint a scheme before signing it, use --unsigned: in that case the scheme
+is copied to a temporary directory and signed there with a throwaway key.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.Flags()
+		asJSON, _ := flags.GetBool("json")
+		unsigned, _ := flags.GetBool("unsigned")
+		listRules, _ := flags.GetBool("list-rules")
+		languages, _ := flags.GetStringSlice("languages")
+		severities, _ := flags.GetStringToString("severity")
+		keyExpiry, _ := flags.GetInt("key-expiry-days")
+		checkURLs, _ := flags.GetBool("check-urls")
+		failOnString, _ := flags.GetString("fail-on")
+
+		if listRules {
+			for _, rule := range irma.LintRules {
+				fmt.Printf("%-18s %-8s %s\n", rule.Name, rule.Severity, rule.Description)
+			}
+			return
+		}
+
+		failOn, err := irma.ParseLintSeverity(failOnString)
+		if err != nil {
+			die("invalid --fail-on", err)
+		}
+		opts := irma.LintOptions{
+			Languages:  languages,
+			KeyExpiry:  time.Duration(keyExpiry) * 24 * time.Hour,
+			Severities: map[string]irma.LintSeverity{},
+			CheckURLs:  checkURLs,
+		}
+		for rule, s := range severities {
+			if opts.Severities[rule], err = irma.ParseLintSeverity(s); err != nil {
+				die("invalid --severity for rule "+rule, err)
+			}
+		}
+
+		path, err := os.Getwd()
+		if len(args) > 0 {
+			path, err = filepath.Abs(args[0])
+		}
+		if err != nil {
+			die("invalid path", err)
+		}
+		issues, err := lintSchemes(path, unsigned, opts)
+		if err != nil {
+			die("failed to lint schemes", err)
+		}
+
+		failed := 0
+		for _, issue := range issues {
+			if issue.Severity.AtLeast(failOn) {
+				failed++
+			}
+		}
+		if asJSON {
+			bts, _ := json.MarshalIndent(issues, "", "  ")
+			fmt.Println(string(bts))
+		} else if len(issues) == 0 {
+			fmt.Println("No issues found.")
+		} else {
+			for _, issue := range issues {
+				fmt.Println(issue.String())
+			}
+		}
+		if failed > 0 {
+			die(fmt.Sprintf("found %d issues of severity %s or higher", failed, failOn), nil)
+		}
+	},
+}
+
+// lintSchemes parses the irma_configuration or scheme directory at the specified path and lints
+// its contents. Parsing errors are returned as issues of the "parse" rule.
+func lintSchemes(path string, unsigned bool, opts irma.LintOptions) ([]*irma.LintIssue, error) {
+	irmaconf, err := common.IsIrmaconfDir(path)
+	if err != nil {
+		return nil, err
+	}
+	scheme, err := common.IsScheme(path, true)
+	if err != nil {
+		return nil, err
+	}
+	if !irmaconf && !scheme {
+		return nil, errors.New("path must contain a scheme, or multiple schemes in subdirectories")
+	}
+
+	if unsigned {
+		if !scheme {
+			return nil, errors.New("--unsigned is only supported for scheme directories")
+		}
+		if path, err = signedSchemeCopy(path); err != nil {
+			return nil, errors.WrapPrefix(err, "failed to sign copy of scheme", 0)
+		}
+		defer os.RemoveAll(filepath.Dir(path))
+	}
+
+	var conf *irma.Configuration
+	if scheme {
+		conf, err = irma.NewConfiguration(filepath.Dir(path), irma.ConfigurationOptions{ReadOnly: true})
+		if err == nil {
+			_, err = conf.ParseSchemeFolder(path)
+		}
+	} else {
+		conf, err = irma.NewConfiguration(path, irma.ConfigurationOptions{ReadOnly: true})
+		if err == nil {
+			err = conf.ParseFolder()
+		}
+	}
+	if err != nil {
+		return []*irma.LintIssue{{
+			Rule:     "parse",
+			Severity: irma.LintSeverityError,
+			Subject:  filepath.Base(path),
+			Message:  strings.TrimSpace(err.Error()),
+		}}, nil
+	}
+	return conf.Lint(opts)
+}
+
+func init() {
+	flags := schemeLintCmd.Flags()
+	flags.Bool("json", false, "output issues in JSON")
+	flags.Bool("unsigned", false, "do not check the signature of the scheme")
+	flags.Bool("list-rules", false, "list the available rules and their default severities")
+	flags.StringSlice("languages", []string{"en", "nl"}, "languages for which translations are required")
+	flags.StringToString("severity", nil, "severity (error, warning, info or off) of a rule, as rule=severity (repeatable)")
+	flags.Int("key-expiry-days", 31, "report public keys expiring within this many days")
+	flags.Bool("check-urls", false, "check that IssueURLs are reachable")
+	flags.String("fail-on", "error", "exit with nonzero exit code on issues of this severity or higher")
+	schemeCmd.AddCommand(schemeLintCmd)
+}
@@ -0,0 +1,255 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	irma "github.com/privacybydesign/irmago"
+	"github.com/sietseringers/cobra"
+)
+
+var schemeNewCmd = &cobra.Command{
+	Use:   "new",
+	Short: "Create new issuers and credential types within a scheme",
+}
+
+var schemeNewIssuerCmd = &cobra.Command{
+	Use:   "issuer [<path>]",
+	Short: "Create a new issuer within a scheme",
+	Long: `Create a new issuer within a scheme.
+
+The issuer command creates the directory and description.xml of a new issuer within the scheme at the
+specified path (or the current directory if not specified), from the flags or, if --interactive is
+specified, by prompting for the values that were not specified as flags. The description is validated in
+the same way as when the scheme is parsed before it is written. Unless --keygen=false is specified, a new
+issuer key pair is generated as well, as done by "irma issuer keygen".
+
+Afterwards, add a logo.png to the issuer directory, and sign the scheme using "irma scheme sign".`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.Flags()
+		interactive, _ := flags.GetBool("interactive")
+		keygen, _ := flags.GetBool("keygen")
+		keylength, _ := flags.GetInt("keylength")
+		numAttributes, _ := flags.GetInt("numattributes")
+		validFor, _ := flags.GetString("valid-for")
+		p := newPrompter(interactive)
+
+		scheme := readSchemeArg(args)
+		issuer := &irma.Issuer{
+			ID:              p.flag(flags.GetString("id")).ask("Issuer ID"),
+			SchemeManagerID: scheme.ID,
+			XMLVersion:      4,
+		}
+		issuer.Name = p.translation(cmd, "name", "Issuer name")
+		issuer.ContactEMail = p.flag(flags.GetString("contact-email")).ask("Contact e-mail address")
+		issuer.ContactAddress = p.flag(flags.GetString("contact-address")).ask("Contact address")
+
+		if _, err := scheme.ValidateIssuer(issuer); err != nil {
+			die("invalid issuer", err)
+		}
+		dir, err := scheme.WriteIssuer(issuer)
+		if err != nil {
+			die("failed to write issuer description", err)
+		}
+		if keygen {
+			expiryDate, err := parseKeyExpiry("", validFor)
+			if err != nil {
+				die("", err)
+			}
+			if err = generateIssuerKeys(dir, keylength, 0, numAttributes, expiryDate, "", "", false, nil); err != nil {
+				die("failed to generate issuer keys", err)
+			}
+		}
+
+		warnings, _ := scheme.ValidateIssuer(issuer)
+		printNewWarnings(warnings)
+		fmt.Printf("Created issuer %s in %s\n", issuer.Identifier(), dir)
+	},
+}
+
+var schemeNewCredentialCmd = &cobra.Command{
+	Use:   "credential [<path>]",
+	Short: "Create a new credential type within a scheme",
+	Long: `Create a new credential type within a scheme.
+
+The credential command creates the directory and description.xml of a new credential type of an existing
+issuer within the scheme at the specified path (or the current directory if not specified), from the flags
+or, if --interactive is specified, by prompting for the values that were not specified as flags. The
+description is validated in the same way as when the scheme is parsed before it is written.
+
+Attributes are specified in order using --attribute, as "id", "id:English name" or "id:English name:Dutch name".
+When revocation servers are specified, a revocation attribute is added after the other attributes.
+
+Afterwards, complete the description (e.g. attribute descriptions), add a logo.png to the credential type
+directory, and sign the scheme using "irma scheme sign".`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.Flags()
+		interactive, _ := flags.GetBool("interactive")
+		singleton, _ := flags.GetBool("singleton")
+		revocationServers, _ := flags.GetStringSlice("revocation-server")
+		attributes, _ := flags.GetStringArray("attribute")
+		p := newPrompter(interactive)
+
+		scheme := readSchemeArg(args)
+		issuerID := p.flag(flags.GetString("issuer")).ask("Issuer ID")
+		issuer, err := scheme.Issuer(issuerID)
+		if err != nil {
+			die("failed to read issuer "+issuerID, err)
+		}
+
+		cred := &irma.CredentialType{
+			ID:                p.flag(flags.GetString("id")).ask("Credential type ID"),
+			IssuerID:          issuer.ID,
+			SchemeManagerID:   scheme.ID,
+			IsSingleton:       singleton,
+			RevocationServers: revocationServers,
+			IssueURL:          irma.TranslatedString{"en": "", "nl": ""},
+			XMLVersion:        4,
+		}
+		cred.Name = p.translation(cmd, "name", "Credential type name")
+		cred.Description = p.translation(cmd, "description", "Credential type description")
+
+		for _, attr := range attributes {
+			parts := strings.SplitN(attr, ":", 3)
+			attrtype := &irma.AttributeType{
+				ID:          parts[0],
+				Name:        irma.TranslatedString{"en": "", "nl": ""},
+				Description: irma.TranslatedString{"en": "", "nl": ""},
+			}
+			for i, lang := range []string{"en", "nl"} {
+				if len(parts) > i+1 {
+					attrtype.Name[lang] = parts[i+1]
+				}
+			}
+			cred.AttributeTypes = append(cred.AttributeTypes, attrtype)
+		}
+		for interactive && len(attributes) == 0 {
+			id := p.value("").ask("Attribute ID (leave empty if done)")
+			if id == "" {
+				break
+			}
+			cred.AttributeTypes = append(cred.AttributeTypes, &irma.AttributeType{
+				ID:          id,
+				Name:        p.translation(nil, "", "Attribute name"),
+				Description: p.translation(nil, "", "Attribute description"),
+			})
+		}
+		if len(revocationServers) > 0 {
+			cred.AttributeTypes = append(cred.AttributeTypes, &irma.AttributeType{RevocationAttribute: true})
+		}
+
+		warnings, err := scheme.ValidateCredentialType(issuer, cred)
+		if err != nil {
+			die("invalid credential type", err)
+		}
+		dir, err := scheme.WriteCredentialType(cred)
+		if err != nil {
+			die("failed to write credential type description", err)
+		}
+		printNewWarnings(warnings)
+		fmt.Printf("Created credential type %s in %s\n", cred.Identifier(), dir)
+	},
+}
+
+// prompter asks the user for values that were not specified as flags, if enabled.
+type prompter struct {
+	enabled bool
+	reader  *bufio.Reader
+	current string
+}
+
+func newPrompter(enabled bool) *prompter {
+	return &prompter{enabled: enabled, reader: bufio.NewReader(os.Stdin)}
+}
+
+func (p *prompter) flag(value string, _ error) *prompter {
+	p.current = value
+	return p
+}
+
+func (p *prompter) value(value string) *prompter {
+	p.current = value
+	return p
+}
+
+func (p *prompter) ask(question string) string {
+	if p.current != "" || !p.enabled {
+		return p.current
+	}
+	fmt.Printf("%s: ", question)
+	line, err := p.reader.ReadString('\n')
+	if err != nil && line == "" {
+		die("failed to read input", err)
+	}
+	return strings.TrimSpace(line)
+}
+
+// translation reads a translated string from the flags <name>-en and <name>-nl (if cmd is
+// not nil), prompting for the missing translations.
+func (p *prompter) translation(cmd *cobra.Command, name, question string) irma.TranslatedString {
+	ts := irma.TranslatedString{}
+	for _, lang := range []string{"en", "nl"} {
+		p.current = ""
+		if cmd != nil {
+			p.flag(cmd.Flags().GetString(name + "-" + lang))
+		}
+		ts[lang] = p.ask(fmt.Sprintf("%s (%s)", question, lang))
+	}
+	return ts
+}
+
+func readSchemeArg(args []string) *irma.SchemeManager {
+	path, err := os.Getwd()
+	if len(args) > 0 {
+		path, err = filepath.Abs(args[0])
+	}
+	if err != nil {
+		die("invalid path", err)
+	}
+	scheme, err := irma.ReadSchemeDescription(path)
+	if err != nil {
+		die("failed to read scheme description", err)
+	}
+	return scheme
+}
+
+func printNewWarnings(warnings []string) {
+	for _, warning := range warnings {
+		fmt.Println("Warning: " + warning)
+	}
+}
+
+func init() {
+	schemeCmd.AddCommand(schemeNewCmd)
+
+	flags := schemeNewIssuerCmd.Flags()
+	flags.BoolP("interactive", "i", false, "prompt for values not specified as flags")
+	flags.String("id", "", "issuer ID")
+	flags.String("name-en", "", "issuer name (English)")
+	flags.String("name-nl", "", "issuer name (Dutch)")
+	flags.String("contact-email", "", "contact e-mail address of the issuer")
+	flags.String("contact-address", "", "contact address of the issuer")
+	flags.Bool("keygen", true, "generate an issuer key pair")
+	flags.IntP("keylength", "l", 2048, "key length of the issuer key pair")
+	flags.IntP("numattributes", "a", 12, "number of attributes supported by the issuer key pair")
+	flags.String("valid-for", "1y", "validity period of the issuer key pair (see \"irma issuer keygen\")")
+	schemeNewCmd.AddCommand(schemeNewIssuerCmd)
+
+	flags = schemeNewCredentialCmd.Flags()
+	flags.BoolP("interactive", "i", false, "prompt for values not specified as flags")
+	flags.String("issuer", "", "ID of the issuer of the credential type")
+	flags.String("id", "", "credential type ID")
+	flags.String("name-en", "", "credential type name (English)")
+	flags.String("name-nl", "", "credential type name (Dutch)")
+	flags.String("description-en", "", "credential type description (English)")
+	flags.String("description-nl", "", "credential type description (Dutch)")
+	flags.StringArray("attribute", nil, "attribute, as id[:English name[:Dutch name]] (repeatable)")
+	flags.Bool("singleton", false, "whether users can have at most one instance of the credential type")
+	flags.StringSlice("revocation-server", nil, "revocation server URL, enabling revocation (repeatable)")
+	schemeNewCmd.AddCommand(schemeNewCredentialCmd)
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	irma "github.com/privacybydesign/irmago"
+	"github.com/sietseringers/cobra"
+)
+
+var schemeSearchCmd = &cobra.Command{
+	Use:   "search [<words>...]",
+	Short: "Search issuers, credential types and attributes in the installed schemes",
+	Long: `Search issuers, credential types and attributes in the installed schemes.
+
+The search command lists the issuers, credential types and attribute types of the schemes in the
+irma_configuration folder in which all specified words occur, most relevant first. Words are matched
+case-insensitively against the beginnings of the words in the names, descriptions, categories and
+identifiers of the items, and in the names of their issuers and credential types; use --lang to only match
+translations in the specified languages. Without words, all items matching the other flags are listed.
+
+Deprecated issuers and credential types, and the credential types and attributes of deprecated issuers, are
+only listed with --deprecated.`,
+	Example: `irma scheme search student card
+irma scheme search --type credentialtype --category Onderwijs --lang nl`,
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.Flags()
+		schemespath, _ := flags.GetString("schemes-path")
+		languages, _ := flags.GetStringSlice("lang")
+		types, _ := flags.GetStringSlice("type")
+		schemes, _ := flags.GetStringSlice("scheme")
+		issuers, _ := flags.GetStringSlice("issuer")
+		category, _ := flags.GetString("category")
+		includeDeprecated, _ := flags.GetBool("deprecated")
+		limit, _ := flags.GetInt("limit")
+		asJSON, _ := flags.GetBool("json")
+
+		conf, err := irma.NewConfiguration(schemespath, irma.ConfigurationOptions{ReadOnly: true})
+		if err != nil {
+			die("failed to open irma_configuration", err)
+		}
+		if err = conf.ParseFolder(); err != nil {
+			die("failed to parse irma_configuration", err)
+		}
+
+		query := irma.CatalogQuery{
+			Text:              strings.Join(args, " "),
+			Languages:         languages,
+			Category:          category,
+			IncludeDeprecated: includeDeprecated,
+			Limit:             limit,
+		}
+		for _, typ := range types {
+			switch t := irma.CatalogItemType(typ); t {
+			case irma.CatalogItemIssuer, irma.CatalogItemCredentialType, irma.CatalogItemAttributeType:
+				query.Types = append(query.Types, t)
+			default:
+				die(fmt.Sprintf("unknown type %s: must be %s, %s or %s", typ,
+					irma.CatalogItemIssuer, irma.CatalogItemCredentialType, irma.CatalogItemAttributeType), nil)
+			}
+		}
+		for _, scheme := range schemes {
+			query.Schemes = append(query.Schemes, irma.NewSchemeManagerIdentifier(scheme))
+		}
+		for _, issuer := range issuers {
+			query.Issuers = append(query.Issuers, irma.NewIssuerIdentifier(issuer))
+		}
+
+		results := conf.SearchCatalog(query)
+		if asJSON {
+			bts, _ := json.MarshalIndent(results, "", "  ")
+			fmt.Println(string(bts))
+			return
+		}
+		if len(results) == 0 {
+			fmt.Println("No results found.")
+			return
+		}
+		lang := "en"
+		if len(languages) > 0 {
+			lang = languages[0]
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "TYPE\tIDENTIFIER\tNAME\tCATEGORY\tDEPRECATED")
+		for _, result := range results {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\n",
+				result.Type, result.Identifier, result.Name[lang], result.Category[lang], result.Deprecated)
+		}
+		_ = w.Flush()
+	},
+}
+
+func init() {
+	flags := schemeSearchCmd.Flags()
+	flags.StringP("schemes-path", "s", irma.DefaultSchemesPath(), "path to irma_configuration")
+	flags.StringSlice("lang", nil, "only match translations in these languages; the first is displayed (default: all, displaying en)")
+	flags.StringSlice("type", nil, "only list items of these types: issuer, credentialtype, attributetype")
+	flags.StringSlice("scheme", nil, "only list items of these schemes")
+	flags.StringSlice("issuer", nil, "only list items of these issuers")
+	flags.String("category", "", "only list credential types of this category, and their attributes")
+	flags.Bool("deprecated", false, "include deprecated items")
+	flags.IntP("limit", "n", 0, "maximum number of results (default: unlimited)")
+	flags.Bool("json", false, "output results in JSON")
+	schemeCmd.AddCommand(schemeSearchCmd)
+}
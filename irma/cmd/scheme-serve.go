@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	"github.com/go-errors/errors"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/privacybydesign/irmago/internal/common"
+	"github.com/privacybydesign/irmago/server"
+	"github.com/sietseringers/cobra"
+)
+
+var schemeServeCmd = &cobra.Command{
+	Use:   "serve [<path>...]",
+	Short: "Run a scheme mirror",
+	Long: `Run a scheme mirror.
+
+The serve command hosts the schemes in the specified irma_configuration or scheme directories (or the
+default irma_configuration directory if not specified) over HTTP, in the same layout as the scheme URLs:
+the files of each scheme are served at /<scheme>/. Only the files listed in the scheme index and the other
+files needed to install or update the scheme are served.
+
+Every --update-interval minutes, the schemes are updated from their own URLs. Updated schemes are only
+served after their signature has been verified. The status of the last update of each scheme is served
+at /status.json.
+
+IRMA servers can update their schemes from the mirror using the --schemes-mirror flag of "irma server".
+As schemes are downloaded over HTTPS, the mirror should be run with TLS enabled (--tls-cert and
+--tls-privkey), or behind a reverse proxy with TLS enabled.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.Flags()
+		listenAddr, _ := flags.GetString("listen-addr")
+		port, _ := flags.GetInt("port")
+		interval, _ := flags.GetInt("update-interval")
+		tlsCert, _ := flags.GetString("tls-cert")
+		tlsPrivkey, _ := flags.GetString("tls-privkey")
+		verbosity, _ := flags.GetCount("verbose")
+		logger.Level = server.Verbosity(verbosity)
+		irma.SetLogger(logger)
+
+		if len(args) == 0 {
+			if args = []string{irma.DefaultSchemesPath()}; args[0] == "" {
+				die("Failed to determine default irma_configuration path", nil)
+			}
+		}
+		confs, err := mirrorConfigurations(args)
+		if err != nil {
+			die("failed to parse schemes", err)
+		}
+		mirror, err := irma.NewSchemeMirror(confs...)
+		if err != nil {
+			die("failed to create scheme mirror", err)
+		}
+		if interval > 0 {
+			mirror.AutoSync(uint(interval))
+		}
+
+		addr := listenAddr + ":" + strconv.Itoa(port)
+		logger.Info("Serving schemes at ", addr)
+		s := &http.Server{Addr: addr, Handler: mirror}
+		if tlsCert != "" || tlsPrivkey != "" {
+			err = s.ListenAndServeTLS(tlsCert, tlsPrivkey)
+		} else {
+			err = s.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			die("failed to run scheme mirror", err)
+		}
+	},
+}
+
+// mirrorConfigurations parses the specified irma_configuration and scheme directories into
+// Configurations, one for each (parent) irma_configuration directory.
+func mirrorConfigurations(paths []string) ([]*irma.Configuration, error) {
+	var confs []*irma.Configuration
+	schemeconfs := map[string]*irma.Configuration{}
+	for _, path := range paths {
+		path, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		if ok, err := common.IsIrmaconfDir(path); err != nil {
+			return nil, err
+		} else if ok {
+			conf, err := irma.NewConfiguration(path, irma.ConfigurationOptions{})
+			if err != nil {
+				return nil, err
+			}
+			if err = conf.ParseFolder(); err != nil {
+				return nil, err
+			}
+			confs = append(confs, conf)
+			continue
+		}
+		if ok, err := common.IsScheme(path, true); err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, errors.Errorf("%s does not contain a scheme, or multiple schemes in subdirectories", path)
+		}
+		conf := schemeconfs[filepath.Dir(path)]
+		if conf == nil {
+			if conf, err = irma.NewConfiguration(filepath.Dir(path), irma.ConfigurationOptions{}); err != nil {
+				return nil, err
+			}
+			schemeconfs[filepath.Dir(path)] = conf
+			confs = append(confs, conf)
+		}
+		if _, err = conf.ParseSchemeFolder(path); err != nil {
+			return nil, err
+		}
+	}
+	return confs, nil
+}
+
+func init() {
+	flags := schemeServeCmd.Flags()
+	flags.StringP("listen-addr", "l", "", "address at which to listen (default 0.0.0.0)")
+	flags.IntP("port", "p", 8090, "port at which to listen")
+	flags.Int("update-interval", 60, "update the schemes every x minutes (0 to disable)")
+	flags.String("tls-cert", "", "path to TLS certificate (chain)")
+	flags.String("tls-privkey", "", "path to TLS private key")
+	flags.CountP("verbose", "v", "verbose (repeatable)")
+	schemeCmd.AddCommand(schemeServeCmd)
+}
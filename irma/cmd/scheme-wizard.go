@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/go-errors/errors"
+	irma "github.com/privacybydesign/irmago"
+	"github.com/sietseringers/cobra"
+)
+
+var schemeWizardCmd = &cobra.Command{
+	Use:   "wizard <wizard-id>",
+	Short: "Preview the steps of an issue wizard",
+	Long: `Preview the steps of an issue wizard.
+
+The wizard command prints the path through the specified issue wizard that the IRMA app shows to a user
+owning the credential types specified with --credential and --credentials-file, with the headers, texts,
+labels and URLs of the items translated to the language specified with --lang. Items of credential types
+that the user already owns are marked as such.
+
+Afterwards, all possible paths through the wizard are listed, along with the combinations of owned
+credential types for which each path is shown. Only the credential types occurring in the wizard and
+(unless the wizard does not expand dependencies) in their dependencies are considered. Use --path-only
+to skip this.
+
+The credentials file contains one credential type identifier per line; empty lines and lines starting
+with # are ignored.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.Flags()
+		schemespath, _ := flags.GetString("schemes-path")
+		lang, _ := flags.GetString("lang")
+		pathOnly, _ := flags.GetBool("path-only")
+		credentials, _ := flags.GetStringSlice("credential")
+		credentialsFile, _ := flags.GetString("credentials-file")
+
+		conf, err := irma.NewConfiguration(schemespath, irma.ConfigurationOptions{ReadOnly: true})
+		if err != nil {
+			die("failed to open irma_configuration", err)
+		}
+		if err = conf.ParseFolder(); err != nil {
+			die("failed to parse irma_configuration", err)
+		}
+		wizard := conf.IssueWizards[irma.NewIssueWizardIdentifier(args[0])]
+		if wizard == nil {
+			die("", errors.Errorf("issue wizard %s not found", args[0]))
+		}
+
+		if credentialsFile != "" {
+			bts, err := ioutil.ReadFile(credentialsFile)
+			if err != nil {
+				die("failed to read credentials file", err)
+			}
+			for _, line := range strings.Split(string(bts), "\n") {
+				if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+					credentials = append(credentials, line)
+				}
+			}
+		}
+		owned := map[irma.CredentialTypeIdentifier]struct{}{}
+		var creds irma.CredentialInfoList
+		for _, cred := range credentials {
+			id := irma.NewCredentialTypeIdentifier(cred)
+			if conf.CredentialTypes[id] == nil {
+				fmt.Printf("Warning: unknown credential type %s\n", id)
+			}
+			owned[id] = struct{}{}
+			creds = append(creds, &irma.CredentialInfo{
+				SchemeManagerID: id.IssuerIdentifier().SchemeManagerIdentifier().Name(),
+				IssuerID:        id.IssuerIdentifier().Name(),
+				ID:              id.Name(),
+			})
+		}
+
+		items, err := wizard.Path(conf, creds)
+		if err != nil {
+			die("failed to compute wizard path", err)
+		}
+		fmt.Printf("Issue wizard %s: %s\n", wizard.ID, translate(&wizard.Title, lang))
+		if wizard.Intro != nil {
+			fmt.Printf("Intro: %s\n", translate(wizard.Intro, lang))
+		}
+		fmt.Println()
+		printWizardItems(conf, items, owned, lang, "")
+		if wizard.SuccessHeader != nil {
+			fmt.Printf("\nSuccess: %s\n  %s\n", translate(wizard.SuccessHeader, lang), translate(wizard.SuccessText, lang))
+		}
+		if pathOnly {
+			return
+		}
+
+		paths, err := wizard.Paths(conf)
+		if err != nil {
+			die("failed to compute wizard paths", err)
+		}
+		fmt.Printf("\n%d possible paths:\n", len(paths))
+		for i, path := range paths {
+			fmt.Printf("\nPath %d, shown when owning:\n", i+1)
+			for _, ids := range path.Owned {
+				fmt.Printf("  - %s\n", formatCredentialTypes(ids))
+			}
+			printWizardItems(conf, path.Items, nil, lang, "  ")
+		}
+	},
+}
+
+func printWizardItems(conf *irma.Configuration, items []irma.IssueWizardItem, owned map[irma.CredentialTypeIdentifier]struct{}, lang, indent string) {
+	for i, item := range items {
+		title := string(item.Type)
+		if item.Credential != nil {
+			title += " " + item.Credential.String()
+			if credtype := conf.CredentialTypes[*item.Credential]; credtype != nil {
+				title += fmt.Sprintf(" (%s)", translate(&credtype.Name, lang))
+			}
+			if _, ok := owned[*item.Credential]; ok {
+				title += " [owned]"
+			}
+		}
+		fmt.Printf("%s%d. %s\n", indent, i+1, title)
+		printWizardField(indent, "Header", item.Header, lang)
+		printWizardField(indent, "Text", item.Text, lang)
+		printWizardField(indent, "Label", item.Label, lang)
+		printWizardField(indent, "URL", item.URL, lang)
+		if item.SessionURL != nil {
+			fmt.Printf("%s   Session URL: %s\n", indent, *item.SessionURL)
+		}
+		if item.InApp != nil && *item.InApp {
+			fmt.Printf("%s   Opened in app\n", indent)
+		}
+	}
+}
+
+func printWizardField(indent, name string, ts *irma.TranslatedString, lang string) {
+	if ts != nil {
+		fmt.Printf("%s   %s: %s\n", indent, name, translate(ts, lang))
+	}
+}
+
+// translate returns the translation of the string in the specified language,
+// falling back to English.
+func translate(ts *irma.TranslatedString, lang string) string {
+	if ts == nil {
+		return ""
+	}
+	if text, ok := (*ts)[lang]; ok {
+		return text
+	}
+	return (*ts)["en"]
+}
+
+func formatCredentialTypes(ids []irma.CredentialTypeIdentifier) string {
+	if len(ids) == 0 {
+		return "nothing"
+	}
+	strs := make([]string, 0, len(ids))
+	for _, id := range ids {
+		strs = append(strs, id.String())
+	}
+	return strings.Join(strs, ", ")
+}
+
+func init() {
+	flags := schemeWizardCmd.Flags()
+	flags.StringP("schemes-path", "s", irma.DefaultSchemesPath(), "path to irma_configuration")
+	flags.StringSlice("credential", nil, "credential type owned by the user (repeatable)")
+	flags.String("credentials-file", "", "file containing the credential types owned by the user")
+	flags.StringP("lang", "l", "en", "language of the translated texts")
+	flags.Bool("path-only", false, "only print the path for the specified credential types")
+	schemeCmd.AddCommand(schemeWizardCmd)
+}
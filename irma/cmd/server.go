@@ -90,13 +90,19 @@ func setFlags(cmd *cobra.Command, production bool) error {
 	flags.StringP("schemes-path", "s", schemespath, "path to irma_configuration")
 	flags.String("schemes-assets-path", "", "if specified, copy schemes from here into --schemes-path")
 	flags.Int("schemes-update", 60, "update IRMA schemes every x minutes (0 to disable)")
+	flags.String("schemes-mirror", "", "if specified, download and update schemes from this scheme mirror")
+	flags.Int("schemes-history", 0, "number of previous versions of each scheme to keep when updating (0 to disable)")
 	flags.StringP("privkeys", "k", "", "path to IRMA private keys")
+	flags.String("privkeys-passphrase-file", "", "file containing the passphrase of encrypted private keys in --privkeys (default: read from IRMASERVER_PRIVKEYS_PASSPHRASE or IRMA_PRIVKEYS_PASSPHRASE, or prompt)")
+	flags.String("signer-socket", "", "Unix socket of an issuer signer (\"irma issuer signer\") holding the private keys, instead of --privkeys")
+	flags.Int("key-expiry-warning", 31, "warn if the latest issuer key expires within x days")
 	flags.String("static-path", "", "Host files under this path as static files (leave empty to disable)")
 	flags.String("static-prefix", "/", "Host static files under this URL prefix")
 	flags.StringP("url", "u", defaulturl, "external URL to server to which the IRMA client connects, \":port\" being replaced by --port value")
 	flags.String("revocation-db-type", "", "database type for revocation database (supported: mysql, postgres)")
 	flags.String("revocation-db-str", "", "connection string for revocation database")
 	flags.Bool("sse", false, "Enable server sent for status updates (experimental)")
+	flags.Bool("reject-deprecated", false, "Reject session requests involving deprecated issuers or credential types instead of warning")
 
 	flags.IntP("port", "p", 8088, "port at which to listen")
 	flags.StringP("listen-addr", "l", "", "address at which to listen (default 0.0.0.0)")
@@ -120,6 +126,7 @@ func setFlags(cmd *cobra.Command, production bool) error {
 	flags.Lookup("no-auth").Header = `Requestor authentication and default requestor permissions`
 
 	flags.String("revocation-settings", "", "revocation settings (in JSON)")
+	flags.Bool("revocation-stats", false, "serve revocation statistics of credential types of which this server is revocation authority (unauthenticated)")
 
 	flags.StringP("jwt-issuer", "j", "irmaserver", "JWT issuer")
 	flags.String("jwt-privkey", "", "JWT private key")
@@ -211,15 +218,21 @@ func configureServer(cmd *cobra.Command) error {
 			SchemesPath:            viper.GetString("schemes-path"),
 			SchemesAssetsPath:      viper.GetString("schemes-assets-path"),
 			SchemesUpdateInterval:  viper.GetInt("schemes-update"),
+			SchemesMirror:          viper.GetString("schemes-mirror"),
+			SchemesHistory:         viper.GetInt("schemes-history"),
 			DisableSchemesUpdate:   viper.GetInt("schemes-update") == 0,
 			IssuerPrivateKeysPath:  viper.GetString("privkeys"),
+			IssuerSignerSocket:     viper.GetString("signer-socket"),
+			KeyExpiryWarning:       viper.GetInt("key-expiry-warning"),
 			RevocationDBType:       viper.GetString("revocation-db-type"),
 			RevocationDBConnStr:    viper.GetString("revocation-db-str"),
 			RevocationSettings:     irma.RevocationSettings{},
+			RevocationStats:        viper.GetBool("revocation-stats"),
 			URL:                    viper.GetString("url"),
 			DisableTLS:             viper.GetBool("no-tls"),
 			Email:                  viper.GetString("email"),
 			EnableSSE:              viper.GetBool("sse"),
+			RejectDeprecated:       viper.GetBool("reject-deprecated"),
 			Verbose:                viper.GetInt("verbose"),
 			Quiet:                  viper.GetBool("quiet"),
 			LogJSON:                viper.GetBool("log-json"),
@@ -268,6 +281,22 @@ func configureServer(cmd *cobra.Command) error {
 		}
 	}
 
+	// Read the passphrase of encrypted private keys, if any
+	if conf.IssuerPrivateKeysPath != "" {
+		encrypted, _ := filepath.Glob(filepath.Join(conf.IssuerPrivateKeysPath, "*.xml"+irma.EncryptedPrivateKeySuffix))
+		if len(encrypted) > 0 {
+			value := viper.GetString("privkeys-passphrase")
+			if value == "" {
+				value = os.Getenv(privkeysPassphraseEnv)
+			}
+			passphrase, err := readPassphrase(viper.GetString("privkeys-passphrase-file"), value, false)
+			if err != nil {
+				return err
+			}
+			conf.IssuerPrivateKeysPassphrase = string(passphrase)
+		}
+	}
+
 	// Handle requestors
 	if err = handleMapOrString("requestors", &conf.Requestors); err != nil {
 		return err
@@ -3,6 +3,8 @@ package cmd
 import (
 	"fmt"
 	"net/http"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"sync"
@@ -205,6 +207,9 @@ func postRequest(serverurl string, request irma.RequestorRequest, name, authmeth
 		return nil, nil, errors.New("Invalid authentication method (must be none, token, hmac or rsa)")
 	}
 
+	for _, warning := range pkg.Warnings {
+		logger.Warn(warning)
+	}
 	token := pkg.Token
 	transport.Server += fmt.Sprintf("session/%s/", token)
 	return pkg.SessionPtr, transport, err
@@ -226,6 +231,14 @@ func configureSessionServer(url string, port int, privatekeysPath string, irmaco
 	}
 	if privatekeysPath != "" {
 		config.IssuerPrivateKeysPath = privatekeysPath
+		encrypted, _ := filepath.Glob(filepath.Join(privatekeysPath, "*.xml"+irma.EncryptedPrivateKeySuffix))
+		if len(encrypted) > 0 {
+			passphrase, err := readPassphrase("", os.Getenv(privkeysPassphraseEnv), false)
+			if err != nil {
+				return err
+			}
+			config.IssuerPrivateKeysPassphrase = string(passphrase)
+		}
 	}
 
 	var err error
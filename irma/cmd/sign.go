@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/ecdsa"
 	"crypto/sha256"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -25,6 +26,12 @@ var signCmd = &cobra.Command{
 	Short: "Sign a scheme directory",
 	Long: `Sign a scheme directory, using the specified ECDSA key. Both arguments are optional; "sk.pem" and the working directory are the defaults. Outputs an index file, signature over the index file, and the public key in the specified directory.
 
+Schemes can also be signed by multiple keys, of which a threshold number of signatures is required. To set this up, specify the public keys of all signers with --publickeys, and the number of required signatures with --threshold. The first signer then signs the scheme as usual, after which the other signers add their signatures to the index created by the first signer using --add. Afterwards, as long as --publickeys is not specified, signing the scheme again keeps the public keys of the scheme, and removes the signatures of the other signers (which have to be added again using --add).
+
+Note that the public keys of a scheme are not part of its updates: clients keep using the pk.pem that they obtained when installing the scheme. Changing the public keys or the threshold of a deployed scheme therefore does not reach existing clients, which continue to require a threshold of signatures of the old keys over updates, until they reinstall the scheme.
+
+Unless --nolog is specified, the new index is appended to the transparency log of the scheme (transparency.log), which must be published along with the scheme: once clients have obtained the log, they only accept updates of the scheme whose index is appended to the same log. Use "irma scheme audit" to compare logs obtained from different sources.
+
 Careful: this command could fail and invalidate or destroy your scheme directory! Use this only if you can restore it from git or backups.`,
 	Args: cobra.MaximumNArgs(2),
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -55,24 +62,59 @@ Careful: this command could fail and invalidate or destroy your scheme directory
 			return err
 		}
 
-		skipverification, err := cmd.Flags().GetBool("noverification")
-		if err != nil {
+		opts := schemeSignOptions{}
+		flags := cmd.Flags()
+		if opts.skipVerification, err = flags.GetBool("noverification"); err != nil {
 			return err
 		}
-		if err := signScheme(privatekey, confpath, skipverification); err != nil {
+		opts.add, _ = flags.GetBool("add")
+		opts.skipLog, _ = flags.GetBool("nolog")
+		opts.threshold, _ = flags.GetInt("threshold")
+		pkfiles, _ := flags.GetStringSlice("publickeys")
+		if opts.add && len(pkfiles) > 0 {
+			die("cannot specify --publickeys with --add", nil)
+		}
+		for _, file := range pkfiles {
+			bts, err := ioutil.ReadFile(file)
+			if err != nil {
+				die("Failed to read public key", err)
+			}
+			pk, err := signed.UnmarshalPemPublicKey(bts)
+			if err != nil {
+				die("Failed to parse public key "+file, err)
+			}
+			opts.publickeys = append(opts.publickeys, pk)
+		}
+
+		if err := signScheme(privatekey, confpath, opts); err != nil {
 			die("Failed to sign scheme", err)
 		}
 		return nil
 	},
 }
 
+type schemeSignOptions struct {
+	// Public keys of all signers of the scheme, and the number of required signatures;
+	// if not specified, the keys in the pk.pem of the scheme are kept if it contains multiple keys
+	publickeys []*ecdsa.PublicKey
+	threshold  int
+	// Add a signature to the existing index instead of recreating it
+	add              bool
+	skipVerification bool
+	skipLog          bool
+}
+
 func init() {
 	schemeCmd.AddCommand(signCmd)
 
 	signCmd.Flags().BoolP("noverification", "n", false, "Skip verification of the scheme after signing it")
+	signCmd.Flags().Bool("nolog", false, "Do not append the new index to the transparency log of the scheme")
+	signCmd.Flags().Bool("add", false, "Add a signature to the existing index, for schemes signed by multiple keys")
+	signCmd.Flags().StringSlice("publickeys", nil, "Public keys (PEM files) of all signers, for schemes signed by multiple keys")
+	signCmd.Flags().Int("threshold", 0, "Number of signatures required, for schemes signed by multiple keys (default: all)")
 }
 
-func signScheme(privatekey *ecdsa.PrivateKey, path string, skipverification bool) error {
+func signScheme(privatekey *ecdsa.PrivateKey, path string, opts schemeSignOptions) error {
 	filename, err := common.SchemeFilename(path)
 	if err != nil {
 		return err
@@ -86,25 +128,62 @@ func signScheme(privatekey *ecdsa.PrivateKey, path string, skipverification bool
 		return err
 	}
 
-	// Write timestamp
-	bts = []byte(strconv.FormatInt(time.Now().Unix(), 10) + "\n")
-	if err := ioutil.WriteFile(filepath.Join(path, "timestamp"), bts, 0644); err != nil {
-		return errors.WrapPrefix(err, "Failed to write timestamp", 0)
-	}
-
-	// Traverse dir and add file hashes to index
-	var index irma.SchemeManagerIndex = make(map[string]irma.SchemeFileHash)
-	err = common.WalkDir(path, func(p string, info os.FileInfo) error {
-		return calculateFileHash(id, path, p, info, index, irma.SchemeType(typ))
-	})
+	// Determine the public keys of the scheme, and which of them is ours
+	pks, threshold, err := schemeSigners(path, privatekey, opts)
 	if err != nil {
-		return errors.WrapPrefix(err, "Failed to calculate file index", 0)
+		return err
 	}
+	position := -1
+	for i, pk := range pks {
+		if pk.X.Cmp(privatekey.X) == 0 && pk.Y.Cmp(privatekey.Y) == 0 {
+			position = i
+			break
+		}
+	}
+	if position < 0 {
+		return errors.New("private key does not correspond to any of the public keys of the scheme")
+	}
+
+	if opts.add {
+		// Sign the existing index
+		if bts, err = ioutil.ReadFile(filepath.Join(path, "index")); err != nil {
+			return errors.WrapPrefix(err, "Failed to read index", 0)
+		}
+	} else {
+		// Write timestamp
+		bts = []byte(strconv.FormatInt(time.Now().Unix(), 10) + "\n")
+		if err := ioutil.WriteFile(filepath.Join(path, "timestamp"), bts, 0644); err != nil {
+			return errors.WrapPrefix(err, "Failed to write timestamp", 0)
+		}
+
+		// Traverse dir and add file hashes to index
+		var index irma.SchemeManagerIndex = make(map[string]irma.SchemeFileHash)
+		err = common.WalkDir(path, func(p string, info os.FileInfo) error {
+			return calculateFileHash(id, path, p, info, index, irma.SchemeType(typ))
+		})
+		if err != nil {
+			return errors.WrapPrefix(err, "Failed to calculate file index", 0)
+		}
+
+		// Write index
+		bts = []byte(index.String())
+		if err := ioutil.WriteFile(filepath.Join(path, "index"), bts, 0644); err != nil {
+			return errors.WrapPrefix(err, "Failed to write index", 0)
+		}
 
-	// Write index
-	bts = []byte(index.String())
-	if err := ioutil.WriteFile(filepath.Join(path, "index"), bts, 0644); err != nil {
-		return errors.WrapPrefix(err, "Failed to write index", 0)
+		// Record the new index in the transparency log of the scheme
+		if !opts.skipLog {
+			if err = irma.AppendSchemeLog(path); err != nil {
+				return errors.WrapPrefix(err, "Failed to append to transparency log", 0)
+			}
+		}
+
+		// Remove the signatures of other signers over the previous index
+		for i := range pks {
+			if err = os.Remove(filepath.Join(path, irma.SchemeSignatureFilename(i))); err != nil && !os.IsNotExist(err) {
+				return errors.WrapPrefix(err, "Failed to remove signature", 0)
+			}
+		}
 	}
 
 	// Create and write signature
@@ -112,20 +191,35 @@ func signScheme(privatekey *ecdsa.PrivateKey, path string, skipverification bool
 	if err != nil {
 		return errors.WrapPrefix(err, "Failed to serialize signature:", 0)
 	}
-	if err = ioutil.WriteFile(filepath.Join(path, "index.sig"), sigbytes, 0644); err != nil {
-		return errors.WrapPrefix(err, "Failed to write index.sig", 0)
+	sigfile := irma.SchemeSignatureFilename(position)
+	if err = ioutil.WriteFile(filepath.Join(path, sigfile), sigbytes, 0644); err != nil {
+		return errors.WrapPrefix(err, "Failed to write "+sigfile, 0)
 	}
 
-	// Write public key
-	pemEncodedPub, err := signed.MarshalPemPublicKey(&privatekey.PublicKey)
-	if err != nil {
-		return errors.WrapPrefix(err, "Failed to serialize public key", 0)
+	// Write public keys
+	if !opts.add {
+		pemEncodedPub, err := irma.MarshalSchemePublicKeys(pks, threshold)
+		if err != nil {
+			return errors.WrapPrefix(err, "Failed to serialize public key", 0)
+		}
+		if err := ioutil.WriteFile(filepath.Join(path, "pk.pem"), pemEncodedPub, 0644); err != nil {
+			return errors.WrapPrefix(err, "Failed to write public key", 0)
+		}
 	}
-	if err := ioutil.WriteFile(filepath.Join(path, "pk.pem"), pemEncodedPub, 0644); err != nil {
-		return errors.WrapPrefix(err, "Failed to write public key", 0)
+
+	if opts.skipVerification {
+		return nil
 	}
 
-	if skipverification {
+	// The scheme can only be verified once enough signers have signed it
+	sigs := map[int][]byte{}
+	for i := range pks {
+		if sig, err := ioutil.ReadFile(filepath.Join(path, irma.SchemeSignatureFilename(i))); err == nil {
+			sigs[i] = sig
+		}
+	}
+	if err = irma.VerifySchemeSignatures(pks, threshold, bts, sigs); err != nil {
+		fmt.Printf("Scheme signed, but not yet verified: %s\n", err.Error())
 		return nil
 	}
 
@@ -136,6 +230,37 @@ func signScheme(privatekey *ecdsa.PrivateKey, path string, skipverification bool
 	return nil
 }
 
+// schemeSigners returns the public keys of all signers of the scheme, and the number of required
+// signatures: those specified in the options, or otherwise those in the pk.pem of the scheme if it
+// contains multiple keys (or if adding a signature), or otherwise just the public key of privatekey.
+func schemeSigners(path string, privatekey *ecdsa.PrivateKey, opts schemeSignOptions) ([]*ecdsa.PublicKey, int, error) {
+	if len(opts.publickeys) > 0 {
+		threshold := opts.threshold
+		if threshold == 0 {
+			threshold = len(opts.publickeys)
+		}
+		return opts.publickeys, threshold, nil
+	}
+	if opts.threshold != 0 {
+		return nil, 0, errors.New("threshold can only be specified along with the public keys")
+	}
+
+	bts, err := ioutil.ReadFile(filepath.Join(path, "pk.pem"))
+	if err != nil && !(os.IsNotExist(err) && !opts.add) {
+		return nil, 0, errors.WrapPrefix(err, "Failed to read public key", 0)
+	}
+	if err == nil {
+		pks, threshold, err := irma.ParseSchemePublicKeys(bts)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(pks) > 1 || opts.add {
+			return pks, threshold, nil
+		}
+	}
+	return []*ecdsa.PublicKey{&privatekey.PublicKey}, 1, nil
+}
+
 func readPrivateKey(path string) (*ecdsa.PrivateKey, error) {
 	bts, err := ioutil.ReadFile(path)
 	if err != nil {
@@ -3,6 +3,7 @@ package cmd
 import (
 	"io/ioutil"
 	"path/filepath"
+	"strings"
 
 	"github.com/go-errors/errors"
 	irma "github.com/privacybydesign/irmago"
@@ -29,19 +30,21 @@ var updateCmd = &cobra.Command{
 			}
 			paths = make([]string, 0, len(files))
 			for _, file := range files {
-				if file.IsDir() {
+				// Skip hidden directories such as the scheme history
+				if file.IsDir() && !strings.HasPrefix(file.Name(), ".") {
 					paths = append(paths, filepath.Join(irmaconf, file.Name()))
 				}
 			}
 		}
 
-		if err := updateSchemeManager(paths); err != nil {
+		history, _ := cmd.Flags().GetInt("history")
+		if err := updateSchemeManager(paths, history); err != nil {
 			die("Updating schemes failed", err)
 		}
 	},
 }
 
-func updateSchemeManager(paths []string) error {
+func updateSchemeManager(paths []string, history int) error {
 	// Before doing anything, first check that all paths are scheme managers
 	for _, path := range paths {
 		isscheme, err := common.IsScheme(path, true)
@@ -58,7 +61,7 @@ func updateSchemeManager(paths []string) error {
 		if err != nil {
 			return err
 		}
-		conf, err := irma.NewConfiguration(filepath.Dir(path), irma.ConfigurationOptions{})
+		conf, err := irma.NewConfiguration(filepath.Dir(path), irma.ConfigurationOptions{SchemeHistory: history})
 		if err != nil {
 			return err
 		}
@@ -85,5 +88,6 @@ func updateHelp() string {
 }
 
 func init() {
+	updateCmd.Flags().Int("history", 3, "number of previous versions of the scheme to keep, for \"irma scheme rollback\" (0 to disable)")
 	schemeCmd.AddCommand(updateCmd)
 }
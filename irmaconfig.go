@@ -15,6 +15,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/privacybydesign/gabi/gabikeys"
@@ -53,14 +54,20 @@ type Configuration struct {
 	// Path to the irma_configuration folder that this instance represents
 	Path        string
 	PrivateKeys PrivateKeyRing
-	Revocation  *RevocationStorage `json:"-"`
-	Scheduler   *gocron.Scheduler
-	Warnings    []string `json:"-"`
-
-	options     ConfigurationOptions
-	initialized bool
-	assets      string
-	readOnly    bool
+	// Signer optionally performs the operations requiring issuer private keys instead of
+	// PrivateKeys, e.g. an IssuerSignerClient; see IssuerSigner()
+	Signer     IssuerSigner       `json:"-"`
+	Revocation *RevocationStorage `json:"-"`
+	Scheduler  *gocron.Scheduler
+	Warnings   []string `json:"-"`
+
+	options             ConfigurationOptions
+	updateSubscriptions schemeUpdateSubscriptions
+	catalog             *catalogIndex
+	catalogLock         sync.Mutex
+	initialized         bool
+	assets              string
+	readOnly            bool
 }
 
 type UnknownIdentifierError struct {
@@ -84,6 +91,18 @@ type ConfigurationOptions struct {
 	RevocationDBConnStr string
 	RevocationDBType    string
 	RevocationSettings  RevocationSettings
+	// RevocationDB optionally specifies a custom storage backend for revocation records,
+	// in which case RevocationDBConnStr and RevocationDBType are ignored.
+	RevocationDB RevocationDB
+	// SchemeMirror optionally specifies the URL of a scheme mirror (e.g. as run by "irma scheme serve"),
+	// from which schemes are downloaded instead of from their own URLs, at $SchemeMirror/$schemeid.
+	SchemeMirror string
+	// SchemeMirrors optionally specifies per scheme ID the URL from which the scheme is downloaded
+	// instead of from its own URL, taking precedence over SchemeMirror.
+	SchemeMirrors map[string]string
+	// SchemeHistory is the number of previous versions of each scheme that are kept when updating
+	// schemes, to which the schemes can be rolled back using RollbackScheme(); 0 disables keeping them.
+	SchemeHistory int
 }
 
 // NewConfiguration returns a new configuration. After this
@@ -195,7 +214,7 @@ func (conf *Configuration) ParseFolder() (err error) {
 	if conf.Revocation == nil {
 		conf.Scheduler = gocron.NewScheduler()
 		conf.Scheduler.Start()
-		conf.Revocation = &RevocationStorage{conf: conf}
+		conf.Revocation = &RevocationStorage{conf: conf, db: conf.options.RevocationDB}
 		if err = conf.Revocation.Load(
 			Logger.IsLevelEnabled(logrus.DebugLevel),
 			conf.options.RevocationDBType,
@@ -538,6 +557,7 @@ func (conf *Configuration) clear() {
 	conf.kssPublicKeys = make(map[SchemeManagerIdentifier]map[int]*rsa.PublicKey)
 	conf.publicKeys = make(map[IssuerIdentifier]map[uint]*gabikeys.PublicKey)
 	conf.reverseHashes = make(map[string]CredentialTypeIdentifier)
+	conf.invalidateCatalog()
 	if conf.PrivateKeys == nil { // keep if already populated
 		conf.PrivateKeys = &privateKeyRingMerge{}
 	}
@@ -747,6 +767,23 @@ func (conf *Configuration) validateAttributes(cred *CredentialType) error {
 // validateTranslations checks for each member of the interface o that is of type TranslatedString
 // that it contains all necessary translations.
 func (conf *Configuration) validateTranslations(file string, o interface{}) {
+	missing := missingTranslations(o, validLangs)
+	names := make([]string, 0, len(missing))
+	for name := range missing {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, invalidLang := range missing[name] {
+			conf.Warnings = append(conf.Warnings, fmt.Sprintf("%s misses %s translation in <%s> tag", file, invalidLang, name))
+		}
+	}
+}
+
+// missingTranslations returns, per name of the members of the interface o that are of type
+// TranslatedString, the languages among langs for which the member has no translation.
+func missingTranslations(o interface{}, langs []string) map[string][]string {
+	missing := map[string][]string{}
 	v := reflect.ValueOf(o)
 
 	// Dereference in case of pointer or interface
@@ -771,7 +808,7 @@ func (conf *Configuration) validateTranslations(file string, o interface{}) {
 		if field.Type() == reflect.TypeOf(&translatedString) {
 			tmp := field.Interface().(*TranslatedString)
 			if tmp == nil {
-				return
+				return missing
 			}
 			val = *tmp
 		} else {
@@ -779,15 +816,15 @@ func (conf *Configuration) validateTranslations(file string, o interface{}) {
 		}
 
 		// assuming that translations also never should be empty
-		if l := val.validate(); len(l) > 0 {
-			for _, invalidLang := range l {
-				conf.Warnings = append(conf.Warnings, fmt.Sprintf("%s misses %s translation in <%s> tag", file, invalidLang, name))
-			}
+		if l := val.missing(langs); len(l) > 0 {
+			missing[name] = l
 		}
 	}
+	return missing
 }
 
 func (conf *Configuration) join(other *Configuration) {
+	conf.invalidateCatalog()
 	for key, val := range other.SchemeManagers {
 		conf.SchemeManagers[key] = val
 	}
@@ -1,20 +1,33 @@
 package irma
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"encoding/json"
 	"encoding/xml"
+	goerrors "errors"
+	"fmt"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/go-chi/chi"
 	"github.com/privacybydesign/gabi"
 	"github.com/privacybydesign/gabi/big"
 	"github.com/privacybydesign/gabi/gabikeys"
 	"github.com/privacybydesign/gabi/revocation"
+	"github.com/privacybydesign/gabi/signed"
 	"github.com/privacybydesign/irmago/internal/common"
 	"github.com/privacybydesign/irmago/internal/test"
 	"github.com/sirupsen/logrus"
@@ -96,6 +109,381 @@ func TestUpdateConfiguration(t *testing.T) {
 	require.Contains(t, updated.RequestorSchemes, requestorschemeid)
 }
 
+func TestSchemeUpdates(t *testing.T) {
+	storage := test.SetupTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+	test.StartSchemeManagerHttpServer()
+	defer test.StopSchemeManagerHttpServer()
+
+	conf, err := NewConfiguration(filepath.Join(storage, "client"), ConfigurationOptions{Assets: filepath.Join("testdata", "irma_configuration")})
+	require.NoError(t, err)
+	require.NoError(t, conf.ParseFolder())
+
+	var updates []*SchemeUpdate
+	unsubscribe := conf.SubscribeSchemeUpdates(func(update *SchemeUpdate) {
+		updates = append(updates, update)
+	})
+
+	// Nothing is delivered for up-to-date schemes
+	schemeid := NewSchemeManagerIdentifier("irma-demo")
+	scheme := conf.SchemeManagers[schemeid]
+	scheme.URL = "http://localhost:48681/irma_configuration/irma-demo"
+	require.NoError(t, conf.UpdateScheme(scheme, nil))
+	require.Empty(t, updates)
+
+	// Update to a copy of the scheme in which a credential type was modified
+	oldTimestamp := scheme.Timestamp
+	scheme.URL = "http://localhost:48681/irma_configuration_updated/irma-demo"
+	require.NoError(t, conf.UpdateScheme(scheme, nil))
+	require.Len(t, updates, 1)
+	update := updates[0]
+	require.NoError(t, update.Error)
+	require.Equal(t, "irma-demo", update.Scheme)
+	require.Equal(t, SchemeTypeIssuer, update.Type)
+	require.Equal(t, oldTimestamp, update.OldTimestamp)
+	require.Equal(t, conf.SchemeManagers[schemeid].Timestamp, update.NewTimestamp)
+	require.Contains(t, update.Changed.SchemeManagers, schemeid)
+	require.Contains(t, update.Changed.CredentialTypes, NewCredentialTypeIdentifier("irma-demo.RU.studentCard"))
+	require.Contains(t, update.Added.AttributeTypes, NewAttributeTypeIdentifier("irma-demo.RU.studentCard.newAttribute"))
+	require.Empty(t, update.Removed.CredentialTypes)
+	require.Empty(t, update.Removed.PublicKeys)
+
+	// Failed updates are delivered with their error
+	scheme = conf.SchemeManagers[schemeid]
+	scheme.Timestamp = Timestamp(time.Time(scheme.Timestamp).Add(-1000 * time.Hour))
+	scheme.URL = "http://localhost:48681/nonexisting"
+	require.Error(t, conf.UpdateScheme(scheme, nil))
+	require.Len(t, updates, 2)
+	require.Error(t, updates[1].Error)
+	require.Nil(t, updates[1].Added)
+
+	// After unsubscribing, nothing is delivered anymore
+	unsubscribe()
+	require.Error(t, conf.UpdateScheme(scheme, nil))
+	require.Len(t, updates, 2)
+}
+
+func TestSchemeThresholdSignatures(t *testing.T) {
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+
+	// The existing single-key format is unchanged
+	pkbts, err := ioutil.ReadFile(filepath.Join("testdata", "irma_configuration", "irma-demo", "pk.pem"))
+	require.NoError(t, err)
+	pks, threshold, err := ParseSchemePublicKeys(pkbts)
+	require.NoError(t, err)
+	require.Len(t, pks, 1)
+	require.Equal(t, 1, threshold)
+	bts, err := MarshalSchemePublicKeys(pks, 1)
+	require.NoError(t, err)
+	require.Equal(t, strings.TrimSpace(string(pkbts)), strings.TrimSpace(string(bts)))
+
+	// Duplicate keys would let a single signer count multiple times towards the threshold
+	_, err = MarshalSchemePublicKeys([]*ecdsa.PublicKey{pks[0], pks[0]}, 2)
+	require.Error(t, err)
+	_, _, err = ParseSchemePublicKeys(append(append([]byte{}, pkbts...), pkbts...))
+	require.Error(t, err)
+
+	// Create a local and remote version of irma-demo signed 2-of-3
+	var sks []*ecdsa.PrivateKey
+	pks = nil
+	for i := 0; i < 3; i++ {
+		sk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		sks = append(sks, sk)
+		pks = append(pks, &sk.PublicKey)
+	}
+	pkbts, err = MarshalSchemePublicKeys(pks, 2)
+	require.NoError(t, err)
+	sign := func(dir string, signers ...int) {
+		require.NoError(t, os.Remove(filepath.Join(dir, "index.sig")))
+		index, err := ioutil.ReadFile(filepath.Join(dir, "index"))
+		require.NoError(t, err)
+		for _, i := range signers {
+			sig, err := signed.Sign(sks[i], index)
+			require.NoError(t, err)
+			require.NoError(t, ioutil.WriteFile(filepath.Join(dir, SchemeSignatureFilename(i)), sig, 0644))
+		}
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "pk.pem"), pkbts, 0644))
+	}
+	local := filepath.Join(storage, "conf", "irma-demo")
+	remote := filepath.Join(storage, "remote", "irma-demo")
+	require.NoError(t, common.CopyDirectory(filepath.Join("testdata", "irma_configuration", "irma-demo"), local))
+	require.NoError(t, common.CopyDirectory(filepath.Join("testdata", "irma_configuration_updated", "irma-demo"), remote))
+	sign(local, 1)
+	sign(remote, 0, 2)
+
+	// A single signature does not suffice
+	conf, err := NewConfiguration(filepath.Dir(local), ConfigurationOptions{})
+	require.NoError(t, err)
+	_, err = conf.ParseSchemeFolder(local)
+	require.Error(t, err)
+
+	// Two do
+	sig, err := signed.Sign(sks[2], mustReadFile(t, filepath.Join(local, "index")))
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(local, SchemeSignatureFilename(2)), sig, 0644))
+	conf, err = NewConfiguration(filepath.Dir(local), ConfigurationOptions{})
+	require.NoError(t, err)
+	scheme, err := conf.ParseSchemeFolder(local)
+	require.NoError(t, err)
+
+	// Updating downloads and checks all signatures and the transparency log, if any; only absent
+	// files are skipped, other errors abort the update
+	failing := ""
+	files := http.FileServer(http.Dir(filepath.Dir(remote)))
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing != "" && strings.HasSuffix(r.URL.Path, "/"+failing) {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		files.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	scheme.(*SchemeManager).URL = ts.URL + "/irma-demo"
+	for _, failing = range []string{SchemeSignatureFilename(1), SchemeLogFilename} {
+		require.Error(t, conf.UpdateScheme(scheme, nil))
+		scheme = conf.SchemeManagers[NewSchemeManagerIdentifier("irma-demo")]
+		scheme.(*SchemeManager).URL = ts.URL + "/irma-demo"
+	}
+	failing = ""
+	require.NoError(t, conf.UpdateScheme(scheme, nil))
+	require.Contains(t, conf.AttributeTypes, NewAttributeTypeIdentifier("irma-demo.RU.studentCard.newAttribute"))
+	require.FileExists(t, filepath.Join(local, "index.sig"))
+	require.FileExists(t, filepath.Join(local, "index.2.sig"))
+	_, err = os.Stat(filepath.Join(local, "index.1.sig"))
+	require.True(t, os.IsNotExist(err))
+
+	// An update signed by too few keys is rejected
+	require.NoError(t, os.Remove(filepath.Join(remote, "index.2.sig")))
+	scheme = conf.SchemeManagers[NewSchemeManagerIdentifier("irma-demo")]
+	scheme.(*SchemeManager).URL = ts.URL + "/irma-demo"
+	scheme.(*SchemeManager).Timestamp = Timestamp(time.Time(scheme.timestamp()).Add(-time.Hour))
+	err = conf.UpdateScheme(scheme, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "1 valid signatures, 2 required")
+}
+
+func mustReadFile(t *testing.T, path string) []byte {
+	bts, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	return bts
+}
+
+func TestSchemeTransparencyLog(t *testing.T) {
+	storage := test.SetupTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+
+	// Logs are hash chains that can be audited for forks
+	var log SchemeLog
+	log = log.Append([]byte("index 1"), Timestamp(time.Unix(1000, 0)))
+	log = log.Append([]byte("index 2"), Timestamp(time.Unix(2000, 0)))
+	parsed, err := ParseSchemeLog(log.Bytes())
+	require.NoError(t, err)
+	require.Equal(t, log.Bytes(), parsed.Bytes())
+	require.NotNil(t, parsed.Contains([]byte("index 1")))
+	require.Nil(t, parsed.Contains([]byte("index 3")))
+	tampered := SchemeLog{log[0]}.Append([]byte("index 3"), Timestamp(time.Unix(2000, 0)))
+	tampered[0] = SchemeLog{}.Append([]byte("index 4"), Timestamp(time.Unix(1000, 0)))[0]
+	_, err = ParseSchemeLog(tampered.Bytes())
+	require.Error(t, err)
+	forked := SchemeLog{log[0]}.Append([]byte("index 3"), Timestamp(time.Unix(2000, 0)))
+	require.Empty(t, AuditSchemeLogs(log, SchemeLog{log[0]}))
+	forks := AuditSchemeLogs(log, SchemeLog{log[0]}, forked)
+	require.Len(t, forks, 1)
+	require.Equal(t, uint64(1), forks[[2]int{0, 2}].Sequence)
+
+	// Set up a scheme having a log, and a remote version of it
+	conf, err := NewConfiguration(filepath.Join(storage, "client"), ConfigurationOptions{Assets: filepath.Join("testdata", "irma_configuration")})
+	require.NoError(t, err)
+	require.NoError(t, conf.ParseFolder())
+	scheme := conf.SchemeManagers[NewSchemeManagerIdentifier("irma-demo")]
+	require.NoError(t, AppendSchemeLog(scheme.path()))
+	locallog, err := ReadSchemeLog(scheme.path())
+	require.NoError(t, err)
+	require.Len(t, locallog, 1)
+
+	remote := filepath.Join(storage, "remote", "irma-demo")
+	require.NoError(t, common.CopyDirectory(filepath.Join("testdata", "irma_configuration_updated", "irma-demo"), remote))
+	ts := httptest.NewServer(http.FileServer(http.Dir(filepath.Dir(remote))))
+	defer ts.Close()
+	scheme.URL = ts.URL + "/irma-demo"
+	remoteIndex := mustReadFile(t, filepath.Join(remote, "index"))
+	writeRemoteLog := func(log SchemeLog) {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(remote, SchemeLogFilename), log.Bytes(), 0644))
+	}
+
+	// Updates must be in a log extending the local log
+	err = conf.UpdateScheme(scheme, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "log is missing")
+	writeRemoteLog(SchemeLog{}.Append(remoteIndex, scheme.Timestamp))
+	err = conf.UpdateScheme(scheme, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "was modified")
+	writeRemoteLog(locallog)
+	err = conf.UpdateScheme(scheme, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "not in the scheme transparency log")
+	require.NotContains(t, conf.AttributeTypes, NewAttributeTypeIdentifier("irma-demo.RU.studentCard.newAttribute"))
+
+	writeRemoteLog(locallog.Append(remoteIndex, scheme.Timestamp))
+	require.NoError(t, conf.UpdateScheme(scheme, nil))
+	require.Contains(t, conf.AttributeTypes, NewAttributeTypeIdentifier("irma-demo.RU.studentCard.newAttribute"))
+	updatedlog, err := ReadSchemeLog(conf.SchemeManagers[scheme.Identifier()].path())
+	require.NoError(t, err)
+	require.Len(t, updatedlog, 2)
+}
+
+func TestSchemeMirror(t *testing.T) {
+	storage := test.CreateTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+	test.StartSchemeManagerHttpServer()
+	defer test.StopSchemeManagerHttpServer()
+
+	// Set up a mirror whose upstream of irma-demo contains a newer version of the scheme
+	mirrorpath := filepath.Join(storage, "mirror")
+	require.NoError(t, common.CopyDirectory(filepath.Join("testdata", "irma_configuration"), mirrorpath))
+	mirrorconf, err := NewConfiguration(mirrorpath, ConfigurationOptions{})
+	require.NoError(t, err)
+	require.NoError(t, mirrorconf.ParseFolder())
+	schemeid := NewSchemeManagerIdentifier("irma-demo")
+	mirrorconf.SchemeManagers[schemeid].URL = "http://localhost:48681/irma_configuration_updated/irma-demo"
+	mirror, err := NewSchemeMirror(mirrorconf)
+	require.NoError(t, err)
+	ts := httptest.NewServer(mirror)
+	defer ts.Close()
+
+	conf, err := NewConfiguration(filepath.Join(storage, "client"), ConfigurationOptions{
+		Assets:       filepath.Join("testdata", "irma_configuration"),
+		SchemeMirror: ts.URL,
+	})
+	require.NoError(t, err)
+	require.NoError(t, conf.ParseFolder())
+	credid := NewCredentialTypeIdentifier("irma-demo.RU.studentCard")
+	attrid := NewAttributeTypeIdentifier("irma-demo.RU.studentCard.newAttribute")
+
+	// Before syncing, the mirror serves the version we already have
+	require.NoError(t, conf.UpdateScheme(conf.SchemeManagers[schemeid], nil))
+	require.False(t, conf.CredentialTypes[credid].ContainsAttribute(attrid))
+
+	require.NoError(t, mirror.Sync())
+	require.NoError(t, conf.UpdateScheme(conf.SchemeManagers[schemeid], nil))
+	require.True(t, conf.CredentialTypes[credid].ContainsAttribute(attrid))
+
+	var status []*SchemeMirrorStatus
+	res, err := http.Get(ts.URL + "/status.json")
+	require.NoError(t, err)
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&status))
+	require.NoError(t, res.Body.Close())
+	require.Len(t, status, 3)
+	require.Equal(t, "irma-demo", status[0].Scheme)
+	require.NotNil(t, status[0].LastSuccess)
+	require.Empty(t, status[0].Error)
+	require.Equal(t, conf.SchemeManagers[schemeid].Timestamp, status[0].Timestamp)
+
+	// Files not belonging to the scheme are not served
+	require.NoError(t, common.SaveFile(filepath.Join(mirrorpath, "test", "secret.txt"), []byte("secret")))
+	for _, path := range []string{"/test/secret.txt", "/test/../irma-demo/index", "/tempscheme/index", "/irma-demo/"} {
+		res, err = http.Get(ts.URL + path)
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		require.Equal(t, http.StatusNotFound, res.StatusCode, path)
+	}
+
+	// Failed synchronizations are reported in the status
+	mirrorconf.SchemeManagers[NewSchemeManagerIdentifier("test")].URL = "http://localhost:48681/nonexisting"
+	require.Error(t, mirror.Sync())
+	status = mirror.Status()
+	require.Equal(t, "test", status[1].Scheme)
+	require.NotEmpty(t, status[1].Error)
+	require.Empty(t, status[0].Error)
+}
+
+func TestSchemeHistory(t *testing.T) {
+	storage := test.SetupTestStorage(t)
+	defer test.ClearTestStorage(t, storage)
+	test.StartSchemeManagerHttpServer()
+	defer test.StopSchemeManagerHttpServer()
+
+	conf, err := NewConfiguration(filepath.Join(storage, "client"), ConfigurationOptions{
+		Assets:        filepath.Join("testdata", "irma_configuration"),
+		SchemeHistory: 2,
+	})
+	require.NoError(t, err)
+	require.NoError(t, conf.ParseFolder())
+
+	schemeid := NewSchemeManagerIdentifier("irma-demo")
+	attrid := NewAttributeTypeIdentifier("irma-demo.RU.studentCard.newAttribute")
+	original := conf.SchemeManagers[schemeid].Timestamp
+	history, err := conf.SchemeHistory(conf.SchemeManagers[schemeid])
+	require.NoError(t, err)
+	require.Empty(t, history)
+
+	// Updating the scheme adds the previous version to the history
+	updateScheme := func() {
+		scheme := conf.SchemeManagers[schemeid]
+		scheme.URL = "http://localhost:48681/irma_configuration_updated/irma-demo"
+		require.NoError(t, conf.UpdateScheme(scheme, nil))
+	}
+	updateScheme()
+	require.Contains(t, conf.AttributeTypes, attrid)
+	scheme := conf.SchemeManagers[schemeid]
+	updatedTimestamp := scheme.Timestamp
+	history, err = conf.SchemeHistory(scheme)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	require.True(t, time.Time(history[0].Timestamp).Equal(time.Time(original)))
+
+	// The history directory is ignored when parsing the configuration
+	conf2, err := NewConfiguration(conf.Path, ConfigurationOptions{})
+	require.NoError(t, err)
+	require.NoError(t, conf2.ParseFolder())
+
+	// Roll back to the previous version, which pins the scheme to that version
+	var updates []*SchemeUpdate
+	unsubscribe := conf.SubscribeSchemeUpdates(func(update *SchemeUpdate) {
+		updates = append(updates, update)
+	})
+	require.NoError(t, conf.RollbackScheme(scheme, nil))
+	unsubscribe()
+	scheme = conf.SchemeManagers[schemeid]
+	require.True(t, time.Time(scheme.Timestamp).Equal(time.Time(original)))
+	require.NotContains(t, conf.AttributeTypes, attrid)
+	require.Len(t, updates, 1)
+	require.NoError(t, updates[0].Error)
+	require.Equal(t, updatedTimestamp, updates[0].OldTimestamp)
+	require.Equal(t, scheme.Timestamp, updates[0].NewTimestamp)
+	require.Contains(t, updates[0].Removed.AttributeTypes, attrid)
+	pin, err := conf.SchemePin(scheme)
+	require.NoError(t, err)
+	require.NotNil(t, pin)
+	require.True(t, time.Time(*pin).Equal(time.Time(original)))
+	history, err = conf.SchemeHistory(scheme)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	require.True(t, time.Time(history[0].Timestamp).Equal(time.Time(updatedTimestamp)))
+
+	// The pinned scheme is not updated
+	updateScheme()
+	require.NotContains(t, conf.AttributeTypes, attrid)
+
+	// After unpinning, it is updated again
+	require.NoError(t, conf.UnpinScheme(conf.SchemeManagers[schemeid]))
+	pin, err = conf.SchemePin(conf.SchemeManagers[schemeid])
+	require.NoError(t, err)
+	require.Nil(t, pin)
+	updateScheme()
+	require.Contains(t, conf.AttributeTypes, attrid)
+	history, err = conf.SchemeHistory(conf.SchemeManagers[schemeid])
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+
+	// Rolling back to a version not in the history fails
+	unknown := Timestamp(time.Unix(1000, 0))
+	require.Error(t, conf.RollbackScheme(conf.SchemeManagers[schemeid], &unknown))
+}
+
 func TestParseInvalidIrmaConfiguration(t *testing.T) {
 	// The description.xml of the scheme manager under this folder has been edited
 	// to invalidate the scheme manager signature
@@ -262,6 +650,378 @@ func TestInstallScheme(t *testing.T) {
 	require.NotNil(t, sk)
 }
 
+func TestDiffSchemes(t *testing.T) {
+	id := NewSchemeManagerIdentifier("irma-demo")
+	oldconf, newconf := parseConfiguration(t), parseConfiguration(t)
+
+	diff, err := DiffSchemes(oldconf, newconf, id)
+	require.NoError(t, err)
+	require.Empty(t, diff.Changes)
+
+	// Remove the first attribute of a credential type, change a translation,
+	// and deprecate and remove issuers and credential types
+	studentCard := NewCredentialTypeIdentifier("irma-demo.RU.studentCard")
+	credtype := *newconf.CredentialTypes[studentCard]
+	credtype.AttributeTypes = nil
+	for _, attr := range newconf.CredentialTypes[studentCard].AttributeTypes[1:] {
+		a := *attr
+		a.Index--
+		credtype.AttributeTypes = append(credtype.AttributeTypes, &a)
+	}
+	credtype.Name = TranslatedString{"en": "Student card", "nl": "Studentenkaart"}
+	newconf.CredentialTypes[studentCard] = &credtype
+	issuer := *newconf.Issuers[NewIssuerIdentifier("irma-demo.MijnOverheid")]
+	issuer.DeprecatedSince = Timestamp(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	newconf.Issuers[issuer.Identifier()] = &issuer
+	delete(newconf.CredentialTypes, NewCredentialTypeIdentifier("irma-demo.MijnOverheid.fullName"))
+	// Changes to other schemes are ignored
+	delete(newconf.CredentialTypes, NewCredentialTypeIdentifier("test.test.email"))
+
+	diff, err = DiffSchemes(oldconf, newconf, id)
+	require.NoError(t, err)
+	changes := map[string]*SchemeChange{}
+	for _, c := range diff.Changes {
+		changes[string(c.Type)+" "+c.Subject+" "+c.Field] = c
+	}
+	require.Contains(t, changes, "issuer-deprecated irma-demo.MijnOverheid DeprecatedSince")
+	require.Equal(t, "2020-01-01", changes["issuer-deprecated irma-demo.MijnOverheid DeprecatedSince"].New)
+	require.Contains(t, changes, "credentialtype-removed irma-demo.MijnOverheid.fullName ")
+	require.Contains(t, changes, "translation-changed irma-demo.RU.studentCard Name.en")
+	removed := changes["attribute-removed irma-demo.RU.studentCard.university "]
+	require.NotNil(t, removed)
+	require.True(t, removed.Breaking)
+	moved := changes["attribute-moved irma-demo.RU.studentCard.level Index"]
+	require.NotNil(t, moved)
+	require.True(t, moved.Breaking)
+	require.Equal(t, "3", moved.Old)
+	require.Equal(t, "2", moved.New)
+	for _, c := range diff.Changes {
+		require.True(t, strings.HasPrefix(c.Subject, "irma-demo."))
+	}
+
+	// Reversing the diff turns removals into additions, which are not breaking
+	diff, err = DiffSchemes(newconf, oldconf, id)
+	require.NoError(t, err)
+	for _, c := range diff.Changes {
+		if c.Type == SchemeChangeAttributeAdded {
+			require.Equal(t, "irma-demo.RU.studentCard.university", c.Subject)
+			require.False(t, c.Breaking)
+		}
+	}
+}
+
+func TestSchemeLint(t *testing.T) {
+	conf := parseConfiguration(t)
+	lint := func(opts LintOptions) map[string][]*LintIssue {
+		issues, err := conf.Lint(opts)
+		require.NoError(t, err)
+		rules := map[string][]*LintIssue{}
+		for _, issue := range issues {
+			rules[issue.Rule] = append(rules[issue.Rule], issue)
+		}
+		return rules
+	}
+
+	// The latest public key of irma-demo.MijnOverheid has expired
+	issues := lint(LintOptions{})
+	require.Len(t, issues["no-valid-key"], 3)
+	require.Equal(t, LintSeverityError, issues["no-valid-key"][0].Severity)
+	require.Empty(t, issues["translations"])
+	require.Empty(t, lint(LintOptions{Severities: map[string]LintSeverity{"no-valid-key": LintSeverityOff}})["no-valid-key"])
+	_, err := conf.Lint(LintOptions{Severities: map[string]LintSeverity{"nonexisting": LintSeverityError}})
+	require.Error(t, err)
+
+	// Translations are checked for the configured languages
+	issues = lint(LintOptions{Languages: []string{"en", "nl", "de"}})
+	require.NotEmpty(t, issues["translations"])
+	require.Equal(t, LintSeverityWarning, issues["translations"][0].Severity)
+
+	// Issues are ranked by severity
+	all, err := conf.Lint(LintOptions{Severities: map[string]LintSeverity{"no-valid-key": LintSeverityInfo}, Languages: []string{"de"}})
+	require.NoError(t, err)
+	require.Equal(t, "translations", all[0].Rule)
+	require.Equal(t, "no-valid-key", all[len(all)-1].Rule)
+
+	// Inconsistent displayIndex values and invalid or unreachable IssueURLs
+	ts := httptest.NewServer(http.NotFoundHandler())
+	defer ts.Close()
+	studentCard := conf.CredentialTypes[NewCredentialTypeIdentifier("irma-demo.RU.studentCard")]
+	index := 0
+	studentCard.AttributeTypes[1].DisplayIndex = &index
+	studentCard.IssueURL = TranslatedString{"en": "not a url", "nl": ts.URL}
+	issues = lint(LintOptions{CheckURLs: true})
+	require.Len(t, issues["display-index"], 2)
+	require.Len(t, issues["issue-url"], 2)
+	require.Len(t, lint(LintOptions{})["issue-url"], 1)
+
+	// Too complex issue wizards
+	no := false
+	wizard := &IssueWizard{ID: NewIssueWizardIdentifier("test-requestors.wizard"), ExpandDependencies: &no}
+	for i := 0; i < maxWizardComplexity; i++ {
+		wizard.Contents = append(wizard.Contents, [][]IssueWizardItem{{{Type: IssueWizardItemTypeWebsite}}})
+	}
+	conf.IssueWizards[wizard.ID] = wizard
+	issues = lint(LintOptions{})
+	require.Len(t, issues["wizard-complexity"], 1)
+	require.Equal(t, wizard.ID.String(), issues["wizard-complexity"][0].Subject)
+}
+
+func TestSchemeBundle(t *testing.T) {
+	storage, err := ioutil.TempDir("", "irmaconf")
+	require.NoError(t, err)
+	defer test.ClearTestStorage(t, storage)
+	conf, err := NewConfiguration(storage, ConfigurationOptions{})
+	require.NoError(t, err)
+
+	var bundle, updated bytes.Buffer
+	require.NoError(t, WriteSchemeBundle(filepath.Join("testdata", "irma_configuration", "irma-demo"), &bundle))
+	require.NoError(t, WriteSchemeBundle(filepath.Join("testdata", "irma_configuration_updated", "irma-demo"), &updated))
+	pk, err := ioutil.ReadFile(filepath.Join("testdata", "irma_configuration", "test", "pk.pem"))
+	require.NoError(t, err)
+
+	// Bundles signed with another key are rejected
+	require.Error(t, conf.InstallSchemeBundle(bytes.NewReader(bundle.Bytes()), pk))
+	require.NotContains(t, conf.SchemeManagers, NewSchemeManagerIdentifier("irma-demo"))
+
+	pk, err = ioutil.ReadFile(filepath.Join("testdata", "irma_configuration", "irma-demo", "pk.pem"))
+	require.NoError(t, err)
+	require.NoError(t, conf.InstallSchemeBundle(bytes.NewReader(bundle.Bytes()), pk))
+	require.Contains(t, conf.SchemeManagers, NewSchemeManagerIdentifier("irma-demo"))
+	require.Equal(t, SchemeManagerStatusValid, conf.SchemeManagers[NewSchemeManagerIdentifier("irma-demo")].Status)
+	require.Error(t, conf.InstallSchemeBundle(bytes.NewReader(bundle.Bytes()), pk))
+
+	// Updating to the same version is a no-op; updating to a newer version applies its changes
+	require.NoError(t, conf.UpdateSchemeBundle(bytes.NewReader(bundle.Bytes()), nil))
+	credid := NewCredentialTypeIdentifier("irma-demo.RU.studentCard")
+	attrid := NewAttributeTypeIdentifier("irma-demo.RU.studentCard.newAttribute")
+	require.False(t, conf.CredentialTypes[credid].ContainsAttribute(attrid))
+	var updates []*SchemeUpdate
+	conf.SubscribeSchemeUpdates(func(update *SchemeUpdate) {
+		updates = append(updates, update)
+	})
+	downloaded := newIrmaIdentifierSet()
+	require.NoError(t, conf.UpdateSchemeBundle(bytes.NewReader(updated.Bytes()), downloaded))
+	require.True(t, conf.CredentialTypes[credid].ContainsAttribute(attrid))
+	require.Contains(t, downloaded.CredentialTypes, credid)
+	require.Len(t, updates, 1)
+	require.NoError(t, updates[0].Error)
+	require.Contains(t, updates[0].Changed.CredentialTypes, credid)
+	require.Contains(t, updates[0].Added.AttributeTypes, attrid)
+
+	// The installed scheme is the same as the one that was bundled
+	parsed, err := NewConfiguration(storage, ConfigurationOptions{ReadOnly: true})
+	require.NoError(t, err)
+	require.NoError(t, parsed.ParseFolder())
+	require.True(t, parsed.CredentialTypes[credid].ContainsAttribute(attrid))
+
+	// Bundles containing files outside of the scheme directory are rejected
+	var evil bytes.Buffer
+	gz := gzip.NewWriter(&evil)
+	tw := tar.NewWriter(gz)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "irma-demo/../../evil", Mode: 0644, Size: 1}))
+	_, err = tw.Write([]byte("x"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	require.Error(t, conf.DangerousTOFUInstallSchemeBundle(&evil))
+	require.NoError(t, common.AssertPathNotExists(filepath.Join(filepath.Dir(storage), "evil")))
+}
+
+func TestSchemeNewDescriptions(t *testing.T) {
+	storage, err := ioutil.TempDir("", "scheme")
+	require.NoError(t, err)
+	defer test.ClearTestStorage(t, storage)
+	dir := filepath.Join(storage, "irma-demo")
+	require.NoError(t, common.CopyDirectory(filepath.Join("testdata", "irma_configuration", "irma-demo"), dir))
+
+	scheme, err := ReadSchemeDescription(dir)
+	require.NoError(t, err)
+	require.Equal(t, "irma-demo", scheme.ID)
+
+	// Invalid descriptions are rejected by the same validation as when parsing schemes
+	issuer := &Issuer{ID: "Acme", SchemeManagerID: "irma-demo", XMLVersion: 4,
+		Name: TranslatedString{"en": "Acme", "nl": "Demo Acme"}}
+	_, err = scheme.ValidateIssuer(issuer)
+	require.Error(t, err) // demo names must be prefixed with "Demo "
+	issuer.ID = "Ac.me"
+	_, err = scheme.ValidateIssuer(issuer)
+	require.Error(t, err)
+
+	issuer.ID, issuer.Name["en"] = "Acme", "Demo Acme"
+	warnings, err := scheme.ValidateIssuer(issuer)
+	require.NoError(t, err)
+	require.Contains(t, warnings, "Issuer irma-demo.Acme has no public keys")
+	_, err = scheme.WriteIssuer(issuer)
+	require.NoError(t, err)
+	_, err = scheme.WriteIssuer(issuer)
+	require.Error(t, err)
+	read, err := scheme.Issuer("Acme")
+	require.NoError(t, err)
+	require.Equal(t, issuer.Name, read.Name)
+	require.Equal(t, issuer.SchemeManagerID, read.SchemeManagerID)
+
+	cred := &CredentialType{
+		ID: "member", IssuerID: "Acme", SchemeManagerID: "irma-demo", XMLVersion: 4,
+		Name:              TranslatedString{"en": "Demo Member", "nl": "Demo Lid"},
+		Description:       TranslatedString{"en": "Membership", "nl": "Lidmaatschap"},
+		RevocationServers: []string{"http://localhost:48683"},
+		AttributeTypes: []*AttributeType{
+			{ID: "number", Name: TranslatedString{"en": "Number"}, Description: TranslatedString{"en": "Number", "nl": "Nummer"}},
+			{ID: "number", Name: TranslatedString{"en": "Number", "nl": "Nummer"}},
+		},
+	}
+	_, err = scheme.ValidateCredentialType(read, cred)
+	require.Error(t, err) // duplicate attribute
+	cred.AttributeTypes[1].ID = "level"
+	_, err = scheme.ValidateCredentialType(read, cred)
+	require.Error(t, err) // revocation servers but no revocation attribute
+	cred.AttributeTypes = append(cred.AttributeTypes, &AttributeType{RevocationAttribute: true})
+	warnings, err = scheme.ValidateCredentialType(read, cred)
+	require.NoError(t, err)
+	require.Contains(t, warnings, "Attribute number of credential type irma-demo.Acme.member misses nl translation in <Name> tag")
+	_, err = scheme.WriteCredentialType(cred)
+	require.NoError(t, err)
+
+	bts, err := ioutil.ReadFile(filepath.Join(dir, "Acme", "Issues", "member", "description.xml"))
+	require.NoError(t, err)
+	parsed := &CredentialType{}
+	require.NoError(t, xml.Unmarshal(bts, parsed))
+	require.Equal(t, cred.Name, parsed.Name)
+	require.Equal(t, cred.RevocationServers, parsed.RevocationServers)
+	require.Len(t, parsed.AttributeTypes, 3)
+	require.Equal(t, "level", parsed.AttributeTypes[1].ID)
+	require.True(t, parsed.AttributeTypes[2].RevocationAttribute)
+	_, err = scheme.ValidateCredentialType(read, parsed)
+	require.NoError(t, err)
+}
+
+func TestDescriptionSourceFormats(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "irma_configuration", "*", "*", "description.xml"))
+	require.NoError(t, err)
+	creds, err := filepath.Glob(filepath.Join("testdata", "irma_configuration", "*", "*", "Issues", "*", "description.xml"))
+	require.NoError(t, err)
+	files = append(files, creds...)
+	require.NotEmpty(t, creds)
+
+	for _, file := range files {
+		bts := mustReadFile(t, file)
+		original, err := ParseDescription(bts, DescriptionFormatXML)
+		require.NoError(t, err, file)
+		for _, format := range []DescriptionFormat{DescriptionFormatJSON, DescriptionFormatYAML} {
+			source, err := ConvertDescription(bts, DescriptionFormatXML, format)
+			require.NoError(t, err, file)
+			x, err := ConvertDescription(source, format, DescriptionFormatXML)
+			require.NoError(t, err, file)
+			parsed, err := ParseDescription(x, DescriptionFormatXML)
+			require.NoError(t, err, file)
+			require.Equal(t, original, parsed, "%s via %s", file, format)
+			again, err := ConvertDescription(x, DescriptionFormatXML, format)
+			require.NoError(t, err, file)
+			require.Equal(t, string(source), string(again))
+		}
+	}
+
+	yml := []byte(`
+version: 4
+scheme: irma-demo
+issuer: MijnOverheid
+id: member
+name: {en: Demo Member, nl: Demo Lid}
+faqSummary: {en: Summary, nl: Samenvatting}
+inCredentialStore: true
+deprecatedSince: 1600000000
+dependencies: [[[irma-demo.MijnOverheid.root]]]
+attributes:
+  - id: number
+    name: {en: Number, nl: Nummer}
+    optional: true
+    displayIndex: 0
+`)
+	description, err := ParseDescription(yml, DescriptionFormatYAML)
+	require.NoError(t, err)
+	cred := description.(*CredentialType)
+	require.Equal(t, "true", cred.AttributeTypes[0].Optional)
+	require.Equal(t, "Samenvatting", (*cred.FAQSummary)["nl"])
+	require.Equal(t, NewCredentialTypeIdentifier("irma-demo.MijnOverheid.root"), cred.Dependencies[0][0][0])
+	x, err := MarshalDescription(cred, DescriptionFormatXML)
+	require.NoError(t, err)
+	require.Contains(t, string(x), "<CredentialType>irma-demo.MijnOverheid.root</CredentialType>")
+	require.Contains(t, string(x), `<Attribute id="number" optional="true" displayIndex="0">`)
+	parsed := &CredentialType{}
+	require.NoError(t, xml.Unmarshal(x, parsed))
+	require.Equal(t, cred, parsed)
+
+	// Misspelled fields are rejected
+	_, err = ParseDescription([]byte("scheme: irma-demo\nid: Acme\ncontactEmial: info@example.com\n"), DescriptionFormatYAML)
+	require.Error(t, err)
+}
+
+func TestRequestorSchemeTools(t *testing.T) {
+	storage, err := ioutil.TempDir("", "scheme")
+	require.NoError(t, err)
+	defer test.ClearTestStorage(t, storage)
+	dir := filepath.Join(storage, "test-requestors")
+	require.NoError(t, common.CopyDirectory(filepath.Join("testdata", "irma_configuration", "test-requestors"), dir))
+
+	scheme, err := ReadRequestorScheme(dir)
+	require.NoError(t, err)
+	require.Len(t, scheme.Requestors(), 1)
+	existing := scheme.Requestor(NewRequestorIdentifier("test-requestors.test-requestor"))
+	require.NotNil(t, existing)
+
+	// Hostnames must be unique across requestors
+	acme := &RequestorInfo{
+		ID:        NewRequestorIdentifier("test-requestors.acme"),
+		Name:      TranslatedString{"en": "Acme", "nl": "Acme"},
+		Hostnames: []string{"localhost"},
+	}
+	require.Error(t, scheme.SetRequestor(acme))
+	acme.Hostnames = []string{"acme.example.com"}
+	require.Error(t, scheme.SetRequestor(&RequestorInfo{ID: NewRequestorIdentifier("other-scheme.acme")}))
+
+	// Logos are named after their hash
+	png := mustReadFile(t, filepath.Join(dir, "assets", "61a1fc7f161e43f8fc5b0c6ac2997cfe6bc0da7d27009b9914a04dca79ec6718.png"))
+	logo, err := scheme.AddLogo(png)
+	require.NoError(t, err)
+	require.Equal(t, "61a1fc7f161e43f8fc5b0c6ac2997cfe6bc0da7d27009b9914a04dca79ec6718", logo)
+	_, err = scheme.AddLogo([]byte("not a png"))
+	require.Error(t, err)
+	acme.Logo = &logo
+	require.NoError(t, scheme.SetRequestor(acme))
+	require.Empty(t, scheme.HostnameConflicts())
+
+	cred := NewCredentialTypeIdentifier("irma-demo.MijnOverheid.root")
+	wizard := &IssueWizard{
+		ID:       NewIssueWizardIdentifier("test-requestors.acme.wizard"),
+		Title:    TranslatedString{"en": "Wizard", "nl": "Wizard"},
+		Contents: IssueWizardContents{{{{Type: IssueWizardItemTypeCredential, Credential: &cred}}}},
+	}
+	_, err = scheme.SetIssueWizard(nil, wizard)
+	require.NoError(t, err)
+	wizard.ID = NewIssueWizardIdentifier("test-requestors.nonexisting.wizard")
+	_, err = scheme.SetIssueWizard(nil, wizard)
+	require.Error(t, err)
+
+	// New requestors go to a new chunk if the existing ones are full
+	require.NoError(t, scheme.WriteRequestors(1))
+	require.FileExists(t, filepath.Join(dir, "requestors-2.json"))
+	scheme, err = ReadRequestorScheme(dir)
+	require.NoError(t, err)
+	require.Len(t, scheme.Requestors(), 2)
+	read := scheme.Requestor(acme.ID)
+	require.NotNil(t, read)
+	require.Equal(t, acme.Hostnames, read.Hostnames)
+	require.Contains(t, read.Wizards, NewIssueWizardIdentifier("test-requestors.acme.wizard"))
+
+	// Rechunking removes chunks that became empty
+	require.NoError(t, scheme.Rechunk(2))
+	require.NoError(t, scheme.WriteRequestors(2))
+	require.NoFileExists(t, filepath.Join(dir, "requestors-2.json"))
+	scheme, err = ReadRequestorScheme(dir)
+	require.NoError(t, err)
+	require.Len(t, scheme.Requestors(), 2)
+}
+
 func TestMetadataAttribute(t *testing.T) {
 	metadata := NewMetadataAttribute(0x02)
 	if metadata.Version() != 0x02 {
@@ -689,8 +1449,8 @@ var (
 
 func TestRevocationMemoryStore(t *testing.T) {
 	conf := parseConfiguration(t)
-	db := conf.Revocation.memdb
-	require.NotNil(t, db)
+	rs := conf.Revocation
+	require.IsType(t, &memRevStorage{}, rs.db)
 
 	// prepare key material
 	sk, err := conf.Revocation.Keys.PrivateKey(revocationTestCred.IssuerIdentifier(), revocationPkCounter)
@@ -703,34 +1463,421 @@ func TestRevocationMemoryStore(t *testing.T) {
 	require.NoError(t, err)
 
 	// insert and retrieve it and check its validity
-	db.Insert(revocationTestCred, update)
-	retrieve(t, pk, db, 0, 0)
+	require.NoError(t, rs.AddUpdate(revocationTestCred, update))
+	retrieve(t, pk, rs, 0, 0)
 
 	// construct new update message with a few revocation events
 	update = revokeMultiple(t, sk, update)
 	oldupdate := *update // save a copy for below
 
 	// insert it, retrieve it with a varying amount of events, verify
-	db.Insert(revocationTestCred, update)
-	retrieve(t, pk, db, 4, 3)
+	require.NoError(t, rs.AddUpdate(revocationTestCred, update))
+	retrieve(t, pk, rs, 4, 3)
 
 	// construct and test against a new update whose events have no overlap with that of our db
 	update = revokeMultiple(t, sk, update)
 	update.Events = update.Events[4:]
 	require.Equal(t, uint64(4), update.Events[0].Index)
-	db.Insert(revocationTestCred, update)
-	retrieve(t, pk, db, 4, 6)
+	require.NoError(t, rs.AddUpdate(revocationTestCred, update))
+	retrieve(t, pk, rs, 4, 6)
 
 	// attempt to insert an update that is too new
 	update = revokeMultiple(t, sk, update)
 	update.Events = update.Events[5:]
 	require.Equal(t, uint64(9), update.Events[0].Index)
-	db.Insert(revocationTestCred, update)
-	retrieve(t, pk, db, 4, 6)
+	require.NoError(t, rs.AddUpdate(revocationTestCred, update))
+	retrieve(t, pk, rs, 4, 6)
 
 	// attempt to insert an update that is too old
-	db.Insert(revocationTestCred, &oldupdate)
-	retrieve(t, pk, db, 4, 6)
+	require.NoError(t, rs.AddUpdate(revocationTestCred, &oldupdate))
+	retrieve(t, pk, rs, 4, 6)
+}
+
+// revocationTestDB is a RevocationDB that records which of its methods are called,
+// backed by the in-memory RevocationDB.
+type revocationTestDB struct {
+	*memRevStorage
+	calls map[string]int
+}
+
+func (db *revocationTestDB) Transaction(f func(tx RevocationDB) error) error {
+	db.calls["Transaction"]++
+	return db.memRevStorage.Transaction(f)
+}
+
+func (db *revocationTestDB) InsertIssuanceRecord(record *IssuanceRecord) error {
+	db.calls["InsertIssuanceRecord"]++
+	return db.memRevStorage.InsertIssuanceRecord(record)
+}
+
+func TestRevocationCustomDB(t *testing.T) {
+	db := &revocationTestDB{memRevStorage: newMemStorage(), calls: map[string]int{}}
+	conf, err := NewConfiguration("testdata/irma_configuration", ConfigurationOptions{
+		RevocationDB:       db,
+		RevocationSettings: RevocationSettings{revocationTestCred: {Authority: true}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, conf.ParseFolder())
+	rs := conf.Revocation
+	require.Equal(t, db, rs.db)
+
+	pk, err := rs.Keys.PublicKey(revocationTestCred.IssuerIdentifier(), revocationPkCounter)
+	require.NoError(t, err)
+	require.NoError(t, rs.EnableRevocation(revocationTestCred, revocationPkCounter))
+	require.Error(t, rs.EnableRevocation(revocationTestCred, revocationPkCounter))
+
+	// store an issuance record and revoke it
+	e, err := rand.Prime(rand.Reader, 100)
+	require.NoError(t, err)
+	issued := time.Now()
+	require.NoError(t, rs.SaveIssuanceRecord(revocationTestCred, &IssuanceRecord{
+		Key:        "testkey",
+		CredType:   revocationTestCred,
+		Issued:     issued.UnixNano(),
+		PKCounter:  &revocationPkCounter,
+		Attr:       (*RevocationAttribute)(big.Convert(e)),
+		ValidUntil: issued.Add(time.Hour).UnixNano(),
+	}))
+	records, err := rs.IssuanceRecords(revocationTestCred, "testkey", time.Time{})
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	require.NoError(t, rs.Revoke(revocationTestCred, "testkey", time.Time{}))
+	_, err = rs.IssuanceRecords(revocationTestCred, "testkey", time.Time{})
+	require.Equal(t, ErrUnknownRevocationKey, err)
+	require.Error(t, rs.Revoke(revocationTestCred, "testkey", time.Time{}))
+
+	// the backend should contain the revocation event and the updated accumulator
+	retrieve(t, pk, rs, 2, 1)
+	problems, err := rs.VerifyDB(revocationTestCred)
+	require.NoError(t, err)
+	require.Empty(t, problems)
+	require.Equal(t, 1, db.calls["InsertIssuanceRecord"])
+	require.NotZero(t, db.calls["Transaction"])
+}
+
+func TestRevocationMemoryTransaction(t *testing.T) {
+	db := newMemStorage()
+	counter := revocationPkCounter
+	event := func(i uint64) *EventRecord {
+		return &EventRecord{Index: &i, CredType: revocationTestCred, PKCounter: &counter}
+	}
+	issuance := &IssuanceRecord{Key: "testkey", CredType: revocationTestCred, Issued: 1, PKCounter: &counter}
+	require.NoError(t, db.InsertEvent(event(0)))
+
+	// modifications of failed transactions are reverted
+	require.Error(t, db.Transaction(func(tx RevocationDB) error {
+		require.NoError(t, tx.InsertAccumulator(&AccumulatorRecord{CredType: revocationTestCred, PKCounter: &counter}))
+		require.NoError(t, tx.InsertEvent(event(1)))
+		require.NoError(t, tx.InsertIssuanceRecord(issuance))
+		return goerrors.New("test")
+	}))
+	accs, err := db.Accumulators(revocationTestCred, nil)
+	require.NoError(t, err)
+	require.Empty(t, accs)
+	count, err := db.EventCount(revocationTestCred, counter)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), count)
+	records, err := db.IssuanceRecords(revocationTestCred, "testkey", 0)
+	require.NoError(t, err)
+	require.Empty(t, records)
+
+	// and those of successful ones are kept
+	require.NoError(t, db.Transaction(func(tx RevocationDB) error {
+		require.NoError(t, tx.InsertEvent(event(1)))
+		return tx.InsertIssuanceRecord(issuance)
+	}))
+	count, err = db.EventCount(revocationTestCred, counter)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), count)
+	records, err = db.IssuanceRecords(revocationTestCred, "testkey", 0)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+
+	// the cache used by requestor servers keeps only the latest events
+	cache := newMemCache()
+	cache.maxEvents = 3
+	for i := uint64(0); i < 5; i++ {
+		require.NoError(t, cache.InsertEvent(event(i)))
+	}
+	events, err := cache.LatestEvents(revocationTestCred, counter, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	require.Equal(t, uint64(2), *events[0].Index)
+}
+
+func TestRevocationStats(t *testing.T) {
+	conf, err := NewConfiguration("testdata/irma_configuration", ConfigurationOptions{
+		RevocationDB:       newMemStorage(),
+		RevocationSettings: RevocationSettings{revocationTestCred: {Authority: true}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, conf.ParseFolder())
+	rs := conf.Revocation
+
+	require.NoError(t, rs.EnableRevocation(revocationTestCred, revocationPkCounter))
+
+	// store issuance records expiring in the past, in the first and in the third deletion run
+	interval := time.Duration(RevocationParameters.DeleteIssuanceRecordsInterval) * time.Minute
+	now := time.Now()
+	for i, validUntil := range []time.Time{now.Add(-time.Hour), now.Add(interval / 2), now.Add(2*interval + interval/2)} {
+		e, err := rand.Prime(rand.Reader, 100)
+		require.NoError(t, err)
+		require.NoError(t, rs.SaveIssuanceRecord(revocationTestCred, &IssuanceRecord{
+			Key:        fmt.Sprintf("testkey%d", i),
+			CredType:   revocationTestCred,
+			Issued:     now.UnixNano(),
+			PKCounter:  &revocationPkCounter,
+			Attr:       (*RevocationAttribute)(big.Convert(e)),
+			ValidUntil: validUntil.UnixNano(),
+		}))
+	}
+	require.NoError(t, rs.Revoke(revocationTestCred, "testkey2", time.Time{}))
+
+	stats, err := rs.Stats(3, revocationTestCred)
+	require.NoError(t, err)
+	require.Len(t, stats, 1)
+	s := stats[0]
+	require.Equal(t, revocationPkCounter, s.PKCounter)
+	require.NotNil(t, s.AccumulatorIndex)
+	require.Equal(t, uint64(1), *s.AccumulatorIndex)
+	require.WithinDuration(t, now, *s.Updated, time.Minute)
+	require.Equal(t, uint64(2), s.Events)
+	require.Equal(t, uint64(2), s.ActiveIssuanceRecords)
+	require.Equal(t, uint64(1), s.RevokedIssuanceRecords)
+	require.Equal(t, []uint64{2, 0, 1}, s.Expiring)
+}
+
+func TestRevocationRotate(t *testing.T) {
+	conf, err := NewConfiguration("testdata/irma_configuration", ConfigurationOptions{
+		RevocationDB:       newMemStorage(),
+		RevocationSettings: RevocationSettings{revocationTestCred: {Authority: true}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, conf.ParseFolder())
+	rs := conf.Revocation
+
+	// public key 0 has no revocation parameters
+	_, err = rs.Rotate(revocationTestCred, 0)
+	require.Error(t, err)
+
+	// use key 1, and store issuance records under it: active, revoked and expired
+	rotation, err := rs.Rotate(revocationTestCred, 1)
+	require.NoError(t, err)
+	require.True(t, rotation.Created)
+	require.Empty(t, rotation.Previous)
+	oldCounter := uint(1)
+	now := time.Now()
+	for i, validUntil := range []time.Time{now.Add(time.Hour), now.Add(time.Hour), now.Add(-time.Hour)} {
+		e, err := rand.Prime(rand.Reader, 100)
+		require.NoError(t, err)
+		require.NoError(t, rs.SaveIssuanceRecord(revocationTestCred, &IssuanceRecord{
+			Key:        fmt.Sprintf("testkey%d", i),
+			CredType:   revocationTestCred,
+			Issued:     now.UnixNano(),
+			PKCounter:  &oldCounter,
+			Attr:       (*RevocationAttribute)(big.Convert(e)),
+			ValidUntil: validUntil.UnixNano(),
+		}))
+	}
+	require.NoError(t, rs.Revoke(revocationTestCred, "testkey1", time.Time{}))
+
+	// rotate to key 2
+	rotation, err = rs.Rotate(revocationTestCred, 2)
+	require.NoError(t, err)
+	require.True(t, rotation.Created)
+	exists, err := rs.Exists(revocationTestCred, 2)
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.Len(t, rotation.Previous, 1)
+	require.Equal(t, uint(1), rotation.Previous[0].PKCounter)
+	require.Len(t, rotation.Previous[0].IssuanceRecords, 1)
+	require.Equal(t, "testkey0", rotation.Previous[0].IssuanceRecords[0].Key)
+
+	// rotating again leaves the accumulator alone
+	rotation, err = rs.Rotate(revocationTestCred, 2)
+	require.NoError(t, err)
+	require.False(t, rotation.Created)
+	updates, err := rs.UpdateLatest(revocationTestCred, 1, nil)
+	require.NoError(t, err)
+	require.Len(t, updates, 2)
+}
+
+// revocationStandIn is a stand-in for a revocation server, serving fixed revocation updates.
+type revocationStandIn struct {
+	*httptest.Server
+	updates  map[uint]*revocation.Update // served at /revocation/{id}/update/{count}
+	events   []*revocation.Update        // sent over SSE, after which the connection is closed if closeSSE
+	closeSSE bool
+	records  chan []byte // issuance records received
+}
+
+func startRevocationStandIn(updates map[uint]*revocation.Update, events []*revocation.Update, closeSSE bool) *revocationStandIn {
+	s := &revocationStandIn{updates: updates, events: events, closeSSE: closeSSE, records: make(chan []byte, 10)}
+	// Route like the IRMA server does, so that requests to wrong paths fail as they would there
+	router := chi.NewRouter()
+	router.Get("/revocation/{id}/update/{count:\\d+}", func(w http.ResponseWriter, r *http.Request) {
+		bts, _ := MarshalBinary(s.updates)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = w.Write(bts)
+	})
+	router.Post("/revocation/{id}/issuancerecord/{counter:\\d+}", func(w http.ResponseWriter, r *http.Request) {
+		bts, _ := ioutil.ReadAll(r.Body)
+		s.records <- bts
+	})
+	router.Get("/revocation/{id}/updateevents", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		for _, u := range s.events {
+			bts, _ := json.Marshal(u)
+			_, _ = fmt.Fprintf(w, "data: %s\n\n", bts)
+		}
+		w.(http.Flusher).Flush()
+		if !s.closeSSE {
+			<-r.Context().Done()
+		}
+	})
+	s.Server = httptest.NewServer(router)
+	return s
+}
+
+func TestRevocationServerFailover(t *testing.T) {
+	conf := parseConfiguration(t)
+	sk, err := conf.Revocation.Keys.PrivateKey(revocationTestCred.IssuerIdentifier(), revocationPkCounter)
+	require.NoError(t, err)
+	update, err := revocation.NewAccumulator(sk)
+	require.NoError(t, err)
+	updates := map[uint]*revocation.Update{revocationPkCounter: update}
+
+	// a server that is down, one that returns server errors, and one that works
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	working := startRevocationStandIn(updates, nil, false)
+	defer working.Close()
+
+	client := RevocationClient{Conf: conf, Settings: RevocationSettings{
+		revocationTestCred: {RevocationServerURL: down.URL, FallbackServerURLs: []string{failing.URL, working.URL}},
+	}}
+	fetched, err := client.FetchUpdatesLatest(revocationTestCred, 0)
+	require.NoError(t, err)
+	require.Contains(t, fetched, revocationPkCounter)
+	require.Equal(t, update.SignedAccumulator.Data, fetched[revocationPkCounter].SignedAccumulator.Data)
+
+	// the working server is now tried first, followed by the others in order of failure
+	require.Equal(t,
+		[]string{working.URL, down.URL, failing.URL},
+		revocationServers.order([]string{down.URL, failing.URL, working.URL}),
+	)
+
+	// issuance records fail over in the same way
+	rec := &IssuanceRecord{Key: "testkey", CredType: revocationTestCred, PKCounter: &revocationPkCounter, Issued: 1}
+	require.NoError(t, client.PostIssuanceRecord(revocationTestCred, sk, rec, down.URL, working.URL))
+	require.Len(t, working.records, 1)
+	require.Error(t, client.PostIssuanceRecord(revocationTestCred, sk, rec, down.URL, failing.URL))
+}
+
+func TestRevocationServerProbe(t *testing.T) {
+	conf := parseConfiguration(t)
+	sk, err := conf.Revocation.Keys.PrivateKey(revocationTestCred.IssuerIdentifier(), revocationPkCounter)
+	require.NoError(t, err)
+	update, err := revocation.NewAccumulator(sk)
+	require.NoError(t, err)
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	recovered := startRevocationStandIn(map[uint]*revocation.Update{revocationPkCounter: update}, nil, false)
+	defer recovered.Close()
+
+	// both servers are marked as unavailable, but only the one that is down stays so after probing
+	revocationServers.failure(down.URL, revocationTestCred)
+	revocationServers.failure(recovered.URL, revocationTestCred)
+	revocationServers.probe()
+	require.Equal(t, []string{recovered.URL, down.URL}, revocationServers.order([]string{down.URL, recovered.URL}))
+	revocationServers.success(down.URL)
+}
+
+func TestRevocationFallbackWithoutURL(t *testing.T) {
+	conf, err := NewConfiguration("testdata/irma_configuration", ConfigurationOptions{
+		RevocationSettings: RevocationSettings{revocationTestCred: {FallbackServerURLs: []string{"http://localhost:48680"}}},
+	})
+	require.NoError(t, err)
+	require.Error(t, conf.ParseFolder())
+}
+
+func TestRevocationSSEFailover(t *testing.T) {
+	defer func(delay uint64) { RevocationParameters.SSEReconnectDelay = delay }(RevocationParameters.SSEReconnectDelay)
+	RevocationParameters.SSEReconnectDelay = 10
+
+	conf := parseConfiguration(t)
+	sk, err := conf.Revocation.Keys.PrivateKey(revocationTestCred.IssuerIdentifier(), revocationPkCounter)
+	require.NoError(t, err)
+	update, err := revocation.NewAccumulator(sk)
+	require.NoError(t, err)
+	newupdate := revokeMultiple(t, sk, update)
+
+	// the first server sends the initial update and then closes the connection, after which the
+	// listener should fail over to the second server, which sends a newer update only over SSE
+	first := startRevocationStandIn(map[uint]*revocation.Update{revocationPkCounter: update}, []*revocation.Update{update}, true)
+	defer first.Close()
+	second := startRevocationStandIn(map[uint]*revocation.Update{revocationPkCounter: update}, []*revocation.Update{newupdate}, false)
+	defer second.Close()
+
+	conf, err = NewConfiguration("testdata/irma_configuration", ConfigurationOptions{
+		RevocationSettings: RevocationSettings{revocationTestCred: {
+			RevocationServerURL: first.URL,
+			FallbackServerURLs:  []string{second.URL},
+			SSE:                 true,
+		}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, conf.ParseFolder())
+	defer func() { require.NoError(t, conf.Revocation.Close()) }()
+
+	require.Eventually(t, func() bool {
+		sacc, err := conf.Revocation.Accumulator(revocationTestCred, revocationPkCounter)
+		return err == nil && sacc.Accumulator.Index == 3
+	}, 5*time.Second, 20*time.Millisecond)
+}
+
+func TestRevocationWebhooks(t *testing.T) {
+	received := make(chan []byte, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bts, _ := ioutil.ReadAll(r.Body)
+		received <- bts
+	}))
+	defer hook.Close()
+
+	conf, err := NewConfiguration("testdata/irma_configuration", ConfigurationOptions{
+		RevocationDB:       newMemStorage(),
+		RevocationSettings: RevocationSettings{revocationTestCred: {Authority: true, Webhooks: []string{hook.URL}}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, conf.ParseFolder())
+	pk, err := conf.Revocation.Keys.PublicKey(revocationTestCred.IssuerIdentifier(), revocationPkCounter)
+	require.NoError(t, err)
+
+	// creating the initial accumulator should post it to the webhook
+	require.NoError(t, conf.Revocation.EnableRevocation(revocationTestCred, revocationPkCounter))
+	select {
+	case bts := <-received:
+		var update revocation.Update
+		require.NoError(t, UnmarshalBinary(bts, &update))
+		_, err = update.Verify(pk)
+		require.NoError(t, err)
+		require.Len(t, update.Events, 1)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "no update received by webhook")
+	}
+
+	// webhooks require authority mode
+	conf, err = NewConfiguration("testdata/irma_configuration", ConfigurationOptions{
+		RevocationSettings: RevocationSettings{revocationTestCred: {Webhooks: []string{hook.URL}}},
+	})
+	require.NoError(t, err)
+	require.Error(t, conf.ParseFolder())
 }
 
 func revokeMultiple(t *testing.T, sk *gabikeys.PrivateKey, update *revocation.Update) *revocation.Update {
@@ -746,18 +1893,20 @@ func revokeMultiple(t *testing.T, sk *gabikeys.PrivateKey, update *revocation.Up
 	return update
 }
 
-func retrieve(t *testing.T, pk *gabikeys.PublicKey, db *memRevStorage, count uint64, expectedIndex uint64) {
+func retrieve(t *testing.T, pk *gabikeys.PublicKey, rs *RevocationStorage, count uint64, expectedIndex uint64) {
 	var updates map[uint]*revocation.Update
 	var err error
 	for i := uint64(0); i <= count; i++ {
-		updates = db.Latest(revocationTestCred, i)
+		updates, err = rs.UpdateLatest(revocationTestCred, i, nil)
+		require.NoError(t, err)
 		require.Len(t, updates, 1)
 		require.NotNil(t, updates[revocationPkCounter])
 		require.Len(t, updates[revocationPkCounter].Events, int(i))
 		_, err = updates[revocationPkCounter].Verify(pk)
 		require.NoError(t, err)
 	}
-	sacc := db.SignedAccumulator(revocationTestCred, revocationPkCounter)
+	sacc, err := rs.Accumulator(revocationTestCred, revocationPkCounter)
+	require.NoError(t, err)
 	acc, err := sacc.UnmarshalVerify(pk)
 	require.NoError(t, err)
 	require.Equal(t, expectedIndex, acc.Index)
@@ -816,6 +1965,322 @@ func TestPrivateKeyRings(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestPrivateKeyRingEncrypted(t *testing.T) {
+	conf := parseConfiguration(t)
+	ru := NewIssuerIdentifier("irma-demo.RU")
+	dir, err := ioutil.TempDir("", "privatekeys")
+	require.NoError(t, err)
+	defer test.ClearTestStorage(t, dir)
+
+	xml := mustReadFile(t, filepath.Join(test.FindTestdataFolder(t), "privatekeys", "irma-demo.RU.2.xml"))
+	passphrase := []byte("passphrase")
+	encrypted, err := EncryptPrivateKey(xml, passphrase)
+	require.NoError(t, err)
+	require.True(t, IsEncryptedPrivateKey(encrypted))
+	require.False(t, IsEncryptedPrivateKey(xml))
+	decrypted, err := DecryptPrivateKey(encrypted, passphrase)
+	require.NoError(t, err)
+	require.Equal(t, xml, decrypted)
+	_, err = DecryptPrivateKey(encrypted, []byte("wrong"))
+	require.Error(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "irma-demo.RU.2.xml.enc"), encrypted, 0600))
+
+	// scrypt parameters demanding excessive resources are rejected before key derivation
+	for _, params := range []string{`"n": 1073741824`, `"n": 32769`, `"r": 1024`, `"p": 0`} {
+		var enc map[string]interface{}
+		require.NoError(t, json.Unmarshal(encrypted, &enc))
+		require.NoError(t, json.Unmarshal([]byte("{"+params+"}"), &enc))
+		bts, err := json.Marshal(enc)
+		require.NoError(t, err)
+		_, err = DecryptPrivateKey(bts, passphrase)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "scrypt")
+	}
+
+	ring, err := NewPrivateKeyRingEncryptedFolder(dir, conf, passphrase)
+	require.NoError(t, err)
+	sk, err := ring.Get(ru, 2)
+	require.NoError(t, err)
+	require.Equal(t, uint(2), sk.Counter)
+	sk, err = ring.Latest(ru)
+	require.NoError(t, err)
+	require.Equal(t, uint(2), sk.Counter)
+	_, err = ring.Get(ru, 1)
+	require.Error(t, err)
+	_, err = NewPrivateKeyRingEncryptedFolder(dir, conf, []byte("wrong"))
+	require.Error(t, err)
+
+	// The plaintext key ring ignores encrypted private keys
+	folderring, err := NewPrivateKeyRingFolder(dir, conf)
+	require.NoError(t, err)
+	_, err = folderring.Latest(ru)
+	require.Error(t, err)
+
+	require.NoError(t, conf.AddPrivateKeyRing(ring))
+	_, err = conf.PrivateKeys.Get(ru, 2)
+	require.NoError(t, err)
+}
+
+func TestIssuerKeyStatus(t *testing.T) {
+	conf := parseConfiguration(t)
+	ru := NewIssuerIdentifier("irma-demo.RU")
+
+	status, err := conf.IssuerKeyStatus(ru)
+	require.NoError(t, err)
+	require.Len(t, status.Keys, 3)
+	require.Equal(t, uint(2), status.Latest().Counter)
+	require.Nil(t, status.LatestPrivate()) // not present in scheme
+	require.True(t, status.Keys[0].Expired(time.Now()))
+	require.False(t, status.Keys[2].Expired(time.Now()))
+
+	ring, err := NewPrivateKeyRingFolder(filepath.Join(test.FindTestdataFolder(t), "privatekeys"), conf)
+	require.NoError(t, err)
+	require.NoError(t, conf.AddPrivateKeyRing(ring))
+	status, err = conf.IssuerKeyStatus(ru)
+	require.NoError(t, err)
+	require.NotNil(t, status.LatestPrivate())
+	require.Equal(t, uint(2), status.LatestPrivate().Counter)
+
+	statuses, err := conf.IssuerKeyStatuses()
+	require.NoError(t, err)
+	require.Len(t, statuses, len(conf.Issuers))
+	for i := 1; i < len(statuses); i++ {
+		require.True(t, statuses[i-1].Issuer.String() < statuses[i].Issuer.String())
+	}
+
+	_, err = conf.IssuerKeyStatus(NewIssuerIdentifier("irma-demo.nonexistent"))
+	require.Error(t, err)
+}
+
+func TestIssuerSigner(t *testing.T) {
+	conf := parseConfiguration(t)
+	issid := revocationTestCred.IssuerIdentifier()
+	pk, err := conf.PublicKey(issid, revocationPkCounter)
+	require.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "signer")
+	require.NoError(t, err)
+	defer test.ClearTestStorage(t, dir)
+	listener, err := net.Listen("unix", filepath.Join(dir, "signer.sock"))
+	require.NoError(t, err)
+	go func() { _ = http.Serve(listener, NewIssuerSignerHandler(conf)) }()
+	defer listener.Close()
+	client := NewIssuerSignerClient(filepath.Join(dir, "signer.sock"))
+
+	counters, err := client.Counters(issid)
+	require.NoError(t, err)
+	local, err := conf.IssuerSigner().Counters(issid)
+	require.NoError(t, err)
+	require.Equal(t, local, counters)
+	keys, err := client.Keys()
+	require.NoError(t, err)
+	require.Equal(t, local, keys[issid])
+	counter, err := LatestPrivateKeyCounter(client, issid)
+	require.NoError(t, err)
+	require.Equal(t, local[len(local)-1], counter)
+
+	// issue a credential using the signer
+	secret, err := gabi.GenerateSecretAttribute()
+	require.NoError(t, err)
+	nonce1, err := gabi.GenerateNonce()
+	require.NoError(t, err)
+	nonce2, err := gabi.GenerateNonce()
+	require.NoError(t, err)
+	builder, err := gabi.NewCredentialBuilder(pk, big.NewInt(1), secret, nonce2, nil)
+	require.NoError(t, err)
+	commitment, err := builder.CommitToSecretAndProve(nonce1)
+	require.NoError(t, err)
+	attrs := []*big.Int{big.NewInt(42), big.NewInt(43)}
+	sig, err := client.IssueSignature(issid, revocationPkCounter, &IssueSignatureRequest{
+		U: commitment.U, Attributes: attrs, Nonce2: nonce2,
+	})
+	require.NoError(t, err)
+	_, err = builder.ConstructCredential(sig, attrs)
+	require.NoError(t, err)
+
+	// create and sign an accumulator, a witness, and an update revoking the witness
+	update, err := client.NewAccumulator(issid, revocationPkCounter)
+	require.NoError(t, err)
+	acc, err := update.Verify(pk)
+	require.NoError(t, err)
+	witness, err := client.RandomWitness(issid, revocationPkCounter, acc)
+	require.NoError(t, err)
+	witness.SignedAccumulator = update.SignedAccumulator
+	require.NoError(t, witness.Verify(pk))
+	newacc, event, err := client.RemoveFromAccumulator(issid, revocationPkCounter, acc, witness.E, update.Events[0])
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), newacc.Index)
+	sacc, err := client.SignAccumulator(issid, revocationPkCounter, newacc)
+	require.NoError(t, err)
+	_, err = (&revocation.Update{SignedAccumulator: sacc, Events: []*revocation.Event{event}}).Verify(pk)
+	require.NoError(t, err)
+
+	// signed issuance records verify like those created by signed.MarshalSign
+	rec := &IssuanceRecord{
+		Key: "testkey", CredType: revocationTestCred, PKCounter: &revocationPkCounter,
+		Attr: (*RevocationAttribute)(witness.E),
+	}
+	message, err := client.SignIssuanceRecord(issid, revocationPkCounter, rec)
+	require.NoError(t, err)
+	var parsed IssuanceRecord
+	require.NoError(t, signed.UnmarshalVerify(pk.ECDSA, message, &parsed))
+	require.Equal(t, "testkey", parsed.Key)
+	require.Zero(t, (*big.Int)(parsed.Attr).Cmp(witness.E))
+	message, err = client.SignIssuanceRecords(issid, revocationPkCounter, []*IssuanceRecord{rec, rec})
+	require.NoError(t, err)
+	var parsedList []*IssuanceRecord
+	require.NoError(t, signed.UnmarshalVerify(pk.ECDSA, message, &parsedList))
+	require.Len(t, parsedList, 2)
+
+	// the signer refuses to sign issuance records of other issuers or keys, or incomplete ones
+	othercounter := revocationPkCounter + 1
+	for _, r := range []*IssuanceRecord{
+		{Key: "testkey", CredType: NewCredentialTypeIdentifier("irma-demo.RU.studentCard"), PKCounter: &revocationPkCounter, Attr: rec.Attr},
+		{Key: "testkey", CredType: revocationTestCred, PKCounter: &othercounter, Attr: rec.Attr},
+		{Key: "testkey", CredType: revocationTestCred, PKCounter: &revocationPkCounter},
+	} {
+		_, err = client.SignIssuanceRecord(issid, revocationPkCounter, r)
+		require.Error(t, err)
+		_, err = client.SignIssuanceRecords(issid, revocationPkCounter, []*IssuanceRecord{rec, r})
+		require.Error(t, err)
+	}
+
+	// missing private keys
+	_, err = client.IssueSignature(issid, 100, &IssueSignatureRequest{U: commitment.U, Attributes: attrs, Nonce2: nonce2})
+	require.True(t, goerrors.Is(err, os.ErrNotExist))
+	counters, err = client.Counters(NewIssuerIdentifier("irma-demo.nonexistent"))
+	require.NoError(t, err)
+	require.Empty(t, counters)
+}
+
+func TestPrivateKeyShares(t *testing.T) {
+	conf := parseConfiguration(t)
+	issid := revocationTestCred.IssuerIdentifier()
+	sk, err := conf.PrivateKeys.Get(issid, revocationPkCounter)
+	require.NoError(t, err)
+	require.True(t, sk.RevocationSupported())
+
+	_, err = SplitPrivateKey(issid, sk, 1, 3)
+	require.Error(t, err)
+	_, err = SplitPrivateKey(issid, sk, 4, 3)
+	require.Error(t, err)
+
+	shares, err := SplitPrivateKey(issid, sk, 3, 5)
+	require.NoError(t, err)
+	require.Len(t, shares, 5)
+	for _, share := range shares {
+		require.NoError(t, share.Verify())
+	}
+
+	combined, err := CombinePrivateKeyShares(conf, []*PrivateKeyShare{shares[4], shares[0], shares[2]})
+	require.NoError(t, err)
+	require.Equal(t, sk.Counter, combined.Counter)
+	require.Equal(t, sk.P, combined.P)
+	require.Equal(t, sk.Q, combined.Q)
+	require.Equal(t, sk.ECDSAString, combined.ECDSAString)
+	combined, err = CombinePrivateKeyShares(conf, shares)
+	require.NoError(t, err)
+	require.Equal(t, sk.P, combined.P)
+
+	// too few, duplicate, corrupted and foreign shares
+	_, err = CombinePrivateKeyShares(conf, shares[:2])
+	require.Error(t, err)
+	_, err = CombinePrivateKeyShares(conf, []*PrivateKeyShare{shares[0], shares[1], shares[1]})
+	require.Error(t, err)
+	corrupted := *shares[1]
+	corrupted.Data = append([]byte{}, shares[1].Data...)
+	corrupted.Data[0] ^= 1
+	_, err = CombinePrivateKeyShares(conf, []*PrivateKeyShare{shares[0], &corrupted, shares[2]})
+	require.Error(t, err)
+	corrupted.Checksum = corrupted.checksum()
+	_, err = CombinePrivateKeyShares(conf, []*PrivateKeyShare{shares[0], &corrupted, shares[2]})
+	require.Error(t, err)
+	other, err := SplitPrivateKey(issid, sk, 3, 5)
+	require.NoError(t, err)
+	_, err = CombinePrivateKeyShares(conf, []*PrivateKeyShare{shares[0], shares[1], other[2]})
+	require.Error(t, err)
+}
+
+func TestSearchCatalog(t *testing.T) {
+	conf := parseConfiguration(t)
+	studentCard := NewCredentialTypeIdentifier("irma-demo.RU.studentCard")
+
+	results := conf.SearchCatalog(CatalogQuery{Text: "student card"})
+	require.NotEmpty(t, results)
+	require.Equal(t, CatalogItemCredentialType, results[0].Type)
+	require.Equal(t, studentCard.String(), results[0].Identifier)
+	require.Contains(t, results[0].Matches, "Name.en")
+
+	// prefix matching in a single language, and matching on issuer name
+	results = conf.SearchCatalog(CatalogQuery{Text: "studentenk", Languages: []string{"nl"}})
+	require.NotEmpty(t, results)
+	require.Equal(t, studentCard.String(), results[0].Identifier)
+	require.Empty(t, conf.SearchCatalog(CatalogQuery{Text: "studentenk", Languages: []string{"en"}}))
+	results = conf.SearchCatalog(CatalogQuery{
+		Text:  "radboud nummer",
+		Types: []CatalogItemType{CatalogItemAttributeType},
+	})
+	require.Len(t, results, 1)
+	require.Equal(t, "irma-demo.RU.studentCard.studentCardNumber", results[0].Identifier)
+	require.Equal(t, studentCard, results[0].CredentialType)
+
+	// filters
+	for _, result := range conf.SearchCatalog(CatalogQuery{Issuers: []IssuerIdentifier{studentCard.IssuerIdentifier()}}) {
+		require.Equal(t, studentCard.IssuerIdentifier(), result.Issuer)
+	}
+	require.Len(t, conf.SearchCatalog(CatalogQuery{Text: "demo", Limit: 2}), 2)
+	require.Empty(t, conf.SearchCatalog(CatalogQuery{Text: "student", Schemes: []SchemeManagerIdentifier{NewSchemeManagerIdentifier("test")}}))
+
+	// categories and deprecation
+	conf.CredentialTypes[studentCard].Category = TranslatedString{"en": "Education", "nl": "Onderwijs"}
+	conf.invalidateCatalog()
+	results = conf.SearchCatalog(CatalogQuery{Category: "onderwijs"})
+	require.Len(t, results, 1+len(conf.CredentialTypes[studentCard].AttributeTypes))
+	require.Equal(t, studentCard.String(), results[0].Identifier)
+	require.Empty(t, conf.SearchCatalog(CatalogQuery{Category: "onderwijs", Languages: []string{"en"}}))
+
+	conf.CredentialTypes[studentCard].DeprecatedSince = Timestamp(time.Now().Add(-time.Hour))
+	conf.invalidateCatalog()
+	require.Empty(t, conf.SearchCatalog(CatalogQuery{Text: "studentenkaart"}))
+	results = conf.SearchCatalog(CatalogQuery{Text: "studentenkaart", IncludeDeprecated: true})
+	require.NotEmpty(t, results)
+	for _, result := range results {
+		require.True(t, result.Deprecated)
+	}
+
+	// the index is rebuilt after reparsing
+	require.NoError(t, conf.ParseFolder())
+	require.NotEmpty(t, conf.SearchCatalog(CatalogQuery{Text: "studentenkaart"}))
+}
+
+func TestDeprecationWarnings(t *testing.T) {
+	conf := parseConfiguration(t)
+	attr := NewAttributeTypeIdentifier("irma-demo.RU.studentCard.studentID")
+	credid := attr.CredentialTypeIdentifier()
+	request := NewDisclosureRequest(attr)
+	require.Empty(t, DeprecationWarnings(conf, request))
+
+	past, future := Timestamp(time.Now().Add(-time.Hour)), Timestamp(time.Now().Add(time.Hour))
+	conf.CredentialTypes[credid].DeprecatedSince = future
+	require.False(t, conf.CredentialTypes[credid].IsDeprecated())
+	require.Empty(t, DeprecationWarnings(conf, request))
+	conf.CredentialTypes[credid].DeprecatedSince = past
+	conf.Issuers[credid.IssuerIdentifier()].DeprecatedSince = past
+	require.True(t, conf.CredentialTypes[credid].IsDeprecated())
+	require.True(t, conf.Issuers[credid.IssuerIdentifier()].IsDeprecated())
+	warnings := DeprecationWarnings(conf, request)
+	require.Len(t, warnings, 2)
+	require.Contains(t, warnings[0], credid.String()+" is deprecated since "+past.DateString())
+	require.Contains(t, warnings[1], credid.IssuerIdentifier().String())
+
+	// credential requests of deprecated credential types are valid; the IRMA server refuses them
+	cred := &CredentialRequest{
+		CredentialTypeID: credid,
+		Attributes:       map[string]string{"university": "Radboud", "studentCardNumber": "1", "studentID": "s1", "level": "1"},
+	}
+	require.NoError(t, cred.Validate(conf))
+}
+
 // Helper functions for wizard tests below
 func credid(s string) CredentialTypeIdentifier {
 	return NewCredentialTypeIdentifier(s)
@@ -977,6 +2442,59 @@ func TestWizardConstructed(t *testing.T) {
 	)
 }
 
+func TestWizardPaths(t *testing.T) {
+	conf := &Configuration{
+		CredentialTypes: map[CredentialTypeIdentifier]*CredentialType{
+			credid("scheme.issuer.a"): credtype("scheme.issuer.a"),
+			credid("scheme.issuer.b"): credtype("scheme.issuer.b"),
+			credid("scheme.issuer.c"): credtype("scheme.issuer.c",
+				"scheme.issuer.a",
+			),
+		},
+	}
+	wizard := IssueWizard{
+		ID: NewIssueWizardIdentifier("test-requestors.test-requestor.testwizard"),
+		Contents: IssueWizardContents{
+			{
+				{credwizarditem("scheme.issuer.b")},
+				{credwizarditem("scheme.issuer.c")},
+			},
+		},
+	}
+
+	require.Equal(t,
+		[]CredentialTypeIdentifier{credid("scheme.issuer.a"), credid("scheme.issuer.b"), credid("scheme.issuer.c")},
+		wizard.CredentialTypes(conf),
+	)
+
+	paths, err := wizard.Paths(conf)
+	require.NoError(t, err)
+	require.Len(t, paths, 2)
+	require.Equal(t, []IssueWizardItem{credwizarditem("scheme.issuer.b")}, paths[0].Items)
+	require.Equal(t, []CredentialTypeIdentifier{}, paths[0].Owned[0])
+	require.Equal(t,
+		[]IssueWizardItem{credwizarditem("scheme.issuer.a"), credwizarditem("scheme.issuer.c")},
+		paths[1].Items,
+	)
+	require.Equal(t,
+		[][]CredentialTypeIdentifier{{credid("scheme.issuer.c")}, {credid("scheme.issuer.a"), credid("scheme.issuer.c")}},
+		paths[1].Owned,
+	)
+
+	var combinations int
+	for _, path := range paths {
+		combinations += len(path.Owned)
+	}
+	require.Equal(t, 8, combinations)
+
+	expand := false
+	wizard.ExpandDependencies = &expand
+	require.Equal(t,
+		[]CredentialTypeIdentifier{credid("scheme.issuer.b"), credid("scheme.issuer.c")},
+		wizard.CredentialTypes(conf),
+	)
+}
+
 func TestWizardFromScheme(t *testing.T) {
 	conf := parseConfiguration(t)
 	id := NewIssueWizardIdentifier("test-requestors.test-requestor.testwizard")
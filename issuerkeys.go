@@ -0,0 +1,116 @@
+package irma
+
+import (
+	"sort"
+	"time"
+
+	"github.com/go-errors/errors"
+)
+
+type (
+	// IssuerKeyStatus describes the lifecycle of the public keys of an issuer: for each of its public
+	// keys, when it expires and whether its private key is available to the IssuerSigner.
+	IssuerKeyStatus struct {
+		Issuer     IssuerIdentifier   `json:"issuer"`
+		Deprecated bool               `json:"deprecated"`
+		Demo       bool               `json:"demo"`
+		Keys       []*PublicKeyStatus `json:"keys"`
+	}
+
+	// PublicKeyStatus describes a public key of an issuer.
+	PublicKeyStatus struct {
+		Counter             uint      `json:"counter"`
+		ExpiryDate          Timestamp `json:"expiryDate"`
+		RevocationSupported bool      `json:"revocationSupported"`
+		PrivateKey          bool      `json:"privateKey"`
+	}
+)
+
+// IssuerKeyStatuses returns the key status of each issuer, ordered by issuer identifier.
+func (conf *Configuration) IssuerKeyStatuses() ([]*IssuerKeyStatus, error) {
+	ids := make([]IssuerIdentifier, 0, len(conf.Issuers))
+	for id := range conf.Issuers {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+
+	statuses := make([]*IssuerKeyStatus, 0, len(ids))
+	for _, id := range ids {
+		status, err := conf.IssuerKeyStatus(id)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// IssuerKeyStatus returns the counters and expiry dates of the public keys of the specified issuer,
+// ordered by counter, along with whether their private keys are available to the IssuerSigner.
+func (conf *Configuration) IssuerKeyStatus(id IssuerIdentifier) (*IssuerKeyStatus, error) {
+	issuer := conf.Issuers[id]
+	if issuer == nil {
+		return nil, errors.Errorf("unknown issuer %s", id)
+	}
+	counters, err := conf.PublicKeyIndices(id)
+	if err != nil {
+		return nil, err
+	}
+	private := map[uint]bool{}
+	privateCounters, err := conf.IssuerSigner().Counters(id)
+	if err != nil {
+		return nil, err
+	}
+	for _, counter := range privateCounters {
+		private[counter] = true
+	}
+	status := &IssuerKeyStatus{
+		Issuer:     id,
+		Deprecated: !issuer.DeprecatedSince.IsZero() && !issuer.DeprecatedSince.After(Timestamp(time.Now())),
+		Demo:       conf.SchemeManagers[id.SchemeManagerIdentifier()].Demo,
+		Keys:       make([]*PublicKeyStatus, 0, len(counters)),
+	}
+	for _, counter := range counters {
+		pk, err := conf.PublicKey(id, counter)
+		if err != nil {
+			return nil, err
+		}
+		if pk == nil {
+			continue
+		}
+		key := &PublicKeyStatus{
+			Counter:             counter,
+			ExpiryDate:          Timestamp(time.Unix(pk.ExpiryDate, 0)),
+			RevocationSupported: pk.RevocationSupported(),
+			PrivateKey:          private[counter],
+		}
+		status.Keys = append(status.Keys, key)
+	}
+	return status, nil
+}
+
+// Latest returns the status of the public key with the highest counter, or nil if the issuer
+// has no public keys.
+func (status *IssuerKeyStatus) Latest() *PublicKeyStatus {
+	if len(status.Keys) == 0 {
+		return nil
+	}
+	return status.Keys[len(status.Keys)-1]
+}
+
+// LatestPrivate returns the status of the public key with the highest counter of which the private
+// key is present, i.e. the key with which IRMA servers issue credentials of the issuer; or nil if
+// no private key of the issuer is present.
+func (status *IssuerKeyStatus) LatestPrivate() *PublicKeyStatus {
+	for i := len(status.Keys) - 1; i >= 0; i-- {
+		if status.Keys[i].PrivateKey {
+			return status.Keys[i]
+		}
+	}
+	return nil
+}
+
+// Expired returns whether the public key is expired at the specified time.
+func (key *PublicKeyStatus) Expired(t time.Time) bool {
+	return time.Time(key.ExpiryDate).Before(t)
+}
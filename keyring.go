@@ -158,7 +158,12 @@ func (p *PrivateKeyRingFolder) Iterate(id IssuerIdentifier, f func(sk *gabikeys.
 		return err
 	}
 	for _, file := range files {
-		sk, err := p.readFile(filepath.Base(file), id)
+		// Skip other files starting with the issuer identifier, such as encrypted private keys
+		filename := filepath.Base(file)
+		if issuerid, _, err := p.parseFilename(filename); err != nil || issuerid == nil || *issuerid != id {
+			continue
+		}
+		sk, err := p.readFile(filename, id)
 		if err != nil {
 			return err
 		}
@@ -179,7 +184,7 @@ func newPrivateKeyRingScheme(conf *Configuration) (*privateKeyRingScheme, error)
 
 func (p *privateKeyRingScheme) counters(issuerid IssuerIdentifier) (i []uint, err error) {
 	scheme := p.conf.SchemeManagers[issuerid.SchemeManagerIdentifier()]
-	return matchKeyPattern(filepath.Join(scheme.path(), issuerid.Name(), "PrivateKeys", "*"))
+	return matchKeyPattern(filepath.Join(scheme.path(), issuerid.Name(), "PrivateKeys", "*.xml"))
 }
 
 func (p *privateKeyRingScheme) Get(id IssuerIdentifier, counter uint) (*gabikeys.PrivateKey, error) {
@@ -0,0 +1,238 @@
+package irma
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/gabi/gabikeys"
+	"golang.org/x/crypto/scrypt"
+)
+
+type (
+	// PrivateKeyRingEncryptedFolder represents a folder on disk containing private keys encrypted
+	// with EncryptPrivateKey(), with filenames of the form scheme.issuer.xml.enc and
+	// scheme.issuer.counter.xml.enc. Other files in the folder are ignored. The private keys are
+	// decrypted and validated when the ring is created, so that the passphrase need not be kept.
+	PrivateKeyRingEncryptedFolder struct {
+		keys map[IssuerIdentifier]map[uint]*gabikeys.PrivateKey
+	}
+
+	// encryptedPrivateKey is the JSON representation of a private key encrypted with AES-256-GCM,
+	// using a key derived from a passphrase with scrypt.
+	encryptedPrivateKey struct {
+		Version    int    `json:"version"`
+		KDF        string `json:"kdf"`
+		N          int    `json:"n"`
+		R          int    `json:"r"`
+		P          int    `json:"p"`
+		Salt       []byte `json:"salt"`
+		Nonce      []byte `json:"nonce"`
+		Ciphertext []byte `json:"ciphertext"`
+	}
+)
+
+// EncryptedPrivateKeySuffix is appended to the filename of private keys when encrypting them.
+const EncryptedPrivateKeySuffix = ".enc"
+
+// Parameters of the scrypt key derivation function for newly encrypted private keys
+const (
+	encryptedPrivateKeyVersion = 1
+	scryptN                    = 1 << 15
+	scryptR                    = 8
+	scryptP                    = 1
+)
+
+// Bounds on the scrypt parameters of encrypted private keys, so that a modified or malicious
+// file cannot make decryption use excessive CPU time or memory (which is 128*N*r bytes)
+const (
+	scryptMinN      = 1 << 14
+	scryptMaxN      = 1 << 20
+	scryptMaxR      = 16
+	scryptMaxP      = 16
+	scryptMaxMemory = 1 << 30
+)
+
+// NewPrivateKeyRingEncryptedFolder decrypts the encrypted private keys in the specified folder
+// using the passphrase.
+func NewPrivateKeyRingEncryptedFolder(path string, conf *Configuration, passphrase []byte) (*PrivateKeyRingEncryptedFolder, error) {
+	files, err := filepath.Glob(filepath.Join(path, "*.xml"+EncryptedPrivateKeySuffix))
+	if err != nil {
+		return nil, err
+	}
+	ring := &PrivateKeyRingEncryptedFolder{keys: map[IssuerIdentifier]map[uint]*gabikeys.PrivateKey{}}
+	for _, file := range files {
+		filename := filepath.Base(file)
+		// This regexp works like the one in PrivateKeyRingFolder.parseFilename()
+		matches := regexp.MustCompile(`^([^.]+\.[^.]+)(\.(\d+))?\.xml\.enc$`).FindStringSubmatch(filename)
+		if len(matches) != 4 {
+			Logger.WithField("file", filename).Infof("Skipping non-private key file encountered in private keys path")
+			continue
+		}
+		issuerid := NewIssuerIdentifier(matches[1])
+		scheme := conf.SchemeManagers[issuerid.SchemeManagerIdentifier()]
+		if scheme == nil {
+			return nil, errors.Errorf("Private key of issuer %s belongs to unknown scheme", issuerid.String())
+		}
+
+		bts, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		xml, err := DecryptPrivateKey(bts, passphrase)
+		if err != nil {
+			return nil, errors.WrapPrefix(err, "failed to decrypt "+filename, 0)
+		}
+		sk, err := gabikeys.NewPrivateKeyFromXML(string(xml), scheme.Demo)
+		if err != nil {
+			return nil, err
+		}
+		if matches[3] != "" {
+			counter, err := strconv.ParseUint(matches[3], 10, 32)
+			if err != nil {
+				return nil, err
+			}
+			if uint(counter) != sk.Counter {
+				return nil, errors.Errorf("private key %s has wrong counter %d in filename, should be %d", filename, counter, sk.Counter)
+			}
+		}
+		if err = validatePrivateKey(issuerid, sk, conf); err != nil {
+			return nil, err
+		}
+		if ring.keys[issuerid] == nil {
+			ring.keys[issuerid] = map[uint]*gabikeys.PrivateKey{}
+		}
+		ring.keys[issuerid][sk.Counter] = sk
+	}
+	return ring, nil
+}
+
+func (p *PrivateKeyRingEncryptedFolder) Get(id IssuerIdentifier, counter uint) (*gabikeys.PrivateKey, error) {
+	sk := p.keys[id][counter]
+	if sk == nil {
+		return nil, ErrMissingPrivateKey
+	}
+	return sk, nil
+}
+
+func (p *PrivateKeyRingEncryptedFolder) Latest(id IssuerIdentifier) (*gabikeys.PrivateKey, error) {
+	var sk *gabikeys.PrivateKey
+	for _, s := range p.keys[id] {
+		if sk == nil || s.Counter > sk.Counter {
+			sk = s
+		}
+	}
+	if sk == nil {
+		return nil, ErrMissingPrivateKey
+	}
+	return sk, nil
+}
+
+func (p *PrivateKeyRingEncryptedFolder) Iterate(id IssuerIdentifier, f func(sk *gabikeys.PrivateKey) error) error {
+	for _, sk := range p.keys[id] {
+		if err := f(sk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EncryptPrivateKey encrypts the specified private key XML with a key derived from the passphrase.
+func EncryptPrivateKey(xml []byte, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("passphrase must not be empty")
+	}
+	enc := &encryptedPrivateKey{
+		Version: encryptedPrivateKeyVersion,
+		KDF:     "scrypt",
+		N:       scryptN,
+		R:       scryptR,
+		P:       scryptP,
+		Salt:    make([]byte, 32),
+	}
+	if _, err := rand.Read(enc.Salt); err != nil {
+		return nil, err
+	}
+	aead, err := enc.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	enc.Nonce = make([]byte, aead.NonceSize())
+	if _, err = rand.Read(enc.Nonce); err != nil {
+		return nil, err
+	}
+	enc.Ciphertext = aead.Seal(nil, enc.Nonce, xml, enc.additionalData())
+	bts, err := json.MarshalIndent(enc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(bts, '\n'), nil
+}
+
+// DecryptPrivateKey decrypts a private key encrypted with EncryptPrivateKey(), returning its XML.
+func DecryptPrivateKey(bts []byte, passphrase []byte) ([]byte, error) {
+	var enc encryptedPrivateKey
+	if err := json.Unmarshal(bts, &enc); err != nil {
+		return nil, errors.WrapPrefix(err, "not an encrypted private key", 0)
+	}
+	if enc.Version != encryptedPrivateKeyVersion {
+		return nil, errors.Errorf("unsupported encrypted private key version %d", enc.Version)
+	}
+	if enc.KDF != "scrypt" {
+		return nil, errors.Errorf("unsupported key derivation function %s", enc.KDF)
+	}
+	aead, err := enc.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(enc.Nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid nonce length")
+	}
+	xml, err := aead.Open(nil, enc.Nonce, enc.Ciphertext, enc.additionalData())
+	if err != nil {
+		return nil, errors.New("wrong passphrase or corrupted private key")
+	}
+	return xml, nil
+}
+
+// IsEncryptedPrivateKey returns whether the specified file contents is a private key
+// encrypted with EncryptPrivateKey().
+func IsEncryptedPrivateKey(bts []byte) bool {
+	var enc encryptedPrivateKey
+	return json.Unmarshal(bytes.TrimSpace(bts), &enc) == nil && enc.Version != 0 && len(enc.Ciphertext) > 0
+}
+
+func (enc *encryptedPrivateKey) aead(passphrase []byte) (cipher.AEAD, error) {
+	if enc.N < scryptMinN || enc.N > scryptMaxN || enc.N&(enc.N-1) != 0 {
+		return nil, errors.Errorf("unsupported scrypt parameter N=%d", enc.N)
+	}
+	if enc.R < 1 || enc.R > scryptMaxR || enc.P < 1 || enc.P > scryptMaxP {
+		return nil, errors.Errorf("unsupported scrypt parameters r=%d, p=%d", enc.R, enc.P)
+	}
+	if 128*enc.N*enc.R > scryptMaxMemory {
+		return nil, errors.New("scrypt parameters require too much memory")
+	}
+	key, err := scrypt.Key(passphrase, enc.Salt, enc.N, enc.R, enc.P, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// additionalData binds the ciphertext to the encryption parameters, so that these cannot be
+// modified without decryption failing.
+func (enc *encryptedPrivateKey) additionalData() []byte {
+	return []byte(fmt.Sprintf("%d/%s/%d/%d/%d", enc.Version, enc.KDF, enc.N, enc.R, enc.P))
+}
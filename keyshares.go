@@ -0,0 +1,208 @@
+package irma
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/gabi/gabikeys"
+)
+
+// PrivateKeyShare is one of the shares into which SplitPrivateKey() splits an issuer private key
+// (including its revocation key, if any) using Shamir secret sharing, of which a threshold number
+// is required to reconstruct the private key using CombinePrivateKeyShares(). Fewer shares reveal
+// nothing about the private key.
+type PrivateKeyShare struct {
+	Version int `json:"version"`
+	// Random identifier shared by all shares resulting from one split
+	Split     string           `json:"split"`
+	Issuer    IssuerIdentifier `json:"issuer"`
+	Counter   uint             `json:"counter"`
+	Threshold int              `json:"threshold"`
+	Shares    int              `json:"shares"`
+	Index     int              `json:"index"`
+	Data      []byte           `json:"data"`
+	// SHA256 hash of the private key XML, to verify the reconstructed private key
+	KeyHash []byte `json:"keyhash"`
+	// SHA256 hash of the other fields, to detect corrupted shares
+	Checksum []byte `json:"checksum"`
+}
+
+const privateKeyShareVersion = 1
+
+// SplitPrivateKey splits the XML serialization of the private key into the specified number of
+// shares, of which threshold shares are required to reconstruct it. At most 255 shares are supported.
+func SplitPrivateKey(id IssuerIdentifier, sk *gabikeys.PrivateKey, threshold, shares int) ([]*PrivateKeyShare, error) {
+	if threshold < 2 || threshold > shares || shares > 255 {
+		return nil, errors.Errorf("invalid threshold %d and number of shares %d: need 2 <= threshold <= shares <= 255", threshold, shares)
+	}
+	var buf bytes.Buffer
+	if _, err := sk.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	secret := buf.Bytes()
+	keyhash := sha256.Sum256(secret)
+	split := make([]byte, 16)
+	if _, err := rand.Read(split); err != nil {
+		return nil, err
+	}
+
+	result := make([]*PrivateKeyShare, shares)
+	for i := range result {
+		result[i] = &PrivateKeyShare{
+			Version:   privateKeyShareVersion,
+			Split:     hex.EncodeToString(split),
+			Issuer:    id,
+			Counter:   sk.Counter,
+			Threshold: threshold,
+			Shares:    shares,
+			Index:     i + 1,
+			Data:      make([]byte, len(secret)),
+			KeyHash:   keyhash[:],
+		}
+	}
+
+	// For each byte of the secret, choose a random polynomial over GF(2^8) of degree threshold-1
+	// whose constant term is the secret byte, and evaluate it at the index of each share
+	coefficients := make([]byte, threshold)
+	for i, b := range secret {
+		coefficients[0] = b
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return nil, err
+		}
+		for _, share := range result {
+			share.Data[i] = gfEvaluate(coefficients, byte(share.Index))
+		}
+	}
+	for i := range coefficients {
+		coefficients[i] = 0
+	}
+
+	for _, share := range result {
+		share.Checksum = share.checksum()
+	}
+	return result, nil
+}
+
+// CombinePrivateKeyShares reconstructs the private key from at least the threshold number of
+// shares created by SplitPrivateKey(), and validates it against the corresponding public key
+// in the configuration.
+func CombinePrivateKeyShares(conf *Configuration, shares []*PrivateKeyShare) (*gabikeys.PrivateKey, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("no shares specified")
+	}
+	first := shares[0]
+	indices := map[int]struct{}{}
+	for _, share := range shares {
+		if err := share.Verify(); err != nil {
+			return nil, err
+		}
+		if share.Split != first.Split || share.Issuer != first.Issuer || share.Counter != first.Counter ||
+			share.Threshold != first.Threshold || share.Shares != first.Shares ||
+			!bytes.Equal(share.KeyHash, first.KeyHash) || len(share.Data) != len(first.Data) {
+			return nil, errors.Errorf("share %d does not belong to the same split as share %d", share.Index, first.Index)
+		}
+		if _, ok := indices[share.Index]; ok {
+			return nil, errors.Errorf("share %d specified more than once", share.Index)
+		}
+		indices[share.Index] = struct{}{}
+	}
+	if len(shares) < first.Threshold {
+		return nil, errors.Errorf("%d shares specified but %d are required", len(shares), first.Threshold)
+	}
+
+	// Lagrange interpolation at 0 of the polynomials through the shares
+	secret := make([]byte, len(first.Data))
+	for i, share := range shares {
+		xi := byte(share.Index)
+		basis := byte(1)
+		for j, other := range shares {
+			if i != j {
+				xj := byte(other.Index)
+				basis = gfMul(basis, gfMul(xj, gfInverse(xj^xi)))
+			}
+		}
+		for k, y := range share.Data {
+			secret[k] ^= gfMul(y, basis)
+		}
+	}
+	keyhash := sha256.Sum256(secret)
+	if subtle.ConstantTimeCompare(keyhash[:], first.KeyHash) != 1 {
+		return nil, errors.New("reconstructed private key does not match its hash: shares are corrupted")
+	}
+
+	scheme := conf.SchemeManagers[first.Issuer.SchemeManagerIdentifier()]
+	if scheme == nil {
+		return nil, errors.Errorf("private key of issuer %s belongs to unknown scheme", first.Issuer)
+	}
+	sk, err := gabikeys.NewPrivateKeyFromXML(string(secret), scheme.Demo)
+	if err != nil {
+		return nil, err
+	}
+	if sk.Counter != first.Counter {
+		return nil, errors.Errorf("reconstructed private key has counter %d instead of %d", sk.Counter, first.Counter)
+	}
+	if err = validatePrivateKey(first.Issuer, sk, conf); err != nil {
+		return nil, err
+	}
+	return sk, nil
+}
+
+// Verify checks the consistency of the share and its checksum.
+func (share *PrivateKeyShare) Verify() error {
+	if share.Version != privateKeyShareVersion {
+		return errors.Errorf("unsupported private key share version %d", share.Version)
+	}
+	if share.Index < 1 || share.Index > share.Shares || share.Threshold < 2 || share.Threshold > share.Shares || share.Shares > 255 {
+		return errors.Errorf("invalid private key share %d of %d (threshold %d)", share.Index, share.Shares, share.Threshold)
+	}
+	if subtle.ConstantTimeCompare(share.checksum(), share.Checksum) != 1 {
+		return errors.Errorf("checksum of private key share %d is invalid: share is corrupted", share.Index)
+	}
+	return nil
+}
+
+func (share *PrivateKeyShare) checksum() []byte {
+	h := sha256.New()
+	_, _ = fmt.Fprintf(h, "%d/%s/%s/%d/%d/%d/%d/%x/%x",
+		share.Version, share.Split, share.Issuer, share.Counter, share.Threshold, share.Shares, share.Index,
+		share.KeyHash, share.Data,
+	)
+	return h.Sum(nil)
+}
+
+// Arithmetic in GF(2^8) modulo the AES polynomial x^8 + x^4 + x^3 + x + 1, without branching
+// on (secret) data
+
+func gfMul(a, b byte) byte {
+	var p byte
+	for i := 0; i < 8; i++ {
+		p ^= a & -(b & 1)
+		a = (a << 1) ^ (0x1b & -(a >> 7))
+		b >>= 1
+	}
+	return p
+}
+
+// gfInverse returns a^254, which is the multiplicative inverse of a for nonzero a.
+func gfInverse(a byte) byte {
+	result := a
+	for i := 0; i < 6; i++ {
+		a = gfMul(a, a)
+		result = gfMul(result, a)
+	}
+	return gfMul(result, result)
+}
+
+// gfEvaluate evaluates the polynomial with the specified coefficients (constant term first) at x.
+func gfEvaluate(coefficients []byte, x byte) byte {
+	var result byte
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		result = gfMul(result, x) ^ coefficients[i]
+	}
+	return result
+}
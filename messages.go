@@ -225,6 +225,8 @@ const (
 	ErrorPanic = ErrorType("panic")
 	// Error involving random blind attributes
 	ErrorRandomBlind = ErrorType("randomblind")
+	// Issuer or credential type is deprecated
+	ErrorDeprecated = ErrorType("deprecated")
 )
 
 type Disclosure struct {
@@ -5,6 +5,7 @@ import (
 	"encoding/xml"
 	"fmt"
 	"io/ioutil"
+	"sort"
 	"strconv"
 	"time"
 
@@ -309,6 +310,41 @@ func (b *BaseRequest) Validate(conf *Configuration) error {
 	return nil
 }
 
+// DeprecationWarnings returns a warning for each issuer and credential type occurring in the session
+// request (including those for which it requests nonrevocation proofs) that is deprecated, i.e.,
+// whose DeprecatedSince lies in the past.
+func DeprecationWarnings(conf *Configuration, request SessionRequest) []string {
+	ids := request.Identifiers()
+	credtypes := map[CredentialTypeIdentifier]struct{}{}
+	for id := range ids.CredentialTypes {
+		credtypes[id] = struct{}{}
+	}
+	for id := range request.Base().Revocation {
+		credtypes[id] = struct{}{}
+	}
+	issuers := map[IssuerIdentifier]struct{}{}
+	for id := range ids.Issuers {
+		issuers[id] = struct{}{}
+	}
+	for id := range credtypes {
+		issuers[id.IssuerIdentifier()] = struct{}{}
+	}
+
+	var warnings []string
+	for id := range issuers {
+		if issuer := conf.Issuers[id]; issuer != nil && issuer.IsDeprecated() {
+			warnings = append(warnings, fmt.Sprintf("issuer %s is deprecated since %s", id, issuer.DeprecatedSince.DateString()))
+		}
+	}
+	for id := range credtypes {
+		if credtype := conf.CredentialTypes[id]; credtype != nil && credtype.IsDeprecated() {
+			warnings = append(warnings, fmt.Sprintf("credential type %s is deprecated since %s", id, credtype.DeprecatedSince.DateString()))
+		}
+	}
+	sort.Strings(warnings)
+	return warnings
+}
+
 // CredentialTypes returns an array of all credential types occuring in this conjunction.
 func (c AttributeCon) CredentialTypes() []CredentialTypeIdentifier {
 	var result []CredentialTypeIdentifier
@@ -821,6 +857,11 @@ func (t Timestamp) Sub(u Timestamp) time.Duration {
 }
 
 // To check whether Timestamp is uninitialized
+// DateString returns the date of the timestamp in UTC, formatted as YYYY-MM-DD.
+func (t Timestamp) DateString() string {
+	return time.Time(t).UTC().Format("2006-01-02")
+}
+
 func (t Timestamp) IsZero() bool {
 	return time.Time(t).IsZero()
 }
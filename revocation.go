@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"math/bits"
-	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -22,20 +21,20 @@ import (
 	"github.com/privacybydesign/gabi/revocation"
 	"github.com/privacybydesign/gabi/signed"
 	sseclient "github.com/sietseringers/go-sse"
+	"github.com/sirupsen/logrus"
 
 	_ "github.com/jinzhu/gorm/dialects/mysql"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
 )
 
 type (
-	// RevocationStorage stores and retrieves revocation-related data from and to a SQL database,
+	// RevocationStorage stores and retrieves revocation-related data from and to a RevocationDB
+	// (by default a SQL database, or an in-memory database if no connection string is configured),
 	// and offers a revocation API for all other irmago code, including a Revoke() method that
 	// revokes an earlier issued credential.
 	RevocationStorage struct {
 		conf     *Configuration
-		sqldb    sqlRevStorage
-		memdb    *memRevStorage
-		sqlMode  bool
+		db       RevocationDB
 		settings RevocationSettings
 
 		Keys   RevocationKeys
@@ -45,6 +44,7 @@ type (
 
 		close  chan struct{}
 		events chan *sseclient.Event
+		resync chan CredentialTypeIdentifier
 	}
 
 	// RevocationClient offers an HTTP client to the revocation server endpoints.
@@ -68,6 +68,18 @@ type (
 		Tolerance           uint64 `json:"tolerance,omitempty" mapstructure:"tolerance"` // in seconds, min 30
 		SSE                 bool   `json:"sse,omitempty" mapstructure:"sse"`
 
+		// URLs of other revocation servers, to fail over to when the one at RevocationServerURL
+		// is unavailable (requires RevocationServerURL to be set)
+		FallbackServerURLs []string `json:"fallback_server_urls,omitempty" mapstructure:"fallback_server_urls"`
+
+		// URLs to which new revocation updates are POSTed (authority mode only), e.g.
+		// https://example.com/revocation/irma-demo.MijnOverheid.root/update of an IRMA server
+		Webhooks []string `json:"webhooks,omitempty" mapstructure:"webhooks"`
+
+		// Accept revocation updates POSTed by the webhooks of the revocation authority at
+		// /revocation/{credtype}/update (not in authority mode)
+		AcceptUpdates bool `json:"accept_updates,omitempty" mapstructure:"accept_updates"`
+
 		// set to now whenever a new update is received, or when the RA indicates
 		// there are no new updates. Thus it specifies up to what time our nonrevocation
 		// guarantees lasts.
@@ -158,6 +170,18 @@ var RevocationParameters = struct {
 	// Cache-control: max-age HTTP return header (in seconds)
 	EventsCacheMaxAge uint64
 
+	// ServerFailureInterval is the time period in seconds after a failed request to a revocation
+	// server, during which the other revocation servers of the credential type are tried first.
+	ServerFailureInterval uint64
+
+	// ServerProbeInterval is the time period in seconds between attempts to reach the revocation
+	// servers that are marked as unavailable, so that they are used again once they recover.
+	ServerProbeInterval uint64
+
+	// SSEReconnectDelay is the time in milliseconds after which a closed SSE connection to a
+	// revocation server is reestablished, to the same or another revocation server.
+	SSEReconnectDelay uint64
+
 	UpdateMinCount      uint64
 	UpdateMaxCount      uint64
 	UpdateMinCountPower int
@@ -173,6 +197,9 @@ var RevocationParameters = struct {
 	UpdateMinCountPower:           4,
 	UpdateMaxCountPower:           9,
 	EventsCacheMaxAge:             60 * 60,
+	ServerFailureInterval:         60,
+	ServerProbeInterval:           20,
+	SSEReconnectDelay:             1000,
 }
 
 func init() {
@@ -184,9 +211,10 @@ func init() {
 
 // EnableRevocation creates an initial accumulator for a given credential type. This function is the
 // only way to create such an initial accumulator and it must be called before anyone can use
-// revocation for this credential type. Requires the issuer private key.
-func (rs *RevocationStorage) EnableRevocation(id CredentialTypeIdentifier, sk *gabikeys.PrivateKey) error {
-	enabled, err := rs.Exists(id, sk.Counter)
+// revocation for this credential type. Requires the issuer private key with the specified counter
+// to be available to the IssuerSigner of the configuration.
+func (rs *RevocationStorage) EnableRevocation(id CredentialTypeIdentifier, counter uint) error {
+	enabled, err := rs.Exists(id, counter)
 	if err != nil {
 		return err
 	}
@@ -194,21 +222,27 @@ func (rs *RevocationStorage) EnableRevocation(id CredentialTypeIdentifier, sk *g
 		return errors.New("revocation already enabled")
 	}
 
-	update, err := revocation.NewAccumulator(sk)
+	update, err := rs.conf.IssuerSigner().NewAccumulator(id.IssuerIdentifier(), counter)
 	if err != nil {
 		return err
 	}
 
-	if err = rs.addUpdate(rs.sqldb, id, update, true); err != nil {
-		return err
-	}
-	return nil
+	return rs.db.Transaction(func(tx RevocationDB) error {
+		return rs.addUpdate(tx, id, update, true)
+	})
 }
 
 // Exists returns whether or not an accumulator exists in the database for the given credential type.
 func (rs *RevocationStorage) Exists(id CredentialTypeIdentifier, counter uint) (bool, error) {
-	// only requires sql implementation
-	return rs.sqldb.Exists((*AccumulatorRecord)(nil), map[string]interface{}{"cred_type": id, "pk_counter": counter})
+	return rs.exists(rs.db, id, counter)
+}
+
+func (rs *RevocationStorage) exists(tx RevocationDB, id CredentialTypeIdentifier, counter uint) (bool, error) {
+	records, err := tx.Accumulators(id, &counter)
+	if err != nil {
+		return false, err
+	}
+	return len(records) > 0, nil
 }
 
 // Revocation update message methods
@@ -218,26 +252,17 @@ func (rs *RevocationStorage) Events(id CredentialTypeIdentifier, pkcounter uint,
 		return nil, errors.New("illegal update interval")
 	}
 
-	// Only requires SQL implementation
-	var events []*revocation.Event
-	if err := rs.sqldb.Transaction(func(tx sqlRevStorage) error {
-		var records []*EventRecord
-		if err := tx.Find(&records,
-			"cred_type = ? and pk_counter = ? and eventindex >= ? and eventindex < ?",
-			id, pkcounter, from, to,
-		); err != nil {
-			return err
-		}
-		if len(records) == 0 {
-			return ErrRevocationStateNotFound
-		}
-		for _, r := range records {
-			events = append(events, r.Event())
-		}
-		return nil
-	}); err != nil {
+	records, err := rs.db.Events(id, pkcounter, from, to)
+	if err != nil {
 		return nil, err
 	}
+	if len(records) == 0 {
+		return nil, ErrRevocationStateNotFound
+	}
+	events := make([]*revocation.Event, 0, len(records))
+	for _, r := range records {
+		events = append(events, r.Event())
+	}
 	if events[len(events)-1].Index < to-1 {
 		return nil, errors.New("interval end too small")
 	}
@@ -245,35 +270,31 @@ func (rs *RevocationStorage) Events(id CredentialTypeIdentifier, pkcounter uint,
 }
 
 func (rs *RevocationStorage) UpdateLatest(id CredentialTypeIdentifier, count uint64, counter *uint) (map[uint]*revocation.Update, error) {
-	var updates map[uint]*revocation.Update
-	if rs.sqlMode {
-		if err := rs.sqldb.Transaction(func(tx sqlRevStorage) error {
-			var (
-				records []*AccumulatorRecord
-				events  []*EventRecord
-			)
-			where := map[string]interface{}{"cred_type": id}
-			if counter != nil {
-				where["pk_counter"] = *counter
-			}
-			if err := tx.Find(&records, where); err != nil {
-				return err
-			}
+	updates := map[uint]*revocation.Update{}
+	if err := rs.db.Transaction(func(tx RevocationDB) error {
+		records, err := tx.Accumulators(id, counter)
+		if err != nil {
+			return err
+		}
+		for _, r := range records {
+			update := &revocation.Update{SignedAccumulator: r.SignedAccumulator()}
 			if count > 0 {
-				if err := tx.Latest(&events, count, where); err != nil {
+				events, err := tx.LatestEvents(id, *r.PKCounter, count)
+				if err != nil {
 					return err
 				}
+				for _, e := range events {
+					update.Events = append(update.Events, e.Event())
+				}
 			}
-			updates = rs.newUpdates(records, events)
-			return nil
-		}); err != nil {
-			return nil, err
-		}
-	} else {
-		updates = rs.memdb.Latest(id, count)
-		if len(updates) == 0 {
-			return nil, ErrRevocationStateNotFound
+			updates[*r.PKCounter] = update
 		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if len(updates) == 0 {
+		return nil, ErrRevocationStateNotFound
 	}
 	for k, u := range updates {
 		pk, err := rs.Keys.PublicKey(id.IssuerIdentifier(), k)
@@ -288,36 +309,16 @@ func (rs *RevocationStorage) UpdateLatest(id CredentialTypeIdentifier, count uin
 	return updates, nil
 }
 
-func (*RevocationStorage) newUpdates(records []*AccumulatorRecord, events []*EventRecord) map[uint]*revocation.Update {
-	updates := make(map[uint]*revocation.Update, len(records))
-	for _, r := range records {
-		updates[*r.PKCounter] = &revocation.Update{SignedAccumulator: r.SignedAccumulator()}
-	}
-	for _, e := range events {
-		update := updates[*e.PKCounter]
-		if update == nil {
-			continue
-		}
-		update.Events = append(update.Events, e.Event())
-	}
-	for _, update := range updates {
-		sort.Slice(update.Events, func(i, j int) bool {
-			return update.Events[i].Index < update.Events[j].Index
-		})
-	}
-	return updates
-}
-
 func (rs *RevocationStorage) AddUpdate(id CredentialTypeIdentifier, record *revocation.Update) error {
-	if rs.sqlMode {
-		return rs.sqldb.Transaction(func(tx sqlRevStorage) error {
-			return rs.addUpdate(tx, id, record, false)
-		})
-	}
-	return rs.addUpdate(rs.sqldb, id, record, false)
+	return rs.db.Transaction(func(tx RevocationDB) error {
+		return rs.addUpdate(tx, id, record, false)
+	})
 }
 
-func (rs *RevocationStorage) addUpdate(tx sqlRevStorage, id CredentialTypeIdentifier, update *revocation.Update, create bool) error {
+// addUpdate verifies and stores the update. If create is true, the accumulator must not yet
+// exist. Otherwise, the update is stored only insofar as it extends the events that we
+// already have; updates that are older than ours or that leave a gap are discarded.
+func (rs *RevocationStorage) addUpdate(tx RevocationDB, id CredentialTypeIdentifier, update *revocation.Update, create bool) error {
 	// Unmarshal and verify the record against the appropriate public key
 	pk, err := rs.Keys.PublicKey(id.IssuerIdentifier(), update.SignedAccumulator.PKCounter)
 	if err != nil {
@@ -327,22 +328,28 @@ func (rs *RevocationStorage) addUpdate(tx sqlRevStorage, id CredentialTypeIdenti
 		return err
 	}
 
-	// Save record
-	if rs.sqlMode {
-		save := tx.Save
-		if create {
-			save = tx.Insert
-		}
-		if err = save(new(AccumulatorRecord).Convert(id, update.SignedAccumulator)); err != nil {
+	// Determine which of the events we don't have yet
+	counter := update.SignedAccumulator.PKCounter
+	events := update.Events
+	if !create {
+		var ok bool
+		if events, ok, err = rs.newEvents(tx, id, update); err != nil || !ok {
 			return err
 		}
-		for _, event := range update.Events {
-			if err = tx.Insert(new(EventRecord).Convert(id, update.SignedAccumulator.PKCounter, event)); err != nil {
-				return err
-			}
+	}
+
+	// Save record
+	save := tx.SaveAccumulator
+	if create {
+		save = tx.InsertAccumulator
+	}
+	if err = save(new(AccumulatorRecord).Convert(id, update.SignedAccumulator)); err != nil {
+		return err
+	}
+	for _, event := range events {
+		if err = tx.InsertEvent(new(EventRecord).Convert(id, counter, event)); err != nil {
+			return err
 		}
-	} else {
-		rs.memdb.Insert(id, update)
 	}
 
 	s := rs.settings.Get(id)
@@ -353,22 +360,70 @@ func (rs *RevocationStorage) addUpdate(tx sqlRevStorage, id CredentialTypeIdenti
 	return nil
 }
 
+// newEvents returns the events of the update that extend the events that we already have, and
+// whether the update should be stored at all.
+func (rs *RevocationStorage) newEvents(tx RevocationDB, id CredentialTypeIdentifier, update *revocation.Update) (
+	[]*revocation.Event, bool, error,
+) {
+	logger := Logger.WithFields(logrus.Fields{"credtype": id, "counter": update.SignedAccumulator.PKCounter})
+	last, err := tx.LatestEvents(id, update.SignedAccumulator.PKCounter, 1)
+	if err != nil {
+		return nil, false, err
+	}
+	theirs := update.Events
+	if len(last) == 0 {
+		if len(theirs) == 0 {
+			logger.Trace("received accumulator without events, discarding")
+			return nil, false, nil
+		}
+		return theirs, true, nil
+	}
+
+	ourEnd := *last[0].Index
+	if len(theirs) == 0 {
+		if update.SignedAccumulator.Accumulator.Index != ourEnd {
+			logger.Trace("received accumulator does not match our events, discarding")
+			return nil, false, nil
+		}
+		return nil, true, nil
+	}
+	theirStart, theirEnd := theirs[0].Index, theirs[len(theirs)-1].Index
+	if theirEnd < ourEnd || ourEnd+1 < theirStart {
+		logger.WithFields(logrus.Fields{"theirStart": theirStart, "theirEnd": theirEnd, "ourEnd": ourEnd}).
+			Trace("events mismatch, discarding")
+		return nil, false, nil
+	}
+	return theirs[ourEnd+1-theirStart:], true, nil
+}
+
 // Issuance records
 
 func (rs *RevocationStorage) AddIssuanceRecord(r *IssuanceRecord) error {
-	return rs.sqldb.Insert(r)
+	return rs.db.InsertIssuanceRecord(r)
 }
 
+// IssuanceRecords returns the issuance records of the specified credential type and revocation key
+// that have not yet been revoked; all of them if issued is the zero value, otherwise only the one
+// issued at that time.
 func (rs *RevocationStorage) IssuanceRecords(id CredentialTypeIdentifier, key string, issued time.Time) ([]*IssuanceRecord, error) {
-	where := map[string]interface{}{"cred_type": id, "revocationkey": key, "revoked_at": 0}
+	return rs.issuanceRecords(rs.db, id, key, issued)
+}
+
+func (rs *RevocationStorage) issuanceRecords(tx RevocationDB, id CredentialTypeIdentifier, key string, issued time.Time) ([]*IssuanceRecord, error) {
+	var t int64
 	if !issued.IsZero() {
-		where["issued"] = issued.UnixNano()
+		t = issued.UnixNano()
 	}
-	var r []*IssuanceRecord
-	err := rs.sqldb.Find(&r, where)
+	records, err := tx.IssuanceRecords(id, key, t)
 	if err != nil {
 		return nil, err
 	}
+	var r []*IssuanceRecord
+	for _, record := range records {
+		if record.RevokedAt == 0 {
+			r = append(r, record)
+		}
+	}
 	if len(r) == 0 {
 		return nil, ErrUnknownRevocationKey
 	}
@@ -385,20 +440,23 @@ func (rs *RevocationStorage) Revoke(id CredentialTypeIdentifier, key string, iss
 	if !rs.settings.Get(id).Authority {
 		return errors.Errorf("cannot revoke %s", id)
 	}
-	return rs.sqldb.Transaction(func(tx sqlRevStorage) error {
+	return rs.db.Transaction(func(tx RevocationDB) error {
 		return rs.revoke(tx, id, key, issued)
 	})
 }
 
-func (rs *RevocationStorage) revoke(tx sqlRevStorage, id CredentialTypeIdentifier, key string, issued time.Time) error {
+func (rs *RevocationStorage) revoke(tx RevocationDB, id CredentialTypeIdentifier, key string, issued time.Time) error {
 	var err error
-	issrecords, err := rs.IssuanceRecords(id, key, issued)
+	issrecords, err := rs.issuanceRecords(tx, id, key, issued)
 	if err != nil {
 		return err
 	}
 
 	// get all relevant accumulators and events from the database
 	accs, events, err := rs.revokeReadRecords(tx, id, issrecords)
+	if err != nil {
+		return err
+	}
 
 	// For each issuance record, perform revocation, adding an Event and advancing the accumulator
 	for _, issrecord := range issrecords {
@@ -414,12 +472,8 @@ func (rs *RevocationStorage) revoke(tx sqlRevStorage, id CredentialTypeIdentifie
 	// Gather accumulators and update events per key counter into revocation updates,
 	// and add them to the database
 	for counter := range accs {
-		sk, err := rs.Keys.PrivateKey(id.IssuerIdentifier(), counter)
-		if err != nil {
-			return err
-		}
 		// exclude parent event from the events
-		update, err := revocation.NewUpdate(sk, accs[counter], events[counter][1:])
+		update, err := rs.newUpdate(id, counter, accs[counter], events[counter][1:])
 		if err != nil {
 			return err
 		}
@@ -431,67 +485,73 @@ func (rs *RevocationStorage) revoke(tx sqlRevStorage, id CredentialTypeIdentifie
 	return nil
 }
 
-func (rs *RevocationStorage) revokeReadRecords(
-	tx sqlRevStorage,
+// newUpdate signs the accumulator using the IssuerSigner of the configuration, and returns it in
+// an update along with the events, c.f. revocation.NewUpdate().
+func (rs *RevocationStorage) newUpdate(
 	id CredentialTypeIdentifier,
-	issrecords []*IssuanceRecord,
-) (map[uint]*revocation.Accumulator, map[uint][]*revocation.Event, error) {
-	// gather all keys used in the issuance requests
-	var keycounters []uint
-	for _, issrecord := range issrecords {
-		keycounters = append(keycounters, *issrecord.PKCounter)
-	}
-
-	// get all relevant accumulators from the database
-	var records []AccumulatorRecord
-	if err := tx.Find(&records, "cred_type = ? and pk_counter in (?)", id, keycounters); err != nil {
-		return nil, nil, err
-	}
-	var eventrecords []EventRecord
-	err := tx.Find(&eventrecords, "eventindex = (?)", tx.gorm.
-		Table("event_records e2").
-		Select("max(e2.eventindex)").
-		Where("e2.cred_type = event_records.cred_type and e2.pk_counter = event_records.pk_counter").
-		QueryExpr(),
-	)
+	counter uint,
+	acc *revocation.Accumulator,
+	events []*revocation.Event,
+) (*revocation.Update, error) {
+	sacc, err := rs.conf.IssuerSigner().SignAccumulator(id.IssuerIdentifier(), counter, acc)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
+	if err = revocation.NewEventList(events...).Verify(acc); err != nil {
+		return nil, err // ensure we don't return an invalid Update
+	}
+	return &revocation.Update{SignedAccumulator: sacc, Events: events}, nil
+}
 
+func (rs *RevocationStorage) revokeReadRecords(
+	tx RevocationDB,
+	id CredentialTypeIdentifier,
+	issrecords []*IssuanceRecord,
+) (map[uint]*revocation.Accumulator, map[uint][]*revocation.Event, error) {
+	// get the accumulator and last event of all keys used in the issuance requests
 	accs := map[uint]*revocation.Accumulator{}
 	events := map[uint][]*revocation.Event{}
-	for _, r := range records {
-		sacc := r.SignedAccumulator()
-		pk, err := rs.Keys.PublicKey(id.IssuerIdentifier(), sacc.PKCounter)
+	for _, issrecord := range issrecords {
+		counter := *issrecord.PKCounter
+		if _, ok := accs[counter]; ok {
+			continue
+		}
+		sacc, err := rs.accumulator(tx, id, counter)
 		if err != nil {
 			return nil, nil, err
 		}
-		accs[*r.PKCounter], err = sacc.UnmarshalVerify(pk)
+		pk, err := rs.Keys.PublicKey(id.IssuerIdentifier(), counter)
 		if err != nil {
 			return nil, nil, err
 		}
-	}
-	for _, e := range eventrecords {
-		events[*e.PKCounter] = append(events[*e.PKCounter], e.Event())
+		if accs[counter], err = sacc.UnmarshalVerify(pk); err != nil {
+			return nil, nil, err
+		}
+		last, err := tx.LatestEvents(id, counter, 1)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(last) == 0 {
+			return nil, nil, ErrRevocationStateNotFound
+		}
+		events[counter] = []*revocation.Event{last[0].Event()}
 	}
 	return accs, events, nil
 }
 
 func (rs *RevocationStorage) revokeCredential(
-	tx sqlRevStorage,
+	tx RevocationDB,
 	issrecord *IssuanceRecord,
 	acc *revocation.Accumulator,
 	parent *revocation.Event,
 ) (*revocation.Accumulator, *revocation.Event, error) {
 	issrecord.RevokedAt = time.Now().UnixNano()
-	if err := tx.Save(&issrecord); err != nil {
-		return nil, nil, err
-	}
-	sk, err := rs.Keys.PrivateKey(issrecord.CredType.IssuerIdentifier(), *issrecord.PKCounter)
-	if err != nil {
+	if err := tx.SaveIssuanceRecord(issrecord); err != nil {
 		return nil, nil, err
 	}
-	newacc, event, err := acc.Remove(sk, (*big.Int)(issrecord.Attr), parent)
+	newacc, event, err := rs.conf.IssuerSigner().RemoveFromAccumulator(
+		issrecord.CredType.IssuerIdentifier(), *issrecord.PKCounter, acc, (*big.Int)(issrecord.Attr), parent,
+	)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -503,27 +563,21 @@ func (rs *RevocationStorage) revokeCredential(
 func (rs *RevocationStorage) Accumulator(id CredentialTypeIdentifier, pkcounter uint) (
 	*revocation.SignedAccumulator, error,
 ) {
-	return rs.accumulator(rs.sqldb, id, pkcounter)
+	return rs.accumulator(rs.db, id, pkcounter)
 }
 
 // accumulator retrieves, verifies and deserializes the accumulator of the given type and key.
-func (rs *RevocationStorage) accumulator(tx sqlRevStorage, id CredentialTypeIdentifier, pkcounter uint) (
+func (rs *RevocationStorage) accumulator(tx RevocationDB, id CredentialTypeIdentifier, pkcounter uint) (
 	*revocation.SignedAccumulator, error,
 ) {
-	var err error
-	var sacc *revocation.SignedAccumulator
-	if rs.sqlMode {
-		record := &AccumulatorRecord{}
-		if err = tx.Last(record, map[string]interface{}{"cred_type": id, "pk_counter": pkcounter}); err != nil {
-			return nil, err
-		}
-		sacc = record.SignedAccumulator()
-	} else {
-		sacc = rs.memdb.SignedAccumulator(id, pkcounter)
-		if sacc == nil {
-			return nil, ErrRevocationStateNotFound
-		}
+	records, err := tx.Accumulators(id, &pkcounter)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, ErrRevocationStateNotFound
 	}
+	sacc := records[0].SignedAccumulator()
 
 	pk, err := rs.Keys.PublicKey(id.IssuerIdentifier(), sacc.PKCounter)
 	if err != nil {
@@ -537,28 +591,27 @@ func (rs *RevocationStorage) accumulator(tx sqlRevStorage, id CredentialTypeIden
 }
 
 func (rs *RevocationStorage) updateAccumulatorTimes() error {
-	if !rs.sqlMode {
-		return nil
-	}
 	var types []CredentialTypeIdentifier
 	for id, settings := range rs.settings {
 		if settings.Authority {
 			types = append(types, id)
 		}
 	}
-	return rs.sqldb.Transaction(func(tx sqlRevStorage) error {
-		var err error
-		var records []AccumulatorRecord
+	if len(types) == 0 {
+		return nil
+	}
+	return rs.db.Transaction(func(tx RevocationDB) error {
+		var records []*AccumulatorRecord
 		Logger.Tracef("updating accumulator times")
-		if err = tx.Find(&records, "cred_type in (?)", types); err != nil {
-			return err
-		}
-		for _, r := range records {
-			pk, err := rs.Keys.PublicKey(r.CredType.IssuerIdentifier(), *r.PKCounter)
+		for _, id := range types {
+			r, err := tx.Accumulators(id, nil)
 			if err != nil {
 				return err
 			}
-			sk, err := rs.Keys.PrivateKey(r.CredType.IssuerIdentifier(), *r.PKCounter)
+			records = append(records, r...)
+		}
+		for _, r := range records {
+			pk, err := rs.Keys.PublicKey(r.CredType.IssuerIdentifier(), *r.PKCounter)
 			if err != nil {
 				return err
 			}
@@ -567,12 +620,12 @@ func (rs *RevocationStorage) updateAccumulatorTimes() error {
 				return err
 			}
 			acc.Time = time.Now().Unix()
-			sacc, err := acc.Sign(sk)
+			sacc, err := rs.conf.IssuerSigner().SignAccumulator(r.CredType.IssuerIdentifier(), *r.PKCounter, acc)
 			if err != nil {
 				return err
 			}
 			r.Data = signedMessage(sacc.Data)
-			if err = tx.Save(r); err != nil {
+			if err = tx.SaveAccumulator(r); err != nil {
 				return err
 			}
 
@@ -621,8 +674,9 @@ func (rs *RevocationStorage) SyncIfOld(id CredentialTypeIdentifier, maxage uint6
 }
 
 // SaveIssuanceRecord either stores the issuance record locally, if we are the revocation server of
-// the crecential type, or it signs and sends it to the remote revocation server.
-func (rs *RevocationStorage) SaveIssuanceRecord(id CredentialTypeIdentifier, rec *IssuanceRecord, sk *gabikeys.PrivateKey) error {
+// the crecential type, or it signs it using the IssuerSigner of the configuration and sends it to
+// the remote revocation server.
+func (rs *RevocationStorage) SaveIssuanceRecord(id CredentialTypeIdentifier, rec *IssuanceRecord) error {
 	credtype := rs.conf.CredentialTypes[id]
 	if credtype == nil {
 		return ErrorUnknownCredentialType
@@ -638,10 +692,18 @@ func (rs *RevocationStorage) SaveIssuanceRecord(id CredentialTypeIdentifier, rec
 	}
 
 	// We have to send it, sign it first
-	if settings.RevocationServerURL == "" {
+	urls := settings.serverURLs()
+	if len(urls) == 0 {
 		return errors.New("cannot send issuance record: no server_url configured")
 	}
-	return rs.client.PostIssuanceRecord(id, sk, rec, settings.RevocationServerURL)
+	if rec.PKCounter == nil {
+		return errors.New("cannot send issuance record: no public key counter")
+	}
+	message, err := rs.conf.IssuerSigner().SignIssuanceRecord(id.IssuerIdentifier(), *rec.PKCounter, rec)
+	if err != nil {
+		return err
+	}
+	return rs.client.postIssuanceRecord(id, *rec.PKCounter, message, urls...)
 }
 
 // Misscelaneous methods
@@ -669,6 +731,11 @@ func (rs *RevocationStorage) handleSSEUpdates() {
 					logger.Warn("failed to add pushed update: ", err)
 				}
 			}
+		case id := <-rs.resync:
+			// we may have missed updates while we were disconnected
+			if err := rs.SyncDB(id); err != nil {
+				Logger.WithField("credtype", id).Warn("failed to fetch revocation updates after reconnecting: ", err)
+			}
 		case <-rs.close:
 			Logger.Trace("stop handling SSE events")
 			return
@@ -676,9 +743,11 @@ func (rs *RevocationStorage) handleSSEUpdates() {
 	}
 }
 
-func (rs *RevocationStorage) listenUpdates(id CredentialTypeIdentifier, url string) {
+// listenUpdates listens for update events sent by the revocation servers at the specified URLs,
+// using the server that is believed to be up. If the connection closes, it reconnects to the
+// same or another server, and fetches any updates that it may have missed in the meantime.
+func (rs *RevocationStorage) listenUpdates(id CredentialTypeIdentifier, urls []string) {
 	logger := Logger.WithField("credtype", id)
-	logger.Trace("listening for SSE update events")
 
 	// make a context that closes when rs.close closes
 	ctx, cancel := context.WithCancel(context.Background())
@@ -690,16 +759,41 @@ func (rs *RevocationStorage) listenUpdates(id CredentialTypeIdentifier, url stri
 			return
 		}
 	}()
-	err := sseclient.Notify(ctx, url, true, rs.events)
-	if err != nil {
-		logger.Warn("SSE connection closed: ", err)
+
+	for reconnect := false; ; reconnect = true {
+		url := revocationServers.order(urls)[0]
+		if reconnect {
+			select {
+			case rs.resync <- id:
+			case <-ctx.Done():
+				return
+			}
+		}
+		logger.WithField("url", url).Trace("listening for SSE update events")
+		err := sseclient.Notify(ctx, fmt.Sprintf("%s/revocation/%s/updateevents", url, id), false, rs.events)
+		if ctx.Err() != nil {
+			logger.Trace("stop listening for SSE update events")
+			return
+		}
+		revocationServers.failure(url, id)
+		if err != nil {
+			logger.Warn("SSE connection closed: ", err)
+		} else {
+			logger.Debug("SSE connection closed by server")
+		}
+		select {
+		case <-ctx.Done():
+			logger.Trace("stop listening for SSE update events")
+			return
+		case <-time.After(time.Duration(RevocationParameters.SSEReconnectDelay) * time.Millisecond):
+		}
 	}
 }
 
 func updateURL(id CredentialTypeIdentifier, conf *Configuration, rs RevocationSettings) ([]string, error) {
 	settings := rs[id]
-	if settings != nil && settings.RevocationServerURL != "" {
-		return []string{settings.RevocationServerURL}, nil
+	if urls := settings.serverURLs(); len(urls) > 0 {
+		return urls, nil
 	} else {
 		credtype := conf.CredentialTypes[id]
 		if credtype == nil {
@@ -716,16 +810,26 @@ func (rs *RevocationStorage) Load(debug bool, dbtype, connstr string, settings R
 	settings.fixCase(rs.conf)
 	settings.fixSlash()
 	var t *CredentialTypeIdentifier
+	sse := map[CredentialTypeIdentifier][]string{}
 	for id, s := range settings {
 		if !s.Authority {
 			if s.Server && s.RevocationServerURL == "" {
 				return errors.Errorf("revocation server mode for %s requires URL to be configured", id.String())
 			}
+			if len(s.FallbackServerURLs) > 0 && s.RevocationServerURL == "" {
+				return errors.Errorf("fallback server URLs for %s require URL to be configured", id.String())
+			}
+			if len(s.Webhooks) > 0 {
+				return errors.Errorf("webhooks for %s require revocation authority mode", id.String())
+			}
 		} else {
 			s.Server = true
-			if s.RevocationServerURL != "" {
+			if s.RevocationServerURL != "" || len(s.FallbackServerURLs) > 0 {
 				return errors.Errorf("revocation authority mode for %s cannot be combined with URL", id.String())
 			}
+			if s.AcceptUpdates {
+				return errors.Errorf("revocation authority mode for %s cannot be combined with accepting updates", id.String())
+			}
 		}
 		if s.Server {
 			t = &id
@@ -735,16 +839,10 @@ func (rs *RevocationStorage) Load(debug bool, dbtype, connstr string, settings R
 			if err != nil {
 				return err
 			}
-			if rs.close == nil {
-				rs.close = make(chan struct{})
-				rs.events = make(chan *sseclient.Event)
-				go rs.handleSSEUpdates()
-			}
-			url := fmt.Sprintf("%s/revocation/%s/updateevents", urls[0], id.String())
-			go rs.listenUpdates(id, url)
+			sse[id] = urls
 		}
 	}
-	if t != nil && connstr == "" {
+	if t != nil && connstr == "" && rs.db == nil {
 		return errors.Errorf("revocation mode for %s requires SQL database but no connection string given", *t)
 	}
 
@@ -755,28 +853,30 @@ func (rs *RevocationStorage) Load(debug bool, dbtype, connstr string, settings R
 		}
 	})
 
+	rs.conf.Scheduler.Every(RevocationParameters.ServerProbeInterval).Seconds().Do(func() {
+		revocationServers.probe()
+	})
+
 	rs.conf.Scheduler.Every(RevocationParameters.DeleteIssuanceRecordsInterval).Minutes().Do(func() {
-		if !rs.sqlMode {
-			return
-		}
-		if err := rs.sqldb.Delete(IssuanceRecord{}, "valid_until < ?", time.Now().UnixNano()); err != nil {
+		if err := rs.db.DeleteExpiredIssuanceRecords(time.Now()); err != nil {
 			err = errors.WrapPrefix(err, "failed to delete expired issuance records", 0)
 			raven.CaptureError(err, nil)
 		}
 	})
 
-	if connstr == "" {
+	switch {
+	case rs.db != nil:
+		Logger.Trace("Using custom revocation database")
+	case connstr == "":
 		Logger.Trace("Using memory revocation database")
-		rs.memdb = newMemStorage()
-		rs.sqlMode = false
-	} else {
+		rs.db = newMemCache()
+	default:
 		Logger.Trace("Connecting to revocation SQL database")
 		db, err := newSqlStorage(debug, dbtype, connstr)
 		if err != nil {
 			return err
 		}
-		rs.sqldb = db
-		rs.sqlMode = true
+		rs.db = db
 	}
 	if settings != nil {
 		rs.settings = settings
@@ -791,6 +891,17 @@ func (rs *RevocationStorage) Load(debug bool, dbtype, connstr string, settings R
 	}
 	rs.client = RevocationClient{Conf: rs.conf, Settings: rs.settings}
 	rs.Keys = RevocationKeys{Conf: rs.conf}
+
+	// Start listening for updates only now that we are ready to process them
+	if len(sse) > 0 && rs.close == nil {
+		rs.close = make(chan struct{})
+		rs.events = make(chan *sseclient.Event)
+		rs.resync = make(chan CredentialTypeIdentifier)
+		go rs.handleSSEUpdates()
+	}
+	for id, urls := range sse {
+		go rs.listenUpdates(id, urls)
+	}
 	return nil
 }
 
@@ -798,7 +909,10 @@ func (rs *RevocationStorage) Close() error {
 	if rs.close != nil {
 		close(rs.close)
 	}
-	return rs.sqldb.Close()
+	if rs.db == nil {
+		return nil
+	}
+	return rs.db.Close()
 }
 
 // SetRevocationUpdates retrieves the latest revocation records from the database, and attaches
@@ -844,23 +958,61 @@ func (rs *RevocationStorage) SetRevocationUpdates(b *BaseRequest) error {
 	return nil
 }
 
+// PostUpdate sends the update to all listeners, if any, if we are the revocation authority
+// for the credential type: over SSE to connected clients, and asynchronously to all webhooks.
 func (rs *RevocationStorage) PostUpdate(id CredentialTypeIdentifier, update *revocation.Update) {
-	if rs.ServerSentEvents == nil || !rs.settings.Get(id).Authority {
+	settings := rs.settings.Get(id)
+	if !settings.Authority {
 		return
 	}
-	Logger.WithField("credtype", id).Tracef("sending SSE update event")
-	bts, _ := json.Marshal(update)
-	rs.ServerSentEvents.SendMessage("revocation/"+id.String(), sse.SimpleMessage(string(bts)))
+	if rs.ServerSentEvents != nil {
+		Logger.WithField("credtype", id).Tracef("sending SSE update event")
+		bts, _ := json.Marshal(update)
+		rs.ServerSentEvents.SendMessage("revocation/"+id.String(), sse.SimpleMessage(string(bts)))
+	}
+	for _, url := range settings.Webhooks {
+		go func(url string) {
+			logger := Logger.WithFields(logrus.Fields{"credtype": id, "url": url})
+			logger.Trace("posting update to webhook")
+			if err := rs.client.PostUpdate(url, update); err != nil {
+				logger.Warn("failed to post update to webhook: ", err)
+			}
+		}(url)
+	}
 }
 
-func (client RevocationClient) PostIssuanceRecord(id CredentialTypeIdentifier, sk *gabikeys.PrivateKey, rec *IssuanceRecord, url string) error {
+// PostIssuanceRecord signs the issuance record and sends it to the first of the revocation
+// servers at the specified URLs that accepts it, trying the servers that are believed to be up first.
+func (client RevocationClient) PostIssuanceRecord(id CredentialTypeIdentifier, sk *gabikeys.PrivateKey, rec *IssuanceRecord, urls ...string) error {
 	message, err := signed.MarshalSign(sk.ECDSA, rec)
 	if err != nil {
 		return err
 	}
-	return client.transport(false).Post(
-		fmt.Sprintf("%s/revocation/%s/issuancerecord/%d", url, id, sk.Counter), nil, []byte(message),
+	return client.postIssuanceRecord(id, sk.Counter, message, urls...)
+}
+
+func (client RevocationClient) postIssuanceRecord(id CredentialTypeIdentifier, counter uint, message signed.Message, urls ...string) error {
+	var (
+		err       error
+		errs      multierror.Error
+		transport = client.transport(false)
 	)
+	for _, url := range revocationServers.order(urls) {
+		err = transport.Post(
+			fmt.Sprintf("%s/revocation/%s/issuancerecord/%d", url, id, counter), nil, []byte(message),
+		)
+		revocationServers.report(url, id, err)
+		if err == nil {
+			return nil
+		}
+		errs.Errors = append(errs.Errors, err)
+	}
+	return &errs
+}
+
+// PostUpdate sends the update to the specified URL, e.g. a webhook.
+func (client RevocationClient) PostUpdate(url string, update *revocation.Update) error {
+	return client.transport(false).Post(url, nil, update)
 }
 
 func (client RevocationClient) FetchUpdateFrom(id CredentialTypeIdentifier, pkcounter uint, from uint64) (*revocation.Update, error) {
@@ -897,6 +1049,7 @@ func (client RevocationClient) FetchUpdateFrom(id CredentialTypeIdentifier, pkco
 		go func(i [2]uint64) {
 			events := &revocation.EventList{ComputeProduct: true}
 			if e := client.getMultiple(
+				id,
 				client.Conf.CredentialTypes[id].RevocationServers,
 				fmt.Sprintf("/revocation/%s/events/%d/%d/%d", id, pkcounter, i[0], i[1]),
 				events,
@@ -938,6 +1091,7 @@ func (client RevocationClient) FetchUpdateLatest(id CredentialTypeIdentifier, pk
 	}
 	update := &revocation.Update{}
 	return update, client.getMultiple(
+		id,
 		urls,
 		fmt.Sprintf("/revocation/%s/update/%d/%d", id, count, pkcounter),
 		&update,
@@ -951,20 +1105,22 @@ func (client RevocationClient) FetchUpdatesLatest(id CredentialTypeIdentifier, c
 	}
 	update := map[uint]*revocation.Update{}
 	return update, client.getMultiple(
+		id,
 		urls,
 		fmt.Sprintf("/revocation/%s/update/%d", id, count),
 		&update,
 	)
 }
 
-func (client RevocationClient) getMultiple(urls []string, path string, dest interface{}) error {
+func (client RevocationClient) getMultiple(id CredentialTypeIdentifier, urls []string, path string, dest interface{}) error {
 	var (
 		errs      multierror.Error
 		transport = client.transport(false)
 	)
-	for _, url := range urls {
+	for _, url := range revocationServers.order(urls) {
 		transport.Server = url
 		err := transport.Get(path, dest)
+		revocationServers.report(url, id, err)
 		if err == nil {
 			return nil
 		} else {
@@ -1010,6 +1166,35 @@ func (rs RevocationKeys) PrivateKey(issid IssuerIdentifier, counter uint) (*gabi
 	return sk, nil
 }
 
+// PrivateKeyCounterLatest returns the counter of the latest private key of the issuer that is
+// available to the IssuerSigner of the configuration, which must support revocation.
+func (rs RevocationKeys) PrivateKeyCounterLatest(issid IssuerIdentifier) (uint, error) {
+	counter, err := LatestPrivateKeyCounter(rs.Conf.IssuerSigner(), issid)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = rs.PublicKey(issid, counter); err != nil {
+		return 0, err
+	}
+	return counter, nil
+}
+
+// PrivateKeyCounter returns an error if the private key with the specified counter is not
+// available to the IssuerSigner of the configuration, or if it does not support revocation.
+func (rs RevocationKeys) PrivateKeyCounter(issid IssuerIdentifier, counter uint) error {
+	counters, err := rs.Conf.IssuerSigner().Counters(issid)
+	if err != nil {
+		return err
+	}
+	for _, c := range counters {
+		if c == counter {
+			_, err = rs.PublicKey(issid, counter)
+			return err
+		}
+	}
+	return ErrMissingPrivateKey
+}
+
 func (rs RevocationKeys) PublicKey(issid IssuerIdentifier, counter uint) (*gabikeys.PublicKey, error) {
 	pk, err := rs.Conf.PublicKey(issid, counter)
 	if err != nil {
@@ -1117,6 +1302,17 @@ func (rs RevocationSettings) Get(id CredentialTypeIdentifier) *RevocationSetting
 	return s
 }
 
+// AcceptsUpdates returns whether revocation updates POSTed by webhooks are accepted for any of
+// the credential types.
+func (rs RevocationSettings) AcceptsUpdates() bool {
+	for _, s := range rs {
+		if s.AcceptUpdates {
+			return true
+		}
+	}
+	return false
+}
+
 func (rs RevocationSettings) fixCase(conf *Configuration) {
 	for id := range conf.CredentialTypes {
 		idlc := NewCredentialTypeIdentifier(strings.ToLower(id.String()))
@@ -1130,7 +1326,19 @@ func (rs RevocationSettings) fixCase(conf *Configuration) {
 func (rs RevocationSettings) fixSlash() {
 	for _, s := range rs {
 		s.RevocationServerURL = strings.TrimRight(s.RevocationServerURL, "/")
+		for i, url := range s.FallbackServerURLs {
+			s.FallbackServerURLs[i] = strings.TrimRight(url, "/")
+		}
+	}
+}
+
+// serverURLs returns the URLs of the revocation servers configured in the settings,
+// or nil if none are configured.
+func (s *RevocationSetting) serverURLs() []string {
+	if s == nil || s.RevocationServerURL == "" {
+		return nil
 	}
+	return append([]string{s.RevocationServerURL}, s.FallbackServerURLs...)
 }
 
 func (hash eventHash) Value() (driver.Value, error) {
@@ -2,30 +2,107 @@ package irma
 
 import (
 	"log"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/go-errors/errors"
 	"github.com/jinzhu/gorm"
-	"github.com/privacybydesign/gabi/revocation"
 	"github.com/sirupsen/logrus"
 )
 
 type (
-	// sqlRevStorage is a wrapper around gorm, storing any record type in a SQL database,
+	// RevocationDB is a storage backend for the revocation records (accumulators, events and
+	// issuance records) of RevocationStorage. Two implementations are included: a SQL database,
+	// used when a connection string is configured, and an in-memory database otherwise. A custom
+	// implementation can be used by passing it to NewConfiguration() in ConfigurationOptions.
+	//
+	// RevocationStorage verifies the signatures and hash chains of all accumulators and events that
+	// it stores and retrieves, so implementations need not do so. Records passed to and returned by
+	// implementations may be modified by the caller afterwards, so implementations should not retain
+	// references to them.
+	RevocationDB interface {
+		// Transaction calls f with a RevocationDB in which all modifications made by f are applied
+		// atomically, if and only if f returns nil.
+		Transaction(f func(tx RevocationDB) error) error
+		Close() error
+
+		// Accumulators returns the accumulator records of the specified credential type, either of
+		// all public key counters or only of the specified one if counter is not nil.
+		Accumulators(id CredentialTypeIdentifier, counter *uint) ([]*AccumulatorRecord, error)
+		// InsertAccumulator stores a new accumulator record, returning an error if one already
+		// exists for the credential type and public key counter.
+		InsertAccumulator(record *AccumulatorRecord) error
+		// SaveAccumulator stores an accumulator record, overwriting any existing record for the
+		// credential type and public key counter.
+		SaveAccumulator(record *AccumulatorRecord) error
+
+		// Events returns the events of the specified credential type and public key counter
+		// having from <= index < to, ordered by index.
+		Events(id CredentialTypeIdentifier, counter uint, from, to uint64) ([]*EventRecord, error)
+		// LatestEvents returns the count latest events of the specified credential type and
+		// public key counter, ordered by index.
+		LatestEvents(id CredentialTypeIdentifier, counter uint, count uint64) ([]*EventRecord, error)
+		// EventCount returns the number of events stored of the specified credential type and
+		// public key counter.
+		EventCount(id CredentialTypeIdentifier, counter uint) (uint64, error)
+		// EventCounters returns the public key counters for which events of the specified
+		// credential type exist.
+		EventCounters(id CredentialTypeIdentifier) ([]uint, error)
+		// InsertEvent stores a new event record, returning an error if one already exists
+		// for the credential type, public key counter and index.
+		InsertEvent(record *EventRecord) error
+
+		// IssuanceRecords returns the issuance records of the specified credential type and
+		// revocation key, either all of them, or only the one issued at the specified time
+		// (in nanoseconds since the Unix epoch) if issued is not 0.
+		IssuanceRecords(id CredentialTypeIdentifier, key string, issued int64) ([]*IssuanceRecord, error)
+		// AllIssuanceRecords returns all issuance records of the specified credential type
+		// and public key counter.
+		AllIssuanceRecords(id CredentialTypeIdentifier, counter uint) ([]*IssuanceRecord, error)
+		// IssuanceRecordCount returns the number of issuance records of the specified credential
+		// type and public key counter, restricted to those that are (not) revoked if revoked is
+		// not nil, and to those whose validity ends before validUntil if it is not 0.
+		IssuanceRecordCount(id CredentialTypeIdentifier, counter uint, revoked *bool, validUntil int64) (uint64, error)
+		// InsertIssuanceRecord stores a new issuance record, returning an error if one already
+		// exists for the credential type, revocation key and issuance time.
+		InsertIssuanceRecord(record *IssuanceRecord) error
+		// SaveIssuanceRecord stores an issuance record, overwriting any existing record for the
+		// credential type, revocation key and issuance time.
+		SaveIssuanceRecord(record *IssuanceRecord) error
+		// DeleteExpiredIssuanceRecords deletes all issuance records whose validity ended before t.
+		DeleteExpiredIssuanceRecords(t time.Time) error
+	}
+
+	// sqlRevStorage is a RevocationDB storing its records in a SQL database using gorm,
 	// for use by revocation servers.
 	sqlRevStorage struct {
 		gorm *gorm.DB
 	}
 
-	// memRevStorage is a much simpler in-memory database, suitable only for storing update messages.
+	// memRevStorage is a RevocationDB storing its records in memory. It is used by requestor
+	// servers, which need only the latest revocation update messages, and in tests.
 	memRevStorage struct {
-		sync.Mutex
-		records map[CredentialTypeIdentifier]*memUpdateRecord
+		lock            *sync.RWMutex // nil within transactions, which are not shared between goroutines
+		accumulators    map[memRevKey]*AccumulatorRecord
+		events          map[memRevKey][]*EventRecord // ordered by index
+		issuanceRecords map[memIssuanceKey]*IssuanceRecord
+		// maxEvents is the number of latest events kept per credential type and public key
+		// counter, older ones being discarded; 0 means all events are kept.
+		maxEvents uint64
+		// undo contains, within transactions, the functions reverting the modifications made so far
+		undo *[]func()
+	}
+
+	memRevKey struct {
+		CredType  CredentialTypeIdentifier
+		PKCounter uint
 	}
 
-	memUpdateRecord struct {
-		sync.Mutex
-		r map[uint]*revocation.Update
+	memIssuanceKey struct {
+		CredType CredentialTypeIdentifier
+		Key      string
+		Issued   int64
 	}
 )
 
@@ -66,7 +143,7 @@ func (s sqlRevStorage) Close() error {
 	return s.gorm.Close()
 }
 
-func (s sqlRevStorage) Transaction(f func(tx sqlRevStorage) error) (err error) {
+func (s sqlRevStorage) Transaction(f func(tx RevocationDB) error) (err error) {
 	tx := sqlRevStorage{gorm: s.gorm.Begin()}
 	defer func() {
 		if e := recover(); e != nil {
@@ -84,139 +161,401 @@ func (s sqlRevStorage) Transaction(f func(tx sqlRevStorage) error) (err error) {
 	return
 }
 
-func (s sqlRevStorage) Insert(o interface{}) error {
-	return s.gorm.Create(o).Error
+func (s sqlRevStorage) Accumulators(id CredentialTypeIdentifier, counter *uint) ([]*AccumulatorRecord, error) {
+	where := map[string]interface{}{"cred_type": id}
+	if counter != nil {
+		where["pk_counter"] = *counter
+	}
+	var records []*AccumulatorRecord
+	return records, s.find(&records, where)
+}
+
+func (s sqlRevStorage) InsertAccumulator(record *AccumulatorRecord) error {
+	return s.gorm.Create(record).Error
 }
 
-func (s sqlRevStorage) Save(o interface{}) error {
-	return s.gorm.Save(o).Error
+func (s sqlRevStorage) SaveAccumulator(record *AccumulatorRecord) error {
+	return s.gorm.Save(record).Error
 }
 
-func (s sqlRevStorage) Last(dest interface{}, query interface{}, args ...interface{}) error {
-	db := s.gorm
-	if query != nil {
-		db = db.Where(query, args...)
+func (s sqlRevStorage) Events(id CredentialTypeIdentifier, counter uint, from, to uint64) ([]*EventRecord, error) {
+	var records []*EventRecord
+	return records, s.find(&records,
+		"cred_type = ? and pk_counter = ? and eventindex >= ? and eventindex < ?",
+		id, counter, from, to,
+	)
+}
+
+func (s sqlRevStorage) LatestEvents(id CredentialTypeIdentifier, counter uint, count uint64) ([]*EventRecord, error) {
+	var records []*EventRecord
+	err := s.gorm.
+		Where(map[string]interface{}{"cred_type": id, "pk_counter": counter}).
+		Limit(count).
+		Set("gorm:order_by_primary_key", "DESC").
+		Find(&records).Error
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
 	}
-	return db.Last(dest).Error
+	return records, nil
+}
+
+func (s sqlRevStorage) EventCount(id CredentialTypeIdentifier, counter uint) (uint64, error) {
+	var count uint64
+	return count, s.gorm.Model((*EventRecord)(nil)).
+		Where(map[string]interface{}{"cred_type": id, "pk_counter": counter}).
+		Count(&count).Error
+}
+
+func (s sqlRevStorage) EventCounters(id CredentialTypeIdentifier) ([]uint, error) {
+	var counters []uint
+	return counters, s.gorm.Model((*EventRecord)(nil)).
+		Where(map[string]interface{}{"cred_type": id}).
+		Pluck("distinct pk_counter", &counters).Error
+}
+
+func (s sqlRevStorage) InsertEvent(record *EventRecord) error {
+	return s.gorm.Create(record).Error
 }
 
-func (s sqlRevStorage) Exists(id interface{}, query interface{}, args ...interface{}) (bool, error) {
-	var c int
-	db := s.gorm.Model(id)
-	if query != nil {
-		db = db.Where(query, args...)
+func (s sqlRevStorage) IssuanceRecords(id CredentialTypeIdentifier, key string, issued int64) ([]*IssuanceRecord, error) {
+	where := map[string]interface{}{"cred_type": id, "revocationkey": key}
+	if issued != 0 {
+		where["issued"] = issued
 	}
-	db = db.Count(&c)
-	return c > 0, db.Error
+	var records []*IssuanceRecord
+	return records, s.find(&records, where)
 }
 
-func (s sqlRevStorage) Delete(id interface{}, query interface{}, args ...interface{}) error {
-	return s.gorm.Delete(id, query, args).Error
+func (s sqlRevStorage) AllIssuanceRecords(id CredentialTypeIdentifier, counter uint) ([]*IssuanceRecord, error) {
+	var records []*IssuanceRecord
+	return records, s.find(&records, map[string]interface{}{"cred_type": id, "pk_counter": counter})
 }
 
-func (s sqlRevStorage) Find(dest interface{}, query interface{}, args ...interface{}) error {
-	return s.gorm.
-		Where(query, args...).
-		Set("gorm:order_by_primary_key", "ASC").
-		Find(dest).Error
+func (s sqlRevStorage) IssuanceRecordCount(id CredentialTypeIdentifier, counter uint, revoked *bool, validUntil int64) (uint64, error) {
+	var count uint64
+	query := s.gorm.Model((*IssuanceRecord)(nil)).
+		Where(map[string]interface{}{"cred_type": id, "pk_counter": counter})
+	if revoked != nil && *revoked {
+		query = query.Where("revoked_at != 0")
+	} else if revoked != nil {
+		query = query.Where("revoked_at = 0")
+	}
+	if validUntil != 0 {
+		query = query.Where("valid_until < ?", validUntil)
+	}
+	return count, query.Count(&count).Error
 }
 
-func (s sqlRevStorage) Latest(dest interface{}, count uint64, query interface{}, args ...interface{}) error {
+func (s sqlRevStorage) InsertIssuanceRecord(record *IssuanceRecord) error {
+	return s.gorm.Create(record).Error
+}
+
+func (s sqlRevStorage) SaveIssuanceRecord(record *IssuanceRecord) error {
+	return s.gorm.Save(record).Error
+}
+
+func (s sqlRevStorage) DeleteExpiredIssuanceRecords(t time.Time) error {
+	return s.gorm.Where("valid_until < ?", t.UnixNano()).Delete(IssuanceRecord{}).Error
+}
+
+func (s sqlRevStorage) find(dest interface{}, query interface{}, args ...interface{}) error {
 	return s.gorm.
 		Where(query, args...).
-		Limit(count).
-		Set("gorm:order_by_primary_key", "DESC").
+		Set("gorm:order_by_primary_key", "ASC").
 		Find(dest).Error
 }
 
 func newMemStorage() *memRevStorage {
 	return &memRevStorage{
-		records: make(map[CredentialTypeIdentifier]*memUpdateRecord),
+		lock:            &sync.RWMutex{},
+		accumulators:    map[memRevKey]*AccumulatorRecord{},
+		events:          map[memRevKey][]*EventRecord{},
+		issuanceRecords: map[memIssuanceKey]*IssuanceRecord{},
 	}
 }
 
-func (m *memRevStorage) get(id CredentialTypeIdentifier) *memUpdateRecord {
-	m.Lock()
-	defer m.Unlock()
-	return m.records[id]
+// newMemCache returns an in-memory RevocationDB that, like the update message cache of requestor
+// servers, keeps only as many events as are attached to session requests at most.
+func newMemCache() *memRevStorage {
+	m := newMemStorage()
+	m.maxEvents = RevocationParameters.UpdateMaxCount
+	return m
 }
 
-func (m *memRevStorage) Latest(id CredentialTypeIdentifier, count uint64) map[uint]*revocation.Update {
-	record := m.get(id)
-	if record == nil {
-		return nil
+// rlock and wlock acquire the appropriate lock if we are not within a transaction,
+// returning a function that releases it.
+func (m *memRevStorage) rlock() func() {
+	if m.lock == nil {
+		return func() {}
+	}
+	m.lock.RLock()
+	return m.lock.RUnlock
+}
+
+func (m *memRevStorage) wlock() func() {
+	if m.lock == nil {
+		return func() {}
 	}
-	record.Lock()
-	defer record.Unlock()
+	m.lock.Lock()
+	return m.lock.Unlock
+}
+
+// Transaction calls f on the database while holding its lock, recording how to revert each
+// modification made by f, which is done if f returns an error. Transactions are serialized with
+// respect to each other and to all other operations.
+func (m *memRevStorage) Transaction(f func(tx RevocationDB) error) (err error) {
+	if m.lock == nil {
+		return f(m) // nested transaction
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
 
-	updates := map[uint]*revocation.Update{}
-	for _, r := range record.r {
-		offset := int64(len(r.Events)) - int64(count)
-		if offset < 0 {
-			offset = 0
+	tx := &memRevStorage{
+		accumulators:    m.accumulators,
+		events:          m.events,
+		issuanceRecords: m.issuanceRecords,
+		maxEvents:       m.maxEvents,
+		undo:            &[]func(){},
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			tx.rollback()
+			panic(e)
 		}
-		update := &revocation.Update{
-			SignedAccumulator: r.SignedAccumulator,
-			Events:            make([]*revocation.Event, int64(len(r.Events))-offset),
+		if err != nil {
+			tx.rollback()
 		}
-		copy(update.Events, r.Events[offset:])
-		if len(update.Events) > 0 {
-			Logger.WithFields(logrus.Fields{
-				"start": update.Events[0].Index, "end": update.Events[len(update.Events)-1].Index,
-			}).Tracef("memdb: get latest")
+	}()
+	return f(tx)
+}
+
+// onRollback registers a function reverting a modification, if we are within a transaction.
+func (m *memRevStorage) onRollback(f func()) {
+	if m.undo != nil {
+		*m.undo = append(*m.undo, f)
+	}
+}
+
+func (m *memRevStorage) rollback() {
+	for i := len(*m.undo) - 1; i >= 0; i-- {
+		(*m.undo)[i]()
+	}
+}
+
+// Records are never modified in place but replaced, so that rollback functions can restore
+// the previous ones.
+
+func (m *memRevStorage) putAccumulator(key memRevKey, record *AccumulatorRecord) {
+	prev, existed := m.accumulators[key]
+	m.onRollback(func() {
+		if existed {
+			m.accumulators[key] = prev
+		} else {
+			delete(m.accumulators, key)
 		}
-		updates[r.SignedAccumulator.PKCounter] = update
+	})
+	m.accumulators[key] = record
+}
+
+func (m *memRevStorage) putIssuanceRecord(key memIssuanceKey, record *IssuanceRecord) {
+	prev, existed := m.issuanceRecords[key]
+	m.onRollback(func() {
+		if existed {
+			m.issuanceRecords[key] = prev
+		} else {
+			delete(m.issuanceRecords, key)
+		}
+	})
+	if record == nil {
+		delete(m.issuanceRecords, key)
+	} else {
+		m.issuanceRecords[key] = record
 	}
+}
 
-	return updates
+func (m *memRevStorage) Close() error {
+	return nil
+}
+
+func (m *memRevStorage) Accumulators(id CredentialTypeIdentifier, counter *uint) ([]*AccumulatorRecord, error) {
+	defer m.rlock()()
+	var records []*AccumulatorRecord
+	for k, r := range m.accumulators {
+		if k.CredType == id && (counter == nil || k.PKCounter == *counter) {
+			record := *r
+			records = append(records, &record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return *records[i].PKCounter < *records[j].PKCounter })
+	return records, nil
 }
 
-func (m *memRevStorage) SignedAccumulator(id CredentialTypeIdentifier, pkcounter uint) *revocation.SignedAccumulator {
-	updates := m.Latest(id, 0)
-	if update := updates[pkcounter]; update != nil {
-		return update.SignedAccumulator
+func (m *memRevStorage) InsertAccumulator(record *AccumulatorRecord) error {
+	defer m.wlock()()
+	key := memRevKey{record.CredType, *record.PKCounter}
+	if m.accumulators[key] != nil {
+		return errors.New("accumulator record already exists")
 	}
+	r := *record
+	m.putAccumulator(key, &r)
 	return nil
 }
 
-func (m *memRevStorage) Insert(id CredentialTypeIdentifier, update *revocation.Update) {
-	logger := Logger.WithFields(logrus.Fields{
-		"credtype": id, "counter": update.SignedAccumulator.PKCounter,
+func (m *memRevStorage) SaveAccumulator(record *AccumulatorRecord) error {
+	defer m.wlock()()
+	r := *record
+	m.putAccumulator(memRevKey{record.CredType, *record.PKCounter}, &r)
+	return nil
+}
+
+func (m *memRevStorage) Events(id CredentialTypeIdentifier, counter uint, from, to uint64) ([]*EventRecord, error) {
+	defer m.rlock()()
+	var records []*EventRecord
+	for _, r := range m.events[memRevKey{id, counter}] {
+		if *r.Index >= from && *r.Index < to {
+			record := *r
+			records = append(records, &record)
+		}
+	}
+	return records, nil
+}
+
+func (m *memRevStorage) LatestEvents(id CredentialTypeIdentifier, counter uint, count uint64) ([]*EventRecord, error) {
+	defer m.rlock()()
+	events := m.events[memRevKey{id, counter}]
+	offset := int64(len(events)) - int64(count)
+	if offset < 0 {
+		offset = 0
+	}
+	records := make([]*EventRecord, 0, int64(len(events))-offset)
+	for _, r := range events[offset:] {
+		record := *r
+		records = append(records, &record)
+	}
+	return records, nil
+}
+
+func (m *memRevStorage) EventCount(id CredentialTypeIdentifier, counter uint) (uint64, error) {
+	defer m.rlock()()
+	return uint64(len(m.events[memRevKey{id, counter}])), nil
+}
+
+func (m *memRevStorage) EventCounters(id CredentialTypeIdentifier) ([]uint, error) {
+	defer m.rlock()()
+	var counters []uint
+	for k, events := range m.events {
+		if k.CredType == id && len(events) > 0 {
+			counters = append(counters, k.PKCounter)
+		}
+	}
+	return counters, nil
+}
+
+func (m *memRevStorage) InsertEvent(record *EventRecord) error {
+	defer m.wlock()()
+	key := memRevKey{record.CredType, *record.PKCounter}
+	events := m.events[key]
+	i := sort.Search(len(events), func(i int) bool { return *events[i].Index >= *record.Index })
+	if i < len(events) && *events[i].Index == *record.Index {
+		return errors.New("event record already exists")
+	}
+	r := *record
+	prev, existed := m.events[key]
+	m.onRollback(func() {
+		if existed {
+			m.events[key] = prev
+		} else {
+			delete(m.events, key)
+		}
 	})
-	record := m.get(id)
-	if record == nil {
-		record = &memUpdateRecord{r: map[uint]*revocation.Update{}}
-		m.records[id] = record
+	// Don't modify the part of the slice visible through prev, which the rollback function restores
+	if i == len(events) {
+		events = append(events, &r)
+	} else {
+		inserted := make([]*EventRecord, 0, len(events)+1)
+		inserted = append(inserted, events[:i]...)
+		inserted = append(inserted, &r)
+		events = append(inserted, events[i:]...)
+	}
+	if m.maxEvents > 0 && uint64(len(events)) > m.maxEvents {
+		events = events[uint64(len(events))-m.maxEvents:]
 	}
-	record.Lock()
-	defer record.Unlock()
+	m.events[key] = events
+	return nil
+}
 
-	r := record.r[update.SignedAccumulator.PKCounter]
-	if r == nil {
-		if len(update.Events) > 0 {
-			logger.Trace("memdb: inserting new record")
-			record.r[update.SignedAccumulator.PKCounter] = update
+func (m *memRevStorage) IssuanceRecords(id CredentialTypeIdentifier, key string, issued int64) ([]*IssuanceRecord, error) {
+	defer m.rlock()()
+	var records []*IssuanceRecord
+	for k, r := range m.issuanceRecords {
+		if k.CredType == id && k.Key == key && (issued == 0 || k.Issued == issued) {
+			record := *r
+			records = append(records, &record)
 		}
-		return
 	}
-	if len(update.Events) == 0 {
-		if r.SignedAccumulator.Accumulator.Index == update.SignedAccumulator.Accumulator.Index {
-			logger.Trace("memdb: received new accumulator")
-			r.SignedAccumulator = update.SignedAccumulator
+	sort.Slice(records, func(i, j int) bool { return records[i].Issued < records[j].Issued })
+	return records, nil
+}
+
+func (m *memRevStorage) AllIssuanceRecords(id CredentialTypeIdentifier, counter uint) ([]*IssuanceRecord, error) {
+	defer m.rlock()()
+	var records []*IssuanceRecord
+	for k, r := range m.issuanceRecords {
+		if k.CredType == id && r.PKCounter != nil && *r.PKCounter == counter {
+			record := *r
+			records = append(records, &record)
 		}
-		return
 	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Key != records[j].Key {
+			return records[i].Key < records[j].Key
+		}
+		return records[i].Issued < records[j].Issued
+	})
+	return records, nil
+}
 
-	ours := r.Events
-	theirs := update.Events
-	theirStart, theirEnd, ourEnd := theirs[0].Index, theirs[len(theirs)-1].Index, ours[len(ours)-1].Index
-	if theirEnd < ourEnd || ourEnd+1 < theirStart {
-		logger.WithFields(logrus.Fields{"theirStart": theirStart, "theirEnd": theirEnd, "ourEnd": ourEnd}).Trace("memdb: events mismatch, discarding")
-		return
+func (m *memRevStorage) IssuanceRecordCount(id CredentialTypeIdentifier, counter uint, revoked *bool, validUntil int64) (uint64, error) {
+	defer m.rlock()()
+	var count uint64
+	for k, r := range m.issuanceRecords {
+		if k.CredType != id || r.PKCounter == nil || *r.PKCounter != counter {
+			continue
+		}
+		if (revoked == nil || *revoked == (r.RevokedAt != 0)) && (validUntil == 0 || r.ValidUntil < validUntil) {
+			count++
+		}
 	}
+	return count, nil
+}
+
+func (m *memRevStorage) InsertIssuanceRecord(record *IssuanceRecord) error {
+	defer m.wlock()()
+	key := memIssuanceKey{record.CredType, record.Key, record.Issued}
+	if m.issuanceRecords[key] != nil {
+		return errors.New("issuance record already exists")
+	}
+	r := *record
+	m.putIssuanceRecord(key, &r)
+	return nil
+}
 
-	logger.Trace("memdb: updating")
-	offset := ourEnd + 1 - theirStart
-	r.SignedAccumulator = update.SignedAccumulator
-	r.Events = append(r.Events, theirs[offset:]...)
+func (m *memRevStorage) SaveIssuanceRecord(record *IssuanceRecord) error {
+	defer m.wlock()()
+	r := *record
+	m.putIssuanceRecord(memIssuanceKey{record.CredType, record.Key, record.Issued}, &r)
+	return nil
+}
+
+func (m *memRevStorage) DeleteExpiredIssuanceRecords(t time.Time) error {
+	defer m.wlock()()
+	for k, r := range m.issuanceRecords {
+		if r.ValidUntil < t.UnixNano() {
+			m.putIssuanceRecord(k, nil)
+		}
+	}
+	return nil
 }
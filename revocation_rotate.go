@@ -0,0 +1,87 @@
+package irma
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-errors/errors"
+)
+
+type (
+	// RevocationRotation describes the result of moving revocation of a credential type to a new
+	// issuer key pair using RevocationStorage.Rotate().
+	RevocationRotation struct {
+		CredType  CredentialTypeIdentifier `json:"credtype"`
+		PKCounter uint                     `json:"pkcounter"`
+		// Created is true if the initial accumulator of the new key pair was created by Rotate(),
+		// and false if it already existed.
+		Created bool `json:"created"`
+		// Previous contains the older public key counters of the credential type for which
+		// revocation state exists.
+		Previous []*RevocationRotationCounter `json:"previous"`
+	}
+
+	// RevocationRotationCounter lists the issuance records of a previous public key counter
+	// that are still in use: they are neither revoked nor expired, so the corresponding
+	// credentials may still be revoked and the revocation state of this counter must be kept.
+	RevocationRotationCounter struct {
+		PKCounter       uint              `json:"pkcounter"`
+		IssuanceRecords []*IssuanceRecord `json:"issuance_records"`
+	}
+)
+
+// Rotate moves revocation of the specified credential type to the issuer key pair having the
+// specified counter, which must support revocation, by creating its initial accumulator if it
+// does not already exist. Requires the issuer private key. Afterwards, revocation state of older
+// key pairs is still used for credentials issued under them, so the issuance records of older
+// counters that are still in use are returned.
+func (rs *RevocationStorage) Rotate(id CredentialTypeIdentifier, counter uint) (*RevocationRotation, error) {
+	issid := id.IssuerIdentifier()
+	if _, err := rs.Keys.PublicKey(issid, counter); err != nil {
+		return nil, errors.WrapPrefix(err, fmt.Sprintf("public key %s-%d", issid, counter), 0)
+	}
+	if err := rs.Keys.PrivateKeyCounter(issid, counter); err != nil {
+		return nil, errors.WrapPrefix(err, fmt.Sprintf("private key %s-%d", issid, counter), 0)
+	}
+
+	rotation := &RevocationRotation{CredType: id, PKCounter: counter}
+	exists, err := rs.Exists(id, counter)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if err = rs.EnableRevocation(id, counter); err != nil {
+			return nil, err
+		}
+		rotation.Created = true
+	}
+
+	records, err := rs.db.Accumulators(id, nil)
+	if err != nil {
+		return nil, err
+	}
+	var counters []uint
+	for _, r := range records {
+		if *r.PKCounter < counter {
+			counters = append(counters, *r.PKCounter)
+		}
+	}
+	sort.Slice(counters, sorter(counters))
+
+	now := time.Now().UnixNano()
+	for _, c := range counters {
+		issrecords, err := rs.db.AllIssuanceRecords(id, c)
+		if err != nil {
+			return nil, err
+		}
+		prev := &RevocationRotationCounter{PKCounter: c, IssuanceRecords: []*IssuanceRecord{}}
+		for _, r := range issrecords {
+			if r.RevokedAt == 0 && r.ValidUntil > now {
+				prev.IssuanceRecords = append(prev.IssuanceRecords, r)
+			}
+		}
+		rotation.Previous = append(rotation.Previous, prev)
+	}
+	return rotation, nil
+}
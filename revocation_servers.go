@@ -0,0 +1,109 @@
+package irma
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/privacybydesign/gabi/revocation"
+)
+
+// revocationServerHealth keeps track of revocation servers to which requests recently failed,
+// so that other revocation servers of the same credential type are tried first. It is shared
+// by all RevocationClient instances, as the availability of a server does not depend on the
+// client talking to it. Servers marked as unavailable are periodically probed using probe(),
+// so that they are tried first again as soon as they have recovered.
+type revocationServerHealth struct {
+	sync.Mutex
+	failed map[string]revocationServerFailure // per server URL
+}
+
+type revocationServerFailure struct {
+	time     time.Time                // time of the last failed request
+	credtype CredentialTypeIdentifier // credential type of the last failed request, used when probing
+}
+
+var revocationServers = &revocationServerHealth{failed: map[string]revocationServerFailure{}}
+
+// order returns a copy of urls, ordered such that the servers that are believed to be up come
+// first, in their original order, followed by the servers to which a request failed during the
+// last RevocationParameters.ServerFailureInterval seconds, least recently failed first.
+func (h *revocationServerHealth) order(urls []string) []string {
+	h.Lock()
+	defer h.Unlock()
+
+	cutoff := time.Now().Add(-time.Duration(RevocationParameters.ServerFailureInterval) * time.Second)
+	down := func(url string) bool {
+		f, ok := h.failed[url]
+		return ok && f.time.After(cutoff)
+	}
+	ordered := make([]string, len(urls))
+	copy(ordered, urls)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		downi, downj := down(ordered[i]), down(ordered[j])
+		if downi != downj {
+			return downj
+		}
+		return downi && h.failed[ordered[i]].time.Before(h.failed[ordered[j]].time)
+	})
+	return ordered
+}
+
+// report updates the health of the server at the specified URL, given the result of a request
+// to it. Error responses returned by the server itself (other than 5xx) indicate that the server
+// is up, so they are not counted as failures.
+func (h *revocationServerHealth) report(url string, id CredentialTypeIdentifier, err error) {
+	if err != nil && revocationServerDown(err) {
+		h.failure(url, id)
+	} else {
+		h.success(url)
+	}
+}
+
+func (h *revocationServerHealth) success(url string) {
+	h.Lock()
+	defer h.Unlock()
+	delete(h.failed, url)
+}
+
+func (h *revocationServerHealth) failure(url string, id CredentialTypeIdentifier) {
+	h.Lock()
+	defer h.Unlock()
+	Logger.WithField("url", url).Debug("marking revocation server as unavailable")
+	h.failed[url] = revocationServerFailure{time: time.Now(), credtype: id}
+}
+
+// probe requests the latest accumulators from each server currently marked as unavailable,
+// for the credential type of the request that last failed, and updates its health accordingly.
+func (h *revocationServerHealth) probe() {
+	h.Lock()
+	failed := make(map[string]revocationServerFailure, len(h.failed))
+	for url, f := range h.failed {
+		failed[url] = f
+	}
+	h.Unlock()
+
+	for url, f := range failed {
+		// Like RevocationClient.getMultiple(), set the server directly, as NewHTTPTransport()
+		// would append a slash to it
+		transport := NewHTTPTransport("", false)
+		transport.Server = url
+		transport.Binary = true
+		var updates map[uint]*revocation.Update
+		err := transport.Get(
+			fmt.Sprintf("/revocation/%s/update/%d", f.credtype, RevocationParameters.UpdateMinCount),
+			&updates,
+		)
+		Logger.WithField("url", url).Trace("probed unavailable revocation server")
+		h.report(url, f.credtype, err)
+	}
+}
+
+func revocationServerDown(err error) bool {
+	serr, ok := err.(*SessionError)
+	if !ok {
+		return true
+	}
+	return serr.ErrorType != ErrorApi || serr.RemoteStatus >= 500
+}
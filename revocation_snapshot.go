@@ -0,0 +1,185 @@
+package irma
+
+import (
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/gabi/gabikeys"
+	"github.com/privacybydesign/gabi/revocation"
+	"github.com/privacybydesign/gabi/signed"
+)
+
+type (
+	// RevocationSnapshot contains the complete revocation state of a number of credential types,
+	// for backup purposes. It is created by RevocationStorage.ExportSnapshot() and can be restored
+	// into an empty revocation database with RevocationStorage.ImportSnapshot().
+	RevocationSnapshot struct {
+		Created Timestamp                                                        `json:"created"`
+		Records map[CredentialTypeIdentifier]map[uint]*RevocationSnapshotRecords `json:"records"`
+	}
+
+	// RevocationSnapshotRecords contains the revocation state of a credential type
+	// for a single public key counter.
+	RevocationSnapshotRecords struct {
+		// Update contains the signed accumulator and the entire event chain leading up to it,
+		// starting at the initial event. Since the accumulator signature covers the hash chain,
+		// this is verifiable using the issuer's revocation public key.
+		Update *revocation.Update `json:"update"`
+		// IssuanceRecords optionally contains all issuance records, signed using the ECDSA key
+		// of the issuer private key.
+		IssuanceRecords signed.Message `json:"issuancerecords,omitempty"`
+	}
+)
+
+// ExportSnapshot returns a snapshot of the accumulators and events of the specified credential
+// types for all public key counters, that is consistent in the sense that each accumulator
+// contains the hash of the last event of the accompanying event chain. If issuanceRecords is true,
+// the issuance records are also included, signed with the issuer private key (which is then required).
+func (rs *RevocationStorage) ExportSnapshot(ids []CredentialTypeIdentifier, issuanceRecords bool) (*RevocationSnapshot, error) {
+	snapshot := &RevocationSnapshot{
+		Created: Timestamp(time.Now()),
+		Records: map[CredentialTypeIdentifier]map[uint]*RevocationSnapshotRecords{},
+	}
+	err := rs.db.Transaction(func(tx RevocationDB) error {
+		for _, id := range ids {
+			records, err := tx.Accumulators(id, nil)
+			if err != nil {
+				return err
+			}
+			if len(records) == 0 {
+				return errors.Errorf("no revocation state found for %s", id)
+			}
+			snapshot.Records[id] = map[uint]*RevocationSnapshotRecords{}
+			for _, r := range records {
+				s, err := rs.exportSnapshotRecords(tx, id, r, issuanceRecords)
+				if err != nil {
+					return err
+				}
+				snapshot.Records[id][*r.PKCounter] = s
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+func (rs *RevocationStorage) exportSnapshotRecords(
+	tx RevocationDB, id CredentialTypeIdentifier, record *AccumulatorRecord, issuanceRecords bool,
+) (*RevocationSnapshotRecords, error) {
+	pk, err := rs.Keys.PublicKey(id.IssuerIdentifier(), *record.PKCounter)
+	if err != nil {
+		return nil, err
+	}
+	sacc := record.SignedAccumulator()
+	acc, err := sacc.UnmarshalVerify(pk)
+	if err != nil {
+		return nil, err
+	}
+
+	// Events are never modified after insertion, so by fetching only the events up to the index
+	// of the accumulator we get a consistent chain, even if revocations happen concurrently.
+	events, err := tx.Events(id, *record.PKCounter, 0, acc.Index+1)
+	if err != nil {
+		return nil, err
+	}
+	update := &revocation.Update{SignedAccumulator: sacc}
+	for _, e := range events {
+		update.Events = append(update.Events, e.Event())
+	}
+	if err = rs.verifyCompleteUpdate(pk, update); err != nil {
+		return nil, errors.WrapPrefix(err, "cannot export revocation state of "+id.String(), 0)
+	}
+	s := &RevocationSnapshotRecords{Update: update}
+
+	if !issuanceRecords {
+		return s, nil
+	}
+	issrecords, err := tx.AllIssuanceRecords(id, *record.PKCounter)
+	if err != nil {
+		return nil, err
+	}
+	s.IssuanceRecords, err = rs.conf.IssuerSigner().SignIssuanceRecords(id.IssuerIdentifier(), *record.PKCounter, issrecords)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ImportSnapshot imports the revocation state contained in the snapshot (c.f. ExportSnapshot())
+// into the database. The accumulators and event chains in the snapshot are verified in the same
+// way as revocation updates received from elsewhere, and the signature over the issuance records
+// (if present) is verified. The database must not yet contain revocation state for any of the
+// credential types and public key counters present in the snapshot.
+func (rs *RevocationStorage) ImportSnapshot(snapshot *RevocationSnapshot) error {
+	return rs.db.Transaction(func(tx RevocationDB) error {
+		for id, records := range snapshot.Records {
+			if rs.conf.CredentialTypes[id] == nil {
+				return ErrorUnknownCredentialType
+			}
+			for counter, r := range records {
+				if err := rs.importSnapshotRecords(tx, id, counter, r); err != nil {
+					return errors.WrapPrefix(err, "cannot import revocation state of "+id.String(), 0)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (rs *RevocationStorage) importSnapshotRecords(
+	tx RevocationDB, id CredentialTypeIdentifier, counter uint, r *RevocationSnapshotRecords,
+) error {
+	if r == nil || r.Update == nil || r.Update.SignedAccumulator == nil {
+		return errors.New("snapshot contains no accumulator")
+	}
+	if r.Update.SignedAccumulator.PKCounter != counter {
+		return errors.Errorf("snapshot contains accumulator of wrong key counter %d", r.Update.SignedAccumulator.PKCounter)
+	}
+	exists, err := rs.exists(tx, id, counter)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return errors.Errorf("revocation state already exists for key counter %d", counter)
+	}
+	pk, err := rs.Keys.PublicKey(id.IssuerIdentifier(), counter)
+	if err != nil {
+		return err
+	}
+	if err = rs.verifyCompleteUpdate(pk, r.Update); err != nil {
+		return err
+	}
+	if err = rs.addUpdate(tx, id, r.Update, true); err != nil {
+		return err
+	}
+
+	if len(r.IssuanceRecords) == 0 {
+		return nil
+	}
+	var issrecords []*IssuanceRecord
+	if err = signed.UnmarshalVerify(pk.ECDSA, r.IssuanceRecords, &issrecords); err != nil {
+		return errors.WrapPrefix(err, "invalid issuance records", 0)
+	}
+	for _, rec := range issrecords {
+		if rec.CredType != id || rec.PKCounter == nil || *rec.PKCounter != counter {
+			return errors.New("snapshot contains issuance record of wrong credential type or key counter")
+		}
+		if err = tx.InsertIssuanceRecord(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyCompleteUpdate checks that the update is validly signed and contains the entire
+// event chain, starting at the initial event.
+func (*RevocationStorage) verifyCompleteUpdate(pk *gabikeys.PublicKey, update *revocation.Update) error {
+	if len(update.Events) == 0 || update.Events[0].Index != 0 {
+		return errors.New("event chain incomplete")
+	}
+	_, err := update.Verify(pk)
+	return err
+}
@@ -0,0 +1,121 @@
+package irma
+
+import (
+	"sort"
+	"time"
+)
+
+// RevocationStats summarizes the revocation state of a credential type and public key counter,
+// as computed by RevocationStorage.Stats().
+type RevocationStats struct {
+	CredType  CredentialTypeIdentifier `json:"credtype"`
+	PKCounter uint                     `json:"pkcounter"`
+
+	// AccumulatorIndex is the index of the current accumulator, and Updated the time at which
+	// it was last updated (i.e., signed). Both are absent if no accumulator is stored.
+	AccumulatorIndex *uint64    `json:"accumulator_index,omitempty"`
+	Updated          *time.Time `json:"updated,omitempty"`
+	Events           uint64     `json:"events"`
+
+	ActiveIssuanceRecords  uint64 `json:"active_issuance_records"`
+	RevokedIssuanceRecords uint64 `json:"revoked_issuance_records"`
+	// Expiring contains, for each of the upcoming runs of the job that deletes expired issuance
+	// records (which runs every RevocationParameters.DeleteIssuanceRecordsInterval minutes), the
+	// number of issuance records that will be deleted by it. Records that have already expired
+	// are counted in the first run.
+	Expiring []uint64 `json:"expiring"`
+}
+
+// Stats computes statistics of the revocation database for each public key counter of the
+// specified credential types, or of all credential types that support revocation if none are
+// specified. The cycles parameter specifies for how many runs of the job deleting expired
+// issuance records the number of records it will delete is counted.
+func (rs *RevocationStorage) Stats(cycles uint, ids ...CredentialTypeIdentifier) ([]*RevocationStats, error) {
+	if len(ids) == 0 {
+		for id, credtype := range rs.conf.CredentialTypes {
+			if credtype.RevocationSupported() {
+				ids = append(ids, id)
+			}
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+	}
+
+	var stats []*RevocationStats
+	for _, id := range ids {
+		s, err := rs.stats(id, cycles)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, s...)
+	}
+	return stats, nil
+}
+
+func (rs *RevocationStorage) stats(id CredentialTypeIdentifier, cycles uint) ([]*RevocationStats, error) {
+	records, err := rs.db.Accumulators(id, nil)
+	if err != nil {
+		return nil, err
+	}
+	counters, err := rs.db.EventCounters(id)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := map[uint]*RevocationStats{}
+	for _, counter := range counters {
+		stats[counter] = &RevocationStats{CredType: id, PKCounter: counter}
+	}
+	for _, r := range records {
+		s := &RevocationStats{CredType: id, PKCounter: *r.PKCounter}
+		stats[*r.PKCounter] = s
+		pk, err := rs.Keys.PublicKey(id.IssuerIdentifier(), *r.PKCounter)
+		if err != nil {
+			return nil, err
+		}
+		acc, err := r.SignedAccumulator().UnmarshalVerify(pk)
+		if err != nil {
+			return nil, err
+		}
+		updated := time.Unix(acc.Time, 0)
+		s.AccumulatorIndex, s.Updated = &acc.Index, &updated
+	}
+
+	keys := make([]uint, 0, len(stats))
+	for counter := range stats {
+		keys = append(keys, counter)
+	}
+	sort.Slice(keys, sorter(keys))
+
+	interval := time.Duration(RevocationParameters.DeleteIssuanceRecordsInterval) * time.Minute
+	now := time.Now()
+	result := make([]*RevocationStats, 0, len(keys))
+	for _, counter := range keys {
+		s := stats[counter]
+		if s.Events, err = rs.db.EventCount(id, counter); err != nil {
+			return nil, err
+		}
+		active, revoked := false, true
+		if s.ActiveIssuanceRecords, err = rs.db.IssuanceRecordCount(id, counter, &active, 0); err != nil {
+			return nil, err
+		}
+		if s.RevokedIssuanceRecords, err = rs.db.IssuanceRecordCount(id, counter, &revoked, 0); err != nil {
+			return nil, err
+		}
+		// Count the records expiring before the end of each run, and subtract the records expiring
+		// before the previous run (guarding against records being deleted in the meantime)
+		s.Expiring = make([]uint64, cycles)
+		var previous uint64
+		for i := uint(0); i < cycles; i++ {
+			count, err := rs.db.IssuanceRecordCount(id, counter, nil, now.Add(time.Duration(i+1)*interval).UnixNano())
+			if err != nil {
+				return nil, err
+			}
+			if count > previous {
+				s.Expiring[i] = count - previous
+				previous = count
+			}
+		}
+		result = append(result, s)
+	}
+	return result, nil
+}
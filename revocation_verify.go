@@ -0,0 +1,198 @@
+package irma
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/privacybydesign/gabi/revocation"
+)
+
+type (
+	// RevocationDBProblem describes an inconsistency in the revocation database,
+	// as found by RevocationStorage.VerifyDB().
+	RevocationDBProblem struct {
+		Type      RevocationDBProblemType  `json:"type"`
+		CredType  CredentialTypeIdentifier `json:"credtype"`
+		PKCounter uint                     `json:"pkcounter"`
+		Index     *uint64                  `json:"index,omitempty"` // event index, if applicable
+		Message   string                   `json:"message"`
+	}
+
+	RevocationDBProblemType string
+)
+
+const (
+	// RevocationDBProblemGap indicates that one or more events are missing from the hash chain.
+	RevocationDBProblemGap = RevocationDBProblemType("gap")
+	// RevocationDBProblemFork indicates that an event or accumulator does not refer to the hash
+	// of its predecessor in the chain.
+	RevocationDBProblemFork = RevocationDBProblemType("fork")
+	// RevocationDBProblemCorrupt indicates a row that could not be parsed or verified.
+	RevocationDBProblemCorrupt = RevocationDBProblemType("corrupt")
+)
+
+// VerifyDB checks the integrity of the revocation database for the specified credential types,
+// or for all credential types that support revocation if none are specified. For each credential
+// type and public key counter, it verifies the signature of the accumulator against the revocation
+// public key from the scheme, and walks through all events checking that the hash chain starting
+// at the initial event (having index 0) is complete and unbroken, and that the accumulator refers
+// to the last event in the chain.
+// All problems that are found are returned; an error is returned only if the database could
+// not be read.
+func (rs *RevocationStorage) VerifyDB(ids ...CredentialTypeIdentifier) ([]*RevocationDBProblem, error) {
+	if len(ids) == 0 {
+		for id, credtype := range rs.conf.CredentialTypes {
+			if credtype.RevocationSupported() {
+				ids = append(ids, id)
+			}
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+	}
+
+	var problems []*RevocationDBProblem
+	for _, id := range ids {
+		p, err := rs.verifyDB(id)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, p...)
+	}
+	return problems, nil
+}
+
+func (rs *RevocationStorage) verifyDB(id CredentialTypeIdentifier) ([]*RevocationDBProblem, error) {
+	records, err := rs.db.Accumulators(id, nil)
+	if err != nil {
+		return nil, err
+	}
+	counters, err := rs.db.EventCounters(id)
+	if err != nil {
+		return nil, err
+	}
+
+	accs := map[uint]*AccumulatorRecord{}
+	for _, r := range records {
+		accs[*r.PKCounter] = r
+	}
+	for _, counter := range counters {
+		if _, ok := accs[counter]; !ok {
+			accs[counter] = nil
+		}
+	}
+	keys := make([]uint, 0, len(accs))
+	for counter := range accs {
+		keys = append(keys, counter)
+	}
+	sort.Slice(keys, sorter(keys))
+
+	var problems []*RevocationDBProblem
+	for _, counter := range keys {
+		p, err := rs.verifyDBChain(id, counter, accs[counter])
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, p...)
+	}
+	return problems, nil
+}
+
+// verifyDBChain verifies the accumulator record (which may be nil) and the event chain
+// of the given credential type and key counter.
+func (rs *RevocationStorage) verifyDBChain(
+	id CredentialTypeIdentifier, counter uint, record *AccumulatorRecord,
+) ([]*RevocationDBProblem, error) {
+	var problems []*RevocationDBProblem
+	report := func(typ RevocationDBProblemType, index *uint64, format string, args ...interface{}) {
+		problems = append(problems, &RevocationDBProblem{
+			Type:      typ,
+			CredType:  id,
+			PKCounter: counter,
+			Index:     index,
+			Message:   fmt.Sprintf(format, args...),
+		})
+	}
+
+	// Verify the accumulator against the public key from the scheme
+	var acc *revocation.Accumulator
+	if record == nil {
+		report(RevocationDBProblemCorrupt, nil, "events found but no accumulator")
+	} else if pk, err := rs.Keys.PublicKey(id.IssuerIdentifier(), counter); err != nil {
+		report(RevocationDBProblemCorrupt, nil, "accumulator found but no usable public key: %s", err.Error())
+	} else if acc, err = record.SignedAccumulator().UnmarshalVerify(pk); err != nil {
+		report(RevocationDBProblemCorrupt, nil, "invalid accumulator: %s", err.Error())
+	}
+
+	// Walk through the events in batches, checking the hash chain
+	latest, err := rs.db.LatestEvents(id, counter, 1)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		prev     *revocation.Event
+		expected uint64
+		last     EventRecord
+		exists   = len(latest) > 0
+		batch    = RevocationParameters.UpdateMaxCount
+	)
+	if exists {
+		last = *latest[0]
+	}
+	for from := uint64(0); exists && from <= *last.Index; from += batch {
+		events, err := rs.db.Events(id, counter, from, from+batch)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range events {
+			index := *r.Index
+			if r.E == nil {
+				report(RevocationDBProblemCorrupt, &index, "event has no revocation attribute")
+				prev, expected = nil, index+1
+				continue
+			}
+			event := r.Event()
+			if _, err = event.ParentHash.Algorithm(); err != nil {
+				report(RevocationDBProblemCorrupt, &index, "event has invalid parent hash: %s", err.Error())
+				prev, expected = nil, index+1
+				continue
+			}
+			if index > expected {
+				missing := expected
+				report(RevocationDBProblemGap, &missing, "events %d to %d missing", expected, index-1)
+			} else if prev != nil {
+				if err = revocation.NewEventList(prev).Verify(&revocation.Accumulator{EventHash: event.ParentHash}); err != nil {
+					report(RevocationDBProblemFork, &index, "parent hash of event does not match event %d", prev.Index)
+				}
+			}
+			prev, expected = event, index+1
+		}
+	}
+
+	// Check that the accumulator is at the head of the chain
+	if acc == nil {
+		return problems, nil
+	}
+	switch {
+	case !exists:
+		report(RevocationDBProblemGap, nil, "accumulator found but no events")
+	case acc.Index > *last.Index:
+		missing := *last.Index + 1
+		report(RevocationDBProblemGap, &missing, "events %d to %d missing", missing, acc.Index)
+	case acc.Index < *last.Index:
+		report(RevocationDBProblemCorrupt, last.Index, "events found beyond accumulator index %d", acc.Index)
+	case prev == nil:
+		// last event was corrupt, already reported above
+	default:
+		if err = revocation.NewEventList(prev).Verify(acc); err != nil {
+			report(RevocationDBProblemFork, &acc.Index, "accumulator does not refer to last event")
+		}
+	}
+	return problems, nil
+}
+
+func (p *RevocationDBProblem) String() string {
+	s := fmt.Sprintf("%s-%d", p.CredType, p.PKCounter)
+	if p.Index != nil {
+		s += fmt.Sprintf(" (event %d)", *p.Index)
+	}
+	return fmt.Sprintf("%s: %s: %s", s, p.Type, p.Message)
+}
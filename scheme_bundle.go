@@ -0,0 +1,363 @@
+package irma
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/irmago/internal/common"
+	"github.com/sirupsen/logrus"
+)
+
+// Scheme bundles are gzipped tar archives containing a single scheme directory, consisting of
+// the scheme's index, its signatures and public keys, and all files listed in the index. They allow
+// schemes to be installed and updated without access to the scheme's URL, for example in air-gapped
+// deployments. When installing or updating a scheme from a bundle, the same signature checks
+// are performed as when downloading the scheme from its URL.
+
+var (
+	// Files included in bundles besides those listed in the index
+	bundleExtraFiles = []*regexp.Regexp{
+		regexp.MustCompile(`^index$`),
+		schemeSignatureFilePattern,
+		regexp.MustCompile(`^pk\.pem$`),
+		regexp.MustCompile(`^transparency\.log$`),
+		// logos of requestor schemes, which are authenticated by their filename
+		regexp.MustCompile(`^assets/[0-9a-f]+\.png$`),
+	}
+	// Files included in bundles of demo schemes, which are otherwise downloaded by UpdateScheme()
+	bundleDemoFiles = regexp.MustCompile(`^[^/]+/PrivateKeys/\d+\.xml$`)
+)
+
+// WriteSchemeBundle writes a bundle of the (validly signed) scheme in the specified directory to w.
+func WriteSchemeBundle(dir string, w io.Writer) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	conf, err := NewConfiguration(filepath.Dir(dir), ConfigurationOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	scheme, err := conf.ParseSchemeFolder(dir)
+	if err != nil {
+		return err
+	}
+	demo := false
+	if sm, ok := scheme.(*SchemeManager); ok {
+		demo = sm.Demo
+	}
+
+	var files []string
+	index := scheme.idx()
+	err = common.WalkDir(dir, func(path string, info os.FileInfo) error {
+		if info.IsDir() {
+			return nil
+		}
+		relpath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		relpath = filepath.ToSlash(relpath)
+		if _, ok := index[scheme.id()+"/"+relpath]; ok || (demo && bundleDemoFiles.MatchString(relpath)) {
+			files = append(files, relpath)
+			return nil
+		}
+		for _, r := range bundleExtraFiles {
+			if r.MatchString(relpath) {
+				files = append(files, relpath)
+				return nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	modtime := time.Time(scheme.timestamp())
+	for _, file := range files {
+		bts, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(file)))
+		if err != nil {
+			return err
+		}
+		// Check that the file was not modified since the scheme was parsed
+		if hash, ok := index[scheme.id()+"/"+file]; ok {
+			if _, err = conf.readHashedFile(filepath.Join(dir, filepath.FromSlash(file)), hash); err != nil {
+				return err
+			}
+		}
+		err = tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     scheme.id() + "/" + file,
+			Mode:     0644,
+			Size:     int64(len(bts)),
+			ModTime:  modtime,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err = tw.Write(bts); err != nil {
+			return err
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// SchemeBundleID returns the identifier of the scheme contained in the specified bundle,
+// without verifying the bundle.
+func SchemeBundleID(bundle io.Reader) (string, error) {
+	gz, err := gzip.NewReader(bundle)
+	if err != nil {
+		return "", err
+	}
+	header, err := tar.NewReader(gz).Next()
+	if err == io.EOF {
+		return "", errors.New("bundle is empty")
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.SplitN(filepath.ToSlash(filepath.Clean(header.Name)), "/", 2)[0], nil
+}
+
+// InstallSchemeBundle adds the scheme contained in the specified bundle to this Configuration,
+// provided its signature is valid against the specified key.
+func (conf *Configuration) InstallSchemeBundle(bundle io.Reader, publickey []byte) error {
+	if len(publickey) == 0 {
+		return errors.New("no public key specified")
+	}
+	return conf.installSchemeBundle(bundle, publickey)
+}
+
+// DangerousTOFUInstallSchemeBundle adds the scheme contained in the specified bundle to this
+// Configuration, trusting the public key contained in the bundle.
+func (conf *Configuration) DangerousTOFUInstallSchemeBundle(bundle io.Reader) error {
+	return conf.installSchemeBundle(bundle, nil)
+}
+
+// UpdateSchemeBundle updates the scheme contained in the specified bundle, which must already be
+// present in this Configuration, to the version in the bundle. As in UpdateScheme(), the bundle
+// must be signed with the public key of the installed scheme, the scheme is only updated if the
+// bundle contains a newer version, and the scheme on disk and in this Configuration is left
+// untouched if any error occurs. It stores the identifiers of new or updated entities in the
+// second parameter. Subscribers registered with SubscribeSchemeUpdates() are notified as in
+// UpdateScheme().
+func (conf *Configuration) UpdateSchemeBundle(bundle io.Reader, downloaded *IrmaIdentifierSet) (err error) {
+	if conf.readOnly {
+		return errors.New("cannot update a read-only configuration")
+	}
+	dir, newschemepath, err := conf.extractSchemeBundle(bundle)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	id := filepath.Base(newschemepath)
+	var scheme Scheme
+	if s, ok := conf.SchemeManagers[NewSchemeManagerIdentifier(id)]; ok {
+		scheme = s
+	} else if s, ok := conf.RequestorSchemes[NewRequestorSchemeIdentifier(id)]; ok {
+		scheme = s
+	} else {
+		return errors.Errorf("cannot update unknown scheme %s", id)
+	}
+	schemepath := scheme.path()
+	var update *SchemeUpdate
+	defer func() {
+		conf.finishSchemeUpdate(scheme, update, err)
+	}()
+
+	// Verify the scheme in the bundle against our public key of the scheme
+	pkbts, err := ioutil.ReadFile(filepath.Join(schemepath, "pk.pem"))
+	if err != nil {
+		return err
+	}
+	newscheme, newconf, err := parseSchemeBundle(dir, newschemepath, pkbts)
+	if err != nil {
+		return err
+	}
+	if newscheme.typ() != scheme.typ() {
+		return errors.Errorf("bundle contains %s scheme, but %s is a %s scheme", newscheme.typ(), id, scheme.typ())
+	}
+	indexbts, err := ioutil.ReadFile(filepath.Join(newschemepath, "index"))
+	if err != nil {
+		return err
+	}
+	logbts, err := ioutil.ReadFile(filepath.Join(newschemepath, SchemeLogFilename))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err = verifySchemeLog(schemepath, logbts, indexbts); err != nil {
+		return err
+	}
+	fields := logrus.Fields{"scheme": id, "type": string(scheme.typ())}
+	if !time.Time(newscheme.timestamp()).After(time.Time(scheme.timestamp())) {
+		Logger.WithFields(fields).Info("local scheme is not older than bundle, not updating")
+		return nil
+	}
+	Logger.WithFields(fields).Info("scheme is outdated, updating from bundle")
+
+	changed := newIrmaIdentifierSet()
+	if err = conf.changedSchemeFiles(scheme, newscheme, newschemepath, changed); err != nil {
+		return err
+	}
+	if update, err = conf.schemeUpdate(scheme, newscheme, newconf, changed); err != nil {
+		return err
+	}
+
+	// Replace old scheme on disk with the new one from the temp dir, and parse it
+	if err = conf.updateSchemeDir(scheme, schemepath, newschemepath); err != nil {
+		return err
+	}
+	scheme.purge(conf)
+	if _, err = conf.ParseSchemeFolder(schemepath); err != nil {
+		return err
+	}
+	if downloaded != nil {
+		downloaded.join(changed)
+	}
+	return nil
+}
+
+func (conf *Configuration) installSchemeBundle(bundle io.Reader, publickey []byte) error {
+	if conf.readOnly {
+		return errors.New("cannot install scheme into a read-only configuration")
+	}
+	dir, newschemepath, err := conf.extractSchemeBundle(bundle)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	scheme, _, err := parseSchemeBundle(dir, newschemepath, publickey)
+	if err != nil {
+		return err
+	}
+	id := scheme.id()
+	if scheme.present(id, conf) {
+		return errors.New("cannot install an already existing scheme")
+	}
+	path := filepath.Join(conf.Path, id)
+	if err = common.AssertPathNotExists(path); err != nil {
+		return errors.New("cannot install scheme: directory already exists")
+	}
+
+	// The temp dir is in conf.Path, so this does not cross devices
+	if err = os.Rename(newschemepath, path); err != nil {
+		return err
+	}
+	_, err = conf.ParseSchemeFolder(path)
+	return err
+}
+
+// extractSchemeBundle extracts the specified bundle into a new temporary directory within the
+// configuration directory, returning the temporary directory and the scheme directory within it.
+func (conf *Configuration) extractSchemeBundle(bundle io.Reader) (string, string, error) {
+	if err := common.EnsureDirectoryExists(conf.Path); err != nil {
+		return "", "", err
+	}
+	dir, err := ioutil.TempDir(conf.Path, "tempscheme")
+	if err != nil {
+		return "", "", err
+	}
+	schemepath, err := extractTarGz(bundle, dir)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return "", "", errors.WrapPrefix(err, "invalid scheme bundle", 0)
+	}
+	return dir, schemepath, nil
+}
+
+func extractTarGz(r io.Reader, dir string) (string, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return "", err
+	}
+	tr := tar.NewReader(gz)
+	var id string
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if header.Typeflag == tar.TypeDir {
+			continue
+		}
+		if header.Typeflag != tar.TypeReg {
+			return "", errors.Errorf("unexpected file type of %s", header.Name)
+		}
+
+		// All files must be in the same scheme directory, and not escape it
+		name := filepath.ToSlash(filepath.Clean(filepath.FromSlash(header.Name)))
+		parts := strings.SplitN(name, "/", 2)
+		if len(parts) != 2 || parts[0] == ".." || parts[0] == "." || strings.HasPrefix(parts[1], "../") ||
+			filepath.IsAbs(header.Name) || strings.HasPrefix(name, "/") {
+			return "", errors.Errorf("invalid file path %s", header.Name)
+		}
+		if id == "" {
+			id = parts[0]
+		} else if id != parts[0] {
+			return "", errors.New("bundle must contain a single scheme")
+		}
+
+		dest := filepath.Join(dir, filepath.FromSlash(name))
+		if err = common.EnsureDirectoryExists(filepath.Dir(dest)); err != nil {
+			return "", err
+		}
+		bts, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return "", err
+		}
+		if err = common.SaveFile(dest, bts); err != nil {
+			return "", err
+		}
+	}
+	if id == "" {
+		return "", errors.New("bundle is empty")
+	}
+	return filepath.Join(dir, id), nil
+}
+
+// parseSchemeBundle verifies and parses the extracted scheme bundle in a new Configuration,
+// using the specified public key if present, and otherwise the one in the bundle.
+func parseSchemeBundle(dir, schemepath string, publickey []byte) (Scheme, *Configuration, error) {
+	if publickey != nil {
+		if err := common.SaveFile(filepath.Join(schemepath, "pk.pem"), publickey); err != nil {
+			return nil, nil, err
+		}
+	}
+	newconf, err := NewConfiguration(dir, ConfigurationOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	scheme, err := newconf.ParseSchemeFolder(schemepath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if scheme.id() != filepath.Base(schemepath) {
+		return nil, nil, errors.Errorf("scheme has id %s but its directory is %s", scheme.id(), filepath.Base(schemepath))
+	}
+	return scheme, newconf, nil
+}
@@ -0,0 +1,357 @@
+package irma
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+type (
+	// CatalogQuery specifies a search through the issuers, credential types and attribute types
+	// of all schemes in a Configuration, using SearchCatalog().
+	CatalogQuery struct {
+		// Text contains the words to search for. Each word must occur, case-insensitively and as
+		// the prefix of a word, in the name, description, category or identifier of an item, or in
+		// the name of its issuer or credential type. If empty, all items matching the other
+		// criteria are returned.
+		Text string
+		// Languages restricts matching of translated strings to these languages (default: all).
+		Languages []string
+		// Types restricts the results to these types of items (default: all).
+		Types []CatalogItemType
+		// Schemes and Issuers restrict the results to items within these schemes and issuers.
+		Schemes []SchemeManagerIdentifier
+		Issuers []IssuerIdentifier
+		// Category restricts the results to credential types, and their attribute types, whose
+		// category equals this case-insensitively in one of the Languages.
+		Category string
+		// IncludeDeprecated includes deprecated items, or items whose issuer or credential type
+		// is deprecated, in the results.
+		IncludeDeprecated bool
+		// Limit is the maximum number of results (default: unlimited).
+		Limit int
+	}
+
+	// CatalogItemType is the type of an item in the results of SearchCatalog().
+	CatalogItemType string
+
+	// CatalogResult is an issuer, credential type or attribute type matching a CatalogQuery.
+	CatalogResult struct {
+		Type           CatalogItemType          `json:"type"`
+		Identifier     string                   `json:"identifier"`
+		Issuer         IssuerIdentifier         `json:"issuer"`
+		CredentialType CredentialTypeIdentifier `json:"credentialType"`
+		Name           TranslatedString         `json:"name"`
+		Description    TranslatedString         `json:"description,omitempty"`
+		Category       TranslatedString         `json:"category,omitempty"`
+		Deprecated     bool                     `json:"deprecated,omitempty"`
+		// Score ranks the results by relevance: matches in the name of an item weigh more than
+		// matches in its description.
+		Score int `json:"score"`
+		// Matches lists the fields in which the words of the query occur, with the language
+		// appended for translated fields, e.g. "Name.en".
+		Matches []string `json:"matches,omitempty"`
+	}
+
+	// catalogIndex is an inverted index over the words of the searchable fields of all items.
+	catalogIndex struct {
+		items    []*catalogItem
+		words    []string // sorted, for prefix lookups
+		postings map[string][]catalogPosting
+	}
+
+	catalogItem struct {
+		result   CatalogResult
+		scheme   SchemeManagerIdentifier
+		issuer   *Issuer         // the item or its issuer, if any, to check for deprecation
+		credtype *CredentialType // likewise, the item or its credential type, if any
+		fields   []catalogField
+	}
+
+	catalogField struct {
+		name   string
+		lang   string
+		weight int
+	}
+
+	catalogPosting struct {
+		item, field int
+	}
+)
+
+const (
+	CatalogItemIssuer         CatalogItemType = "issuer"
+	CatalogItemCredentialType CatalogItemType = "credentialtype"
+	CatalogItemAttributeType  CatalogItemType = "attributetype"
+)
+
+// catalogTypeOrder orders results of equal score.
+var catalogTypeOrder = map[CatalogItemType]int{
+	CatalogItemIssuer:         0,
+	CatalogItemCredentialType: 1,
+	CatalogItemAttributeType:  2,
+}
+
+// SearchCatalog returns the issuers, credential types and attribute types matching the query,
+// most relevant first. The search index is built on first use, and rebuilt after the schemes
+// of the configuration are (re)parsed.
+func (conf *Configuration) SearchCatalog(query CatalogQuery) []*CatalogResult {
+	index := conf.catalogIndex()
+
+	// For each item, collect the fields in which the words of the query occur, and sum over the
+	// words the best score of the fields in which each word occurs
+	words := catalogWords(query.Text)
+	matches := make([]map[int]struct{}, len(index.items))
+	scores := make([]int, len(index.items))
+	for i := range index.items {
+		matches[i] = map[int]struct{}{}
+	}
+	for _, word := range words {
+		best := map[int]int{}
+		for i := sort.SearchStrings(index.words, word); i < len(index.words) && strings.HasPrefix(index.words[i], word); i++ {
+			for _, p := range index.postings[index.words[i]] {
+				field := index.items[p.item].fields[p.field]
+				if field.lang != "" && !catalogContains(query.Languages, field.lang) {
+					continue
+				}
+				score := field.weight
+				if index.words[i] == word {
+					score++
+				}
+				if score > best[p.item] {
+					best[p.item] = score
+				}
+				matches[p.item][p.field] = struct{}{}
+			}
+		}
+		for item := range index.items {
+			if s, ok := best[item]; ok && scores[item] >= 0 {
+				scores[item] += s
+			} else {
+				scores[item] = -1 // this word does not occur in the item
+			}
+		}
+	}
+
+	var results []*CatalogResult
+	for i, item := range index.items {
+		if scores[i] < 0 || !query.matches(item) {
+			continue
+		}
+		result := item.result
+		result.Score = scores[i]
+		result.Deprecated = (item.issuer != nil && item.issuer.IsDeprecated()) ||
+			(item.credtype != nil && item.credtype.IsDeprecated())
+		if result.Deprecated && !query.IncludeDeprecated {
+			continue
+		}
+		for f := range matches[i] {
+			field := item.fields[f]
+			if field.lang != "" {
+				result.Matches = append(result.Matches, field.name+"."+field.lang)
+			} else {
+				result.Matches = append(result.Matches, field.name)
+			}
+		}
+		sort.Strings(result.Matches)
+		results = append(results, &result)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Type != b.Type {
+			return catalogTypeOrder[a.Type] < catalogTypeOrder[b.Type]
+		}
+		return a.Identifier < b.Identifier
+	})
+	if query.Limit > 0 && len(results) > query.Limit {
+		results = results[:query.Limit]
+	}
+	return results
+}
+
+// matches checks the criteria of the query other than its text.
+func (query *CatalogQuery) matches(item *catalogItem) bool {
+	if len(query.Types) > 0 {
+		found := false
+		for _, typ := range query.Types {
+			found = found || typ == item.result.Type
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(query.Schemes) > 0 {
+		found := false
+		for _, scheme := range query.Schemes {
+			found = found || scheme == item.scheme
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(query.Issuers) > 0 {
+		found := false
+		for _, issuer := range query.Issuers {
+			found = found || issuer == item.result.Issuer
+		}
+		if !found {
+			return false
+		}
+	}
+	if query.Category != "" {
+		found := false
+		for lang, category := range item.result.Category {
+			if catalogContains(query.Languages, lang) && strings.EqualFold(strings.TrimSpace(category), strings.TrimSpace(query.Category)) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (conf *Configuration) catalogIndex() *catalogIndex {
+	conf.catalogLock.Lock()
+	defer conf.catalogLock.Unlock()
+	if conf.catalog == nil {
+		conf.catalog = newCatalogIndex(conf)
+	}
+	return conf.catalog
+}
+
+// invalidateCatalog discards the search index, so that it is rebuilt on the next search.
+func (conf *Configuration) invalidateCatalog() {
+	conf.catalogLock.Lock()
+	defer conf.catalogLock.Unlock()
+	conf.catalog = nil
+}
+
+func newCatalogIndex(conf *Configuration) *catalogIndex {
+	index := &catalogIndex{postings: map[string][]catalogPosting{}}
+
+	for id, issuer := range conf.Issuers {
+		item := &catalogItem{
+			result: CatalogResult{
+				Type:       CatalogItemIssuer,
+				Identifier: id.String(),
+				Issuer:     id,
+				Name:       issuer.Name,
+			},
+			scheme: id.SchemeManagerIdentifier(),
+			issuer: issuer,
+		}
+		index.add(item, "ID", "", 3, id.String())
+		index.addTranslated(item, "Name", 4, issuer.Name)
+	}
+
+	for id, cred := range conf.CredentialTypes {
+		issuer := conf.Issuers[id.IssuerIdentifier()]
+		item := &catalogItem{
+			result: CatalogResult{
+				Type:           CatalogItemCredentialType,
+				Identifier:     id.String(),
+				Issuer:         id.IssuerIdentifier(),
+				CredentialType: id,
+				Name:           cred.Name,
+				Description:    cred.Description,
+				Category:       cred.Category,
+			},
+			scheme:   id.SchemeManagerIdentifier(),
+			issuer:   issuer,
+			credtype: cred,
+		}
+		index.add(item, "ID", "", 3, id.String())
+		index.addTranslated(item, "Name", 4, cred.Name)
+		index.addTranslated(item, "Category", 2, cred.Category)
+		index.addTranslated(item, "Description", 1, cred.Description)
+		if issuer != nil {
+			index.addTranslated(item, "Issuer", 2, issuer.Name)
+		}
+	}
+
+	for id, attr := range conf.AttributeTypes {
+		credid := id.CredentialTypeIdentifier()
+		cred := conf.CredentialTypes[credid]
+		issuer := conf.Issuers[credid.IssuerIdentifier()]
+		item := &catalogItem{
+			result: CatalogResult{
+				Type:           CatalogItemAttributeType,
+				Identifier:     id.String(),
+				Issuer:         credid.IssuerIdentifier(),
+				CredentialType: credid,
+				Name:           attr.Name,
+				Description:    attr.Description,
+			},
+			scheme:   credid.SchemeManagerIdentifier(),
+			issuer:   issuer,
+			credtype: cred,
+		}
+		index.add(item, "ID", "", 3, id.String())
+		index.addTranslated(item, "Name", 4, attr.Name)
+		index.addTranslated(item, "Description", 1, attr.Description)
+		if cred != nil {
+			item.result.Category = cred.Category
+			index.addTranslated(item, "CredentialType", 2, cred.Name)
+			index.addTranslated(item, "Category", 1, cred.Category)
+		}
+		if issuer != nil {
+			index.addTranslated(item, "Issuer", 1, issuer.Name)
+		}
+	}
+
+	for word := range index.postings {
+		index.words = append(index.words, word)
+	}
+	sort.Strings(index.words)
+	return index
+}
+
+func (index *catalogIndex) addTranslated(item *catalogItem, name string, weight int, ts TranslatedString) {
+	for lang, text := range ts {
+		index.add(item, name, lang, weight, text)
+	}
+}
+
+// add adds the words of the text to the index as a field of the item, adding the item to
+// the index if it was not already.
+func (index *catalogIndex) add(item *catalogItem, name, lang string, weight int, text string) {
+	if len(index.items) == 0 || index.items[len(index.items)-1] != item {
+		index.items = append(index.items, item)
+	}
+	p := catalogPosting{item: len(index.items) - 1, field: len(item.fields)}
+	item.fields = append(item.fields, catalogField{name: name, lang: lang, weight: weight})
+	seen := map[string]struct{}{}
+	for _, word := range catalogWords(text) {
+		if _, ok := seen[word]; ok {
+			continue
+		}
+		seen[word] = struct{}{}
+		index.postings[word] = append(index.postings[word], p)
+	}
+}
+
+// catalogWords splits the text into lowercase words consisting of letters and digits.
+func catalogWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// catalogContains returns whether lang is among langs, or langs is empty.
+func catalogContains(langs []string, lang string) bool {
+	if len(langs) == 0 {
+		return true
+	}
+	for _, l := range langs {
+		if l == lang {
+			return true
+		}
+	}
+	return false
+}
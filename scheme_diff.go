@@ -0,0 +1,275 @@
+package irma
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-errors/errors"
+)
+
+type (
+	// SchemeDiff describes the differences in content between two versions of an issuer scheme,
+	// as computed by DiffSchemes().
+	SchemeDiff struct {
+		Scheme  SchemeManagerIdentifier `json:"scheme"`
+		Changes []*SchemeChange         `json:"changes"`
+	}
+
+	// SchemeChange describes a single difference between two versions of a scheme.
+	SchemeChange struct {
+		Type SchemeChangeType `json:"type"`
+		// Subject is the identifier of the scheme, issuer, credential type, attribute type
+		// or public key (as issuer-counter) that changed.
+		Subject string `json:"subject"`
+		// Field is the changed field of the subject, if applicable; for translations, the
+		// language is appended, e.g. "Name.en".
+		Field string `json:"field,omitempty"`
+		Old   string `json:"old,omitempty"`
+		New   string `json:"new,omitempty"`
+		// Breaking is true if the change breaks existing credentials of the credential type:
+		// removing attributes or changing their order changes the indices of the attributes
+		// in the AttributeList of credentials that were already issued.
+		Breaking bool `json:"breaking,omitempty"`
+	}
+
+	SchemeChangeType string
+)
+
+const (
+	SchemeChangeIssuerAdded              = SchemeChangeType("issuer-added")
+	SchemeChangeIssuerRemoved            = SchemeChangeType("issuer-removed")
+	SchemeChangeIssuerDeprecated         = SchemeChangeType("issuer-deprecated")
+	SchemeChangeCredentialTypeAdded      = SchemeChangeType("credentialtype-added")
+	SchemeChangeCredentialTypeRemoved    = SchemeChangeType("credentialtype-removed")
+	SchemeChangeCredentialTypeDeprecated = SchemeChangeType("credentialtype-deprecated")
+	SchemeChangeAttributeAdded           = SchemeChangeType("attribute-added")
+	SchemeChangeAttributeRemoved         = SchemeChangeType("attribute-removed")
+	SchemeChangeAttributeMoved           = SchemeChangeType("attribute-moved")
+	SchemeChangePublicKeyAdded           = SchemeChangeType("publickey-added")
+	SchemeChangePublicKeyRemoved         = SchemeChangeType("publickey-removed")
+	SchemeChangeTranslation              = SchemeChangeType("translation-changed")
+	SchemeChangeRevocationServers        = SchemeChangeType("revocationservers-changed")
+)
+
+// DiffSchemes compares the contents of the specified issuer scheme in two configurations,
+// in which the old and new version of the scheme have been parsed (for example, using
+// ParseSchemeFolder()). Changes are returned ordered by subject.
+func DiffSchemes(oldconf, newconf *Configuration, id SchemeManagerIdentifier) (*SchemeDiff, error) {
+	oldscheme, newscheme := oldconf.SchemeManagers[id], newconf.SchemeManagers[id]
+	if oldscheme == nil || newscheme == nil {
+		return nil, errors.Errorf("scheme %s not found in both configurations", id)
+	}
+
+	d := &SchemeDiff{Scheme: id, Changes: []*SchemeChange{}}
+	d.translation(id.String(), "Name", oldscheme.Name, newscheme.Name)
+	d.translation(id.String(), "Description", oldscheme.Description, newscheme.Description)
+
+	// Issuers and their public keys
+	issuers := map[IssuerIdentifier]struct{}{}
+	for issid := range oldconf.Issuers {
+		issuers[issid] = struct{}{}
+	}
+	for issid := range newconf.Issuers {
+		issuers[issid] = struct{}{}
+	}
+	for _, issid := range sortedIssuerIDs(issuers) {
+		if issid.SchemeManagerIdentifier() != id {
+			continue
+		}
+		oldiss, newiss := oldconf.Issuers[issid], newconf.Issuers[issid]
+		switch {
+		case oldiss == nil:
+			d.add(SchemeChangeIssuerAdded, issid.String(), "", "", "")
+		case newiss == nil:
+			d.add(SchemeChangeIssuerRemoved, issid.String(), "", "", "")
+		default:
+			d.deprecation(SchemeChangeIssuerDeprecated, issid.String(), oldiss.DeprecatedSince, newiss.DeprecatedSince)
+			d.translation(issid.String(), "Name", oldiss.Name, newiss.Name)
+		}
+		if err := d.publicKeys(oldconf, newconf, issid); err != nil {
+			return nil, err
+		}
+	}
+
+	// Credential types and their attributes
+	credtypes := map[CredentialTypeIdentifier]struct{}{}
+	for credid := range oldconf.CredentialTypes {
+		credtypes[credid] = struct{}{}
+	}
+	for credid := range newconf.CredentialTypes {
+		credtypes[credid] = struct{}{}
+	}
+	for _, credid := range sortedCredentialTypeIDs(credtypes) {
+		if credid.SchemeManagerIdentifier() != id {
+			continue
+		}
+		oldcred, newcred := oldconf.CredentialTypes[credid], newconf.CredentialTypes[credid]
+		switch {
+		case oldcred == nil:
+			d.add(SchemeChangeCredentialTypeAdded, credid.String(), "", "", "")
+		case newcred == nil:
+			d.add(SchemeChangeCredentialTypeRemoved, credid.String(), "", "", "")
+		default:
+			d.credentialType(oldcred, newcred)
+		}
+	}
+
+	return d, nil
+}
+
+func (d *SchemeDiff) add(typ SchemeChangeType, subject, field, old, new string) *SchemeChange {
+	change := &SchemeChange{Type: typ, Subject: subject, Field: field, Old: old, New: new}
+	d.Changes = append(d.Changes, change)
+	return change
+}
+
+func (d *SchemeDiff) translation(subject, field string, old, new TranslatedString) {
+	langs := map[string]struct{}{}
+	for lang := range old {
+		langs[lang] = struct{}{}
+	}
+	for lang := range new {
+		langs[lang] = struct{}{}
+	}
+	sorted := make([]string, 0, len(langs))
+	for lang := range langs {
+		sorted = append(sorted, lang)
+	}
+	sort.Strings(sorted)
+	for _, lang := range sorted {
+		if old[lang] != new[lang] {
+			d.add(SchemeChangeTranslation, subject, field+"."+lang, old[lang], new[lang])
+		}
+	}
+}
+
+func (d *SchemeDiff) deprecation(typ SchemeChangeType, subject string, old, new Timestamp) {
+	if time.Time(old).Equal(time.Time(new)) {
+		return
+	}
+	format := func(t Timestamp) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.DateString()
+	}
+	d.add(typ, subject, "DeprecatedSince", format(old), format(new))
+}
+
+func (d *SchemeDiff) publicKeys(oldconf, newconf *Configuration, issid IssuerIdentifier) error {
+	counters := func(conf *Configuration) (map[uint]struct{}, error) {
+		m := map[uint]struct{}{}
+		if conf.Issuers[issid] == nil {
+			return m, nil
+		}
+		indices, err := conf.PublicKeyIndices(issid)
+		for _, i := range indices {
+			m[i] = struct{}{}
+		}
+		return m, err
+	}
+	oldcounters, err := counters(oldconf)
+	if err != nil {
+		return err
+	}
+	newcounters, err := counters(newconf)
+	if err != nil {
+		return err
+	}
+	all := make([]uint, 0, len(oldcounters)+len(newcounters))
+	for c := range oldcounters {
+		all = append(all, c)
+	}
+	for c := range newcounters {
+		if _, ok := oldcounters[c]; !ok {
+			all = append(all, c)
+		}
+	}
+	sort.Slice(all, sorter(all))
+	for _, c := range all {
+		_, inold := oldcounters[c]
+		_, innew := newcounters[c]
+		subject := fmt.Sprintf("%s-%d", issid, c)
+		if !inold {
+			d.add(SchemeChangePublicKeyAdded, subject, "", "", "")
+		} else if !innew {
+			d.add(SchemeChangePublicKeyRemoved, subject, "", "", "")
+		}
+	}
+	return nil
+}
+
+func (d *SchemeDiff) credentialType(old, new *CredentialType) {
+	credid := old.Identifier().String()
+	d.deprecation(SchemeChangeCredentialTypeDeprecated, credid, old.DeprecatedSince, new.DeprecatedSince)
+	d.translation(credid, "Name", old.Name, new.Name)
+	d.translation(credid, "Description", old.Description, new.Description)
+	d.translation(credid, "IssueURL", old.IssueURL, new.IssueURL)
+	if oldservers, newservers := strings.Join(old.RevocationServers, " "), strings.Join(new.RevocationServers, " "); oldservers != newservers {
+		d.add(SchemeChangeRevocationServers, credid, "RevocationServers", oldservers, newservers)
+	}
+
+	// Attributes: in the AttributeList of a credential, the attributes are stored in the order
+	// in which they occur in the credential type, so removals and reorderings break existing
+	// credentials. Attributes added at the end do not.
+	newattrs := map[string]*AttributeType{}
+	for _, attr := range new.AttributeTypes {
+		newattrs[attr.ID] = attr
+	}
+	oldattrs := map[string]*AttributeType{}
+	for _, attr := range old.AttributeTypes {
+		oldattrs[attr.ID] = attr
+		attrid := credid + "." + attr.ID
+		newattr := newattrs[attr.ID]
+		if newattr == nil {
+			d.add(SchemeChangeAttributeRemoved, attrid, "", "", "").Breaking = true
+			continue
+		}
+		if attr.Index != newattr.Index {
+			d.add(SchemeChangeAttributeMoved, attrid, "Index", fmt.Sprint(attr.Index), fmt.Sprint(newattr.Index)).
+				Breaking = true
+		}
+		d.translation(attrid, "Name", attr.Name, newattr.Name)
+		d.translation(attrid, "Description", attr.Description, newattr.Description)
+	}
+	for _, attr := range new.AttributeTypes {
+		if oldattrs[attr.ID] == nil {
+			// Adding an attribute shifts the attributes after it, which is detected above
+			d.add(SchemeChangeAttributeAdded, credid+"."+attr.ID, "Index", "", fmt.Sprint(attr.Index))
+		}
+	}
+}
+
+func sortedIssuerIDs(ids map[IssuerIdentifier]struct{}) []IssuerIdentifier {
+	sorted := make([]IssuerIdentifier, 0, len(ids))
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].String() < sorted[j].String() })
+	return sorted
+}
+
+func sortedCredentialTypeIDs(ids map[CredentialTypeIdentifier]struct{}) []CredentialTypeIdentifier {
+	sorted := make([]CredentialTypeIdentifier, 0, len(ids))
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].String() < sorted[j].String() })
+	return sorted
+}
+
+func (c *SchemeChange) String() string {
+	s := fmt.Sprintf("%s: %s", c.Type, c.Subject)
+	if c.Field != "" {
+		s += " " + c.Field
+	}
+	if c.Old != "" || c.New != "" {
+		s += fmt.Sprintf(": %q -> %q", c.Old, c.New)
+	}
+	if c.Breaking {
+		s += " (BREAKING)"
+	}
+	return s
+}
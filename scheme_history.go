@@ -0,0 +1,215 @@
+package irma
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/irmago/internal/common"
+	"github.com/sirupsen/logrus"
+)
+
+// Previous versions of schemes are kept in the history directory within the configuration
+// directory, at .history/$schemeid/$timestamp, where $timestamp is the Unix timestamp of the
+// version (as in its timestamp file). If a scheme is pinned to a version, that version's
+// timestamp is stored in .history/$schemeid/pinned. As the history directory is hidden,
+// it is ignored when parsing the configuration directory.
+
+const schemeHistoryDir = ".history"
+
+// SchemeVersion is a previous version of a scheme kept in the scheme history.
+type SchemeVersion struct {
+	Timestamp Timestamp
+	Path      string
+}
+
+// SchemeHistory returns the previous versions of the scheme kept in the scheme history,
+// the most recent first.
+func (conf *Configuration) SchemeHistory(scheme Scheme) ([]*SchemeVersion, error) {
+	return conf.schemeHistory(scheme.id())
+}
+
+// RollbackScheme replaces the scheme with the specified previous version from the scheme history,
+// or the most recent previous version if version is nil, after verifying it. The current version
+// of the scheme is added to the history, so that the rollback can be undone by another rollback.
+// The scheme is pinned to the version to which it was rolled back, so that it is not updated
+// again to the version from which it was rolled back, until it is unpinned using UnpinScheme().
+// Subscribers registered with SubscribeSchemeUpdates() are notified as in UpdateScheme().
+func (conf *Configuration) RollbackScheme(scheme Scheme, version *Timestamp) (err error) {
+	if conf.readOnly {
+		return errors.New("cannot roll back a scheme in a read-only configuration")
+	}
+	var update *SchemeUpdate
+	defer func() {
+		conf.finishSchemeUpdate(scheme, update, err)
+	}()
+	id := scheme.id()
+	versions, err := conf.schemeHistory(id)
+	if err != nil {
+		return err
+	}
+	var v *SchemeVersion
+	for _, candidate := range versions {
+		if version == nil || time.Time(candidate.Timestamp).Equal(time.Time(*version)) {
+			v = candidate
+			break
+		}
+	}
+	if v == nil {
+		return errors.Errorf("version not found in history of scheme %s", id)
+	}
+
+	// As in UpdateScheme(), copy the version to a temporary directory, and verify and parse it
+	// into another *Configuration instance, before modifying the scheme on disk and in memory
+	schemepath := scheme.path()
+	dir, err := ioutil.TempDir(filepath.Dir(schemepath), "tempscheme")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	newschemepath := filepath.Join(dir, id)
+	if err = common.CopyDirectory(v.Path, newschemepath); err != nil {
+		return err
+	}
+	newconf, err := NewConfiguration(dir, ConfigurationOptions{})
+	if err != nil {
+		return err
+	}
+	newscheme, err := newconf.ParseSchemeFolder(newschemepath)
+	if err != nil {
+		return err
+	}
+	changed := newIrmaIdentifierSet()
+	if err = conf.changedSchemeFiles(scheme, newscheme, newschemepath, changed); err != nil {
+		return err
+	}
+	if update, err = conf.schemeUpdate(scheme, newscheme, newconf, changed); err != nil {
+		return err
+	}
+
+	if err = conf.PinScheme(scheme, &v.Timestamp); err != nil {
+		return err
+	}
+	Logger.WithFields(logrus.Fields{"scheme": id, "version": v.Timestamp.String()}).Info("rolling back scheme")
+	oldscheme, err := conf.replaceSchemeDir(newscheme, schemepath, newschemepath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(oldscheme))
+	}()
+	// Archive the version we rolled back from, and remove the one we rolled back to from the history
+	if err = conf.archiveScheme(id, oldscheme, 0); err != nil {
+		Logger.WithField("scheme", id).Warn("failed to add scheme to history: ", err)
+	}
+	if err = os.RemoveAll(v.Path); err != nil {
+		return err
+	}
+
+	scheme.purge(conf)
+	_, err = conf.ParseSchemeFolder(schemepath)
+	return err
+}
+
+// PinScheme pins the scheme to the specified version, or its current version if version is nil:
+// the scheme is then not updated to versions newer than the specified version.
+func (conf *Configuration) PinScheme(scheme Scheme, version *Timestamp) error {
+	if conf.readOnly {
+		return errors.New("cannot pin a scheme in a read-only configuration")
+	}
+	if version == nil {
+		t := scheme.timestamp()
+		version = &t
+	}
+	dir := filepath.Join(conf.Path, schemeHistoryDir, scheme.id())
+	if err := common.EnsureDirectoryExists(dir); err != nil {
+		return err
+	}
+	return common.SaveFile(filepath.Join(dir, "pinned"), []byte(strconv.FormatInt(time.Time(*version).Unix(), 10)))
+}
+
+// UnpinScheme removes the pin of the scheme, if any, so that it is updated again.
+func (conf *Configuration) UnpinScheme(scheme Scheme) error {
+	if conf.readOnly {
+		return errors.New("cannot unpin a scheme in a read-only configuration")
+	}
+	err := os.Remove(filepath.Join(conf.Path, schemeHistoryDir, scheme.id(), "pinned"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// SchemePin returns the version to which the scheme is pinned, or nil if it is not pinned.
+func (conf *Configuration) SchemePin(scheme Scheme) (*Timestamp, error) {
+	return conf.schemePin(scheme.id())
+}
+
+func (conf *Configuration) schemePin(id string) (*Timestamp, error) {
+	ts, _, err := readTimestamp(filepath.Join(conf.Path, schemeHistoryDir, id, "pinned"))
+	return ts, err
+}
+
+func (conf *Configuration) schemeHistory(id string) ([]*SchemeVersion, error) {
+	dir := filepath.Join(conf.Path, schemeHistoryDir, id)
+	var versions []*SchemeVersion
+	err := common.IterateSubfolders(dir, func(path string, _ os.FileInfo) error {
+		unix, err := strconv.ParseInt(filepath.Base(path), 10, 64)
+		if err != nil {
+			return nil // not a scheme version
+		}
+		versions = append(versions, &SchemeVersion{Timestamp: Timestamp(time.Unix(unix, 0)), Path: path})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Timestamp.After(versions[j].Timestamp)
+	})
+	return versions, nil
+}
+
+// archiveScheme moves the scheme version in the specified directory into the scheme history,
+// afterwards removing the oldest versions from the history so that at most keep versions
+// remain, if keep is positive.
+func (conf *Configuration) archiveScheme(id, dir string, keep int) error {
+	ts, exists, err := readTimestamp(filepath.Join(dir, "timestamp"))
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errors.New("scheme has no timestamp")
+	}
+	historydir := filepath.Join(conf.Path, schemeHistoryDir, id)
+	if err = common.EnsureDirectoryExists(historydir); err != nil {
+		return err
+	}
+	dest := filepath.Join(historydir, strconv.FormatInt(time.Time(*ts).Unix(), 10))
+	if err = os.RemoveAll(dest); err != nil {
+		return err
+	}
+	if err = os.Rename(dir, dest); err != nil {
+		return err
+	}
+
+	if keep <= 0 {
+		return nil
+	}
+	versions, err := conf.schemeHistory(id)
+	if err != nil {
+		return err
+	}
+	for i := keep; i < len(versions); i++ {
+		if err = os.RemoveAll(versions[i].Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,396 @@
+package irma
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/irmago/internal/common"
+)
+
+type (
+	// LintSeverity is the severity of a LintRule, used to rank the issues found by Lint().
+	LintSeverity string
+
+	// LintRule is a check performed by Lint() on the contents of the schemes in a configuration.
+	LintRule struct {
+		Name        string
+		Severity    LintSeverity
+		Description string
+
+		check func(l *linter)
+	}
+
+	// LintIssue is a problem found by Lint().
+	LintIssue struct {
+		Rule     string       `json:"rule"`
+		Severity LintSeverity `json:"severity"`
+		// Subject is the identifier of the scheme, issuer, credential type, attribute type,
+		// public key (as issuer-counter) or issue wizard having the problem.
+		Subject string `json:"subject"`
+		Message string `json:"message"`
+	}
+
+	// LintOptions configures Lint().
+	LintOptions struct {
+		// Languages for which translations are required; defaults to English and Dutch.
+		Languages []string
+		// KeyExpiry is the period before the expiry of the latest public key of an issuer in which
+		// the key is reported as nearing expiry; defaults to 31 days.
+		KeyExpiry time.Duration
+		// Severities overrides the severities of the rules, per rule name.
+		// Rules can be disabled using LintSeverityOff.
+		Severities map[string]LintSeverity
+		// CheckURLs enables checking that the IssueURLs of credential types are reachable.
+		CheckURLs bool
+	}
+
+	linter struct {
+		conf   *Configuration
+		opts   LintOptions
+		rule   *LintRule
+		issues []*LintIssue
+	}
+)
+
+const (
+	LintSeverityError   = LintSeverity("error")
+	LintSeverityWarning = LintSeverity("warning")
+	LintSeverityInfo    = LintSeverity("info")
+	LintSeverityOff     = LintSeverity("off")
+)
+
+var lintSeverityRanks = map[LintSeverity]int{
+	LintSeverityOff:     0,
+	LintSeverityInfo:    1,
+	LintSeverityWarning: 2,
+	LintSeverityError:   3,
+}
+
+// LintRules contains the rules checked by Lint(), with their default severities.
+var LintRules = []*LintRule{
+	{Name: "translations", Severity: LintSeverityWarning, check: lintTranslations,
+		Description: "translations are present for all configured languages"},
+	{Name: "logo", Severity: LintSeverityWarning, check: lintLogos,
+		Description: "issuers and credential types have a logo.png"},
+	{Name: "key-expiry", Severity: LintSeverityWarning, check: lintKeyExpiry,
+		Description: "the latest public key of an issuer does not expire soon"},
+	{Name: "no-valid-key", Severity: LintSeverityError, check: lintValidKeys,
+		Description: "the latest public key of the issuer of a credential type has not expired"},
+	{Name: "issue-url", Severity: LintSeverityWarning, check: lintIssueURLs,
+		Description: "IssueURLs of credential types are valid and (if enabled) reachable"},
+	{Name: "dependency-cycle", Severity: LintSeverityError, check: lintDependencies,
+		Description: "dependencies of credential types can be satisfied without cycles"},
+	{Name: "wizard-complexity", Severity: LintSeverityError, check: lintWizardComplexity,
+		Description: fmt.Sprintf("issue wizards and dependencies of credential types have less than %d items", maxWizardComplexity)},
+	{Name: "display-index", Severity: LintSeverityWarning, check: lintDisplayIndices,
+		Description: "attribute displayIndex values are either all absent or a permutation of the attribute indices"},
+}
+
+// ParseLintSeverity parses the specified severity.
+func ParseLintSeverity(s string) (LintSeverity, error) {
+	severity := LintSeverity(strings.ToLower(s))
+	if _, ok := lintSeverityRanks[severity]; !ok {
+		return "", errors.Errorf("unknown severity %q", s)
+	}
+	return severity, nil
+}
+
+// AtLeast returns whether the severity is at least as severe as the specified severity.
+func (severity LintSeverity) AtLeast(other LintSeverity) bool {
+	return lintSeverityRanks[severity] >= lintSeverityRanks[other]
+}
+
+func (issue *LintIssue) String() string {
+	return fmt.Sprintf("%s: %s: %s [%s]", issue.Severity, issue.Subject, issue.Message, issue.Rule)
+}
+
+// Lint checks the contents of the issuer schemes and issue wizards in the configuration
+// against the LintRules, returning the issues found, the most severe first. Unlike the
+// warnings collected when parsing, the checks are configurable and ranked by severity,
+// so that they can be used to gate changes to schemes.
+func (conf *Configuration) Lint(opts LintOptions) ([]*LintIssue, error) {
+	if len(opts.Languages) == 0 {
+		opts.Languages = validLangs
+	}
+	if opts.KeyExpiry == 0 {
+		opts.KeyExpiry = 31 * 24 * time.Hour
+	}
+	for name, severity := range opts.Severities {
+		if lintRule(name) == nil {
+			return nil, errors.Errorf("unknown lint rule %q", name)
+		}
+		if _, ok := lintSeverityRanks[severity]; !ok {
+			return nil, errors.Errorf("unknown severity %q for lint rule %s", severity, name)
+		}
+	}
+
+	l := &linter{conf: conf, opts: opts, issues: []*LintIssue{}}
+	for _, rule := range LintRules {
+		if l.severity(rule) == LintSeverityOff {
+			continue
+		}
+		l.rule = rule
+		rule.check(l)
+	}
+
+	sort.SliceStable(l.issues, func(i, j int) bool {
+		a, b := l.issues[i], l.issues[j]
+		if a.Severity != b.Severity {
+			return lintSeverityRanks[a.Severity] > lintSeverityRanks[b.Severity]
+		}
+		return a.Subject < b.Subject
+	})
+	return l.issues, nil
+}
+
+func lintRule(name string) *LintRule {
+	for _, rule := range LintRules {
+		if rule.Name == name {
+			return rule
+		}
+	}
+	return nil
+}
+
+func (l *linter) severity(rule *LintRule) LintSeverity {
+	if severity, ok := l.opts.Severities[rule.Name]; ok {
+		return severity
+	}
+	return rule.Severity
+}
+
+func (l *linter) report(subject string, format string, args ...interface{}) {
+	l.issues = append(l.issues, &LintIssue{
+		Rule:     l.rule.Name,
+		Severity: l.severity(l.rule),
+		Subject:  subject,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (l *linter) issuers() []*Issuer {
+	ids := map[IssuerIdentifier]struct{}{}
+	for id := range l.conf.Issuers {
+		ids[id] = struct{}{}
+	}
+	var issuers []*Issuer
+	for _, id := range sortedIssuerIDs(ids) {
+		issuers = append(issuers, l.conf.Issuers[id])
+	}
+	return issuers
+}
+
+func (l *linter) credentialTypes() []*CredentialType {
+	ids := map[CredentialTypeIdentifier]struct{}{}
+	for id := range l.conf.CredentialTypes {
+		ids[id] = struct{}{}
+	}
+	var credtypes []*CredentialType
+	for _, id := range sortedCredentialTypeIDs(ids) {
+		credtypes = append(credtypes, l.conf.CredentialTypes[id])
+	}
+	return credtypes
+}
+
+func (l *linter) translations(subject string, o interface{}) {
+	missing := missingTranslations(o, l.opts.Languages)
+	names := make([]string, 0, len(missing))
+	for name := range missing {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		l.report(subject, "missing %s translation of %s", strings.Join(missing[name], ", "), name)
+	}
+}
+
+func lintTranslations(l *linter) {
+	for id, scheme := range l.conf.SchemeManagers {
+		l.translations(id.String(), scheme)
+	}
+	for _, issuer := range l.issuers() {
+		l.translations(issuer.Identifier().String(), issuer)
+	}
+	for _, cred := range l.credentialTypes() {
+		l.translations(cred.Identifier().String(), cred)
+		for _, attr := range cred.AttributeTypes {
+			if !attr.RevocationAttribute {
+				l.translations(attr.GetAttributeTypeIdentifier().String(), attr)
+			}
+		}
+	}
+	for _, wizard := range l.conf.IssueWizards {
+		l.translations(wizard.ID.String(), wizard)
+	}
+}
+
+func lintLogos(l *linter) {
+	for _, issuer := range l.issuers() {
+		scheme := l.conf.SchemeManagers[issuer.SchemeManagerIdentifier()]
+		if err := common.AssertPathExists(filepath.Join(scheme.path(), issuer.ID, "logo.png")); err != nil {
+			l.report(issuer.Identifier().String(), "no logo.png")
+		}
+	}
+	for _, cred := range l.credentialTypes() {
+		if cred.Logo(l.conf) == "" {
+			l.report(cred.Identifier().String(), "no logo.png")
+		}
+	}
+}
+
+// latestPublicKey returns the counter and expiry date of the latest public key of the issuer,
+// reporting an issue if the keys cannot be read.
+func (l *linter) latestPublicKey(issuer *Issuer) (uint, time.Time, bool) {
+	id := issuer.Identifier()
+	indices, err := l.conf.PublicKeyIndices(id)
+	if err != nil {
+		l.report(id.String(), "failed to read public keys: %s", err)
+		return 0, time.Time{}, false
+	}
+	if len(indices) == 0 {
+		return 0, time.Time{}, false
+	}
+	counter := indices[len(indices)-1]
+	pk, err := l.conf.PublicKey(id, counter)
+	if err != nil || pk == nil {
+		l.report(fmt.Sprintf("%s-%d", id, counter), "failed to read public key: %v", err)
+		return 0, time.Time{}, false
+	}
+	return counter, time.Unix(pk.ExpiryDate, 0), true
+}
+
+func lintKeyExpiry(l *linter) {
+	now := time.Now()
+	for _, issuer := range l.issuers() {
+		if issuer.IsDeprecated() {
+			continue
+		}
+		counter, expiry, ok := l.latestPublicKey(issuer)
+		if ok && expiry.After(now) && expiry.Before(now.Add(l.opts.KeyExpiry)) {
+			l.report(fmt.Sprintf("%s-%d", issuer.Identifier(), counter),
+				"latest public key expires soon (at %s)", expiry.UTC().Format(time.RFC3339))
+		}
+	}
+}
+
+func lintValidKeys(l *linter) {
+	now := time.Now()
+	valid := map[IssuerIdentifier]bool{}
+	for _, issuer := range l.issuers() {
+		_, expiry, ok := l.latestPublicKey(issuer)
+		valid[issuer.Identifier()] = ok && expiry.After(now)
+	}
+	for _, cred := range l.credentialTypes() {
+		issuer := l.conf.Issuers[cred.IssuerIdentifier()]
+		if cred.IsDeprecated() || issuer == nil || issuer.IsDeprecated() {
+			continue
+		}
+		if !valid[cred.IssuerIdentifier()] {
+			l.report(cred.Identifier().String(), "latest public key of issuer %s is absent or expired", cred.IssuerIdentifier())
+		}
+	}
+}
+
+func lintIssueURLs(l *linter) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	for _, cred := range l.credentialTypes() {
+		if cred.IsDeprecated() {
+			continue
+		}
+		for _, lang := range sortedLanguages(cred.IssueURL) {
+			u := cred.IssueURL[lang]
+			if u == "" {
+				continue
+			}
+			parsed, err := url.Parse(u)
+			if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				l.report(cred.Identifier().String(), "invalid %s IssueURL %s", lang, u)
+				continue
+			}
+			if !l.opts.CheckURLs {
+				continue
+			}
+			res, err := client.Get(u)
+			if err != nil {
+				l.report(cred.Identifier().String(), "unreachable %s IssueURL %s: %s", lang, u, err)
+				continue
+			}
+			_ = res.Body.Close()
+			if res.StatusCode >= 400 {
+				l.report(cred.Identifier().String(), "unreachable %s IssueURL %s: status %d", lang, u, res.StatusCode)
+			}
+		}
+	}
+}
+
+func lintDependencies(l *linter) {
+	for _, cred := range l.credentialTypes() {
+		if cred.Dependencies == nil {
+			continue
+		}
+		if err := cred.validateDependencies(l.conf, DependencyChain{}, cred.Identifier()); err != nil {
+			l.report(cred.Identifier().String(), "invalid dependencies: %s", err)
+		}
+	}
+}
+
+func lintWizardComplexity(l *linter) {
+	for _, cred := range l.credentialTypes() {
+		if cred.Dependencies == nil {
+			continue
+		}
+		complexity, err := cred.Dependencies.WizardContents().complexity(l.conf, true)
+		if err == nil && complexity >= maxWizardComplexity {
+			l.report(cred.Identifier().String(), "dependencies require %d wizard items (maximum: %d)",
+				complexity, maxWizardComplexity-1)
+		}
+	}
+	for _, wizard := range l.conf.IssueWizards {
+		complexity, err := wizard.Contents.complexity(l.conf, wizard.ExpandDependencies == nil || *wizard.ExpandDependencies)
+		if err != nil {
+			l.report(wizard.ID.String(), "failed to compute wizard items: %s", err)
+		} else if complexity >= maxWizardComplexity {
+			l.report(wizard.ID.String(), "wizard requires %d items (maximum: %d)", complexity, maxWizardComplexity-1)
+		}
+	}
+}
+
+func lintDisplayIndices(l *linter) {
+	for _, cred := range l.credentialTypes() {
+		count := len(cred.AttributeTypes)
+		indices := map[int]string{}
+		specified := 0
+		for i, attr := range cred.AttributeTypes {
+			index := i
+			if attr.DisplayIndex != nil {
+				index = *attr.DisplayIndex
+				specified++
+			}
+			if index < 0 || index >= count {
+				l.report(attr.GetAttributeTypeIdentifier().String(), "displayIndex %d out of range", index)
+			}
+			if other, ok := indices[index]; ok {
+				l.report(attr.GetAttributeTypeIdentifier().String(), "displayIndex %d also used by attribute %s", index, other)
+			}
+			indices[index] = attr.ID
+		}
+		if specified > 0 && specified < count {
+			l.report(cred.Identifier().String(), "displayIndex specified for %d of %d attributes", specified, count)
+		}
+	}
+}
+
+func sortedLanguages(ts TranslatedString) []string {
+	langs := make([]string, 0, len(ts))
+	for lang := range ts {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
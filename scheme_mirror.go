@@ -0,0 +1,251 @@
+package irma
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/jasonlvhit/gocron"
+)
+
+type (
+	// SchemeMirror serves the schemes of one or more Configurations over HTTP, in the layout
+	// expected by Configuration.UpdateScheme(): the files of a scheme are served at /$schemeid/.
+	// It updates the schemes from their own URLs using Sync(), which verifies the signatures of
+	// the updated schemes before they are served. The status of the last synchronization of each
+	// scheme is served as JSON at /status.json.
+	//
+	// Configurations can download and update schemes from a mirror by specifying its URL
+	// in the SchemeMirror or SchemeMirrors fields of the ConfigurationOptions.
+	SchemeMirror struct {
+		confs  []*Configuration
+		mutex  sync.RWMutex
+		served map[string]*mirroredScheme
+		status map[string]*SchemeMirrorStatus
+
+		scheduler *gocron.Scheduler
+		stop      chan bool
+	}
+
+	// SchemeMirrorStatus is the synchronization status of a scheme served by a SchemeMirror.
+	SchemeMirrorStatus struct {
+		Scheme string     `json:"scheme"`
+		Type   SchemeType `json:"type"`
+		// URL from which the scheme was last synchronized
+		URL string `json:"url,omitempty"`
+		// Timestamp of the currently served version of the scheme
+		Timestamp   Timestamp  `json:"timestamp"`
+		LastSync    *time.Time `json:"lastSync,omitempty"`
+		LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+		// Error of the last synchronization, if it failed
+		Error string `json:"error,omitempty"`
+	}
+
+	mirroredScheme struct {
+		path  string
+		index SchemeManagerIndex
+		demo  bool
+	}
+)
+
+// Files of demo schemes that are served besides the files in the index, as downloaded
+// by UpdateScheme() from the scheme URL
+var mirrorDemoFiles = regexp.MustCompile(`^sk\.pem$`)
+
+// NewSchemeMirror returns a SchemeMirror serving the (parsed) schemes in the specified
+// Configurations. To synchronize the schemes, the Configurations must not be read-only.
+func NewSchemeMirror(confs ...*Configuration) (*SchemeMirror, error) {
+	m := &SchemeMirror{
+		confs:  confs,
+		served: map[string]*mirroredScheme{},
+		status: map[string]*SchemeMirrorStatus{},
+	}
+	for _, conf := range confs {
+		for _, scheme := range conf.schemes() {
+			if _, ok := m.status[scheme.id()]; ok {
+				return nil, errors.Errorf("scheme %s occurs more than once", scheme.id())
+			}
+			m.status[scheme.id()] = &SchemeMirrorStatus{Scheme: scheme.id(), Type: scheme.typ()}
+			m.serve(scheme)
+		}
+	}
+	return m, nil
+}
+
+// Sync updates all schemes from their URLs, returning the errors that occurred (if any)
+// joined in a single error. The status of each scheme is updated accordingly.
+func (m *SchemeMirror) Sync() error {
+	var errs []string
+	for _, conf := range m.confs {
+		for _, scheme := range conf.schemes() {
+			if err := m.sync(conf, scheme); err != nil {
+				errs = append(errs, scheme.id()+": "+err.Error())
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New("failed to synchronize schemes: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// AutoSync synchronizes the schemes now and every interval minutes afterwards, until Stop() is called.
+func (m *SchemeMirror) AutoSync(interval uint) {
+	Logger.Infof("Synchronizing mirrored schemes every %d minutes", interval)
+	sync := func() {
+		if err := m.Sync(); err != nil {
+			Logger.Error(err.Error())
+		}
+	}
+	m.scheduler = gocron.NewScheduler()
+	m.scheduler.Every(uint64(interval)).Minutes().Do(sync)
+	m.stop = m.scheduler.Start()
+	go sync()
+}
+
+// Stop stops the automatic synchronization started by AutoSync().
+func (m *SchemeMirror) Stop() {
+	if m.stop != nil {
+		m.stop <- true
+		m.stop = nil
+	}
+}
+
+func (m *SchemeMirror) sync(conf *Configuration, scheme Scheme) error {
+	err := conf.UpdateScheme(scheme, nil)
+	now := time.Now()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	status := m.status[scheme.id()]
+	if status == nil {
+		status = &SchemeMirrorStatus{Scheme: scheme.id(), Type: scheme.typ()}
+		m.status[scheme.id()] = status
+	}
+	status.URL = conf.schemeURL(scheme.id(), scheme.url())
+	status.LastSync = &now
+	if err != nil {
+		status.Error = err.Error()
+		return err
+	}
+	status.Error = ""
+	status.LastSuccess = &now
+	// UpdateScheme() replaces the scheme instance in conf if it was updated
+	updated := conf.scheme(scheme.id(), scheme.typ())
+	if updated == nil {
+		return errors.Errorf("scheme %s disappeared after update", scheme.id())
+	}
+	m.serveLocked(updated)
+	return nil
+}
+
+func (m *SchemeMirror) serve(scheme Scheme) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.serveLocked(scheme)
+}
+
+func (m *SchemeMirror) serveLocked(scheme Scheme) {
+	s := &mirroredScheme{path: scheme.path(), index: scheme.idx()}
+	if sm, ok := scheme.(*SchemeManager); ok {
+		s.demo = sm.Demo
+	}
+	m.served[scheme.id()] = s
+	m.status[scheme.id()].Timestamp = scheme.timestamp()
+}
+
+// Status returns the synchronization status of all served schemes, ordered by scheme.
+func (m *SchemeMirror) Status() []*SchemeMirrorStatus {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	statuses := make([]*SchemeMirrorStatus, 0, len(m.status))
+	for _, status := range m.status {
+		s := *status
+		statuses = append(statuses, &s)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Scheme < statuses[j].Scheme })
+	return statuses
+}
+
+// ServeHTTP serves the files of the schemes, and their status at /status.json.
+func (m *SchemeMirror) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if r.URL.Path == "/status.json" {
+		bts, err := json.Marshal(m.Status())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(bts)
+		return
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	m.mutex.RLock()
+	scheme, ok := m.served[parts[0]]
+	m.mutex.RUnlock()
+	if !ok || !scheme.serves(parts[0], parts[1]) {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, filepath.Join(scheme.path, filepath.FromSlash(parts[1])))
+}
+
+// serves returns whether the specified file of the scheme is served: only files listed in the
+// scheme index are, along with the other files that are downloaded when updating a scheme.
+// In particular, private keys are not served unless the scheme is a demo scheme.
+func (s *mirroredScheme) serves(id, file string) bool {
+	if strings.Contains(file, "..") {
+		return false
+	}
+	if _, ok := s.index[id+"/"+file]; ok {
+		return true
+	}
+	for _, r := range bundleExtraFiles {
+		if r.MatchString(file) {
+			return true
+		}
+	}
+	return s.demo && (bundleDemoFiles.MatchString(file) || mirrorDemoFiles.MatchString(file))
+}
+
+// schemes returns all issuer and requestor schemes of the configuration.
+func (conf *Configuration) schemes() []Scheme {
+	var schemes []Scheme
+	for _, scheme := range conf.SchemeManagers {
+		schemes = append(schemes, scheme)
+	}
+	for _, scheme := range conf.RequestorSchemes {
+		schemes = append(schemes, scheme)
+	}
+	return schemes
+}
+
+// scheme returns the scheme of the specified type and ID, or nil if not present.
+func (conf *Configuration) scheme(id string, typ SchemeType) Scheme {
+	switch typ {
+	case SchemeTypeIssuer:
+		if scheme, ok := conf.SchemeManagers[NewSchemeManagerIdentifier(id)]; ok {
+			return scheme
+		}
+	case SchemeTypeRequestor:
+		if scheme, ok := conf.RequestorSchemes[NewRequestorSchemeIdentifier(id)]; ok {
+			return scheme
+		}
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package irma
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/irmago/internal/common"
+)
+
+// Helpers for tools that create new issuers and credential types within a scheme directory,
+// before the scheme is (re)signed. These validate the new descriptions in the same way as
+// is done when parsing the scheme.
+
+type (
+	// Writers of the canonical XML form of issuer and credential type descriptions, containing
+	// all fields of the Issuer and CredentialType structs that are read from description.xml.
+	// Absent translations and zero values are omitted, so that parsing the XML yields the
+	// original description.
+
+	issuerXML struct {
+		XMLName         xml.Name          `xml:"Issuer"`
+		XMLVersion      int               `xml:"version,attr"`
+		ID              string            `xml:"ID"`
+		Name            *TranslatedString `xml:"Name,omitempty"`
+		SchemeManager   string            `xml:"SchemeManager"`
+		ContactAddress  string            `xml:"ContactAddress,omitempty"`
+		ContactEMail    string            `xml:"ContactEMail,omitempty"`
+		DeprecatedSince *Timestamp        `xml:"DeprecatedSince,omitempty"`
+	}
+
+	credentialTypeXML struct {
+		XMLName                 xml.Name          `xml:"IssueSpecification"`
+		XMLVersion              int               `xml:"version,attr"`
+		Name                    *TranslatedString `xml:"Name,omitempty"`
+		SchemeManager           string            `xml:"SchemeManager"`
+		IssuerID                string            `xml:"IssuerID"`
+		CredentialID            string            `xml:"CredentialID"`
+		Description             *TranslatedString `xml:"Description,omitempty"`
+		Category                *TranslatedString `xml:"Category,omitempty"`
+		FAQIntro                *TranslatedString `xml:"FAQIntro,omitempty"`
+		FAQPurpose              *TranslatedString `xml:"FAQPurpose,omitempty"`
+		FAQContent              *TranslatedString `xml:"FAQContent,omitempty"`
+		FAQHowto                *TranslatedString `xml:"FAQHowto,omitempty"`
+		FAQSummary              *TranslatedString `xml:"FAQSummary,omitempty"`
+		IssueURL                *TranslatedString `xml:"IssueURL,omitempty"`
+		IsULIssueURL            bool              `xml:"IsULIssueURL,omitempty"`
+		ShouldBeSingleton       bool              `xml:"ShouldBeSingleton,omitempty"`
+		DisallowDelete          bool              `xml:"DisallowDelete,omitempty"`
+		IsInCredentialStore     bool              `xml:"IsInCredentialStore,omitempty"`
+		DeprecatedSince         *Timestamp        `xml:"DeprecatedSince,omitempty"`
+		ForegroundColor         string            `xml:"ForegroundColor,omitempty"`
+		BackgroundGradientStart string            `xml:"BackgroundGradientStart,omitempty"`
+		BackgroundGradientEnd   string            `xml:"BackgroundGradientEnd,omitempty"`
+		RevocationServers       *struct {
+			URLs []string `xml:"RevocationServer"`
+		} `xml:"RevocationServers,omitempty"`
+		RevocationUpdateCount uint64                 `xml:"RevocationUpdateCount,omitempty"`
+		RevocationUpdateSpeed uint64                 `xml:"RevocationUpdateSpeed,omitempty"`
+		Dependencies          CredentialDependencies `xml:"Dependencies,omitempty"`
+		Attributes            []*attributeXML        `xml:"Attributes>Attribute"`
+	}
+
+	attributeXML struct {
+		ID           string            `xml:"id,attr,omitempty"`
+		Optional     string            `xml:"optional,attr,omitempty"`
+		RandomBlind  bool              `xml:"randomblind,attr,omitempty"`
+		DisplayIndex *int              `xml:"displayIndex,attr,omitempty"`
+		DisplayHint  string            `xml:"displayHint,attr,omitempty"`
+		Revocation   bool              `xml:"revocation,attr,omitempty"`
+		Name         *TranslatedString `xml:"Name,omitempty"`
+		Description  *TranslatedString `xml:"Description,omitempty"`
+	}
+)
+
+var identifierPattern = regexp.MustCompile(`^[a-zA-Z0-9_\-]+$`)
+
+// ReadSchemeDescription reads the description of the issuer scheme in the specified directory,
+// without verifying the signature of the scheme.
+func ReadSchemeDescription(dir string) (*SchemeManager, error) {
+	filename, err := common.SchemeFilename(dir)
+	if err != nil {
+		return nil, err
+	}
+	if filename != "description.xml" {
+		return nil, errors.New("not an issuer scheme")
+	}
+	scheme := &SchemeManager{}
+	if err = readDescription(filepath.Join(dir, filename), scheme); err != nil {
+		return nil, err
+	}
+	scheme.storagepath = dir
+	return scheme, nil
+}
+
+// Issuer reads the description of the specified issuer from the scheme directory.
+func (scheme *SchemeManager) Issuer(id string) (*Issuer, error) {
+	issuer := &Issuer{}
+	if err := readDescription(filepath.Join(scheme.path(), id, "description.xml"), issuer); err != nil {
+		return nil, err
+	}
+	return issuer, nil
+}
+
+// ValidateIssuer validates the issuer description as if it were contained in the scheme,
+// returning the warnings (for example, about missing translations) that parsing the scheme
+// would yield.
+func (scheme *SchemeManager) ValidateIssuer(issuer *Issuer) ([]string, error) {
+	if !identifierPattern.MatchString(issuer.ID) {
+		return nil, errors.Errorf("invalid issuer ID %q", issuer.ID)
+	}
+	conf := &Configuration{}
+	err := conf.validateIssuer(scheme, issuer, filepath.Join(scheme.path(), issuer.ID))
+	return conf.Warnings, err
+}
+
+// ValidateCredentialType validates the credential type description as if it were contained
+// in the scheme, returning the warnings that parsing the scheme would yield.
+func (scheme *SchemeManager) ValidateCredentialType(issuer *Issuer, cred *CredentialType) ([]string, error) {
+	if !identifierPattern.MatchString(cred.ID) {
+		return nil, errors.Errorf("invalid credential type ID %q", cred.ID)
+	}
+	ids := map[string]struct{}{}
+	for _, attr := range cred.AttributeTypes {
+		if attr.RevocationAttribute {
+			continue
+		}
+		if !identifierPattern.MatchString(attr.ID) {
+			return nil, errors.Errorf("invalid attribute ID %q", attr.ID)
+		}
+		if _, ok := ids[attr.ID]; ok {
+			return nil, errors.Errorf("duplicate attribute ID %q", attr.ID)
+		}
+		ids[attr.ID] = struct{}{}
+	}
+	conf := &Configuration{}
+	dir := filepath.Join(scheme.path(), issuer.ID, "Issues", cred.ID)
+	err := conf.validateCredentialType(scheme, issuer, cred, dir)
+	return conf.Warnings, err
+}
+
+// WriteIssuer writes the description of a new issuer to its directory within the scheme,
+// returning the directory.
+func (scheme *SchemeManager) WriteIssuer(issuer *Issuer) (string, error) {
+	return writeDescription(filepath.Join(scheme.path(), issuer.ID), newIssuerXML(issuer))
+}
+
+// WriteCredentialType writes the description of a new credential type to its directory
+// within the scheme, returning the directory.
+func (scheme *SchemeManager) WriteCredentialType(cred *CredentialType) (string, error) {
+	return writeDescription(filepath.Join(scheme.path(), cred.IssuerID, "Issues", cred.ID), newCredentialTypeXML(cred))
+}
+
+func newIssuerXML(issuer *Issuer) *issuerXML {
+	return &issuerXML{
+		XMLVersion:      issuer.XMLVersion,
+		ID:              issuer.ID,
+		Name:            translationPtr(issuer.Name),
+		SchemeManager:   issuer.SchemeManagerID,
+		ContactAddress:  issuer.ContactAddress,
+		ContactEMail:    issuer.ContactEMail,
+		DeprecatedSince: timestampPtr(issuer.DeprecatedSince),
+	}
+}
+
+func newCredentialTypeXML(cred *CredentialType) *credentialTypeXML {
+	x := &credentialTypeXML{
+		XMLVersion:              cred.XMLVersion,
+		Name:                    translationPtr(cred.Name),
+		SchemeManager:           cred.SchemeManagerID,
+		IssuerID:                cred.IssuerID,
+		CredentialID:            cred.ID,
+		Description:             translationPtr(cred.Description),
+		Category:                translationPtr(cred.Category),
+		FAQIntro:                translationPtr(cred.FAQIntro),
+		FAQPurpose:              translationPtr(cred.FAQPurpose),
+		FAQContent:              translationPtr(cred.FAQContent),
+		FAQHowto:                translationPtr(cred.FAQHowto),
+		FAQSummary:              cred.FAQSummary,
+		IssueURL:                translationPtr(cred.IssueURL),
+		IsULIssueURL:            cred.IsULIssueURL,
+		ShouldBeSingleton:       cred.IsSingleton,
+		DisallowDelete:          cred.DisallowDelete,
+		IsInCredentialStore:     cred.IsInCredentialStore,
+		DeprecatedSince:         timestampPtr(cred.DeprecatedSince),
+		ForegroundColor:         cred.ForegroundColor,
+		BackgroundGradientStart: cred.BackgroundGradientStart,
+		BackgroundGradientEnd:   cred.BackgroundGradientEnd,
+		RevocationUpdateCount:   cred.RevocationUpdateCount,
+		RevocationUpdateSpeed:   cred.RevocationUpdateSpeed,
+		Dependencies:            cred.Dependencies,
+	}
+	if len(cred.RevocationServers) > 0 {
+		x.RevocationServers = &struct {
+			URLs []string `xml:"RevocationServer"`
+		}{cred.RevocationServers}
+	}
+	for _, attr := range cred.AttributeTypes {
+		x.Attributes = append(x.Attributes, &attributeXML{
+			ID:           attr.ID,
+			Optional:     attr.Optional,
+			RandomBlind:  attr.RandomBlind,
+			DisplayIndex: attr.DisplayIndex,
+			DisplayHint:  attr.DisplayHint,
+			Revocation:   attr.RevocationAttribute,
+			Name:         translationPtr(attr.Name),
+			Description:  translationPtr(attr.Description),
+		})
+	}
+	return x
+}
+
+// translationPtr returns nil for absent translations, which are then omitted from the XML.
+func translationPtr(ts TranslatedString) *TranslatedString {
+	if ts == nil {
+		return nil
+	}
+	return &ts
+}
+
+// timestampPtr returns nil for zero timestamps, which are then omitted from the XML.
+func timestampPtr(ts Timestamp) *Timestamp {
+	if ts.IsZero() {
+		return nil
+	}
+	return &ts
+}
+
+func readDescription(path string, description interface{}) error {
+	bts, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return xml.Unmarshal(bts, description)
+}
+
+// writeDescription writes description.xml to the specified directory, which is created,
+// refusing to overwrite an existing description.
+func writeDescription(dir string, description interface{}) (string, error) {
+	path := filepath.Join(dir, "description.xml")
+	if err := common.AssertPathNotExists(path); err != nil {
+		return "", errors.Errorf("%s already exists", path)
+	}
+	if err := common.EnsureDirectoryExists(dir); err != nil {
+		return "", err
+	}
+	bts, err := marshalDescriptionXML(description)
+	if err != nil {
+		return "", err
+	}
+	return dir, common.SaveFile(path, bts)
+}
+
+func marshalDescriptionXML(description interface{}) ([]byte, error) {
+	bts, err := xml.MarshalIndent(description, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("%s\n", bts)), nil
+}
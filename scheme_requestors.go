@@ -0,0 +1,300 @@
+package irma
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/irmago/internal/common"
+)
+
+// Helpers for tools that maintain the requestors of a requestor scheme within its directory, before
+// the scheme is (re)signed. The requestors of a scheme are stored in one or more chunks: JSON files
+// containing a RequestorChunk, named requestors.json, requestors-2.json, and so on. Clients only
+// download the chunks that changed, so requestors keep their chunk when the scheme is modified.
+
+const requestorChunkSuffix = ".json"
+
+// ReadRequestorScheme reads the description and the requestors of the requestor scheme in the
+// specified directory, without verifying the signature of the scheme.
+func ReadRequestorScheme(dir string) (*RequestorScheme, error) {
+	filename, err := common.SchemeFilename(dir)
+	if err != nil {
+		return nil, err
+	}
+	if filename != "description.json" {
+		return nil, errors.New("not a requestor scheme")
+	}
+	bts, err := ioutil.ReadFile(filepath.Join(dir, filename))
+	if err != nil {
+		return nil, err
+	}
+	scheme := &RequestorScheme{storagepath: dir, chunks: map[RequestorIdentifier]string{}}
+	if err = json.Unmarshal(bts, scheme); err != nil {
+		return nil, errors.WrapPrefix(err, "failed to parse requestor scheme description", 0)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"+requestorChunkSuffix))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		name := filepath.Base(file)
+		if name == filename {
+			continue
+		}
+		var chunk RequestorChunk
+		if bts, err = ioutil.ReadFile(file); err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(bts, &chunk); err != nil {
+			return nil, errors.WrapPrefix(err, "failed to parse "+name, 0)
+		}
+		for _, requestor := range chunk {
+			if _, ok := scheme.chunks[requestor.ID]; ok {
+				return nil, errors.Errorf("duplicate requestor %s", requestor.ID)
+			}
+			scheme.chunks[requestor.ID] = name
+			scheme.requestors = append(scheme.requestors, requestor)
+		}
+	}
+	return scheme, nil
+}
+
+// Requestors returns the requestors of the scheme.
+func (scheme *RequestorScheme) Requestors() []*RequestorInfo {
+	return scheme.requestors
+}
+
+// Requestor returns the specified requestor, or nil if the scheme does not contain it.
+func (scheme *RequestorScheme) Requestor(id RequestorIdentifier) *RequestorInfo {
+	for _, requestor := range scheme.requestors {
+		if requestor.ID == id {
+			return requestor
+		}
+	}
+	return nil
+}
+
+// SetRequestor adds the requestor to the scheme, or replaces the requestor of the scheme having
+// the same ID. The requestor must not have hostnames that are in use by other requestors.
+func (scheme *RequestorScheme) SetRequestor(requestor *RequestorInfo) error {
+	if requestor.ID.RequestorSchemeIdentifier() != scheme.ID {
+		return errors.Errorf("requestor %s does not belong to scheme %s", requestor.ID, scheme.ID)
+	}
+	if !identifierPattern.MatchString(requestor.ID.Name()) {
+		return errors.Errorf("invalid requestor ID %q", requestor.ID.Name())
+	}
+	requestor.Scheme = scheme.ID
+	if scheme.Demo && len(requestor.Hostnames) > 0 {
+		return errors.New("requestors of demo schemes cannot have hostnames")
+	}
+	if requestor.Logo != nil {
+		if err := scheme.assertLogoExists(*requestor.Logo); err != nil {
+			return err
+		}
+	}
+
+	hostnames := map[string]struct{}{}
+	for _, hostname := range requestor.Hostnames {
+		if _, ok := hostnames[hostname]; ok {
+			return errors.Errorf("duplicate hostname %s", hostname)
+		}
+		hostnames[hostname] = struct{}{}
+	}
+	for _, other := range scheme.requestors {
+		if other.ID == requestor.ID {
+			continue
+		}
+		for _, hostname := range other.Hostnames {
+			if _, ok := hostnames[hostname]; ok {
+				return errors.Errorf("hostname %s is already used by requestor %s", hostname, other.ID)
+			}
+		}
+	}
+
+	for i, other := range scheme.requestors {
+		if other.ID == requestor.ID {
+			scheme.requestors[i] = requestor
+			return nil
+		}
+	}
+	scheme.requestors = append(scheme.requestors, requestor)
+	return nil
+}
+
+// SetIssueWizard adds the issue wizard to its requestor, or replaces the wizard of the requestor
+// having the same ID, returning the warnings that validating the wizard yields. The credential
+// types in the wizard are checked against conf, if it contains their schemes; conf may be nil.
+func (scheme *RequestorScheme) SetIssueWizard(conf *Configuration, wizard *IssueWizard) ([]string, error) {
+	requestor := scheme.Requestor(wizard.ID.RequestorIdentifier())
+	if requestor == nil {
+		return nil, errors.Errorf("requestor %s not found", wizard.ID.RequestorIdentifier())
+	}
+	if !identifierPattern.MatchString(wizard.ID.Name()) {
+		return nil, errors.Errorf("invalid issue wizard ID %q", wizard.ID.Name())
+	}
+	if wizard.Logo != nil {
+		if err := scheme.assertLogoExists(*wizard.Logo); err != nil {
+			return nil, err
+		}
+	}
+	if conf == nil {
+		conf = &Configuration{}
+	}
+	warnings := len(conf.Warnings)
+	if err := wizard.Validate(conf); err != nil {
+		return nil, err
+	}
+	if requestor.Wizards == nil {
+		requestor.Wizards = map[IssueWizardIdentifier]*IssueWizard{}
+	}
+	requestor.Wizards[wizard.ID] = wizard
+	return conf.Warnings[warnings:], nil
+}
+
+// AddLogo stores the specified PNG logo in the assets directory of the scheme, named after the
+// SHA256 hash of its contents, returning the hash to be used as the logo of requestors and
+// issue wizards.
+func (scheme *RequestorScheme) AddLogo(bts []byte) (string, error) {
+	if http.DetectContentType(bts) != "image/png" {
+		return "", errors.New("logo is not a PNG image")
+	}
+	hash := sha256.Sum256(bts)
+	logo := hex.EncodeToString(hash[:])
+	dir := filepath.Join(scheme.path(), "assets")
+	if err := common.EnsureDirectoryExists(dir); err != nil {
+		return "", err
+	}
+	return logo, common.SaveFile(filepath.Join(dir, logo+".png"), bts)
+}
+
+func (scheme *RequestorScheme) assertLogoExists(logo string) error {
+	path := filepath.Join(scheme.path(), "assets", logo+".png")
+	if err := common.AssertPathExists(path); err != nil {
+		return errors.Errorf("logo %s not found in scheme assets", logo)
+	}
+	return nil
+}
+
+// HostnameConflicts returns the hostnames that are used by more than one requestor of the scheme,
+// along with the requestors using them.
+func (scheme *RequestorScheme) HostnameConflicts() map[string][]RequestorIdentifier {
+	users := map[string][]RequestorIdentifier{}
+	for _, requestor := range scheme.requestors {
+		for _, hostname := range requestor.Hostnames {
+			users[hostname] = append(users[hostname], requestor.ID)
+		}
+	}
+	conflicts := map[string][]RequestorIdentifier{}
+	for hostname, ids := range users {
+		if len(ids) > 1 {
+			conflicts[hostname] = ids
+		}
+	}
+	return conflicts
+}
+
+// Rechunk distributes the requestors of the scheme, ordered by their IDs, over chunks containing
+// at most the specified number of requestors, to be written by WriteRequestors().
+func (scheme *RequestorScheme) Rechunk(size int) error {
+	if size < 1 {
+		return errors.New("chunk size must be positive")
+	}
+	sort.Slice(scheme.requestors, func(i, j int) bool {
+		return scheme.requestors[i].ID.String() < scheme.requestors[j].ID.String()
+	})
+	scheme.chunks = map[RequestorIdentifier]string{}
+	for i, requestor := range scheme.requestors {
+		scheme.chunks[requestor.ID] = requestorChunkFilename(i / size)
+	}
+	return nil
+}
+
+// WriteRequestors writes the requestors of the scheme to its chunks. Requestors that were added
+// to the scheme are written to the smallest chunk, or to a new chunk if all chunks already contain
+// chunkSize requestors (if chunkSize is positive). Chunks that no longer contain requestors
+// are removed.
+func (scheme *RequestorScheme) WriteRequestors(chunkSize int) error {
+	if scheme.chunks == nil {
+		scheme.chunks = map[RequestorIdentifier]string{}
+	}
+	chunks := map[string]RequestorChunk{}
+	for _, requestor := range scheme.requestors {
+		if name, ok := scheme.chunks[requestor.ID]; ok {
+			chunks[name] = append(chunks[name], requestor)
+		}
+	}
+	for _, requestor := range scheme.requestors {
+		if _, ok := scheme.chunks[requestor.ID]; ok {
+			continue
+		}
+		name := smallestRequestorChunk(chunks)
+		if name == "" || (chunkSize > 0 && len(chunks[name]) >= chunkSize) {
+			name = nextRequestorChunk(chunks)
+		}
+		scheme.chunks[requestor.ID] = name
+		chunks[name] = append(chunks[name], requestor)
+	}
+
+	for name, chunk := range chunks {
+		bts, err := json.MarshalIndent(chunk, "", "    ")
+		if err != nil {
+			return err
+		}
+		if err = common.SaveFile(filepath.Join(scheme.path(), name), append(bts, '\n')); err != nil {
+			return err
+		}
+	}
+
+	// Remove chunks of which all requestors moved to other chunks
+	files, err := filepath.Glob(filepath.Join(scheme.path(), "*"+requestorChunkSuffix))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		name := filepath.Base(file)
+		if _, ok := chunks[name]; ok || name == "description.json" {
+			continue
+		}
+		if err = os.Remove(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func requestorChunkFilename(i int) string {
+	if i == 0 {
+		return "requestors" + requestorChunkSuffix
+	}
+	return fmt.Sprintf("requestors-%d%s", i+1, requestorChunkSuffix)
+}
+
+// smallestRequestorChunk returns the name of the chunk containing the fewest requestors, if any.
+func smallestRequestorChunk(chunks map[string]RequestorChunk) string {
+	smallest := ""
+	for name, chunk := range chunks {
+		if smallest == "" || len(chunk) < len(chunks[smallest]) ||
+			(len(chunk) == len(chunks[smallest]) && name < smallest) {
+			smallest = name
+		}
+	}
+	return smallest
+}
+
+func nextRequestorChunk(chunks map[string]RequestorChunk) string {
+	for i := 0; ; i++ {
+		if name := requestorChunkFilename(i); chunks[name] == nil {
+			return name
+		}
+	}
+}
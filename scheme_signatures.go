@@ -0,0 +1,207 @@
+package irma
+
+import (
+	"crypto/ecdsa"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/gabi/signed"
+	"github.com/privacybydesign/irmago/internal/common"
+)
+
+// Schemes may be signed by multiple keys, of which a threshold number of signatures is required
+// (M-of-N signatures). The public keys of such schemes are stored in pk.pem as consecutive PEM
+// blocks, the first one of which specifies the threshold M in its "Threshold" header (defaulting
+// to the number of keys). The signature of the i-th key (counting from 0) over the index is stored
+// in index.sig for i = 0, and in index.$i.sig otherwise. Single-key schemes consist of a pk.pem
+// containing a single key and an index.sig, as before; as the first key and its signature use the
+// same files, software that only supports single-key schemes can verify multi-key schemes that are
+// signed by their first key. As pk.pem is excluded from scheme updates, clients keep using the
+// public keys and threshold of the scheme that they installed.
+
+const schemeThresholdHeader = "Threshold"
+
+var schemeSignatureFilePattern = regexp.MustCompile(`^index(\.[1-9]\d*)?\.sig$`)
+
+// ParseSchemePublicKeys parses the contents of the pk.pem file of a scheme into its public keys
+// and the number of signatures of these keys required for the scheme to be valid.
+func ParseSchemePublicKeys(bts []byte) ([]*ecdsa.PublicKey, int, error) {
+	var (
+		pks       []*ecdsa.PublicKey
+		threshold int
+		block     *pem.Block
+		rest      = bts
+	)
+	for {
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		pk, err := signed.UnmarshalPublicKey(block.Bytes)
+		if err != nil {
+			return nil, 0, errors.WrapPrefix(err, "failed to parse scheme public key", 0)
+		}
+		if containsSchemePublicKey(pks, pk) {
+			return nil, 0, errors.New("duplicate scheme public key")
+		}
+		if len(pks) == 0 {
+			if t, ok := block.Headers[schemeThresholdHeader]; ok {
+				if threshold, err = strconv.Atoi(t); err != nil {
+					return nil, 0, errors.Errorf("invalid scheme public key threshold %s", t)
+				}
+			}
+		}
+		pks = append(pks, pk)
+	}
+	if len(pks) == 0 {
+		return nil, 0, errors.New("no scheme public key found")
+	}
+	if threshold == 0 {
+		threshold = len(pks)
+	}
+	if threshold < 1 || threshold > len(pks) {
+		return nil, 0, errors.Errorf("scheme public key threshold %d out of range", threshold)
+	}
+	return pks, threshold, nil
+}
+
+// MarshalSchemePublicKeys encodes the public keys of a scheme along with the required number
+// of signatures in the format of the pk.pem file of a scheme.
+func MarshalSchemePublicKeys(pks []*ecdsa.PublicKey, threshold int) ([]byte, error) {
+	if threshold < 1 || threshold > len(pks) {
+		return nil, errors.Errorf("threshold must be between 1 and %d", len(pks))
+	}
+	var bts []byte
+	for i, pk := range pks {
+		if containsSchemePublicKey(pks[:i], pk) {
+			return nil, errors.New("duplicate public key")
+		}
+		der, err := signed.MarshalPublicKey(pk)
+		if err != nil {
+			return nil, errors.WrapPrefix(err, "Failed to serialize public key", 0)
+		}
+		block := &pem.Block{Type: "PUBLIC KEY", Bytes: der}
+		// Single-key schemes are encoded exactly as before
+		if i == 0 && len(pks) > 1 {
+			block.Headers = map[string]string{schemeThresholdHeader: strconv.Itoa(threshold)}
+		}
+		bts = append(bts, pem.EncodeToMemory(block)...)
+	}
+	return bts, nil
+}
+
+func containsSchemePublicKey(pks []*ecdsa.PublicKey, pk *ecdsa.PublicKey) bool {
+	for _, other := range pks {
+		if pk.X.Cmp(other.X) == 0 && pk.Y.Cmp(other.Y) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// SchemeSignatureFilename returns the name of the file containing the signature over the scheme
+// index of the public key at the specified position in the pk.pem file of the scheme.
+func SchemeSignatureFilename(i int) string {
+	if i == 0 {
+		return "index.sig"
+	}
+	return fmt.Sprintf("index.%d.sig", i)
+}
+
+// VerifySchemeSignatures checks that the index is validly signed by at least the threshold number
+// of the specified public keys, where sigs maps the position of each key to its signature.
+// Invalid signatures (e.g. over a previous version of the index) are not counted.
+func VerifySchemeSignatures(pks []*ecdsa.PublicKey, threshold int, index []byte, sigs map[int][]byte) error {
+	valid := 0
+	var lastErr error
+	for i, pk := range pks {
+		sig, ok := sigs[i]
+		if !ok {
+			continue
+		}
+		if err := signed.Verify(pk, index, sig); err != nil {
+			lastErr = errors.Errorf("%s: %s", SchemeSignatureFilename(i), err.Error())
+			continue
+		}
+		valid++
+	}
+	if valid >= threshold {
+		return nil
+	}
+	if threshold == 1 && lastErr != nil {
+		return lastErr
+	}
+	return errors.Errorf("scheme index has %d valid signatures, %d required", valid, threshold)
+}
+
+func (conf *Configuration) schemePublicKeys(dir string) ([]*ecdsa.PublicKey, int, error) {
+	pkbts, err := ioutil.ReadFile(filepath.Join(dir, "pk.pem"))
+	if err != nil {
+		return nil, 0, err
+	}
+	return ParseSchemePublicKeys(pkbts)
+}
+
+// readSchemeSignatures reads the signatures of the specified number of keys over the index
+// of the scheme in the specified directory, skipping absent ones.
+func readSchemeSignatures(dir string, count int) (map[int][]byte, error) {
+	sigs := map[int][]byte{}
+	for i := 0; i < count; i++ {
+		sig, err := ioutil.ReadFile(filepath.Join(dir, SchemeSignatureFilename(i)))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sigs[i] = sig
+	}
+	return sigs, nil
+}
+
+// downloadSchemeSignatures downloads the signatures of the specified number of keys over the
+// index of the scheme. If the scheme has multiple keys, signatures that are absent (404) are
+// skipped; whether enough signatures are present is checked by VerifySchemeSignatures(). Other
+// errors, such as network errors or server errors, abort the download.
+func downloadSchemeSignatures(t *HTTPTransport, count int) (map[int][]byte, error) {
+	sigs := map[int][]byte{}
+	for i := 0; i < count; i++ {
+		sig, err := t.GetBytes(SchemeSignatureFilename(i))
+		if err != nil {
+			if serr, ok := err.(*SessionError); ok && serr.RemoteStatus == 404 && count > 1 {
+				continue
+			}
+			return nil, err
+		}
+		sigs[i] = sig
+	}
+	return sigs, nil
+}
+
+// writeSchemeSignatures writes the signatures to the scheme in the specified directory,
+// removing any other signature files.
+func writeSchemeSignatures(dir string, sigs map[int][]byte) error {
+	files, err := filepath.Glob(filepath.Join(dir, "index*.sig"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if schemeSignatureFilePattern.MatchString(filepath.Base(file)) {
+			if err = os.Remove(file); err != nil {
+				return err
+			}
+		}
+	}
+	for i, sig := range sigs {
+		if err = common.SaveFile(filepath.Join(dir, SchemeSignatureFilename(i)), sig); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,384 @@
+package irma
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-errors/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Issuer and credential type descriptions may be authored in JSON or YAML instead of XML, and then
+// converted to the canonical XML form (in description.xml) before the scheme is signed. As only
+// XML files are included in the index of issuer schemes, the source files may be kept alongside.
+// The source format contains the same fields as the XML, with camelCase names. A source document
+// describes a credential type if it has an "issuer" field, and an issuer otherwise.
+// Conversion is lossless: parsing the converted description yields the original description.
+
+// DescriptionFormat is a file format for issuer and credential type descriptions.
+type DescriptionFormat string
+
+const (
+	DescriptionFormatXML  DescriptionFormat = "xml"
+	DescriptionFormatJSON DescriptionFormat = "json"
+	DescriptionFormatYAML DescriptionFormat = "yaml"
+)
+
+type (
+	issuerSource struct {
+		Version         int               `json:"version"`
+		Scheme          string            `json:"scheme"`
+		ID              string            `json:"id"`
+		Name            *TranslatedString `json:"name,omitempty"`
+		ContactAddress  string            `json:"contactAddress,omitempty"`
+		ContactEMail    string            `json:"contactEmail,omitempty"`
+		DeprecatedSince *Timestamp        `json:"deprecatedSince,omitempty"`
+	}
+
+	credentialTypeSource struct {
+		Version                 int                    `json:"version"`
+		Scheme                  string                 `json:"scheme"`
+		Issuer                  string                 `json:"issuer"`
+		ID                      string                 `json:"id"`
+		Name                    *TranslatedString      `json:"name,omitempty"`
+		Description             *TranslatedString      `json:"description,omitempty"`
+		Category                *TranslatedString      `json:"category,omitempty"`
+		FAQIntro                *TranslatedString      `json:"faqIntro,omitempty"`
+		FAQPurpose              *TranslatedString      `json:"faqPurpose,omitempty"`
+		FAQContent              *TranslatedString      `json:"faqContent,omitempty"`
+		FAQHowto                *TranslatedString      `json:"faqHowto,omitempty"`
+		FAQSummary              *TranslatedString      `json:"faqSummary,omitempty"`
+		IssueURL                *TranslatedString      `json:"issueUrl,omitempty"`
+		IsULIssueURL            bool                   `json:"isULIssueUrl,omitempty"`
+		Singleton               bool                   `json:"singleton,omitempty"`
+		DisallowDelete          bool                   `json:"disallowDelete,omitempty"`
+		InCredentialStore       bool                   `json:"inCredentialStore,omitempty"`
+		DeprecatedSince         *Timestamp             `json:"deprecatedSince,omitempty"`
+		ForegroundColor         string                 `json:"foregroundColor,omitempty"`
+		BackgroundGradientStart string                 `json:"backgroundGradientStart,omitempty"`
+		BackgroundGradientEnd   string                 `json:"backgroundGradientEnd,omitempty"`
+		RevocationServers       []string               `json:"revocationServers,omitempty"`
+		RevocationUpdateCount   uint64                 `json:"revocationUpdateCount,omitempty"`
+		RevocationUpdateSpeed   uint64                 `json:"revocationUpdateSpeed,omitempty"`
+		Dependencies            CredentialDependencies `json:"dependencies,omitempty"`
+		Attributes              []*attributeTypeSource `json:"attributes,omitempty"`
+	}
+
+	attributeTypeSource struct {
+		ID           string            `json:"id,omitempty"`
+		Name         *TranslatedString `json:"name,omitempty"`
+		Description  *TranslatedString `json:"description,omitempty"`
+		Optional     optionalFlag      `json:"optional,omitempty"`
+		RandomBlind  bool              `json:"randomBlind,omitempty"`
+		DisplayIndex *int              `json:"displayIndex,omitempty"`
+		DisplayHint  string            `json:"displayHint,omitempty"`
+		Revocation   bool              `json:"revocation,omitempty"`
+	}
+
+	// optionalFlag is the optional attribute of attribute types, which is a string in the XML.
+	// In the source format it is a boolean, or a string for values other than "true" and "false".
+	optionalFlag string
+)
+
+// MarshalJSON implements json.Marshaler.
+func (o optionalFlag) MarshalJSON() ([]byte, error) {
+	if o == "true" || o == "false" {
+		return []byte(o), nil
+	}
+	return json.Marshal(string(o))
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (o *optionalFlag) UnmarshalJSON(bts []byte) error {
+	var b bool
+	if err := json.Unmarshal(bts, &b); err == nil {
+		if b {
+			*o = "true"
+		} else {
+			*o = "false"
+		}
+		return nil
+	}
+	return json.Unmarshal(bts, (*string)(o))
+}
+
+// DescriptionFormatOf returns the description format of the specified file, based on its extension.
+func DescriptionFormatOf(filename string) (DescriptionFormat, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".xml":
+		return DescriptionFormatXML, nil
+	case ".json":
+		return DescriptionFormatJSON, nil
+	case ".yaml", ".yml":
+		return DescriptionFormatYAML, nil
+	default:
+		return "", errors.Errorf("unknown description format of %s", filename)
+	}
+}
+
+// ParseDescription parses an issuer or credential type description in the specified format,
+// returning an *Issuer or a *CredentialType.
+func ParseDescription(bts []byte, format DescriptionFormat) (interface{}, error) {
+	switch format {
+	case DescriptionFormatXML:
+		return parseDescriptionXML(bts)
+	case DescriptionFormatYAML:
+		var doc interface{}
+		if err := yaml.Unmarshal(bts, &doc); err != nil {
+			return nil, errors.WrapPrefix(err, "failed to parse YAML", 0)
+		}
+		var err error
+		if bts, err = json.Marshal(doc); err != nil {
+			return nil, errors.WrapPrefix(err, "failed to parse YAML", 0)
+		}
+		return parseDescriptionJSON(bts)
+	case DescriptionFormatJSON:
+		return parseDescriptionJSON(bts)
+	default:
+		return nil, errors.Errorf("unknown description format %s", format)
+	}
+}
+
+// MarshalDescription encodes an *Issuer or a *CredentialType in the specified format.
+func MarshalDescription(description interface{}, format DescriptionFormat) ([]byte, error) {
+	var x, source interface{}
+	switch d := description.(type) {
+	case *Issuer:
+		x, source = newIssuerXML(d), newIssuerSource(d)
+	case *CredentialType:
+		x, source = newCredentialTypeXML(d), newCredentialTypeSource(d)
+	default:
+		return nil, errors.Errorf("cannot marshal description of type %T", description)
+	}
+
+	switch format {
+	case DescriptionFormatXML:
+		return marshalDescriptionXML(x)
+	case DescriptionFormatJSON:
+		bts, err := json.MarshalIndent(source, "", "    ")
+		if err != nil {
+			return nil, err
+		}
+		return append(bts, '\n'), nil
+	case DescriptionFormatYAML:
+		bts, err := json.Marshal(source)
+		if err != nil {
+			return nil, err
+		}
+		// Parse the JSON as YAML (of which JSON is a subset) to obtain the fields in order
+		var node yaml.Node
+		if err = yaml.Unmarshal(bts, &node); err != nil {
+			return nil, err
+		}
+		resetYAMLStyle(&node)
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err = enc.Encode(&node); err != nil {
+			return nil, err
+		}
+		if err = enc.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, errors.Errorf("unknown description format %s", format)
+	}
+}
+
+// ConvertDescription converts an issuer or credential type description between formats.
+func ConvertDescription(bts []byte, from, to DescriptionFormat) ([]byte, error) {
+	description, err := ParseDescription(bts, from)
+	if err != nil {
+		return nil, err
+	}
+	return MarshalDescription(description, to)
+}
+
+func parseDescriptionXML(bts []byte) (interface{}, error) {
+	// Determine the type of description from the root element
+	dec := xml.NewDecoder(bytes.NewReader(bts))
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, errors.WrapPrefix(err, "failed to parse XML", 0)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		var description interface{}
+		switch start.Name.Local {
+		case "Issuer":
+			description = &Issuer{}
+		case "IssueSpecification":
+			description = &CredentialType{}
+		default:
+			return nil, errors.Errorf("unknown description root element <%s>", start.Name.Local)
+		}
+		if err = xml.Unmarshal(bts, description); err != nil {
+			return nil, errors.WrapPrefix(err, "failed to parse XML", 0)
+		}
+		return description, nil
+	}
+}
+
+func parseDescriptionJSON(bts []byte) (interface{}, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(bts, &fields); err != nil {
+		return nil, errors.WrapPrefix(err, "failed to parse description", 0)
+	}
+
+	// Reject unknown fields, so that typos in field names do not go unnoticed
+	dec := json.NewDecoder(bytes.NewReader(bts))
+	dec.DisallowUnknownFields()
+	if _, ok := fields["issuer"]; !ok {
+		source := &issuerSource{}
+		if err := dec.Decode(source); err != nil {
+			return nil, errors.WrapPrefix(err, "failed to parse issuer description", 0)
+		}
+		return source.issuer(), nil
+	}
+	source := &credentialTypeSource{}
+	if err := dec.Decode(source); err != nil {
+		return nil, errors.WrapPrefix(err, "failed to parse credential type description", 0)
+	}
+	return source.credentialType(), nil
+}
+
+func newIssuerSource(issuer *Issuer) *issuerSource {
+	return &issuerSource{
+		Version:         issuer.XMLVersion,
+		Scheme:          issuer.SchemeManagerID,
+		ID:              issuer.ID,
+		Name:            translationPtr(issuer.Name),
+		ContactAddress:  issuer.ContactAddress,
+		ContactEMail:    issuer.ContactEMail,
+		DeprecatedSince: timestampPtr(issuer.DeprecatedSince),
+	}
+}
+
+func (source *issuerSource) issuer() *Issuer {
+	return &Issuer{
+		XMLVersion:      source.Version,
+		SchemeManagerID: source.Scheme,
+		ID:              source.ID,
+		Name:            translation(source.Name),
+		ContactAddress:  source.ContactAddress,
+		ContactEMail:    source.ContactEMail,
+		DeprecatedSince: timestamp(source.DeprecatedSince),
+	}
+}
+
+func newCredentialTypeSource(cred *CredentialType) *credentialTypeSource {
+	source := &credentialTypeSource{
+		Version:                 cred.XMLVersion,
+		Scheme:                  cred.SchemeManagerID,
+		Issuer:                  cred.IssuerID,
+		ID:                      cred.ID,
+		Name:                    translationPtr(cred.Name),
+		Description:             translationPtr(cred.Description),
+		Category:                translationPtr(cred.Category),
+		FAQIntro:                translationPtr(cred.FAQIntro),
+		FAQPurpose:              translationPtr(cred.FAQPurpose),
+		FAQContent:              translationPtr(cred.FAQContent),
+		FAQHowto:                translationPtr(cred.FAQHowto),
+		FAQSummary:              cred.FAQSummary,
+		IssueURL:                translationPtr(cred.IssueURL),
+		IsULIssueURL:            cred.IsULIssueURL,
+		Singleton:               cred.IsSingleton,
+		DisallowDelete:          cred.DisallowDelete,
+		InCredentialStore:       cred.IsInCredentialStore,
+		DeprecatedSince:         timestampPtr(cred.DeprecatedSince),
+		ForegroundColor:         cred.ForegroundColor,
+		BackgroundGradientStart: cred.BackgroundGradientStart,
+		BackgroundGradientEnd:   cred.BackgroundGradientEnd,
+		RevocationServers:       cred.RevocationServers,
+		RevocationUpdateCount:   cred.RevocationUpdateCount,
+		RevocationUpdateSpeed:   cred.RevocationUpdateSpeed,
+		Dependencies:            cred.Dependencies,
+	}
+	for _, attr := range cred.AttributeTypes {
+		source.Attributes = append(source.Attributes, &attributeTypeSource{
+			ID:           attr.ID,
+			Name:         translationPtr(attr.Name),
+			Description:  translationPtr(attr.Description),
+			Optional:     optionalFlag(attr.Optional),
+			RandomBlind:  attr.RandomBlind,
+			DisplayIndex: attr.DisplayIndex,
+			DisplayHint:  attr.DisplayHint,
+			Revocation:   attr.RevocationAttribute,
+		})
+	}
+	return source
+}
+
+func (source *credentialTypeSource) credentialType() *CredentialType {
+	cred := &CredentialType{
+		XMLVersion:              source.Version,
+		XMLName:                 xml.Name{Local: "IssueSpecification"},
+		SchemeManagerID:         source.Scheme,
+		IssuerID:                source.Issuer,
+		ID:                      source.ID,
+		Name:                    translation(source.Name),
+		Description:             translation(source.Description),
+		Category:                translation(source.Category),
+		FAQIntro:                translation(source.FAQIntro),
+		FAQPurpose:              translation(source.FAQPurpose),
+		FAQContent:              translation(source.FAQContent),
+		FAQHowto:                translation(source.FAQHowto),
+		FAQSummary:              source.FAQSummary,
+		IssueURL:                translation(source.IssueURL),
+		IsULIssueURL:            source.IsULIssueURL,
+		IsSingleton:             source.Singleton,
+		DisallowDelete:          source.DisallowDelete,
+		IsInCredentialStore:     source.InCredentialStore,
+		DeprecatedSince:         timestamp(source.DeprecatedSince),
+		ForegroundColor:         source.ForegroundColor,
+		BackgroundGradientStart: source.BackgroundGradientStart,
+		BackgroundGradientEnd:   source.BackgroundGradientEnd,
+		RevocationServers:       source.RevocationServers,
+		RevocationUpdateCount:   source.RevocationUpdateCount,
+		RevocationUpdateSpeed:   source.RevocationUpdateSpeed,
+		Dependencies:            source.Dependencies,
+	}
+	for _, attr := range source.Attributes {
+		cred.AttributeTypes = append(cred.AttributeTypes, &AttributeType{
+			ID:                  attr.ID,
+			Name:                translation(attr.Name),
+			Description:         translation(attr.Description),
+			Optional:            string(attr.Optional),
+			RandomBlind:         attr.RandomBlind,
+			DisplayIndex:        attr.DisplayIndex,
+			DisplayHint:         attr.DisplayHint,
+			RevocationAttribute: attr.Revocation,
+		})
+	}
+	return cred
+}
+
+func translation(ts *TranslatedString) TranslatedString {
+	if ts == nil {
+		return nil
+	}
+	return *ts
+}
+
+func timestamp(ts *Timestamp) Timestamp {
+	if ts == nil {
+		return Timestamp{}
+	}
+	return *ts
+}
+
+// resetYAMLStyle removes the JSON styling (flow style and quoted strings) from the node,
+// so that it is encoded as idiomatic YAML.
+func resetYAMLStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		resetYAMLStyle(child)
+	}
+}
@@ -0,0 +1,253 @@
+package irma
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/irmago/internal/common"
+)
+
+// A scheme transparency log is an append-only, hash-chained log of the indices of a scheme that
+// were published, stored in the scheme directory in the file transparency.log (which is not itself
+// in the index). Each line of the log is an entry in JSON, containing the SHA256 hash of the index
+// and the timestamp of the scheme, and the SHA256 hash of the previous entry. The log is appended
+// to by "irma scheme sign". When updating a scheme, the new log must extend the log of the current
+// version, and must contain the new index. As everyone updating the scheme obtains the same log,
+// a malicious update targeted at some users (e.g. signed using a stolen key) shows up in the log,
+// or results in a fork of the log, which can be detected by comparing logs from different sources
+// using AuditSchemeLogs() (or "irma scheme audit").
+
+// SchemeLogFilename is the name of the file containing the transparency log of a scheme.
+const SchemeLogFilename = "transparency.log"
+
+type (
+	// SchemeLog is a scheme transparency log.
+	SchemeLog []*SchemeLogEntry
+
+	// SchemeLogEntry is an entry of a scheme transparency log.
+	SchemeLogEntry struct {
+		Sequence  uint64    `json:"seq"`
+		Timestamp Timestamp `json:"timestamp"`
+		// Index is the hex-encoded SHA256 hash of the index
+		Index string `json:"index"`
+		// Previous is the hex-encoded SHA256 hash of the previous entry, absent for the first entry
+		Previous string `json:"prev,omitempty"`
+	}
+
+	// SchemeLogFork describes two logs that diverge at the specified entry.
+	SchemeLogFork struct {
+		Sequence uint64
+		Entries  [2]*SchemeLogEntry
+	}
+)
+
+// ParseSchemeLog parses the specified transparency log, and checks that it is a valid hash chain.
+func ParseSchemeLog(bts []byte) (SchemeLog, error) {
+	var log SchemeLog
+	scanner := bufio.NewScanner(bytes.NewReader(bts))
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		entry := &SchemeLogEntry{}
+		if err := json.Unmarshal(line, entry); err != nil {
+			return nil, errors.WrapPrefix(err, "invalid scheme log entry", 0)
+		}
+		log = append(log, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := log.verify(); err != nil {
+		return nil, err
+	}
+	return log, nil
+}
+
+func (log SchemeLog) verify() error {
+	for i, entry := range log {
+		if entry.Sequence != uint64(i) {
+			return errors.Errorf("scheme log entry %d has sequence number %d", i, entry.Sequence)
+		}
+		if _, err := hex.DecodeString(entry.Index); err != nil || len(entry.Index) != 2*sha256.Size {
+			return errors.Errorf("scheme log entry %d has invalid index hash", i)
+		}
+		if i == 0 {
+			if entry.Previous != "" {
+				return errors.New("first scheme log entry refers to a previous entry")
+			}
+			continue
+		}
+		if entry.Previous != log[i-1].hash() {
+			return errors.Errorf("scheme log entry %d does not refer to the previous entry", i)
+		}
+		if entry.Timestamp.Before(log[i-1].Timestamp) {
+			return errors.Errorf("scheme log entry %d has a timestamp before that of the previous entry", i)
+		}
+	}
+	return nil
+}
+
+func (entry *SchemeLogEntry) hash() string {
+	bts, _ := json.Marshal(entry)
+	sum := sha256.Sum256(bts)
+	return hex.EncodeToString(sum[:])
+}
+
+// Append returns the log with a new entry for the specified index and timestamp appended.
+func (log SchemeLog) Append(index []byte, timestamp Timestamp) SchemeLog {
+	sum := sha256.Sum256(index)
+	entry := &SchemeLogEntry{
+		Sequence:  uint64(len(log)),
+		Timestamp: timestamp,
+		Index:     hex.EncodeToString(sum[:]),
+	}
+	if len(log) > 0 {
+		entry.Previous = log[len(log)-1].hash()
+	}
+	return append(log, entry)
+}
+
+// Bytes returns the log in the format of the transparency.log file.
+func (log SchemeLog) Bytes() []byte {
+	var buf bytes.Buffer
+	for _, entry := range log {
+		bts, _ := json.Marshal(entry)
+		buf.Write(bts)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// Contains returns the entry for the specified index, or nil if it is not in the log.
+func (log SchemeLog) Contains(index []byte) *SchemeLogEntry {
+	sum := sha256.Sum256(index)
+	hash := hex.EncodeToString(sum[:])
+	for i := len(log) - 1; i >= 0; i-- {
+		if log[i].Index == hash {
+			return log[i]
+		}
+	}
+	return nil
+}
+
+// Fork returns where the two logs diverge, or nil if one of them extends the other.
+func (log SchemeLog) Fork(other SchemeLog) *SchemeLogFork {
+	for i := 0; i < len(log) && i < len(other); i++ {
+		if log[i].hash() != other[i].hash() {
+			return &SchemeLogFork{Sequence: uint64(i), Entries: [2]*SchemeLogEntry{log[i], other[i]}}
+		}
+	}
+	return nil
+}
+
+// AuditSchemeLogs checks that the specified logs, e.g. obtained from different sources, are
+// consistent with each other: for each pair of logs, one must extend the other.
+// The forks between any pair of logs are returned, keyed by the positions of the logs.
+func AuditSchemeLogs(logs ...SchemeLog) map[[2]int]*SchemeLogFork {
+	forks := map[[2]int]*SchemeLogFork{}
+	for i := range logs {
+		for j := i + 1; j < len(logs); j++ {
+			if fork := logs[i].Fork(logs[j]); fork != nil {
+				forks[[2]int{i, j}] = fork
+			}
+		}
+	}
+	return forks
+}
+
+// ReadSchemeLog reads the transparency log of the scheme in the specified directory,
+// returning nil if the scheme has no log.
+func ReadSchemeLog(dir string) (SchemeLog, error) {
+	bts, err := ioutil.ReadFile(filepath.Join(dir, SchemeLogFilename))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseSchemeLog(bts)
+}
+
+// AppendSchemeLog appends an entry for the current index and timestamp of the scheme in the
+// specified directory to its transparency log, creating it if necessary.
+func AppendSchemeLog(dir string) error {
+	log, err := ReadSchemeLog(dir)
+	if err != nil {
+		return err
+	}
+	index, err := ioutil.ReadFile(filepath.Join(dir, "index"))
+	if err != nil {
+		return err
+	}
+	timestamp, exists, err := readTimestamp(filepath.Join(dir, "timestamp"))
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errors.New("scheme has no timestamp")
+	}
+	return common.SaveFile(filepath.Join(dir, SchemeLogFilename), log.Append(index, *timestamp).Bytes())
+}
+
+// updateSchemeLog downloads the transparency log of the scheme, if present (i.e., unless the
+// server responds with 404), and checks the new index against it using verifySchemeLog(),
+// storing it in the updated scheme directory.
+func (conf *Configuration) updateSchemeLog(scheme Scheme, newschemepath string, index []byte) error {
+	t := NewHTTPTransport(conf.schemeURL(scheme.id(), scheme.url()), true)
+	log, err := t.GetBytes(SchemeLogFilename)
+	if err != nil {
+		// Only a 404 means that the scheme has no log, in which case verifySchemeLog() checks
+		// whether the scheme must have one; other errors abort the update
+		if serr, ok := err.(*SessionError); !ok || serr.RemoteStatus != 404 {
+			return errors.WrapPrefix(err, "failed to download scheme transparency log", 0)
+		}
+		log = nil
+	}
+	if err = verifySchemeLog(scheme.path(), log, index); err != nil {
+		return err
+	}
+	if log == nil {
+		return nil
+	}
+	return common.SaveFile(filepath.Join(newschemepath, SchemeLogFilename), log)
+}
+
+// verifySchemeLog checks that the new transparency log of a scheme (nil if absent) is consistent
+// with the log of the current version of the scheme in the specified directory, and that it
+// contains the new index. Schemes without a log may be updated to versions without a log.
+func verifySchemeLog(dir string, newlog []byte, index []byte) error {
+	oldlog, err := ReadSchemeLog(dir)
+	if err != nil {
+		return err
+	}
+	if newlog == nil {
+		if oldlog != nil {
+			return errors.New("scheme transparency log is missing")
+		}
+		return nil
+	}
+
+	log, err := ParseSchemeLog(newlog)
+	if err != nil {
+		return err
+	}
+	if len(log) < len(oldlog) {
+		return errors.New("scheme transparency log was truncated")
+	}
+	if fork := oldlog.Fork(log); fork != nil {
+		return errors.Errorf("scheme transparency log was modified at entry %d", fork.Sequence)
+	}
+	if log.Contains(index) == nil {
+		return errors.New("scheme index is not in the scheme transparency log")
+	}
+	return nil
+}
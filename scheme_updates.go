@@ -0,0 +1,224 @@
+package irma
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+type (
+	// SchemeUpdate describes the changes made to a scheme by UpdateScheme(), UpdateSchemeBundle()
+	// or RollbackScheme(), or the error that occurred while updating it. It is delivered to the handlers registered using
+	// Configuration.SubscribeSchemeUpdates().
+	SchemeUpdate struct {
+		Scheme       string
+		Type         SchemeType
+		OldTimestamp Timestamp
+		// NewTimestamp is the timestamp of the updated scheme, or zero if updating failed
+		NewTimestamp Timestamp
+
+		// Added contains the identifiers of issuers, credential types, attribute types and
+		// public keys that were added to the scheme; Removed contains those that were removed
+		// from it; and Changed contains the scheme itself, and the issuers, credential types,
+		// attribute types and public keys that were present before and after the update but
+		// were modified by it. All three are nil if updating failed.
+		Added, Removed, Changed *IrmaIdentifierSet
+
+		// Error is the error that occurred while updating, if any
+		Error error
+	}
+
+	// SchemeUpdateHandler is a function that is called after a scheme was updated,
+	// or updating it failed.
+	SchemeUpdateHandler func(*SchemeUpdate)
+
+	schemeUpdateSubscriptions struct {
+		sync.Mutex
+		next     int
+		handlers map[int]SchemeUpdateHandler
+	}
+)
+
+// SubscribeSchemeUpdates registers the handler, which is invoked after each time UpdateScheme()
+// (and so UpdateSchemes() and AutoUpdateSchemes()), UpdateSchemeBundle() or RollbackScheme()
+// updated a scheme, or failed to do so. Nothing is delivered for schemes that are up-to-date.
+// The handler is invoked synchronously from the updating goroutine after the Configuration has
+// been updated, so it should not block.
+// The returned function unregisters the handler.
+func (conf *Configuration) SubscribeSchemeUpdates(handler SchemeUpdateHandler) (unsubscribe func()) {
+	subs := &conf.updateSubscriptions
+	subs.Lock()
+	defer subs.Unlock()
+	if subs.handlers == nil {
+		subs.handlers = map[int]SchemeUpdateHandler{}
+	}
+	id := subs.next
+	subs.next++
+	subs.handlers[id] = handler
+	return func() {
+		subs.Lock()
+		defer subs.Unlock()
+		delete(subs.handlers, id)
+	}
+}
+
+func (conf *Configuration) notifySchemeUpdate(update *SchemeUpdate) {
+	subs := &conf.updateSubscriptions
+	subs.Lock()
+	ids := make([]int, 0, len(subs.handlers))
+	for id := range subs.handlers {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	handlers := make([]SchemeUpdateHandler, 0, len(ids))
+	for _, id := range ids {
+		handlers = append(handlers, subs.handlers[id])
+	}
+	subs.Unlock()
+
+	for _, handler := range handlers {
+		handler(update)
+	}
+}
+
+// finishSchemeUpdate notifies the subscribers of the update of the scheme, or of err if updating
+// it failed. Nothing is delivered if neither is present, i.e. if the scheme was up-to-date.
+func (conf *Configuration) finishSchemeUpdate(oldscheme Scheme, update *SchemeUpdate, err error) {
+	if err != nil {
+		conf.notifySchemeUpdate(&SchemeUpdate{
+			Scheme: oldscheme.id(), Type: oldscheme.typ(), OldTimestamp: oldscheme.timestamp(), Error: err,
+		})
+	} else if update != nil {
+		conf.notifySchemeUpdate(update)
+	}
+}
+
+// changedSchemeFiles records in changed the identifiers of the entities whose files differ between
+// the scheme and its new version at newschemepath.
+func (conf *Configuration) changedSchemeFiles(oldscheme, newscheme Scheme, newschemepath string, changed *IrmaIdentifierSet) error {
+	oldIndex := oldscheme.idx()
+	for path, hash := range newscheme.idx() {
+		if oldhash, ok := oldIndex[path]; ok && oldhash.Equal(hash) {
+			continue
+		}
+		pathStripped := path[len(newscheme.id())+1:]
+		bts, err := ioutil.ReadFile(filepath.Join(newschemepath, filepath.FromSlash(pathStripped)))
+		if err != nil {
+			return err
+		}
+		if err = newscheme.handleUpdateFile(conf, newschemepath, pathStripped, bts, nil, changed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// schemeUpdate computes the changes between the scheme currently in the configuration and its
+// updated version, which has been parsed into newconf. The changed set contains the identifiers
+// of entities whose files were downloaded during the update.
+func (conf *Configuration) schemeUpdate(oldscheme, newscheme Scheme, newconf *Configuration, changed *IrmaIdentifierSet) (*SchemeUpdate, error) {
+	update := &SchemeUpdate{
+		Scheme:       newscheme.id(),
+		Type:         newscheme.typ(),
+		OldTimestamp: oldscheme.timestamp(),
+		NewTimestamp: newscheme.timestamp(),
+		Added:        newIrmaIdentifierSet(),
+		Removed:      newIrmaIdentifierSet(),
+		Changed:      newIrmaIdentifierSet(),
+	}
+	if newscheme.typ() == SchemeTypeRequestor {
+		update.Changed.RequestorSchemes[NewRequestorSchemeIdentifier(newscheme.id())] = struct{}{}
+		return update, nil
+	}
+
+	id := NewSchemeManagerIdentifier(newscheme.id())
+	update.Changed.SchemeManagers[id] = struct{}{}
+
+	for issid := range changed.Issuers {
+		if _, ok := conf.Issuers[issid]; ok {
+			update.Changed.Issuers[issid] = struct{}{}
+		} else {
+			update.Added.Issuers[issid] = struct{}{}
+		}
+	}
+	for issid := range conf.Issuers {
+		if _, ok := newconf.Issuers[issid]; !ok && issid.SchemeManagerIdentifier() == id {
+			update.Removed.Issuers[issid] = struct{}{}
+		}
+	}
+
+	for credid := range changed.CredentialTypes {
+		if _, ok := conf.CredentialTypes[credid]; ok {
+			update.Changed.CredentialTypes[credid] = struct{}{}
+		} else {
+			update.Added.CredentialTypes[credid] = struct{}{}
+		}
+	}
+	for credid := range conf.CredentialTypes {
+		if _, ok := newconf.CredentialTypes[credid]; !ok && credid.SchemeManagerIdentifier() == id {
+			update.Removed.CredentialTypes[credid] = struct{}{}
+		}
+	}
+
+	for attrid, newattr := range newconf.AttributeTypes {
+		oldattr, ok := conf.AttributeTypes[attrid]
+		if !ok {
+			update.Added.AttributeTypes[attrid] = struct{}{}
+		} else if !reflect.DeepEqual(oldattr, newattr) {
+			update.Changed.AttributeTypes[attrid] = struct{}{}
+		}
+	}
+	for attrid := range conf.AttributeTypes {
+		if _, ok := newconf.AttributeTypes[attrid]; !ok && attrid.CredentialTypeIdentifier().SchemeManagerIdentifier() == id {
+			update.Removed.AttributeTypes[attrid] = struct{}{}
+		}
+	}
+
+	// Public keys of all issuers before and after the update
+	issuers := map[IssuerIdentifier]struct{}{}
+	for issid := range newconf.Issuers {
+		issuers[issid] = struct{}{}
+	}
+	for issid := range update.Removed.Issuers {
+		issuers[issid] = struct{}{}
+	}
+	for issid := range issuers {
+		var oldkeys, newkeys []uint
+		var err error
+		if _, ok := conf.Issuers[issid]; ok {
+			if oldkeys, err = conf.PublicKeyIndices(issid); err != nil {
+				return nil, err
+			}
+		}
+		if _, ok := newconf.Issuers[issid]; ok {
+			if newkeys, err = newconf.PublicKeyIndices(issid); err != nil {
+				return nil, err
+			}
+		}
+		for _, counter := range newkeys {
+			if !containsCounter(oldkeys, counter) {
+				update.Added.PublicKeys[issid] = append(update.Added.PublicKeys[issid], counter)
+			} else if containsCounter(changed.PublicKeys[issid], counter) {
+				update.Changed.PublicKeys[issid] = append(update.Changed.PublicKeys[issid], counter)
+			}
+		}
+		for _, counter := range oldkeys {
+			if !containsCounter(newkeys, counter) {
+				update.Removed.PublicKeys[issid] = append(update.Removed.PublicKeys[issid], counter)
+			}
+		}
+	}
+
+	return update, nil
+}
+
+func containsCounter(counters []uint, counter uint) bool {
+	for _, c := range counters {
+		if c == counter {
+			return true
+		}
+	}
+	return false
+}
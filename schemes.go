@@ -2,13 +2,13 @@ package irma
 
 import (
 	"bytes"
-	"crypto/ecdsa"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
 	"reflect"
 	"regexp"
@@ -17,7 +17,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/privacybydesign/gabi/signed"
 	"github.com/privacybydesign/irmago/internal/common"
 	"github.com/sirupsen/logrus"
 
@@ -62,7 +61,7 @@ type (
 		setPath(path string)
 		parseContents(conf *Configuration) error
 		validate(conf *Configuration) (error, SchemeManagerStatus)
-		update() error
+		update(conf *Configuration) error
 		handleUpdateFile(conf *Configuration, path, filename string, bts []byte, transport *HTTPTransport, _ *IrmaIdentifierSet) error
 		delete(conf *Configuration) error
 		add(conf *Configuration)
@@ -111,8 +110,9 @@ const (
 func (conf *Configuration) DownloadDefaultSchemes() error {
 	Logger.Info("downloading default schemes (may take a while)")
 	for _, s := range DefaultSchemes {
-		Logger.WithFields(logrus.Fields{"url": s.URL}).Debugf("Downloading scheme")
-		if err := conf.installScheme(s.URL, s.Publickey, ""); err != nil {
+		url := conf.schemeURL(path.Base(s.URL), s.URL)
+		Logger.WithFields(logrus.Fields{"url": url}).Debugf("Downloading scheme")
+		if err := conf.installScheme(url, s.Publickey, ""); err != nil {
 			return err
 		}
 	}
@@ -173,7 +173,9 @@ func (conf *Configuration) UpdateSchemes() error {
 // with the remote version at the scheme's URL, downloading and storing
 // new and modified files, according to the index files of both versions.
 // It stores the identifiers of new or updated entities in the second parameter.
-func (conf *Configuration) UpdateScheme(scheme Scheme, downloaded *IrmaIdentifierSet) error {
+// Subscribers registered with SubscribeSchemeUpdates() are notified of the changes
+// if the scheme was updated, or of the error if updating failed.
+func (conf *Configuration) UpdateScheme(scheme Scheme, downloaded *IrmaIdentifierSet) (err error) {
 	if conf.readOnly {
 		return errors.New("cannot update a read-only configuration")
 	}
@@ -185,9 +187,16 @@ func (conf *Configuration) UpdateScheme(scheme Scheme, downloaded *IrmaIdentifie
 		typ        = string(scheme.typ())
 		id         = scheme.id()
 		schemepath = scheme.path()
+		oldscheme  = scheme
+		update     *SchemeUpdate
+		changed    = newIrmaIdentifierSet()
 	)
+	defer func() {
+		conf.finishSchemeUpdate(oldscheme, update, err)
+	}()
+
 	Logger.WithFields(logrus.Fields{"scheme": id, "type": typ}).Info("checking for updates")
-	shouldUpdate, _, index, err := conf.checkRemoteScheme(scheme)
+	shouldUpdate, indexbts, sigs, index, err := conf.checkRemoteScheme(scheme)
 	if err != nil {
 		return err
 	}
@@ -213,8 +222,19 @@ func (conf *Configuration) UpdateScheme(scheme Scheme, downloaded *IrmaIdentifie
 		_ = os.RemoveAll(dir)
 	}()
 
+	// save the index and its signature against which we authenticated the timestamp
+	// for future use: as they are themselves not in the index, the loop below doesn't touch them
+	if err = conf.writeIndex(newschemepath, indexbts, sigs); err != nil {
+		return err
+	}
+
+	// check that the new index is consistent with the transparency log of the scheme, if any
+	if err = conf.updateSchemeLog(scheme, newschemepath, indexbts); err != nil {
+		return err
+	}
+
 	// iterate over the index and download new and changed files into the temp dir
-	if err = conf.updateSchemeFiles(scheme, index, newschemepath, downloaded); err != nil {
+	if err = conf.updateSchemeFiles(scheme, index, newschemepath, changed); err != nil {
 		return err
 	}
 
@@ -226,7 +246,10 @@ func (conf *Configuration) UpdateScheme(scheme Scheme, downloaded *IrmaIdentifie
 	if scheme, err = newconf.ParseSchemeFolder(newschemepath); err != nil {
 		return err
 	}
-	if err = scheme.update(); err != nil {
+	if err = scheme.update(conf); err != nil {
+		return err
+	}
+	if update, err = conf.schemeUpdate(oldscheme, scheme, newconf, changed); err != nil {
 		return err
 	}
 
@@ -237,6 +260,9 @@ func (conf *Configuration) UpdateScheme(scheme Scheme, downloaded *IrmaIdentifie
 
 	scheme.purge(conf)
 	conf.join(newconf)
+	if downloaded != nil {
+		downloaded.join(changed)
+	}
 	return nil
 }
 
@@ -260,6 +286,7 @@ func (conf *Configuration) ParseSchemeFolder(dir string) (scheme Scheme, serr er
 	// anything, and handle accordingly.
 	scheme.add(conf)
 	defer func() {
+		conf.invalidateCatalog() // rebuild the search index with the new contents
 		if serr != nil {
 			scheme.setStatus(serr.(*SchemeManagerError).Status)
 		}
@@ -292,7 +319,7 @@ func (conf *Configuration) updateSchemeFiles(
 	scheme Scheme, index SchemeManagerIndex, newschemepath string, downloaded *IrmaIdentifierSet,
 ) error {
 	var (
-		transport = NewHTTPTransport(scheme.url(), true)
+		transport = NewHTTPTransport(conf.schemeURL(scheme.id(), scheme.url()), true)
 		oldIndex  = scheme.idx()
 		id        = scheme.id()
 	)
@@ -430,7 +457,7 @@ func (conf *Configuration) reinstallSchemeFromRemote(scheme Scheme) error {
 	if err = scheme.delete(conf); err != nil {
 		return err
 	}
-	return conf.installScheme(scheme.url(), pkbts, filepath.Base(scheme.path()))
+	return conf.installScheme(conf.schemeURL(scheme.id(), scheme.url()), pkbts, filepath.Base(scheme.path()))
 }
 
 // newSchemeDir returns the name of a newly created directory into which a scheme can be installed:
@@ -502,56 +529,61 @@ func (conf *Configuration) installScheme(url string, publickey []byte, dir strin
 	return conf.UpdateScheme(scheme, nil)
 }
 
-func (conf *Configuration) checkRemoteScheme(scheme Scheme) (bool, *Timestamp, SchemeManagerIndex, error) {
-	timestamp, indexbts, sigbts, index, err := conf.checkRemoteTimestamp(scheme)
+// checkRemoteScheme returns whether the scheme should be updated to the remote version,
+// along with the remote index, its signatures, and the parsed index.
+func (conf *Configuration) checkRemoteScheme(scheme Scheme) (bool, []byte, map[int][]byte, SchemeManagerIndex, error) {
+	timestamp, indexbts, sigs, index, err := conf.checkRemoteTimestamp(scheme)
 	if err != nil {
-		return false, nil, nil, err
+		return false, nil, nil, nil, err
 	}
 	id := scheme.id()
 	typ := string(scheme.typ())
 	timestampdiff := int64(timestamp.Sub(scheme.timestamp()))
 	if timestampdiff == 0 {
 		Logger.WithFields(logrus.Fields{"scheme": id, "type": typ}).Info("scheme is up-to-date, not updating")
-		return false, nil, index, nil
+		return false, nil, nil, index, nil
 	} else if timestampdiff < 0 {
 		Logger.WithFields(logrus.Fields{"scheme": id, "type": typ}).Info("local scheme is newer than remote, not updating")
-		return false, nil, index, nil
+		return false, nil, nil, index, nil
 	}
 	// timestampdiff > 0
-	Logger.WithFields(logrus.Fields{"scheme": id, "type": typ}).Info("scheme is outdated, updating")
-
-	// save the index and its signature against which we authenticated the timestamp
-	// for future use: as they are themselves not in the index, the loop below doesn't touch them
-	if err = conf.writeIndex(scheme.path(), indexbts, sigbts); err != nil {
-		return false, nil, nil, err
+	pin, err := conf.schemePin(id)
+	if err != nil {
+		return false, nil, nil, nil, err
+	}
+	if pin != nil && timestamp.After(*pin) {
+		Logger.WithFields(logrus.Fields{"scheme": id, "type": typ, "pinned": pin.String()}).
+			Info("scheme is pinned to an older version, not updating")
+		return false, nil, nil, index, nil
 	}
+	Logger.WithFields(logrus.Fields{"scheme": id, "type": typ}).Info("scheme is outdated, updating")
 
-	return true, timestamp, index, nil
+	return true, indexbts, sigs, index, nil
 }
 
 func (conf *Configuration) checkRemoteTimestamp(scheme Scheme) (
-	*Timestamp, []byte, []byte, SchemeManagerIndex, error,
+	*Timestamp, []byte, map[int][]byte, SchemeManagerIndex, error,
 ) {
-	t := NewHTTPTransport(scheme.url(), true)
+	t := NewHTTPTransport(conf.schemeURL(scheme.id(), scheme.url()), true)
 	indexbts, err := t.GetBytes("index")
 	if err != nil {
 		return nil, nil, nil, nil, err
 	}
-	sig, err := t.GetBytes("index.sig")
+	pks, threshold, err := conf.schemePublicKeys(scheme.path())
 	if err != nil {
 		return nil, nil, nil, nil, err
 	}
-	timestampbts, err := t.GetBytes("timestamp")
+	sigs, err := downloadSchemeSignatures(t, len(pks))
 	if err != nil {
 		return nil, nil, nil, nil, err
 	}
-	pk, err := conf.schemePublicKey(scheme.path())
+	timestampbts, err := t.GetBytes("timestamp")
 	if err != nil {
 		return nil, nil, nil, nil, err
 	}
 
-	// Verify signature and the timestamp hash in the index
-	if err = signed.Verify(pk, indexbts, sig); err != nil {
+	// Verify signatures and the timestamp hash in the index
+	if err = VerifySchemeSignatures(pks, threshold, indexbts, sigs); err != nil {
 		return nil, nil, nil, nil, err
 	}
 	index := SchemeManagerIndex(make(map[string]SchemeFileHash))
@@ -568,17 +600,17 @@ func (conf *Configuration) checkRemoteTimestamp(scheme Scheme) (
 		return nil, nil, nil, nil, err
 	}
 
-	return timestamp, indexbts, sig, index, nil
+	return timestamp, indexbts, sigs, index, nil
 }
 
-func (conf *Configuration) writeIndex(dest string, indexbts, sigbts []byte) error {
+func (conf *Configuration) writeIndex(dest string, indexbts []byte, sigs map[int][]byte) error {
 	if err := common.EnsureDirectoryExists(dest); err != nil {
 		return err
 	}
 	if err := common.SaveFile(filepath.Join(dest, "index"), indexbts); err != nil {
 		return err
 	}
-	return common.SaveFile(filepath.Join(dest, "index.sig"), sigbts)
+	return writeSchemeSignatures(dest, sigs)
 }
 
 func (conf *Configuration) isUpToDate(subdir string) (bool, error) {
@@ -626,7 +658,7 @@ func (conf *Configuration) verifySignature(dir string) (err error) {
 		}
 	}()
 
-	if err := common.AssertPathExists(filepath.Join(dir, "index"), filepath.Join(dir, "index.sig"), filepath.Join(dir, "pk.pem")); err != nil {
+	if err := common.AssertPathExists(filepath.Join(dir, "index"), filepath.Join(dir, "pk.pem")); err != nil {
 		return errors.New("Missing scheme manager index file, signature, or public key")
 	}
 
@@ -636,27 +668,22 @@ func (conf *Configuration) verifySignature(dir string) (err error) {
 		return err
 	}
 
-	// Read and parse scheme public key
-	pk, err := conf.schemePublicKey(dir)
+	// Read and parse scheme public keys
+	pks, threshold, err := conf.schemePublicKeys(dir)
 	if err != nil {
 		return err
 	}
 
-	// Read and parse signature
-	sig, err := ioutil.ReadFile(filepath.Join(dir, "index.sig"))
+	// Read and parse signatures
+	sigs, err := readSchemeSignatures(dir, len(pks))
 	if err != nil {
 		return err
 	}
-
-	return signed.Verify(pk, indexbts, sig)
-}
-
-func (conf *Configuration) schemePublicKey(dir string) (*ecdsa.PublicKey, error) {
-	pkbts, err := ioutil.ReadFile(filepath.Join(dir, "pk.pem"))
-	if err != nil {
-		return nil, err
+	if len(sigs) == 0 {
+		return errors.New("Missing scheme manager index file, signature, or public key")
 	}
-	return signed.UnmarshalPemPublicKey(pkbts)
+
+	return VerifySchemeSignatures(pks, threshold, indexbts, sigs)
 }
 
 // readSignedFile reads the file at the specified path
@@ -803,6 +830,18 @@ func downloadScheme(url string) (Scheme, error) {
 	return nil, errors.New("no scheme description file found")
 }
 
+// schemeURL returns the URL from which the scheme with the specified ID and URL is to be downloaded,
+// taking into account the scheme mirrors configured in the ConfigurationOptions.
+func (conf *Configuration) schemeURL(id, url string) string {
+	if mirror, ok := conf.options.SchemeMirrors[id]; ok {
+		return mirror
+	}
+	if conf.options.SchemeMirror != "" {
+		return strings.TrimSuffix(conf.options.SchemeMirror, "/") + "/" + id
+	}
+	return url
+}
+
 func (conf *Configuration) tempSchemeCopy(scheme Scheme) (string, string, error) {
 	dir, err := ioutil.TempDir(filepath.Dir(scheme.path()), "tempscheme")
 	if err != nil {
@@ -819,27 +858,46 @@ func (conf *Configuration) tempSchemeCopy(scheme Scheme) (string, string, error)
 }
 
 // Move oldscheme to a temp dir in the same directory als oldscheme;
-// move newscheme to the location of oldscheme; and delete oldscheme.
+// move newscheme to the location of oldscheme; and delete oldscheme, or move it into the
+// scheme history if enabled.
 // If the first move works then the second one should too, so this will either entirely succeed
 // or leave the old scheme untouched.
 func (conf *Configuration) updateSchemeDir(scheme Scheme, oldscheme, newscheme string) error {
+	moved, err := conf.replaceSchemeDir(scheme, oldscheme, newscheme)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.RemoveAll(filepath.Dir(moved))
+	}()
+	if conf.options.SchemeHistory > 0 {
+		if err = conf.archiveScheme(scheme.id(), moved, conf.options.SchemeHistory); err != nil {
+			Logger.WithField("scheme", scheme.id()).Warn("failed to add scheme to history: ", err)
+		}
+	}
+	return nil
+}
+
+// replaceSchemeDir replaces oldscheme with newscheme as described at updateSchemeDir(),
+// returning the location to which oldscheme was moved. The caller must remove its parent directory.
+func (conf *Configuration) replaceSchemeDir(scheme Scheme, oldscheme, newscheme string) (string, error) {
 	// Create a directory in the same directory as oldscheme,
 	// this is to make sure os.Rename does not fail with an "invalid cross-device link" error.
 	tmp, err := ioutil.TempDir(filepath.Dir(oldscheme), "oldscheme")
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer func() {
+	moved := filepath.Join(tmp, scheme.id())
+	if err = os.Rename(oldscheme, moved); err != nil {
 		_ = os.RemoveAll(tmp)
-	}()
-	if err = os.Rename(oldscheme, filepath.Join(tmp, scheme.id())); err != nil {
-		return err
+		return "", err
 	}
 	if err = os.Rename(newscheme, oldscheme); err != nil {
-		return err
+		_ = os.RemoveAll(tmp)
+		return "", err
 	}
 	scheme.setPath(oldscheme)
-	return nil
+	return moved, nil
 }
 
 var (
@@ -850,11 +908,13 @@ var (
 		regexp.MustCompile(`^.*?/sk\.pem$`),
 		regexp.MustCompile(`^.*?/index`),
 		regexp.MustCompile(`^.*?/index\.sig`),
+		regexp.MustCompile(`^.*?/transparency\.log$`),
 		regexp.MustCompile(`^.*?/AUTHORS$`),
 		regexp.MustCompile(`^.*?/LICENSE$`),
 		regexp.MustCompile(`^.*?/README\.md$`),
 		regexp.MustCompile(`^.*?/.*?/PrivateKeys$`),
 		regexp.MustCompile(`^.*?/.*?/PrivateKeys/\d+.xml$`),
+		regexp.MustCompile(`^.*?/.*?/PrivateKeys/\d+\.xml\.enc$`),
 		regexp.MustCompile(`^.*?/assets/?\w*(\.png)?$`),
 		regexp.MustCompile(`\.DS_Store$`),
 	}
@@ -1027,8 +1087,8 @@ func (scheme *SchemeManager) validate(conf *Configuration) (error, SchemeManager
 	return nil, SchemeManagerStatusValid
 }
 
-func (scheme *SchemeManager) update() error {
-	return scheme.downloadDemoPrivateKeys()
+func (scheme *SchemeManager) update(conf *Configuration) error {
+	return scheme.downloadDemoPrivateKeys(conf.schemeURL(scheme.ID, scheme.URL))
 }
 
 func (scheme *SchemeManager) handleUpdateFile(conf *Configuration, _, filename string, _ []byte, _ *HTTPTransport, downloaded *IrmaIdentifierSet) error {
@@ -1065,6 +1125,7 @@ func (scheme *SchemeManager) delete(conf *Configuration) error {
 	}
 
 	id := scheme.Identifier()
+	conf.invalidateCatalog()
 	delete(conf.SchemeManagers, id)
 	delete(conf.DisabledSchemeManagers, id)
 	name := id.String()
@@ -1220,13 +1281,13 @@ func (scheme *SchemeManager) parseCredentialsFolder(conf *Configuration, issuer
 // downloadDemoPrivateKeys attempts to download the scheme and issuer private keys, if the scheme is
 // a demo scheme and if they are not already present in the scheme, without failing if any of them
 // is not available.
-func (scheme *SchemeManager) downloadDemoPrivateKeys() error {
+func (scheme *SchemeManager) downloadDemoPrivateKeys(url string) error {
 	if !scheme.Demo {
 		return nil
 	}
 
 	Logger.WithField("scheme", scheme.ID).Debugf("Attempting downloading of private keys")
-	transport := NewHTTPTransport(scheme.URL, true)
+	transport := NewHTTPTransport(url, true)
 
 	_, err := downloadFile(transport, scheme.path(), "sk.pem")
 	if err != nil { // If downloading of any of the private key fails just log it, and then continue
@@ -1372,7 +1433,7 @@ func (scheme *RequestorScheme) checkLogo(conf *Configuration, logo string) (erro
 	return nil, ""
 }
 
-func (scheme *RequestorScheme) update() error {
+func (scheme *RequestorScheme) update(_ *Configuration) error {
 	return nil
 }
 
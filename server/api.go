@@ -28,6 +28,8 @@ var Logger *logrus.Logger = logrus.StandardLogger()
 type SessionPackage struct {
 	SessionPtr *irma.Qr `json:"sessionPtr"`
 	Token      string   `json:"token"`
+	// Warnings about the session request, e.g. about deprecated issuers or credential types
+	Warnings []string `json:"warnings,omitempty"`
 }
 
 // SessionResult contains session information such as the session status, type, possible errors,
@@ -4,12 +4,12 @@ import (
 	"crypto/rsa"
 	"encoding/json"
 	"fmt"
+	"path/filepath"
 	"regexp"
 	"strings"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/go-errors/errors"
-	"github.com/privacybydesign/gabi/gabikeys"
 	irma "github.com/privacybydesign/irmago"
 	"github.com/privacybydesign/irmago/internal/common"
 	"github.com/sirupsen/logrus"
@@ -29,8 +29,23 @@ type Configuration struct {
 	DisableSchemesUpdate bool `json:"disable_schemes_update" mapstructure:"disable_schemes_update"`
 	// Update all schemes every x minutes (default value 0 means 60) (use DisableSchemesUpdate to disable)
 	SchemesUpdateInterval int `json:"schemes_update" mapstructure:"schemes_update"`
+	// If specified, schemes are downloaded and updated from this scheme mirror instead of from their own URLs
+	// (only used if IrmaConfiguration == nil)
+	SchemesMirror string `json:"schemes_mirror" mapstructure:"schemes_mirror"`
+	// Number of previous versions of each scheme to keep when updating schemes, to allow rolling back
+	// using "irma scheme rollback" (default value 0 disables keeping them) (only used if IrmaConfiguration == nil)
+	SchemesHistory int `json:"schemes_history" mapstructure:"schemes_history"`
 	// Path to issuer private keys to parse
 	IssuerPrivateKeysPath string `json:"privkeys" mapstructure:"privkeys"`
+	// Passphrase with which encrypted issuer private keys (*.xml.enc) in IssuerPrivateKeysPath are decrypted
+	IssuerPrivateKeysPassphrase string `json:"-"`
+	// Unix socket of an issuer signer daemon (e.g. "irma issuer signer") that performs all operations
+	// requiring issuer private keys, so that this server never holds them (cannot be combined with
+	// IssuerPrivateKeysPath)
+	IssuerSignerSocket string `json:"signer_socket" mapstructure:"signer_socket"`
+	// Log warnings if the latest usable key of an issuer whose private keys are present expires within
+	// x days (default value 0 means 31)
+	KeyExpiryWarning int `json:"key_expiry_warning" mapstructure:"key_expiry_warning"`
 	// URL at which the IRMA app can reach this server during sessions
 	URL string `json:"url" mapstructure:"url"`
 	// Required to be set to true if URL does not begin with https:// in production mode.
@@ -62,6 +77,10 @@ type Configuration struct {
 	// Whether to augment the clientreturnurl with the server token of the request (this allows for stateless
 	// requestor servers more easily)
 	AugmentClientReturnURL bool `json:"augment_client_return_url" mapstructure:"augment_client_return_url"`
+	// Whether to reject session requests involving deprecated issuers or credential types, instead
+	// of only warning about them in the session creation response. Issuance of deprecated
+	// credential types is always refused.
+	RejectDeprecated bool `json:"reject_deprecated" mapstructure:"reject_deprecated"`
 
 	// Logging verbosity level: 0 is normal, 1 includes DEBUG level, 2 includes TRACE level
 	Verbose int `json:"verbose" mapstructure:"verbose"`
@@ -78,6 +97,13 @@ type Configuration struct {
 	RevocationDBType string `json:"revocation_db_type" mapstructure:"revocation_db_type"`
 	// Credentials types for which revocation database should be hosted
 	RevocationSettings irma.RevocationSettings `json:"revocation_settings" mapstructure:"revocation_settings"`
+	// Custom storage backend for revocation records. If specified, RevocationDBConnStr and
+	// RevocationDBType are ignored.
+	RevocationDB irma.RevocationDB `json:"-"`
+	// Serve revocation statistics at GET /revocation/{credtype}/stats for the credential types of
+	// which this server is the revocation authority. As the endpoint is unauthenticated, restrict
+	// access to it (e.g. in a reverse proxy) when enabling this.
+	RevocationStats bool `json:"revocation_stats" mapstructure:"revocation_stats"`
 
 	// Production mode: enables safer and stricter defaults and config checking
 	Production bool `json:"production" mapstructure:"production"`
@@ -121,7 +147,7 @@ func (conf *Configuration) HavePrivateKeys() bool {
 		if conf.IrmaConfiguration.SchemeManagers[id.SchemeManagerIdentifier()].Demo {
 			continue
 		}
-		if _, err = conf.IrmaConfiguration.PrivateKeys.Latest(id); err == nil {
+		if _, err = irma.LatestPrivateKeyCounter(conf.IrmaConfiguration.IssuerSigner(), id); err == nil {
 			return true
 		}
 	}
@@ -178,9 +204,12 @@ func (conf *Configuration) verifyIrmaConf() error {
 		conf.Logger.WithField("schemes_path", conf.SchemesPath).Info("Determined schemes path")
 		conf.IrmaConfiguration, err = irma.NewConfiguration(conf.SchemesPath, irma.ConfigurationOptions{
 			Assets:              conf.SchemesAssetsPath,
+			SchemeMirror:        conf.SchemesMirror,
+			SchemeHistory:       conf.SchemesHistory,
 			RevocationDBType:    conf.RevocationDBType,
 			RevocationDBConnStr: conf.RevocationDBConnStr,
 			RevocationSettings:  conf.RevocationSettings,
+			RevocationDB:        conf.RevocationDB,
 		})
 		if err != nil {
 			return err
@@ -207,6 +236,9 @@ func (conf *Configuration) verifyIrmaConf() error {
 }
 
 func (conf *Configuration) verifyPrivateKeys() error {
+	if conf.IssuerSignerSocket != "" {
+		return conf.verifyIssuerSigner()
+	}
 	if conf.IssuerPrivateKeysPath == "" {
 		return nil
 	}
@@ -214,32 +246,68 @@ func (conf *Configuration) verifyPrivateKeys() error {
 	if err != nil {
 		return err
 	}
-	return conf.IrmaConfiguration.AddPrivateKeyRing(ring)
+	if err = conf.IrmaConfiguration.AddPrivateKeyRing(ring); err != nil {
+		return err
+	}
+
+	encrypted, err := filepath.Glob(filepath.Join(conf.IssuerPrivateKeysPath, "*.xml"+irma.EncryptedPrivateKeySuffix))
+	if err != nil || len(encrypted) == 0 {
+		return err
+	}
+	if conf.IssuerPrivateKeysPassphrase == "" {
+		return errors.New("encrypted private keys found but no passphrase specified")
+	}
+	encring, err := irma.NewPrivateKeyRingEncryptedFolder(
+		conf.IssuerPrivateKeysPath, conf.IrmaConfiguration, []byte(conf.IssuerPrivateKeysPassphrase),
+	)
+	if err != nil {
+		return err
+	}
+	return conf.IrmaConfiguration.AddPrivateKeyRing(encring)
+}
+
+func (conf *Configuration) verifyIssuerSigner() error {
+	if conf.IssuerPrivateKeysPath != "" {
+		return errors.New("issuer private keys path and issuer signer socket cannot both be specified")
+	}
+	signer := irma.NewIssuerSignerClient(conf.IssuerSignerSocket)
+	keys, err := signer.Keys()
+	if err != nil {
+		return err
+	}
+	conf.Logger.Infof("Using issuer signer at %s, having private keys of %d issuers", conf.IssuerSignerSocket, len(keys))
+	conf.IrmaConfiguration.Signer = signer
+	return nil
 }
 
 func (conf *Configuration) prepareRevocation(credid irma.CredentialTypeIdentifier) error {
-	var sk *gabikeys.PrivateKey
-	err := conf.IrmaConfiguration.PrivateKeys.Iterate(credid.IssuerIdentifier(), func(isk *gabikeys.PrivateKey) error {
-		if !isk.RevocationSupported() {
-			return nil
+	issid := credid.IssuerIdentifier()
+	counters, err := conf.IrmaConfiguration.IssuerSigner().Counters(issid)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, counter := range counters {
+		pk, err := conf.IrmaConfiguration.PublicKey(issid, counter)
+		if err != nil {
+			return err
+		}
+		if pk == nil || !pk.RevocationSupported() {
+			continue
 		}
-		sk = isk
-		exists, err := conf.IrmaConfiguration.Revocation.Exists(credid, isk.Counter)
+		found = true
+		exists, err := conf.IrmaConfiguration.Revocation.Exists(credid, counter)
 		if err != nil {
-			return errors.WrapPrefix(err, fmt.Sprintf("failed to check if accumulator exists for %s-%d", credid, isk.Counter), 0)
+			return errors.WrapPrefix(err, fmt.Sprintf("failed to check if accumulator exists for %s-%d", credid, counter), 0)
 		}
 		if !exists {
-			conf.Logger.Warnf("Creating initial accumulator for %s-%d", credid, isk.Counter)
-			if err = conf.IrmaConfiguration.Revocation.EnableRevocation(credid, sk); err != nil {
-				return errors.WrapPrefix(err, fmt.Sprintf("failed create initial accumulator for %s-%d", credid, isk.Counter), 0)
+			conf.Logger.Warnf("Creating initial accumulator for %s-%d", credid, counter)
+			if err = conf.IrmaConfiguration.Revocation.EnableRevocation(credid, counter); err != nil {
+				return errors.WrapPrefix(err, fmt.Sprintf("failed create initial accumulator for %s-%d", credid, counter), 0)
 			}
 		}
-		return nil
-	})
-	if err != nil {
-		return err
 	}
-	if sk == nil {
+	if !found {
 		return errors.Errorf("revocation server mode enabled for %s but no private key installed", credid)
 	}
 	return nil
@@ -281,7 +349,7 @@ func (conf *Configuration) verifyRevocation() error {
 		if !credtype.RevocationSupported() {
 			continue
 		}
-		_, err := rev.Keys.PrivateKeyLatest(credid.IssuerIdentifier())
+		_, err := rev.Keys.PrivateKeyCounterLatest(credid.IssuerIdentifier())
 		haveSK := err == nil
 		settings := conf.RevocationSettings[credid]
 		if haveSK && (settings == nil || (settings.RevocationServerURL == "" && !settings.Server)) {
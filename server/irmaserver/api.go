@@ -6,6 +6,7 @@ package irmaserver
 
 import (
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/alexandrevicenzi/go-sse"
@@ -25,6 +26,10 @@ type Server struct {
 	stopScheduler    chan bool
 	handlers         map[string]server.SessionHandler
 	serverSentEvents *sse.Server
+
+	keysLock   sync.Mutex
+	keysExpiry map[irma.IssuerIdentifier]time.Time
+	keysErr    error
 }
 
 // Default server instance
@@ -74,6 +79,9 @@ func New(conf *server.Configuration) (*Server, error) {
 		}
 	})
 
+	s.checkIssuerKeys()
+	s.scheduler.Every(1).Hour().Do(s.checkIssuerKeys)
+
 	s.stopScheduler = s.scheduler.Start()
 
 	return s, nil
@@ -131,6 +139,12 @@ func (s *Server) HandlerFunc() http.HandlerFunc {
 		r.Get("/update/{count:\\d+}", s.handleRevocationGetUpdateLatest)
 		r.Get("/update/{count:\\d+}/{counter:\\d+}", s.handleRevocationGetUpdateLatest)
 		r.Post("/issuancerecord/{counter:\\d+}", s.handleRevocationPostIssuanceRecord)
+		if s.conf.RevocationSettings.AcceptsUpdates() {
+			r.Post("/update", s.handleRevocationPostUpdate)
+		}
+		if s.conf.RevocationStats {
+			r.Get("/stats", s.handleRevocationGetStats)
+		}
 	})
 
 	return s.router.ServeHTTP
@@ -148,6 +162,26 @@ func (s *Server) Stop() {
 	s.sessions.stop()
 }
 
+// Ready returns an error if the server cannot issue credentials of an issuer of which it has
+// private keys, because the public key of the latest private key of that issuer has expired.
+func Ready() error {
+	return s.Ready()
+}
+func (s *Server) Ready() error {
+	s.keysLock.Lock()
+	defer s.keysLock.Unlock()
+	if s.keysErr != nil {
+		return s.keysErr
+	}
+	now := time.Now()
+	for id, expiry := range s.keysExpiry {
+		if expiry.Before(now) {
+			return errors.Errorf("latest private key of issuer %s has expired", id)
+		}
+	}
+	return nil
+}
+
 // StartSession starts an IRMA session, running the handler on completion, if specified.
 // The session token (the second return parameter) can be used in GetSessionResult()
 // and CancelSession().
@@ -12,6 +12,7 @@ import (
 
 	"github.com/go-chi/chi"
 	"github.com/privacybydesign/gabi"
+	"github.com/privacybydesign/gabi/revocation"
 	"github.com/privacybydesign/gabi/signed"
 	irma "github.com/privacybydesign/irmago"
 	"github.com/privacybydesign/irmago/internal/common"
@@ -214,22 +215,25 @@ func (session *session) handlePostCommitments(commitments *irma.IssueCommitmentM
 	var sigs []*gabi.IssueSignatureMessage
 	for i, cred := range request.Credentials {
 		id := cred.CredentialTypeID.IssuerIdentifier()
-		pk, _ := session.conf.IrmaConfiguration.PublicKey(id, cred.KeyCounter)
-		sk, _ := session.conf.IrmaConfiguration.PrivateKeys.Latest(id)
-		issuer := gabi.NewIssuer(sk, pk, one)
 		proof, ok := commitments.Proofs[i+discloseCount].(*gabi.ProofU)
 		if !ok {
 			return nil, session.fail(server.ErrorMalformedInput, "Received invalid issuance commitment")
 		}
-		attrs, witness, err := session.computeAttributes(sk, cred)
+		attrs, witness, err := session.computeAttributes(cred)
 		if err != nil {
 			return nil, session.fail(server.ErrorIssuanceFailed, err.Error())
 		}
 		rb := session.conf.IrmaConfiguration.CredentialTypes[cred.CredentialTypeID].RandomBlindAttributeIndices()
-		sig, err := issuer.IssueSignature(proof.U, attrs, witness, commitments.Nonce2, rb)
+		sig, err := session.conf.IrmaConfiguration.IssuerSigner().IssueSignature(id, cred.KeyCounter, &irma.IssueSignatureRequest{
+			U:          proof.U,
+			Attributes: attrs,
+			Nonce2:     commitments.Nonce2,
+			Blind:      rb,
+		})
 		if err != nil {
 			return nil, session.fail(server.ErrorIssuanceFailed, err.Error())
 		}
+		sig.NonRevocationWitness = witness
 		sigs = append(sigs, sig)
 	}
 
@@ -540,3 +544,61 @@ func (s *Server) handleRevocationPostIssuanceRecord(w http.ResponseWriter, r *ht
 	w.WriteHeader(200)
 	return
 }
+
+// POST revocation/{credtype}/update
+func (s *Server) handleRevocationPostUpdate(w http.ResponseWriter, r *http.Request) {
+	cred := irma.NewCredentialTypeIdentifier(chi.URLParam(r, "id"))
+
+	// Only accept updates for credential types for which this is configured; in particular,
+	// the revocation authority is the source of updates, so it does not accept them
+	if settings := s.conf.RevocationSettings[cred]; settings == nil || !settings.AcceptUpdates {
+		server.WriteBinaryResponse(w, nil, server.RemoteError(server.ErrorInvalidRequest, "not supported by this server"))
+		return
+	}
+	if credtype := s.conf.IrmaConfiguration.CredentialTypes[cred]; credtype == nil || !credtype.RevocationSupported() {
+		server.WriteBinaryResponse(w, nil, server.RemoteError(server.ErrorInvalidRequest, "unknown credential type or revocation not supported"))
+		return
+	}
+
+	bts, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, revocationUpdateMaxSize))
+	if err != nil {
+		server.WriteBinaryResponse(w, nil, server.RemoteError(server.ErrorInvalidRequest, err.Error()))
+		return
+	}
+	var update revocation.Update
+	if err = irma.UnmarshalBinary(bts, &update); err != nil || update.SignedAccumulator == nil {
+		server.WriteBinaryResponse(w, nil, server.RemoteError(server.ErrorMalformedInput, "failed to parse update"))
+		return
+	}
+
+	// AddUpdate verifies the update against the issuer public key in the scheme
+	if err = s.conf.IrmaConfiguration.Revocation.AddUpdate(cred, &update); err != nil {
+		server.WriteBinaryResponse(w, nil, server.RemoteError(server.ErrorRevocation, err.Error()))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// GET revocation/{credtype}/stats[?cycles={cycles}]
+func (s *Server) handleRevocationGetStats(w http.ResponseWriter, r *http.Request) {
+	cred := irma.NewCredentialTypeIdentifier(chi.URLParam(r, "id"))
+	cycles := uint64(3)
+	if c := r.URL.Query().Get("cycles"); c != "" {
+		var err error
+		if cycles, err = strconv.ParseUint(c, 10, 8); err != nil {
+			server.WriteResponse(w, nil, server.RemoteError(server.ErrorInvalidRequest, "invalid cycles parameter"))
+			return
+		}
+	}
+
+	if settings := s.conf.RevocationSettings[cred]; settings == nil || !settings.Authority {
+		server.WriteResponse(w, nil, server.RemoteError(server.ErrorInvalidRequest, "not supported by this server"))
+		return
+	}
+	stats, err := s.conf.IrmaConfiguration.Revocation.Stats(uint(cycles), cred)
+	if err != nil {
+		server.WriteResponse(w, nil, server.RemoteError(server.ErrorRevocation, err.Error()))
+		return
+	}
+	server.WriteJson(w, stats)
+}
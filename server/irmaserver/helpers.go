@@ -10,6 +10,7 @@ import (
 	"log"
 	"net/http"
 	"reflect"
+	"strings"
 	"time"
 
 	"github.com/alexandrevicenzi/go-sse"
@@ -19,7 +20,6 @@ import (
 	"github.com/go-errors/errors"
 	"github.com/privacybydesign/gabi"
 	"github.com/privacybydesign/gabi/big"
-	"github.com/privacybydesign/gabi/gabikeys"
 	"github.com/privacybydesign/gabi/revocation"
 	irma "github.com/privacybydesign/irmago"
 	"github.com/privacybydesign/irmago/internal/common"
@@ -90,6 +90,9 @@ func (session *session) chooseProtocolVersion(minClient, maxClient *irma.Protoco
 
 const retryTimeLimit = 10 * time.Second
 
+// revocationUpdateMaxSize is the maximum size of revocation updates POSTed by webhooks.
+const revocationUpdateMaxSize = 1 << 20
+
 // checkCache returns a previously cached response, for replaying against multiple requests from
 // irmago's retryablehttp client, if:
 // - the same was POSTed as last time
@@ -108,7 +111,7 @@ func (session *session) checkCache(message []byte) (int, []byte) {
 
 // Issuance helpers
 
-func (session *session) computeWitness(sk *gabikeys.PrivateKey, cred *irma.CredentialRequest) (*revocation.Witness, error) {
+func (session *session) computeWitness(cred *irma.CredentialRequest) (*revocation.Witness, error) {
 	id := cred.CredentialTypeID
 	credtyp := session.conf.IrmaConfiguration.CredentialTypes[id]
 	if !credtyp.RevocationSupported() || !session.request.Base().RevocationSupported() {
@@ -141,7 +144,7 @@ func (session *session) computeWitness(sk *gabikeys.PrivateKey, cred *irma.Crede
 		return nil, err
 	}
 
-	witness, err := revocation.RandomWitness(sk, acc)
+	witness, err := session.conf.IrmaConfiguration.IssuerSigner().RandomWitness(id.IssuerIdentifier(), cred.KeyCounter, acc)
 	if err != nil {
 		return nil, err
 	}
@@ -150,11 +153,9 @@ func (session *session) computeWitness(sk *gabikeys.PrivateKey, cred *irma.Crede
 	return witness, nil
 }
 
-func (session *session) computeAttributes(
-	sk *gabikeys.PrivateKey, cred *irma.CredentialRequest,
-) ([]*big.Int, *revocation.Witness, error) {
+func (session *session) computeAttributes(cred *irma.CredentialRequest) ([]*big.Int, *revocation.Witness, error) {
 	id := cred.CredentialTypeID
-	witness, err := session.computeWitness(sk, cred)
+	witness, err := session.computeWitness(cred)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -170,15 +171,16 @@ func (session *session) computeAttributes(
 	}
 
 	if witness != nil {
+		counter := cred.KeyCounter
 		issrecord := &irma.IssuanceRecord{
 			CredType:   id,
-			PKCounter:  &sk.Counter,
+			PKCounter:  &counter,
 			Key:        cred.RevocationKey,
 			Attr:       (*irma.RevocationAttribute)(nonrevAttr),
 			Issued:     issuedAt.UnixNano(),
 			ValidUntil: attributes.Expiry().UnixNano(),
 		}
-		err = session.conf.IrmaConfiguration.Revocation.SaveIssuanceRecord(id, issrecord, sk)
+		err = session.conf.IrmaConfiguration.Revocation.SaveIssuanceRecord(id, issrecord)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -187,18 +189,50 @@ func (session *session) computeAttributes(
 	return attributes.Ints, witness, nil
 }
 
+// checkIssuerKeys logs a warning for each issuer of which the public key of the latest private key
+// expires soon, and an error if it has expired; and records their expiry dates for Ready().
+// Deprecated issuers are skipped.
+func (s *Server) checkIssuerKeys() {
+	statuses, err := s.conf.IrmaConfiguration.IssuerKeyStatuses()
+	s.keysLock.Lock()
+	defer s.keysLock.Unlock()
+	s.keysErr = err
+	if err != nil {
+		_ = server.LogError(err)
+		return
+	}
+
+	days := s.conf.KeyExpiryWarning
+	if days == 0 {
+		days = 31
+	}
+	now := time.Now()
+	s.keysExpiry = map[irma.IssuerIdentifier]time.Time{}
+	for _, status := range statuses {
+		key := status.LatestPrivate()
+		if status.Deprecated || key == nil {
+			continue
+		}
+		expiry := time.Time(key.ExpiryDate)
+		s.keysExpiry[status.Issuer] = expiry
+		fields := logrus.Fields{"issuer": status.Issuer.String(), "counter": key.Counter, "expiry": expiry.String()}
+		if key.Expired(now) {
+			s.conf.Logger.WithFields(fields).Error("Public key of latest private key of issuer has expired, credentials of this issuer cannot be issued")
+		} else if key.Expired(now.AddDate(0, 0, days)) {
+			s.conf.Logger.WithFields(fields).Warn("Public key of latest private key of issuer expires soon")
+		}
+	}
+}
+
 func (s *Server) validateIssuanceRequest(request *irma.IssuanceRequest) error {
 	for _, cred := range request.Credentials {
 		// Check that we have the appropriate private key
 		iss := cred.CredentialTypeID.IssuerIdentifier()
-		privatekey, err := s.conf.IrmaConfiguration.PrivateKeys.Latest(iss)
+		counter, err := irma.LatestPrivateKeyCounter(s.conf.IrmaConfiguration.IssuerSigner(), iss)
 		if err != nil {
 			return err
 		}
-		if privatekey == nil {
-			return errors.Errorf("missing private key of issuer %s", iss.String())
-		}
-		pubkey, err := s.conf.IrmaConfiguration.PublicKey(iss, privatekey.Counter)
+		pubkey, err := s.conf.IrmaConfiguration.PublicKey(iss, counter)
 		if err != nil {
 			return err
 		}
@@ -207,9 +241,9 @@ func (s *Server) validateIssuanceRequest(request *irma.IssuanceRequest) error {
 		}
 		now := time.Now()
 		if now.Unix() > pubkey.ExpiryDate {
-			return errors.Errorf("cannot issue using expired public key %s-%d", iss.String(), privatekey.Counter)
+			return errors.Errorf("cannot issue using expired public key %s-%d", iss.String(), counter)
 		}
-		cred.KeyCounter = privatekey.Counter
+		cred.KeyCounter = counter
 
 		if s.conf.IrmaConfiguration.CredentialTypes[cred.CredentialTypeID].RevocationSupported() {
 			settings := s.conf.RevocationSettings[cred.CredentialTypeID]
@@ -226,6 +260,21 @@ func (s *Server) validateIssuanceRequest(request *irma.IssuanceRequest) error {
 			return err
 		}
 
+		// Refuse to issue deprecated credential types, or credential types of deprecated issuers
+		credtype := s.conf.IrmaConfiguration.CredentialTypes[cred.CredentialTypeID]
+		if credtype.IsDeprecated() {
+			return &irma.SessionError{ErrorType: irma.ErrorDeprecated, Err: errors.Errorf(
+				"cannot issue credential type %s: deprecated since %s",
+				cred.CredentialTypeID, credtype.DeprecatedSince.DateString(),
+			)}
+		}
+		if issuer := s.conf.IrmaConfiguration.Issuers[iss]; issuer != nil && issuer.IsDeprecated() {
+			return &irma.SessionError{ErrorType: irma.ErrorDeprecated, Err: errors.Errorf(
+				"cannot issue credential type %s: issuer deprecated since %s",
+				cred.CredentialTypeID, issuer.DeprecatedSince.DateString(),
+			)}
+		}
+
 		// Ensure the credential has an expiry date
 		defaultValidity := irma.Timestamp(time.Now().AddDate(0, 6, 0))
 		if cred.Validity == nil {
@@ -290,6 +339,16 @@ func (s *Server) validateRequest(request irma.SessionRequest) error {
 	if err := base.Validate(s.conf.IrmaConfiguration); err != nil {
 		return err
 	}
+	if warnings := irma.DeprecationWarnings(s.conf.IrmaConfiguration, request); len(warnings) > 0 {
+		if s.conf.RejectDeprecated {
+			return &irma.SessionError{ErrorType: irma.ErrorDeprecated, Err: errors.Errorf(
+				"session request involves deprecated identifiers: %s", strings.Join(warnings, "; "),
+			)}
+		}
+		for _, warning := range warnings {
+			s.conf.Logger.Warn("Session request: ", warning)
+		}
+	}
 	if base.AugmentReturnURL {
 		if !s.conf.AugmentClientReturnURL {
 			return errors.New("augmenting client return url not enabled in server configuration")
@@ -141,7 +141,7 @@ func (conf *Configuration) CanRevoke(requestor string, cred irma.CredentialTypeI
 	if len(permissions) == 0 { // requestor is not present in the permissions
 		return false, ""
 	}
-	_, err := conf.IrmaConfiguration.Revocation.Keys.PrivateKeyLatest(cred.IssuerIdentifier())
+	_, err := conf.IrmaConfiguration.Revocation.Keys.PrivateKeyCounterLatest(cred.IssuerIdentifier())
 	if err != nil {
 		return false, err.Error()
 	}
@@ -345,17 +345,18 @@ func (conf *Configuration) validatePermissionSet(requestor string, requestorperm
 					continue
 				}
 				if (typ == "issuing" || typ == "revoking") && !conf.SkipPrivateKeysCheck {
-					sk, err := conf.IrmaConfiguration.PrivateKeys.Latest(credtype.IssuerIdentifier())
-					if err != nil {
-						errs = append(errs, fmt.Sprintf("%s %s permission '%s': failed to load private key: %s", requestor, typ, permission, err))
+					issid := credtype.IssuerIdentifier()
+					counter, err := irma.LatestPrivateKeyCounter(conf.IrmaConfiguration.IssuerSigner(), issid)
+					if err == irma.ErrMissingPrivateKey {
+						errs = append(errs, fmt.Sprintf("%s %s permission '%s': private key not installed", requestor, typ, permission))
 						continue
 					}
-					if sk == nil {
-						errs = append(errs, fmt.Sprintf("%s %s permission '%s': private key not installed", requestor, typ, permission))
+					if err != nil {
+						errs = append(errs, fmt.Sprintf("%s %s permission '%s': failed to load private key: %s", requestor, typ, permission, err))
 						continue
 					}
 					if typ == "revoking" {
-						if ok := sk.RevocationSupported(); !ok {
+						if pk, err := conf.IrmaConfiguration.PublicKey(issid, counter); err != nil || pk == nil || !pk.RevocationSupported() {
 							errs = append(errs, fmt.Sprintf("%s %s permission '%s': private key does not support revocation (add revocation key material to it using \"irma issuer revocation keypair\")", requestor, typ, permission))
 							continue
 						}
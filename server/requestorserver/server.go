@@ -194,6 +194,9 @@ func (s *Server) Handler() http.Handler {
 	router.NotFound(server.LogMiddleware("requestor", log)(router.NotFoundHandler()).ServeHTTP)
 	router.MethodNotAllowed(server.LogMiddleware("requestor", log)(router.MethodNotAllowedHandler()).ServeHTTP)
 
+	// Readiness probe, not logged as it is requested often
+	router.Get("/ready", s.handleReady)
+
 	// Group main API endpoints, so we can attach our request/response logger to it
 	// while not adding it to the endpoints already added above (which do their own logging).
 
@@ -460,6 +463,14 @@ func (s *Server) handlePublicKey(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(pubBytes)
 }
 
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	if err := s.irmaserv.Ready(); err != nil {
+		server.WriteError(w, server.ErrorNotReady, err.Error())
+		return
+	}
+	server.WriteString(w, "OK")
+}
+
 func (s *Server) doResultCallback(result *server.SessionResult) {
 	url := s.irmaserv.GetRequest(result.Token).Base().CallbackURL
 	if url == "" {
@@ -527,6 +538,7 @@ func (s *Server) createSession(w http.ResponseWriter, requestor string, rrequest
 	server.WriteJson(w, server.SessionPackage{
 		SessionPtr: qr,
 		Token:      token,
+		Warnings:   irma.DeprecationWarnings(s.conf.IrmaConfiguration, rrequest.SessionRequest()),
 	})
 }
 
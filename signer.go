@@ -0,0 +1,568 @@
+package irma
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	goerrors "errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fxamacker/cbor"
+	"github.com/go-errors/errors"
+	"github.com/privacybydesign/gabi"
+	"github.com/privacybydesign/gabi/big"
+	"github.com/privacybydesign/gabi/gabikeys"
+	"github.com/privacybydesign/gabi/revocation"
+	"github.com/privacybydesign/gabi/signed"
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	// IssuerSigner performs the operations that require issuer private keys: computing issuance
+	// signatures and, for revocation, nonrevocation witnesses, accumulators and ECDSA signatures.
+	// Private keys are identified by their issuer and counter.
+	//
+	// LocalIssuerSigner performs these operations using the private keys of a Configuration.
+	// IssuerSignerClient delegates them to a signer daemon listening on a Unix socket (such as
+	// "irma issuer signer"), so that the process using it never holds any private key material.
+	IssuerSigner interface {
+		// Counters returns the counters of the private keys of the issuer, in ascending order.
+		Counters(id IssuerIdentifier) ([]uint, error)
+		// IssueSignature computes the CL signature on the attributes and the commitment of the
+		// client, along with the proof of its correctness (c.f. gabi.Issuer.IssueSignature()).
+		IssueSignature(id IssuerIdentifier, counter uint, request *IssueSignatureRequest) (*gabi.IssueSignatureMessage, error)
+		// RandomWitness returns a new random nonrevocation witness valid against the accumulator.
+		RandomWitness(id IssuerIdentifier, counter uint, acc *revocation.Accumulator) (*revocation.Witness, error)
+		// NewAccumulator creates and signs a new initial accumulator.
+		NewAccumulator(id IssuerIdentifier, counter uint) (*revocation.Update, error)
+		// RemoveFromAccumulator removes the revocation attribute e from the accumulator, returning the
+		// new accumulator and the event having the specified event as its parent.
+		RemoveFromAccumulator(
+			id IssuerIdentifier, counter uint, acc *revocation.Accumulator, e *big.Int, parent *revocation.Event,
+		) (*revocation.Accumulator, *revocation.Event, error)
+		// SignAccumulator signs the accumulator with the ECDSA private key, c.f.
+		// revocation.Accumulator.Sign().
+		SignAccumulator(id IssuerIdentifier, counter uint, acc *revocation.Accumulator) (*revocation.SignedAccumulator, error)
+		// SignIssuanceRecord signs the issuance record, which must be of a credential type of the
+		// issuer and of the specified private key counter, with the ECDSA private key.
+		SignIssuanceRecord(id IssuerIdentifier, counter uint, record *IssuanceRecord) (signed.Message, error)
+		// SignIssuanceRecords signs the issuance records, like SignIssuanceRecord() does, as a list.
+		SignIssuanceRecords(id IssuerIdentifier, counter uint, records []*IssuanceRecord) (signed.Message, error)
+	}
+
+	// IssueSignatureRequest contains the input of IssuerSigner.IssueSignature(). The nonrevocation
+	// witness (if any) is not sent to the signer; instead the caller includes it in the resulting
+	// gabi.IssueSignatureMessage.
+	IssueSignatureRequest struct {
+		U          *big.Int   `json:"u"`
+		Attributes []*big.Int `json:"attributes"`
+		Nonce2     *big.Int   `json:"nonce2"`
+		// Indices of the random blind attributes
+		Blind []int `json:"blind,omitempty"`
+	}
+
+	// LocalIssuerSigner is an IssuerSigner using the private keys in the PrivateKeyRing
+	// of a Configuration.
+	LocalIssuerSigner struct {
+		Conf *Configuration
+	}
+
+	// IssuerSignerClient is an IssuerSigner that sends its operations over a Unix socket to a signer
+	// daemon serving an IssuerSignerHandler.
+	IssuerSignerClient struct {
+		Socket string
+		client *http.Client
+	}
+
+	// IssuerSignerHandler serves the operations of the IssuerSigner of a Configuration over HTTP,
+	// for use by IssuerSignerClient. It should only be served on a Unix socket that is accessible
+	// only to the processes that are allowed to use the private keys.
+	IssuerSignerHandler struct {
+		conf *Configuration
+	}
+
+	removeFromAccumulatorRequest struct {
+		Accumulator *revocation.Accumulator `json:"acc"`
+		E           *big.Int                `json:"e"`
+		Parent      *revocation.Event       `json:"parent"`
+	}
+
+	removeFromAccumulatorResponse struct {
+		Accumulator *revocation.Accumulator `json:"acc"`
+		Event       *revocation.Event       `json:"event"`
+	}
+
+	// issuanceRecordsRequest contains one or more CBOR-encoded issuance records to be signed,
+	// as IssuanceRecord does not support JSON encoding of its revocation attribute.
+	issuanceRecordsRequest struct {
+		Records []byte `json:"records"`
+	}
+)
+
+// Maximum size of requests to an IssuerSignerHandler
+const issuerSignerMaxRequestSize = 1 << 20
+
+// ErrorName of the RemoteError returned by IssuerSignerHandler when a private key is not present
+const issuerSignerErrorMissingPrivateKey = "MISSING_PRIVATE_KEY"
+
+// IssuerSigner returns the IssuerSigner to be used for operations requiring issuer private keys:
+// Signer if set, and otherwise a LocalIssuerSigner using the private keys in PrivateKeys.
+func (conf *Configuration) IssuerSigner() IssuerSigner {
+	if conf.Signer != nil {
+		return conf.Signer
+	}
+	return LocalIssuerSigner{Conf: conf}
+}
+
+// LatestPrivateKeyCounter returns the highest counter of the private keys of the issuer
+// that are available to the signer.
+func LatestPrivateKeyCounter(signer IssuerSigner, id IssuerIdentifier) (uint, error) {
+	counters, err := signer.Counters(id)
+	if err != nil {
+		return 0, err
+	}
+	if len(counters) == 0 {
+		return 0, ErrMissingPrivateKey
+	}
+	return counters[len(counters)-1], nil
+}
+
+// Local signer
+
+func (s LocalIssuerSigner) Counters(id IssuerIdentifier) ([]uint, error) {
+	counters := []uint{}
+	if s.Conf.PrivateKeys == nil {
+		return counters, nil
+	}
+	err := s.Conf.PrivateKeys.Iterate(id, func(sk *gabikeys.PrivateKey) error {
+		counters = append(counters, sk.Counter)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(counters, func(i, j int) bool { return counters[i] < counters[j] })
+	return counters, nil
+}
+
+func (s LocalIssuerSigner) IssueSignature(id IssuerIdentifier, counter uint, request *IssueSignatureRequest) (*gabi.IssueSignatureMessage, error) {
+	sk, err := s.privateKey(id, counter, false)
+	if err != nil {
+		return nil, err
+	}
+	pk, err := s.Conf.PublicKey(id, counter)
+	if err != nil {
+		return nil, err
+	}
+	if pk == nil {
+		return nil, ErrMissingPublicKey
+	}
+	if request.U == nil || request.Nonce2 == nil {
+		return nil, errors.New("incomplete issue signature request")
+	}
+	return gabi.NewIssuer(sk, pk, big.NewInt(1)).IssueSignature(request.U, request.Attributes, nil, request.Nonce2, request.Blind)
+}
+
+func (s LocalIssuerSigner) RandomWitness(id IssuerIdentifier, counter uint, acc *revocation.Accumulator) (*revocation.Witness, error) {
+	sk, err := s.privateKey(id, counter, true)
+	if err != nil {
+		return nil, err
+	}
+	return revocation.RandomWitness(sk, acc)
+}
+
+func (s LocalIssuerSigner) NewAccumulator(id IssuerIdentifier, counter uint) (*revocation.Update, error) {
+	sk, err := s.privateKey(id, counter, true)
+	if err != nil {
+		return nil, err
+	}
+	return revocation.NewAccumulator(sk)
+}
+
+func (s LocalIssuerSigner) RemoveFromAccumulator(
+	id IssuerIdentifier, counter uint, acc *revocation.Accumulator, e *big.Int, parent *revocation.Event,
+) (*revocation.Accumulator, *revocation.Event, error) {
+	sk, err := s.privateKey(id, counter, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	return acc.Remove(sk, e, parent)
+}
+
+func (s LocalIssuerSigner) SignAccumulator(id IssuerIdentifier, counter uint, acc *revocation.Accumulator) (*revocation.SignedAccumulator, error) {
+	if acc == nil || acc.Nu == nil {
+		return nil, errors.New("missing accumulator")
+	}
+	sk, err := s.privateKey(id, counter, true)
+	if err != nil {
+		return nil, err
+	}
+	return acc.Sign(sk)
+}
+
+func (s LocalIssuerSigner) SignIssuanceRecord(id IssuerIdentifier, counter uint, record *IssuanceRecord) (signed.Message, error) {
+	if err := s.checkIssuanceRecord(id, counter, record); err != nil {
+		return nil, err
+	}
+	sk, err := s.privateKey(id, counter, true)
+	if err != nil {
+		return nil, err
+	}
+	return signed.MarshalSign(sk.ECDSA, record)
+}
+
+func (s LocalIssuerSigner) SignIssuanceRecords(id IssuerIdentifier, counter uint, records []*IssuanceRecord) (signed.Message, error) {
+	for _, record := range records {
+		if err := s.checkIssuanceRecord(id, counter, record); err != nil {
+			return nil, err
+		}
+	}
+	sk, err := s.privateKey(id, counter, true)
+	if err != nil {
+		return nil, err
+	}
+	return signed.MarshalSign(sk.ECDSA, records)
+}
+
+// checkIssuanceRecord checks that the issuance record is complete, and that it belongs to a
+// credential type of the issuer supporting revocation and to the specified private key.
+func (s LocalIssuerSigner) checkIssuanceRecord(id IssuerIdentifier, counter uint, record *IssuanceRecord) error {
+	if record == nil || record.Key == "" || record.Attr == nil || record.PKCounter == nil {
+		return errors.New("incomplete issuance record")
+	}
+	if record.CredType.IssuerIdentifier() != id {
+		return errors.Errorf("issuance record of credential type %s not of issuer %s", record.CredType, id)
+	}
+	if *record.PKCounter != counter {
+		return errors.Errorf("issuance record of private key %d, not %d", *record.PKCounter, counter)
+	}
+	credtype := s.Conf.CredentialTypes[record.CredType]
+	if credtype == nil || !credtype.RevocationSupported() {
+		return errors.Errorf("credential type %s unknown or not supporting revocation", record.CredType)
+	}
+	return nil
+}
+
+func (s LocalIssuerSigner) privateKey(id IssuerIdentifier, counter uint, revocation bool) (*gabikeys.PrivateKey, error) {
+	if s.Conf.PrivateKeys == nil {
+		return nil, ErrMissingPrivateKey
+	}
+	sk, err := s.Conf.PrivateKeys.Get(id, counter)
+	if err != nil {
+		return nil, err
+	}
+	if sk == nil {
+		return nil, ErrMissingPrivateKey
+	}
+	if revocation && !sk.RevocationSupported() {
+		return nil, errors.Errorf("private key %s-%d does not support revocation", id, counter)
+	}
+	return sk, nil
+}
+
+// Signer client
+
+// NewIssuerSignerClient returns an IssuerSignerClient connecting to the signer daemon
+// listening on the specified Unix socket.
+func NewIssuerSignerClient(socket string) *IssuerSignerClient {
+	return &IssuerSignerClient{
+		Socket: socket,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var dialer net.Dialer
+					return dialer.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+// Keys returns the counters of the private keys of all issuers of which the signer has private keys.
+func (c *IssuerSignerClient) Keys() (map[IssuerIdentifier][]uint, error) {
+	keys := map[IssuerIdentifier][]uint{}
+	if err := c.request(http.MethodGet, "counters", nil, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (c *IssuerSignerClient) Counters(id IssuerIdentifier) ([]uint, error) {
+	var counters []uint
+	if err := c.request(http.MethodGet, "counters/"+id.String(), nil, &counters); err != nil {
+		return nil, err
+	}
+	return counters, nil
+}
+
+func (c *IssuerSignerClient) IssueSignature(id IssuerIdentifier, counter uint, request *IssueSignatureRequest) (*gabi.IssueSignatureMessage, error) {
+	sig := &gabi.IssueSignatureMessage{}
+	if err := c.request(http.MethodPost, issuerSignerPath("issuesignature", id, counter), request, sig); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+func (c *IssuerSignerClient) RandomWitness(id IssuerIdentifier, counter uint, acc *revocation.Accumulator) (*revocation.Witness, error) {
+	witness := &revocation.Witness{}
+	if err := c.request(http.MethodPost, issuerSignerPath("randomwitness", id, counter), acc, witness); err != nil {
+		return nil, err
+	}
+	return witness, nil
+}
+
+func (c *IssuerSignerClient) NewAccumulator(id IssuerIdentifier, counter uint) (*revocation.Update, error) {
+	update := &revocation.Update{}
+	if err := c.request(http.MethodPost, issuerSignerPath("newaccumulator", id, counter), nil, update); err != nil {
+		return nil, err
+	}
+	return update, nil
+}
+
+func (c *IssuerSignerClient) RemoveFromAccumulator(
+	id IssuerIdentifier, counter uint, acc *revocation.Accumulator, e *big.Int, parent *revocation.Event,
+) (*revocation.Accumulator, *revocation.Event, error) {
+	var res removeFromAccumulatorResponse
+	req := &removeFromAccumulatorRequest{Accumulator: acc, E: e, Parent: parent}
+	if err := c.request(http.MethodPost, issuerSignerPath("removefromaccumulator", id, counter), req, &res); err != nil {
+		return nil, nil, err
+	}
+	if res.Accumulator == nil || res.Event == nil {
+		return nil, nil, errors.New("issuer signer returned incomplete response")
+	}
+	return res.Accumulator, res.Event, nil
+}
+
+func (c *IssuerSignerClient) SignAccumulator(id IssuerIdentifier, counter uint, acc *revocation.Accumulator) (*revocation.SignedAccumulator, error) {
+	sacc := &revocation.SignedAccumulator{}
+	if err := c.request(http.MethodPost, issuerSignerPath("signaccumulator", id, counter), acc, sacc); err != nil {
+		return nil, err
+	}
+	sacc.Accumulator = acc
+	return sacc, nil
+}
+
+func (c *IssuerSignerClient) SignIssuanceRecord(id IssuerIdentifier, counter uint, record *IssuanceRecord) (signed.Message, error) {
+	return c.signIssuanceRecords("signissuancerecord", id, counter, record)
+}
+
+func (c *IssuerSignerClient) SignIssuanceRecords(id IssuerIdentifier, counter uint, records []*IssuanceRecord) (signed.Message, error) {
+	return c.signIssuanceRecords("signissuancerecords", id, counter, records)
+}
+
+func (c *IssuerSignerClient) signIssuanceRecords(operation string, id IssuerIdentifier, counter uint, records interface{}) (signed.Message, error) {
+	bts, err := cbor.Marshal(records, cbor.EncOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var res signed.Message
+	if err = c.request(http.MethodPost, issuerSignerPath(operation, id, counter), &issuanceRecordsRequest{Records: bts}, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *IssuerSignerClient) request(method, path string, object, result interface{}) error {
+	var body io.Reader
+	if object != nil {
+		bts, err := json.Marshal(object)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(bts)
+	}
+	// The host is ignored, as we always dial the Unix socket
+	req, err := http.NewRequest(method, "http://signer/"+path, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return errors.WrapPrefix(err, "failed to contact issuer signer at "+c.Socket, 0)
+	}
+	defer res.Body.Close()
+	bts, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		apierr := &RemoteError{}
+		if err = json.Unmarshal(bts, apierr); err != nil || apierr.ErrorName == "" {
+			return errors.Errorf("issuer signer returned status %d", res.StatusCode)
+		}
+		if apierr.ErrorName == issuerSignerErrorMissingPrivateKey {
+			return ErrMissingPrivateKey
+		}
+		return errors.Errorf("issuer signer returned error %s: %s", apierr.ErrorName, apierr.Description)
+	}
+	return json.Unmarshal(bts, result)
+}
+
+func issuerSignerPath(operation string, id IssuerIdentifier, counter uint) string {
+	return operation + "/" + id.String() + "/" + strconv.FormatUint(uint64(counter), 10)
+}
+
+// Signer server
+
+// NewIssuerSignerHandler returns an IssuerSignerHandler serving the operations of the
+// IssuerSigner of the configuration, i.e. normally using the private keys in its PrivateKeys.
+func NewIssuerSignerHandler(conf *Configuration) *IssuerSignerHandler {
+	return &IssuerSignerHandler{conf: conf}
+}
+
+func (h *IssuerSignerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if r.Method == http.MethodGet && parts[0] == "counters" && len(parts) <= 2 {
+		h.serveCounters(w, parts[1:])
+		return
+	}
+	if r.Method != http.MethodPost || len(parts) != 3 {
+		http.NotFound(w, r)
+		return
+	}
+
+	id := NewIssuerIdentifier(parts[1])
+	counter, err := strconv.ParseUint(parts[2], 10, 32)
+	if err != nil {
+		writeIssuerSignerError(w, http.StatusBadRequest, "MALFORMED_INPUT", err)
+		return
+	}
+	bts, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, issuerSignerMaxRequestSize))
+	if err != nil {
+		writeIssuerSignerError(w, http.StatusBadRequest, "MALFORMED_INPUT", err)
+		return
+	}
+	Logger.WithFields(logrus.Fields{"operation": parts[0], "issuer": id, "counter": counter}).
+		Debug("Issuer signer request")
+
+	result, err := h.handle(parts[0], id, uint(counter), bts)
+	if err != nil {
+		if goerrors.Is(err, os.ErrNotExist) {
+			writeIssuerSignerError(w, http.StatusNotFound, issuerSignerErrorMissingPrivateKey, err)
+		} else {
+			writeIssuerSignerError(w, http.StatusBadRequest, "SIGNER_ERROR", err)
+		}
+		return
+	}
+	writeIssuerSignerResult(w, result)
+}
+
+func (h *IssuerSignerHandler) serveCounters(w http.ResponseWriter, ids []string) {
+	signer := h.conf.IssuerSigner()
+	if len(ids) == 1 {
+		counters, err := signer.Counters(NewIssuerIdentifier(ids[0]))
+		if err != nil {
+			writeIssuerSignerError(w, http.StatusInternalServerError, "SIGNER_ERROR", err)
+			return
+		}
+		writeIssuerSignerResult(w, counters)
+		return
+	}
+
+	keys := map[IssuerIdentifier][]uint{}
+	for id := range h.conf.Issuers {
+		counters, err := signer.Counters(id)
+		if err != nil {
+			writeIssuerSignerError(w, http.StatusInternalServerError, "SIGNER_ERROR", err)
+			return
+		}
+		if len(counters) > 0 {
+			keys[id] = counters
+		}
+	}
+	writeIssuerSignerResult(w, keys)
+}
+
+func (h *IssuerSignerHandler) handle(operation string, id IssuerIdentifier, counter uint, bts []byte) (interface{}, error) {
+	signer := h.conf.IssuerSigner()
+	switch operation {
+	case "issuesignature":
+		var req IssueSignatureRequest
+		if err := json.Unmarshal(bts, &req); err != nil {
+			return nil, err
+		}
+		return signer.IssueSignature(id, counter, &req)
+	case "randomwitness":
+		var acc revocation.Accumulator
+		if err := json.Unmarshal(bts, &acc); err != nil {
+			return nil, err
+		}
+		if acc.Nu == nil {
+			return nil, errors.New("missing accumulator")
+		}
+		return signer.RandomWitness(id, counter, &acc)
+	case "newaccumulator":
+		return signer.NewAccumulator(id, counter)
+	case "removefromaccumulator":
+		var req removeFromAccumulatorRequest
+		if err := json.Unmarshal(bts, &req); err != nil {
+			return nil, err
+		}
+		if req.Accumulator == nil || req.Accumulator.Nu == nil || req.E == nil || req.Parent == nil {
+			return nil, errors.New("incomplete remove from accumulator request")
+		}
+		acc, event, err := signer.RemoveFromAccumulator(id, counter, req.Accumulator, req.E, req.Parent)
+		if err != nil {
+			return nil, err
+		}
+		return &removeFromAccumulatorResponse{Accumulator: acc, Event: event}, nil
+	case "signaccumulator":
+		var acc revocation.Accumulator
+		if err := json.Unmarshal(bts, &acc); err != nil {
+			return nil, err
+		}
+		return signer.SignAccumulator(id, counter, &acc)
+	case "signissuancerecord":
+		var record IssuanceRecord
+		if err := unmarshalIssuanceRecordsRequest(bts, &record); err != nil {
+			return nil, err
+		}
+		return signer.SignIssuanceRecord(id, counter, &record)
+	case "signissuancerecords":
+		var records []*IssuanceRecord
+		if err := unmarshalIssuanceRecordsRequest(bts, &records); err != nil {
+			return nil, err
+		}
+		return signer.SignIssuanceRecords(id, counter, records)
+	default:
+		return nil, errors.Errorf("unknown operation %s", operation)
+	}
+}
+
+func unmarshalIssuanceRecordsRequest(bts []byte, dest interface{}) error {
+	var req issuanceRecordsRequest
+	if err := json.Unmarshal(bts, &req); err != nil {
+		return err
+	}
+	return cbor.Unmarshal(req.Records, dest)
+}
+
+func writeIssuerSignerResult(w http.ResponseWriter, result interface{}) {
+	bts, err := json.Marshal(result)
+	if err != nil {
+		writeIssuerSignerError(w, http.StatusInternalServerError, "SIGNER_ERROR", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(bts)
+}
+
+func writeIssuerSignerError(w http.ResponseWriter, status int, name string, err error) {
+	Logger.WithField("error", name).Warn("Issuer signer request failed: ", err)
+	bts, _ := json.Marshal(&RemoteError{Status: status, ErrorName: name, Description: err.Error()})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(bts)
+}